@@ -5,7 +5,6 @@ import (
     "fmt"
     "io/ioutil"
     "os"
-    "regexp"
     "strings"
 
     "sqlight/pkg/db"
@@ -13,15 +12,41 @@ import (
 )
 
 func main() {
+    // Initialize database, encrypted under the passphrase in
+    // SQLIGHT_PASSPHRASE if it is set. The database is a page file, or a
+    // compressed JSON file if SQLIGHT_COMPRESS is set, since only JSON
+    // files can be compressed.
+    options := db.Options{
+        Passphrase: os.Getenv("SQLIGHT_PASSPHRASE"),
+        Compress:   os.Getenv("SQLIGHT_COMPRESS") != "",
+    }
+    path := "database.db"
+    if options.Compress {
+        path = legacyPath
+    }
+
     // Print welcome message
-    printWelcome()
+    printWelcome(path)
+
+    // Copy the JSON database of earlier versions into a new page file
+    copied, err := db.Migrate(path, legacyPath, options)
+    if err != nil {
+        fmt.Printf("Error copying %s into %s: %v\n", legacyPath, path, err)
+        return
+    }
+    if copied {
+        fmt.Printf("Copied %s into %s; %s is left as it was\n\n", legacyPath, path, legacyPath)
+    }
 
-    // Initialize database
-    database, err := db.NewDatabase("database.json")
+    database, err := db.OpenWith(path, options)
     if err != nil {
         fmt.Printf("Error initializing database: %v\n", err)
         return
     }
+    defer database.Close()
+    if err := database.ReadOnly(); err != nil {
+        fmt.Printf("Warning: %v\n", err)
+    }
 
     // Check if a SQL file was provided as an argument
     if len(os.Args) > 1 {
@@ -35,24 +60,16 @@ func main() {
             return
         }
         
-        // Process the file content
-        fileContent := string(content)
-        
-        // Remove comments
-        re := regexp.MustCompile(`--.*`)
-        fileContent = re.ReplaceAllString(fileContent, "")
-        
-        // Split into statements
-        re = regexp.MustCompile(`;[\s\n]*`)
-        statements := re.Split(fileContent, -1)
+        // Split into statements with the SQL lexer, which leaves semicolons
+        // and dashes in strings alone
+        statements, err := sql.SplitStatements(string(content))
+        if err != nil {
+            fmt.Printf("Error reading SQL file: %v\n", err)
+            return
+        }
         
         // Execute each statement
         for _, stmt := range statements {
-            stmt = strings.TrimSpace(stmt)
-            if stmt == "" {
-                continue
-            }
-            
             // Add semicolon back for parsing
             stmt += ";"
             
@@ -225,7 +242,10 @@ func main() {
     fmt.Println("\nINFO: \nExiting due to EOF. Goodbye!")
 }
 
-func printWelcome() {
+// legacyPath is the JSON database file of earlier versions
+const legacyPath = "database.json"
+
+func printWelcome(path string) {
     welcome := `
 ······································································
 : ________  ________  ___       ___  ________  ___  ___  _________   :
@@ -240,5 +260,5 @@ func printWelcome() {
 `
     fmt.Println(welcome)
     fmt.Println("Welcome to SQLight! Type 'help' for usage information.")
-    fmt.Println("Using database file: database.json\n")
+    fmt.Printf("Using database file: %s\n\n", path)
 }
@@ -2,4 +2,7 @@ module sqlight
 
 go 1.21.2
 
-require github.com/gorilla/mux v1.8.1 // indirect
+require (
+	github.com/gorilla/mux v1.8.1 // indirect
+	golang.org/x/crypto v0.31.0
+)
@@ -0,0 +1,143 @@
+// Package crypt encrypts stored data with a passphrase.
+//
+// A 256-bit key is derived from the passphrase with scrypt, using a random
+// salt and a cost recorded alongside the data as Params, and data is sealed
+// with AES-GCM, which also authenticates it: data altered in the file, or
+// opened with the wrong passphrase, fails to open rather than decrypting to
+// garbage.
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// SaltSize is the size of the random salt of a key derivation
+	SaltSize = 16
+
+	// ParamsSize is the size of encoded Params
+	ParamsSize = SaltSize + 3
+
+	// Overhead is the number of bytes Seal adds to the data it seals: the
+	// nonce before it and the authentication tag after it
+	Overhead = nonceSize + tagSize
+
+	nonceSize = 12
+	tagSize   = 16
+	keySize   = 32
+)
+
+// DefaultCost is the scrypt cost of new keys, as the base 2 logarithm of N.
+// Each step doubles the time and memory taken to derive a key, and so to try
+// a guessed passphrase.
+var DefaultCost = 15
+
+var (
+	// ErrPassphraseRequired is returned when opening encrypted data without
+	// a passphrase
+	ErrPassphraseRequired = errors.New("database is encrypted: a passphrase is required")
+
+	// ErrNotEncrypted is returned when opening data that is not encrypted
+	// with a passphrase
+	ErrNotEncrypted = errors.New("database is not encrypted")
+
+	// ErrWrongPassphrase is returned when a passphrase does not open the data
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+
+	// ErrAuthentication is returned by Open when sealed data was altered
+	ErrAuthentication = errors.New("encrypted data fails authentication")
+)
+
+// Params are the salt and cost of a key derivation
+type Params struct {
+	Salt [SaltSize]byte
+	LogN uint8 // scrypt's N is 2^LogN
+	R, P uint8
+}
+
+// NewParams returns parameters with a random salt and the default cost
+func NewParams() (Params, error) {
+	params := Params{LogN: uint8(DefaultCost), R: 8, P: 1}
+	if _, err := rand.Read(params.Salt[:]); err != nil {
+		return Params{}, err
+	}
+	return params, nil
+}
+
+// Encode returns the parameters as ParamsSize bytes
+func (params Params) Encode() []byte {
+	buf := make([]byte, 0, ParamsSize)
+	buf = append(buf, params.Salt[:]...)
+	return append(buf, params.LogN, params.R, params.P)
+}
+
+// DecodeParams decodes parameters written by Encode
+func DecodeParams(data []byte) (Params, error) {
+	var params Params
+	if len(data) < ParamsSize {
+		return params, errors.New("key parameters are truncated")
+	}
+	copy(params.Salt[:], data)
+	params.LogN, params.R, params.P = data[SaltSize], data[SaltSize+1], data[SaltSize+2]
+	if params.LogN < 1 || params.LogN > 30 || params.R == 0 || params.P == 0 {
+		return params, fmt.Errorf("invalid key parameters N=2^%d r=%d p=%d", params.LogN, params.R, params.P)
+	}
+	return params, nil
+}
+
+// Key seals and opens data
+type Key struct {
+	aead cipher.AEAD
+}
+
+// DeriveKey derives the key for a passphrase
+func DeriveKey(passphrase string, params Params) (*Key, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	secret, err := scrypt.Key([]byte(passphrase), params.Salt[:], 1<<params.LogN, int(params.R), int(params.P), keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{aead: aead}, nil
+}
+
+// Seal encrypts and authenticates plaintext under a fresh random nonce,
+// returning len(plaintext)+Overhead bytes. The additional data is
+// authenticated but not stored, and must be given again to Open; it binds
+// the sealed data to where it is kept.
+func (k *Key) Seal(plaintext, additional []byte) []byte {
+	sealed := make([]byte, nonceSize, nonceSize+len(plaintext)+tagSize)
+	if _, err := rand.Read(sealed); err != nil {
+		panic(fmt.Sprintf("crypt: reading random nonce: %v", err))
+	}
+	return k.aead.Seal(sealed, sealed, plaintext, additional)
+}
+
+// Open decrypts data sealed by Seal with the same additional data,
+// returning ErrAuthentication if it was sealed with another key or has been
+// altered
+func (k *Key) Open(sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < Overhead {
+		return nil, ErrAuthentication
+	}
+	plaintext, err := k.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], additional)
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,78 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// testParams returns parameters cheap enough to derive many keys in tests
+func testParams(t *testing.T) Params {
+	t.Helper()
+	params, err := NewParams()
+	if err != nil {
+		t.Fatalf("NewParams: %v", err)
+	}
+	params.LogN = 10
+	return params
+}
+
+func TestSealAndOpen(t *testing.T) {
+	params := testParams(t)
+	key, err := DeriveKey("correct horse", params)
+	if err != nil {
+		t.Fatalf("DeriveKey: %v", err)
+	}
+	plaintext := []byte("alice@example.com")
+	sealed := key.Seal(plaintext, []byte("page 1"))
+	if len(sealed) != len(plaintext)+Overhead {
+		t.Errorf("Sealed %d bytes into %d, expected %d", len(plaintext), len(sealed), len(plaintext)+Overhead)
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Error("The sealed data holds the plaintext")
+	}
+	if again := key.Seal(plaintext, []byte("page 1")); bytes.Equal(again, sealed) {
+		t.Error("Sealing the same data twice gave the same result")
+	}
+
+	// The same passphrase and parameters derive the same key
+	decoded, err := DecodeParams(params.Encode())
+	if err != nil || decoded != params {
+		t.Fatalf("DecodeParams returned %+v, %v, expected %+v", decoded, err, params)
+	}
+	same, err := DeriveKey("correct horse", decoded)
+	if err != nil {
+		t.Fatalf("DeriveKey: %v", err)
+	}
+	opened, err := same.Open(sealed, []byte("page 1"))
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("Open returned %q, %v", opened, err)
+	}
+
+	wrong, err := DeriveKey("battery staple", params)
+	if err != nil {
+		t.Fatalf("DeriveKey: %v", err)
+	}
+	if _, err := wrong.Open(sealed, []byte("page 1")); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("Opening with the wrong passphrase returned %v", err)
+	}
+	if _, err := key.Open(sealed, []byte("page 2")); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("Opening with other additional data returned %v", err)
+	}
+	sealed[len(sealed)/2] ^= 1
+	if _, err := key.Open(sealed, []byte("page 1")); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("Opening altered data returned %v", err)
+	}
+}
+
+func TestInvalidParams(t *testing.T) {
+	if _, err := DecodeParams(make([]byte, ParamsSize-1)); err == nil {
+		t.Error("Expected truncated parameters to fail")
+	}
+	if _, err := DecodeParams(make([]byte, ParamsSize)); err == nil {
+		t.Error("Expected zero parameters to fail")
+	}
+	if _, err := DeriveKey("", testParams(t)); err == nil {
+		t.Error("Expected an empty passphrase to fail")
+	}
+}
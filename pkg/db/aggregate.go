@@ -0,0 +1,315 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sqlight/pkg/interfaces"
+)
+
+// aggregateFuncs lists the supported aggregate functions
+var aggregateFuncs = map[string]bool{
+	"COUNT": true,
+	"SUM":   true,
+	"AVG":   true,
+	"MIN":   true,
+	"MAX":   true,
+}
+
+// isAggregate reports whether an expression is an aggregate function call
+func isAggregate(expr interfaces.Expr) bool {
+	call, ok := expr.(*interfaces.FuncCall)
+	return ok && aggregateFuncs[strings.ToUpper(call.Name)]
+}
+
+// containsAggregate reports whether an expression contains an aggregate call
+func containsAggregate(expr interfaces.Expr) bool {
+	found := false
+	walkExpr(expr, func(e interfaces.Expr) bool {
+		if isAggregate(e) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// isAggregateQuery reports whether a SELECT groups its rows
+func isAggregateQuery(stmt *interfaces.SelectStatement, items []interfaces.SelectColumn) bool {
+	if len(stmt.GroupBy) > 0 {
+		return true
+	}
+	for _, item := range items {
+		if containsAggregate(item.Expr) {
+			return true
+		}
+	}
+	if containsAggregate(stmt.Having) {
+		return true
+	}
+	for _, item := range stmt.OrderBy {
+		if containsAggregate(item.Expr) {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregateRows groups records by the GROUP BY expressions, filters groups
+// with HAVING and produces one output row per remaining group
+func aggregateRows(stmt *interfaces.SelectStatement, items []interfaces.SelectColumn, columns []string, rows []*row, s *scope) ([]selectRow, error) {
+	for _, expr := range stmt.GroupBy {
+		if containsAggregate(expr) {
+			return nil, fmt.Errorf("aggregate functions are not allowed in GROUP BY")
+		}
+	}
+
+	groups, err := groupRows(rows, stmt.GroupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]selectRow, 0, len(groups))
+	for _, group := range groups {
+		eval := func(expr interfaces.Expr) (interface{}, error) {
+			return evalGroupExpr(expr, group, s)
+		}
+
+		if stmt.Having != nil {
+			value, err := eval(stmt.Having)
+			if err != nil {
+				return nil, err
+			}
+			if !isTrue(value) {
+				continue
+			}
+		}
+
+		result, err := buildSelectRow(stmt, items, columns, eval)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// groupRows partitions rows by the values of the GROUP BY expressions,
+// keeping groups in order of first appearance. Without GROUP BY every row
+// belongs to a single group, which exists even when there are no rows.
+func groupRows(rows []*row, groupBy []interfaces.Expr) ([][]*row, error) {
+	if len(groupBy) == 0 {
+		return [][]*row{rows}, nil
+	}
+
+	var groups [][]*row
+	index := make(map[string]int)
+	for _, r := range rows {
+		var key strings.Builder
+		for _, expr := range groupBy {
+			value, err := evalExpr(expr, r)
+			if err != nil {
+				return nil, err
+			}
+			key.WriteString(valueKey(value))
+			key.WriteByte(0)
+		}
+
+		if i, exists := index[key.String()]; exists {
+			groups[i] = append(groups[i], r)
+		} else {
+			index[key.String()] = len(groups)
+			groups = append(groups, []*row{r})
+		}
+	}
+	return groups, nil
+}
+
+// evalGroupExpr evaluates an expression for a group: aggregate calls are
+// computed over the whole group and bare column references take their value
+// from the group's first row (or are NULL for an empty group)
+func evalGroupExpr(expr interfaces.Expr, group []*row, s *scope) (interface{}, error) {
+	resolved, err := resolveAggregates(expr, group)
+	if err != nil {
+		return nil, err
+	}
+
+	first := s.nullRow()
+	if len(group) > 0 {
+		first = group[0]
+	}
+	return evalExpr(resolved, first)
+}
+
+// resolveAggregates returns a copy of expr with each aggregate call replaced
+// by a literal holding its value over the group
+func resolveAggregates(expr interfaces.Expr, group []*row) (interfaces.Expr, error) {
+	switch e := expr.(type) {
+	case *interfaces.FuncCall:
+		if !isAggregate(e) {
+			return e, nil
+		}
+		value, err := computeAggregate(e, group)
+		if err != nil {
+			return nil, err
+		}
+		return &interfaces.Literal{Value: value}, nil
+
+	case *interfaces.BinaryExpr:
+		left, err := resolveAggregates(e.Left, group)
+		if err != nil {
+			return nil, err
+		}
+		right, err := resolveAggregates(e.Right, group)
+		if err != nil {
+			return nil, err
+		}
+		return &interfaces.BinaryExpr{Op: e.Op, Left: left, Right: right}, nil
+
+	case *interfaces.UnaryExpr:
+		inner, err := resolveAggregates(e.Expr, group)
+		if err != nil {
+			return nil, err
+		}
+		return &interfaces.UnaryExpr{Op: e.Op, Expr: inner}, nil
+
+	case *interfaces.IsNullExpr:
+		inner, err := resolveAggregates(e.Expr, group)
+		if err != nil {
+			return nil, err
+		}
+		return &interfaces.IsNullExpr{Expr: inner, Not: e.Not}, nil
+
+	case *interfaces.InExpr:
+		left, err := resolveAggregates(e.Expr, group)
+		if err != nil {
+			return nil, err
+		}
+		list := make([]interfaces.Expr, len(e.List))
+		for i, item := range e.List {
+			if list[i], err = resolveAggregates(item, group); err != nil {
+				return nil, err
+			}
+		}
+		return &interfaces.InExpr{Expr: left, List: list, Subquery: e.Subquery, Not: e.Not}, nil
+
+	case *interfaces.BetweenExpr:
+		bounds := make([]interfaces.Expr, 3)
+		for i, part := range []interfaces.Expr{e.Expr, e.Low, e.High} {
+			var err error
+			if bounds[i], err = resolveAggregates(part, group); err != nil {
+				return nil, err
+			}
+		}
+		return &interfaces.BetweenExpr{Expr: bounds[0], Low: bounds[1], High: bounds[2], Not: e.Not}, nil
+	}
+	return expr, nil
+}
+
+// computeAggregate evaluates one aggregate call over a group. NULL argument
+// values are ignored; COUNT of no values is 0 and every other aggregate of
+// no values is NULL.
+func computeAggregate(call *interfaces.FuncCall, group []*row) (interface{}, error) {
+	name := strings.ToUpper(call.Name)
+	if len(call.Args) != 1 {
+		return nil, fmt.Errorf("%s() takes exactly one argument", name)
+	}
+	arg := call.Args[0]
+
+	if _, ok := arg.(*interfaces.StarExpr); ok {
+		if name != "COUNT" || call.Distinct {
+			return nil, fmt.Errorf("%s(*) is not supported", name)
+		}
+		return len(group), nil
+	}
+	if containsAggregate(arg) {
+		return nil, fmt.Errorf("aggregate function calls cannot be nested")
+	}
+
+	// Collect the non-NULL argument values
+	values := make([]interface{}, 0, len(group))
+	seen := make(map[string]bool)
+	for _, r := range group {
+		value, err := evalExpr(arg, r)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		if call.Distinct {
+			key := valueKey(value)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		values = append(values, value)
+	}
+
+	switch name {
+	case "COUNT":
+		return len(values), nil
+
+	case "MIN", "MAX":
+		var best interface{}
+		for _, value := range values {
+			if best == nil {
+				best = value
+				continue
+			}
+			cmp := compareValues(value, best)
+			if (name == "MIN" && cmp < 0) || (name == "MAX" && cmp > 0) {
+				best = value
+			}
+		}
+		return best, nil
+
+	case "SUM", "AVG":
+		if len(values) == 0 {
+			return nil, nil
+		}
+
+		// Integers are summed exactly; any real value makes the result real
+		intSum, floatSum, isFloat := 0, 0.0, false
+		for _, value := range values {
+			if i, ok := value.(int); ok {
+				intSum += i
+				continue
+			}
+			f, ok := toFloat(value)
+			if !ok {
+				return nil, fmt.Errorf("%s() of non-numeric value %v", name, value)
+			}
+			floatSum += f
+			isFloat = true
+		}
+
+		if name == "AVG" {
+			return (float64(intSum) + floatSum) / float64(len(values)), nil
+		}
+		if isFloat {
+			return float64(intSum) + floatSum, nil
+		}
+		return intSum, nil
+	}
+
+	return nil, fmt.Errorf("unknown aggregate function %s", name)
+}
+
+// valueKey returns a string identifying a value for grouping and DISTINCT.
+// Values share a key when "=" finds them equal: numbers and numeric strings
+// by value, and other strings case-insensitively.
+func valueKey(value interface{}) string {
+	switch v := keyValue(value).(type) {
+	case nil:
+		return "null"
+	case int, int64, float64, bool:
+		return "n:" + strconv.FormatFloat(numericValue(v), 'g', -1, 64)
+	case string:
+		return "s:" + strings.ToLower(v)
+	default:
+		return fmt.Sprintf("o:%v", v)
+	}
+}
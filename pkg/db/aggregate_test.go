@@ -0,0 +1,73 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"sqlight/pkg/interfaces"
+	"sqlight/pkg/storage"
+)
+
+func TestComputeAggregateNullHandling(t *testing.T) {
+	s := newTableScope(&interfaces.Table{Name: "t", Columns: []interfaces.Column{{Name: "qty"}}}, "t")
+	var group []*row
+	for _, qty := range []interface{}{2, nil, 4, 2} {
+		group = append(group, s.row(&interfaces.Record{Columns: map[string]interface{}{"qty": qty}}))
+	}
+	qty := &interfaces.ColumnRef{Name: "qty"}
+
+	tests := []struct {
+		call     *interfaces.FuncCall
+		group    []*row
+		expected interface{}
+	}{
+		{&interfaces.FuncCall{Name: "COUNT", Args: []interfaces.Expr{&interfaces.StarExpr{}}}, group, 4},
+		{&interfaces.FuncCall{Name: "COUNT", Args: []interfaces.Expr{qty}}, group, 3},
+		{&interfaces.FuncCall{Name: "count", Args: []interfaces.Expr{qty}, Distinct: true}, group, 2},
+		{&interfaces.FuncCall{Name: "SUM", Args: []interfaces.Expr{qty}}, group, 8},
+		{&interfaces.FuncCall{Name: "AVG", Args: []interfaces.Expr{qty}}, group, 8.0 / 3},
+		{&interfaces.FuncCall{Name: "MIN", Args: []interfaces.Expr{qty}}, group, 2},
+		{&interfaces.FuncCall{Name: "MAX", Args: []interfaces.Expr{qty}}, group, 4},
+		{&interfaces.FuncCall{Name: "COUNT", Args: []interfaces.Expr{qty}}, nil, 0},
+		{&interfaces.FuncCall{Name: "SUM", Args: []interfaces.Expr{qty}}, nil, nil},
+		{&interfaces.FuncCall{Name: "MAX", Args: []interfaces.Expr{qty}}, group[1:2], nil},
+	}
+
+	for _, tt := range tests {
+		got, err := computeAggregate(tt.call, tt.group)
+		if err != nil {
+			t.Fatalf("%s returned error: %v", tt.call, err)
+		}
+		if got != tt.expected {
+			t.Errorf("%s over %d rows = %v, expected %v", tt.call, len(tt.group), got, tt.expected)
+		}
+	}
+}
+
+func TestGroupByMatchesEquality(t *testing.T) {
+	d, err := NewDatabase(storage.NewMemoryBackend())
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, customer TEXT, qty TEXT)",
+		"INSERT INTO orders VALUES (1, 'Bob', 2), (2, 'alice', '2'), (3, 'BOB', '2.0'), (4, 'bob', 3)",
+	)
+
+	// Strings that differ only in case group together, as they compare equal
+	result := execAll(t, d, "SELECT customer, COUNT(*) AS n FROM orders GROUP BY customer ORDER BY customer")
+	var groups []string
+	for _, record := range result.Records {
+		groups = append(groups, fmt.Sprintf("%v=%v", record.Columns["customer"], record.Columns["n"]))
+	}
+	if expected := []string{"alice=1", "Bob=3"}; !reflect.DeepEqual(groups, expected) {
+		t.Errorf("GROUP BY customer returned %v, expected %v", groups, expected)
+	}
+
+	// 2, '2' and '2.0' are one value
+	result = execAll(t, d, "SELECT COUNT(DISTINCT qty) AS n FROM orders")
+	if n := result.Records[0].Columns["n"]; n != 2 {
+		t.Errorf("COUNT(DISTINCT qty) = %v, expected 2", n)
+	}
+}
@@ -0,0 +1,181 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"sqlight/pkg/interfaces"
+)
+
+// executeAlterTable handles ALTER TABLE statements. Every check is made
+// before the table is changed, so a failing statement leaves it untouched.
+func (d *Database) executeAlterTable(stmt *interfaces.AlterTableStatement) (*interfaces.Result, error) {
+	table, tableName, err := d.getTable(stmt.TableName, true)
+	if err != nil {
+		return nil, err
+	}
+	st, err := d.store(table)
+	if err != nil {
+		return nil, err
+	}
+
+	// Inside a transaction the snapshot holds the working copy of every table
+	tables := d.tables
+	if d.inTransaction {
+		tables = d.snapshot
+	}
+
+	var message string
+	switch stmt.Action {
+	case "ADD COLUMN":
+		if err := addColumn(table, st.tree.Scan(), stmt.Column); err != nil {
+			return nil, err
+		}
+		message = fmt.Sprintf("Column %s added to table %s", stmt.Column.Name, tableName)
+
+	case "DROP COLUMN":
+		for _, index := range table.Indexes {
+			for _, col := range index.Columns {
+				if strings.EqualFold(col, stmt.ColumnName) {
+					return nil, fmt.Errorf("cannot drop column %s used by index %s", col, index.Name)
+				}
+			}
+		}
+		if err := dropColumn(table, st.tree.Scan(), stmt.ColumnName); err != nil {
+			return nil, err
+		}
+		message = fmt.Sprintf("Column %s dropped from table %s", stmt.ColumnName, tableName)
+
+	case "RENAME COLUMN":
+		if err := renameColumn(table, st.tree.Scan(), stmt.ColumnName, stmt.NewName); err != nil {
+			return nil, err
+		}
+		message = fmt.Sprintf("Column %s renamed to %s", stmt.ColumnName, stmt.NewName)
+
+	case "RENAME TABLE":
+		if _, _, err := d.getTable(stmt.NewName, true); err == nil && !strings.EqualFold(stmt.NewName, tableName) {
+			return nil, fmt.Errorf("table %s already exists", stmt.NewName)
+		}
+		delete(tables, tableName)
+		table.Name = stmt.NewName
+		tableName = stmt.NewName
+		message = fmt.Sprintf("Table %s renamed to %s", stmt.TableName, stmt.NewName)
+
+	default:
+		return nil, fmt.Errorf("unsupported ALTER TABLE action: %s", stmt.Action)
+	}
+
+	// Index trees are rebuilt for the new schema when next used, and rows
+	// are saved in column order, so adding or dropping a column rewrites them
+	d.invalidateIndexes(table)
+	if stmt.Action == "ADD COLUMN" || stmt.Action == "DROP COLUMN" {
+		st.rewrite = true
+		st.measure()
+	}
+
+	tables[tableName] = table
+	if !d.inTransaction {
+		if err := d.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &interfaces.Result{
+		Success: true,
+		Message: message,
+	}, nil
+}
+
+// addColumn appends a column to a table, setting it to the column's default
+// in every one of its records
+func addColumn(table *interfaces.Table, records []*interfaces.Record, col interfaces.Column) error {
+	if _, exists := findColumn(table, col.Name); exists {
+		return fmt.Errorf("column %s already exists", col.Name)
+	}
+	if col.PrimaryKey {
+		return fmt.Errorf("cannot add a PRIMARY KEY column")
+	}
+	if col.Unique {
+		return fmt.Errorf("cannot add a UNIQUE column")
+	}
+
+	value, err := getColumnValue(&col, col.Default)
+	if err != nil {
+		return fmt.Errorf("invalid default for column %s: %v", col.Name, err)
+	}
+	if !col.Nullable && value == nil {
+		return fmt.Errorf("cannot add a NOT NULL column with default value NULL")
+	}
+	col.Default = value
+
+	table.Columns = append(table.Columns, col)
+	for _, record := range records {
+		record.Columns[col.Name] = value
+	}
+	return nil
+}
+
+// dropColumn removes a column from a table and from every one of its records
+func dropColumn(table *interfaces.Table, records []*interfaces.Record, name string) error {
+	i, exists := findColumn(table, name)
+	if !exists {
+		return fmt.Errorf("column %s does not exist", name)
+	}
+	col := table.Columns[i]
+	if col.PrimaryKey {
+		return fmt.Errorf("cannot drop PRIMARY KEY column %s", col.Name)
+	}
+	if col.Unique {
+		return fmt.Errorf("cannot drop UNIQUE column %s", col.Name)
+	}
+	if len(table.Columns) == 1 {
+		return fmt.Errorf("cannot drop the only column of table %s", table.Name)
+	}
+
+	table.Columns = append(table.Columns[:i:i], table.Columns[i+1:]...)
+	for _, record := range records {
+		delete(record.Columns, col.Name)
+	}
+	return nil
+}
+
+// renameColumn renames a column of a table and its key in every one of its
+// records
+func renameColumn(table *interfaces.Table, records []*interfaces.Record, name, newName string) error {
+	i, exists := findColumn(table, name)
+	if !exists {
+		return fmt.Errorf("column %s does not exist", name)
+	}
+	if j, exists := findColumn(table, newName); exists && j != i {
+		return fmt.Errorf("column %s already exists", newName)
+	}
+
+	oldName := table.Columns[i].Name
+	table.Columns[i].Name = newName
+	for _, index := range table.Indexes {
+		for j, col := range index.Columns {
+			if col == oldName {
+				index.Columns[j] = newName
+			}
+		}
+	}
+	for _, record := range records {
+		value, exists := record.Columns[oldName]
+		delete(record.Columns, oldName)
+		if exists {
+			record.Columns[newName] = value
+		}
+	}
+	return nil
+}
+
+// findColumn returns the position of a column in a table, matching the
+// name case-insensitively
+func findColumn(table *interfaces.Table, name string) (int, bool) {
+	for i, col := range table.Columns {
+		if strings.EqualFold(col.Name, name) {
+			return i, true
+		}
+	}
+	return -1, false
+}
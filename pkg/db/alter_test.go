@@ -0,0 +1,70 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"sqlight/pkg/storage"
+)
+
+func TestAlterTableRewritesRecords(t *testing.T) {
+	d, err := NewDatabase(storage.NewMemoryBackend())
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, nick TEXT)",
+		"INSERT INTO users VALUES (1, 'Alice', 'al'), (2, 'Bob', 'bobby')",
+		"ALTER TABLE users ADD COLUMN age INTEGER DEFAULT 30",
+		"ALTER TABLE users DROP COLUMN nick",
+		"ALTER TABLE users RENAME COLUMN name TO full_name",
+		"ALTER TABLE users RENAME TO people",
+	)
+
+	table, _, err := d.getTable("people", false)
+	if err != nil {
+		t.Fatalf("Renamed table not found: %v", err)
+	}
+	for _, record := range table.Records {
+		keys := make(map[string]bool)
+		for k := range record.Columns {
+			keys[k] = true
+		}
+		expected := map[string]bool{"id": true, "full_name": true, "age": true}
+		if !reflect.DeepEqual(keys, expected) {
+			t.Errorf("Expected record columns %v, got %v", expected, keys)
+		}
+		if record.Columns["age"] != 30 {
+			t.Errorf("Expected default age 30, got %v", record.Columns["age"])
+		}
+	}
+}
+
+func TestAlterTableRollback(t *testing.T) {
+	d, err := NewDatabase(storage.NewMemoryBackend())
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO users VALUES (1, 'Alice')",
+		"BEGIN",
+		"ALTER TABLE users ADD COLUMN age INTEGER DEFAULT 30",
+		"ALTER TABLE users RENAME COLUMN name TO full_name",
+		"ALTER TABLE users RENAME TO people",
+		"ROLLBACK",
+	)
+
+	result := execAll(t, d, "SELECT * FROM users")
+	if !reflect.DeepEqual(result.Columns, []string{"id", "name"}) {
+		t.Errorf("Expected original columns after rollback, got %v", result.Columns)
+	}
+	if !reflect.DeepEqual(result.Records[0].Columns, map[string]interface{}{"id": 1, "name": "Alice"}) {
+		t.Errorf("Expected original record after rollback, got %v", result.Records[0].Columns)
+	}
+	if _, _, err := d.getTable("people", true); err == nil {
+		t.Error("Expected renamed table to be rolled back")
+	}
+}
@@ -10,32 +10,54 @@ const (
 	NodeTypeInternal
 )
 
-const (
-	LeafNodeMaxRecords  = 3 // Small for testing
-	InternalNodeMaxKeys = 3
-)
+// DefaultFanOut is the fan-out of trees created with NewBTree
+const DefaultFanOut = 64
+
+// MinFanOut is the smallest fan-out a tree can have
+const MinFanOut = 3
 
 // Node represents a B+ tree node
 type Node struct {
 	IsLeaf   bool
-	Keys     []int
+	Keys     []Key
 	Records  []*interfaces.Record
 	Children []*Node
-	Next     *Node
+	Next     *Node // next leaf in key order
+	Prev     *Node // previous leaf in key order
 	Parent   *Node
 }
 
-// BTree represents a B+ tree
+// BTree represents a B+ tree. Keys are ordered by compareKeys using the
+// tree's collations and may repeat; equal keys keep their insertion order.
+//
+// With a fan-out of f, an internal node has at most f children and every
+// node holds at most f-1 keys. Apart from the root, leaves stay at least
+// half full and internal nodes keep at least (f-1)/2 keys.
 type BTree struct {
-	Root *Node
+	Root       *Node
+	Collations []Collation
+	fanOut     int
+}
+
+// NewBTree creates a new B+ tree with the default fan-out whose key columns
+// compare text with the given collations, or case-insensitively when none
+// is given
+func NewBTree(collations ...Collation) *BTree {
+	return NewBTreeWithFanOut(DefaultFanOut, collations...)
 }
 
-// NewBTree creates a new B+ tree
-func NewBTree() *BTree {
+// NewBTreeWithFanOut creates a new B+ tree with the given fan-out; fan-outs
+// below MinFanOut are raised to it
+func NewBTreeWithFanOut(fanOut int, collations ...Collation) *BTree {
+	if fanOut < MinFanOut {
+		fanOut = MinFanOut
+	}
 	return &BTree{
+		Collations: collations,
+		fanOut:     fanOut,
 		Root: &Node{
 			IsLeaf:   true,
-			Keys:     make([]int, 0),
+			Keys:     make([]Key, 0),
 			Records:  make([]*interfaces.Record, 0),
 			Children: nil,
 		},
@@ -43,28 +65,28 @@ func NewBTree() *BTree {
 }
 
 // Insert adds a new record to the B+ tree
-func (t *BTree) Insert(id int, record *interfaces.Record) error {
+func (t *BTree) Insert(key Key, record *interfaces.Record) error {
 	if t.Root == nil {
 		t.Root = &Node{
 			IsLeaf:   true,
-			Keys:     make([]int, 0),
+			Keys:     make([]Key, 0),
 			Records:  make([]*interfaces.Record, 0),
 			Children: nil,
 		}
 	}
 
 	node := t.Root
-	// Find the leaf node where this record should be inserted
+	// Find the leaf node where this record should be inserted, after any
+	// equal keys
 	for !node.IsLeaf {
-		pos := node.findPosition(id)
-		node = node.Children[pos]
+		node = node.Children[t.upperBound(node, key)]
 	}
 
 	// Insert into leaf node
-	t.insertIntoLeaf(node, id, record)
+	t.insertIntoLeaf(node, key, record)
 
 	// Check if we need to split
-	if len(node.Keys) > LeafNodeMaxRecords {
+	if len(node.Keys) > t.maxKeys() {
 		t.splitLeaf(node)
 	}
 
@@ -72,20 +94,58 @@ func (t *BTree) Insert(id int, record *interfaces.Record) error {
 }
 
 // Helper methods
-func (n *Node) findPosition(key int) int {
+
+// maxKeys returns the most keys a node may hold
+func (t *BTree) maxKeys() int {
+	if t.fanOut < MinFanOut {
+		return DefaultFanOut - 1
+	}
+	return t.fanOut - 1
+}
+
+// minLeafKeys returns the fewest keys a leaf other than the root may hold,
+// which is the size of the smaller half of a split leaf
+func (t *BTree) minLeafKeys() int {
+	return (t.maxKeys() + 1) / 2
+}
+
+// minInternalKeys returns the fewest keys an internal node other than the
+// root may hold, which is the size of the smaller half of a split node
+func (t *BTree) minInternalKeys() int {
+	return t.maxKeys() / 2
+}
+
+// compare orders two keys of the tree
+func (t *BTree) compare(a, b Key) int {
+	return compareKeys(a, b, t.Collations)
+}
+
+// lowerBound returns the position of the first key of a node not less than key
+func (t *BTree) lowerBound(n *Node, key Key) int {
+	for i, k := range n.Keys {
+		if t.compare(key, k) <= 0 {
+			return i
+		}
+	}
+	return len(n.Keys)
+}
+
+// upperBound returns the position of the first key of a node greater than
+// key, comparing only as many columns as key has
+func (t *BTree) upperBound(n *Node, key Key) int {
 	for i, k := range n.Keys {
-		if key <= k {
+		if t.compare(key, prefix(k, len(key))) < 0 {
 			return i
 		}
 	}
 	return len(n.Keys)
 }
 
-func (t *BTree) insertIntoLeaf(node *Node, key int, record *interfaces.Record) {
-	pos := node.findPosition(key)
+func (t *BTree) insertIntoLeaf(node *Node, key Key, record *interfaces.Record) {
+	pos := t.upperBound(node, key)
 
 	// Insert key
-	node.Keys = append(node.Keys, 0)
+	node.Keys = append(node.Keys, nil)
 	copy(node.Keys[pos+1:], node.Keys[pos:])
 	node.Keys[pos] = key
 
@@ -99,10 +159,14 @@ func (t *BTree) splitLeaf(node *Node) {
 	// Create new leaf node
 	newNode := &Node{
 		IsLeaf:   true,
-		Keys:     make([]int, 0),
+		Keys:     make([]Key, 0),
 		Records:  make([]*interfaces.Record, 0),
 		Children: nil,
 		Next:     node.Next,
+		Prev:     node,
+	}
+	if node.Next != nil {
+		node.Next.Prev = newNode
 	}
 	node.Next = newNode
 
@@ -120,7 +184,7 @@ func (t *BTree) splitLeaf(node *Node) {
 		// Create new root
 		newRoot := &Node{
 			IsLeaf:   false,
-			Keys:     []int{newNode.Keys[0]},
+			Keys:     []Key{newNode.Keys[0]},
 			Children: []*Node{node, newNode},
 		}
 		t.Root = newRoot
@@ -133,12 +197,18 @@ func (t *BTree) splitLeaf(node *Node) {
 	}
 }
 
-func (t *BTree) insertIntoParent(leftNode *Node, key int, rightNode *Node) {
+func (t *BTree) insertIntoParent(leftNode *Node, key Key, rightNode *Node) {
 	parent := leftNode.Parent
-	pos := parent.findPosition(key)
+
+	// The new node goes directly after the node it was split from; with
+	// repeated keys the separator alone cannot tell where that is
+	pos := 0
+	for parent.Children[pos] != leftNode {
+		pos++
+	}
 
 	// Insert key
-	parent.Keys = append(parent.Keys, 0)
+	parent.Keys = append(parent.Keys, nil)
 	copy(parent.Keys[pos+1:], parent.Keys[pos:])
 	parent.Keys[pos] = key
 
@@ -148,7 +218,7 @@ func (t *BTree) insertIntoParent(leftNode *Node, key int, rightNode *Node) {
 	parent.Children[pos+1] = rightNode
 
 	// Check if we need to split the parent
-	if len(parent.Keys) > InternalNodeMaxKeys {
+	if len(parent.Keys) > t.maxKeys() {
 		t.splitInternal(parent)
 	}
 }
@@ -157,7 +227,7 @@ func (t *BTree) splitInternal(node *Node) {
 	// Create new internal node
 	newNode := &Node{
 		IsLeaf:   false,
-		Keys:     make([]int, 0),
+		Keys:     make([]Key, 0),
 		Children: make([]*Node, 0),
 	}
 
@@ -180,7 +250,7 @@ func (t *BTree) splitInternal(node *Node) {
 		// Create new root
 		newRoot := &Node{
 			IsLeaf:   false,
-			Keys:     []int{promotedKey},
+			Keys:     []Key{promotedKey},
 			Children: []*Node{node, newNode},
 		}
 		t.Root = newRoot
@@ -193,67 +263,294 @@ func (t *BTree) splitInternal(node *Node) {
 	}
 }
 
-// Delete removes a record with the given key from the B-tree
-func (t *BTree) Delete(key int) {
+// seek returns the leaf and position of the first entry whose key is not
+// less than key. The position is past the end of the last leaf when every
+// key is less.
+func (t *BTree) seek(key Key) (*Node, int) {
 	if t.Root == nil {
-		return
+		return nil, 0
 	}
 
-	// Find the leaf node containing the key
+	// Descend to the leftmost leaf that can hold key
 	node := t.Root
 	for !node.IsLeaf {
-		pos := node.findPosition(key)
-		if pos >= len(node.Children) {
-			return
+		node = node.Children[t.lowerBound(node, key)]
+	}
+
+	// The first such key may be in a following leaf
+	pos := t.lowerBound(node, key)
+	for pos == len(node.Keys) && node.Next != nil {
+		node = node.Next
+		pos = t.lowerBound(node, key)
+	}
+	return node, pos
+}
+
+// seekAfter returns the leaf and position of the first entry whose key,
+// compared by its first len(key) columns, is greater than key. Like seek, the
+// position may be past the end of the last leaf.
+func (t *BTree) seekAfter(key Key) (*Node, int) {
+	if t.Root == nil {
+		return nil, 0
+	}
+
+	node := t.Root
+	for !node.IsLeaf {
+		node = node.Children[t.upperBound(node, key)]
+	}
+
+	pos := t.upperBound(node, key)
+	for pos == len(node.Keys) && node.Next != nil {
+		node = node.Next
+		pos = t.upperBound(node, key)
+	}
+	return node, pos
+}
+
+// Delete removes the first record with the given key from the B-tree
+func (t *BTree) Delete(key Key) {
+	node, pos := t.seek(key)
+	if node != nil && pos < len(node.Keys) && t.compare(node.Keys[pos], key) == 0 {
+		t.remove(node, pos)
+	}
+}
+
+// DeleteRecord removes the entry for a particular record stored under key,
+// reporting whether it was found
+func (t *BTree) DeleteRecord(key Key, record *interfaces.Record) bool {
+	node, pos := t.seek(key)
+	for node != nil {
+		for ; pos < len(node.Keys); pos++ {
+			if t.compare(node.Keys[pos], key) != 0 {
+				return false
+			}
+			if node.Records[pos] == record {
+				t.remove(node, pos)
+				return true
+			}
 		}
-		node = node.Children[pos]
+		node = node.Next
+		pos = 0
 	}
+	return false
+}
+
+// remove removes the key and record at pos from a leaf and restores the
+// minimum fill of the nodes above it
+func (t *BTree) remove(leaf *Node, pos int) {
+	leaf.Keys = append(leaf.Keys[:pos], leaf.Keys[pos+1:]...)
+	leaf.Records = append(leaf.Records[:pos], leaf.Records[pos+1:]...)
+	t.rebalance(leaf)
+}
 
-	// Find the position of the key in the leaf node
-	pos := -1
-	for i, k := range node.Keys {
-		if k == key {
-			pos = i
-			break
+// rebalance fixes a node that may have fallen below the minimum number of
+// keys by borrowing an entry from a sibling that can spare one or, failing
+// that, merging it with a sibling, which removes a key from the parent and
+// may leave the parent to be rebalanced in turn. Separator keys are updated
+// when entries move between siblings; a separator left by a deleted key
+// needs no update as it still bounds the keys on either side.
+func (t *BTree) rebalance(node *Node) {
+	if node == t.Root {
+		// A root without keys is replaced by its only child
+		if !node.IsLeaf && len(node.Keys) == 0 {
+			t.Root = node.Children[0]
+			t.Root.Parent = nil
 		}
+		return
 	}
 
-	// If key not found, return
-	if pos == -1 {
+	min := t.minInternalKeys()
+	if node.IsLeaf {
+		min = t.minLeafKeys()
+	}
+	if len(node.Keys) >= min {
 		return
 	}
 
-	// Remove the key and record
-	node.Keys = append(node.Keys[:pos], node.Keys[pos+1:]...)
-	node.Records = append(node.Records[:pos], node.Records[pos+1:]...)
+	parent := node.Parent
+	i := 0
+	for parent.Children[i] != node {
+		i++
+	}
+	var left, right *Node
+	if i > 0 {
+		left = parent.Children[i-1]
+	}
+	if i < len(parent.Children)-1 {
+		right = parent.Children[i+1]
+	}
 
-	// If root is a leaf node, we're done
-	if node == t.Root {
-		return
+	switch {
+	case left != nil && len(left.Keys) > min:
+		t.borrowFromLeft(node, left, i)
+	case right != nil && len(right.Keys) > min:
+		t.borrowFromRight(node, right, i)
+	case left != nil:
+		t.merge(left, node, i-1)
+		t.rebalance(parent)
+	default:
+		t.merge(node, right, i)
+		t.rebalance(parent)
 	}
+}
 
-	// If node has enough keys, we're done
-	if len(node.Keys) >= LeafNodeMaxRecords/2 {
+// borrowFromLeft moves the last entry of left, the sibling before node, to
+// the front of node; i is node's position in the parent
+func (t *BTree) borrowFromLeft(node, left *Node, i int) {
+	parent := node.Parent
+	last := len(left.Keys) - 1
+
+	if node.IsLeaf {
+		node.Keys = append([]Key{left.Keys[last]}, node.Keys...)
+		node.Records = append([]*interfaces.Record{left.Records[last]}, node.Records...)
+		left.Keys, left.Records = left.Keys[:last], left.Records[:last]
+		parent.Keys[i-1] = node.Keys[0]
 		return
 	}
 
-	// Try to borrow from siblings
-	if node.Next != nil && len(node.Next.Keys) > LeafNodeMaxRecords/2 {
-		// Borrow from right sibling
-		node.Keys = append(node.Keys, node.Next.Keys[0])
-		node.Records = append(node.Records, node.Next.Records[0])
-		node.Next.Keys = node.Next.Keys[1:]
-		node.Next.Records = node.Next.Records[1:]
+	// Rotate through the parent: its separator comes down in front of
+	// node's keys and left's last key goes up in its place
+	child := left.Children[last+1]
+	node.Keys = append([]Key{parent.Keys[i-1]}, node.Keys...)
+	node.Children = append([]*Node{child}, node.Children...)
+	child.Parent = node
+	parent.Keys[i-1] = left.Keys[last]
+	left.Keys, left.Children = left.Keys[:last], left.Children[:last+1]
+}
+
+// borrowFromRight moves the first entry of right, the sibling after node, to
+// the end of node; i is node's position in the parent
+func (t *BTree) borrowFromRight(node, right *Node, i int) {
+	parent := node.Parent
+
+	if node.IsLeaf {
+		node.Keys = append(node.Keys, right.Keys[0])
+		node.Records = append(node.Records, right.Records[0])
+		right.Keys, right.Records = right.Keys[1:], right.Records[1:]
+		parent.Keys[i] = right.Keys[0]
 		return
 	}
 
-	// If we can't borrow, merge with next sibling if possible
-	if node.Next != nil {
-		// Merge with right sibling
-		node.Keys = append(node.Keys, node.Next.Keys...)
-		node.Records = append(node.Records, node.Next.Records...)
-		node.Next = node.Next.Next
+	child := right.Children[0]
+	node.Keys = append(node.Keys, parent.Keys[i])
+	node.Children = append(node.Children, child)
+	child.Parent = node
+	parent.Keys[i] = right.Keys[0]
+	right.Keys, right.Children = right.Keys[1:], right.Children[1:]
+}
+
+// merge moves every entry of right into left, its sibling before it, and
+// removes right and the separator at position i from their parent
+func (t *BTree) merge(left, right *Node, i int) {
+	parent := left.Parent
+
+	if left.IsLeaf {
+		left.Keys = append(left.Keys, right.Keys...)
+		left.Records = append(left.Records, right.Records...)
+		left.Next = right.Next
+		if right.Next != nil {
+			right.Next.Prev = left
+		}
+	} else {
+		left.Keys = append(append(left.Keys, parent.Keys[i]), right.Keys...)
+		for _, child := range right.Children {
+			child.Parent = left
+		}
+		left.Children = append(left.Children, right.Children...)
+	}
+
+	parent.Keys = append(parent.Keys[:i], parent.Keys[i+1:]...)
+	parent.Children = append(parent.Children[:i+1], parent.Children[i+2:]...)
+}
+
+// Check verifies the structure of the tree, returning an error describing
+// the first problem found: keys out of order or outside the bounds set by
+// the separators above them, nodes too full or, apart from the root, too
+// empty, leaves at different depths, or broken parent or leaf links.
+func (t *BTree) Check() error {
+	if t.Root == nil {
+		return nil
+	}
+	if t.Root.Parent != nil {
+		return fmt.Errorf("root has a parent")
+	}
+
+	var leaves []*Node
+	leafDepth := -1
+	var check func(node *Node, depth int, lo, hi Key) error
+	check = func(node *Node, depth int, lo, hi Key) error {
+		if len(node.Keys) > t.maxKeys() {
+			return fmt.Errorf("node at depth %d has %d keys, more than the maximum %d", depth, len(node.Keys), t.maxKeys())
+		}
+		for i, key := range node.Keys {
+			if i > 0 && t.compare(node.Keys[i-1], key) > 0 {
+				return fmt.Errorf("node at depth %d has key %v after %v", depth, key, node.Keys[i-1])
+			}
+			if (lo != nil && t.compare(key, lo) < 0) || (hi != nil && t.compare(key, hi) > 0) {
+				return fmt.Errorf("node at depth %d has key %v outside the separators %v and %v", depth, key, lo, hi)
+			}
+		}
+
+		if node.IsLeaf {
+			if node != t.Root && len(node.Keys) < t.minLeafKeys() {
+				return fmt.Errorf("leaf has %d keys, fewer than the minimum %d", len(node.Keys), t.minLeafKeys())
+			}
+			if len(node.Records) != len(node.Keys) {
+				return fmt.Errorf("leaf has %d keys but %d records", len(node.Keys), len(node.Records))
+			}
+			if leafDepth >= 0 && depth != leafDepth {
+				return fmt.Errorf("leaves at depths %d and %d", leafDepth, depth)
+			}
+			leafDepth = depth
+			leaves = append(leaves, node)
+			return nil
+		}
+
+		min := t.minInternalKeys()
+		if node == t.Root {
+			min = 1
+		}
+		if len(node.Keys) < min {
+			return fmt.Errorf("internal node at depth %d has %d keys, fewer than the minimum %d", depth, len(node.Keys), min)
+		}
+		if len(node.Children) != len(node.Keys)+1 {
+			return fmt.Errorf("internal node at depth %d has %d keys but %d children", depth, len(node.Keys), len(node.Children))
+		}
+		for i, child := range node.Children {
+			if child.Parent != node {
+				return fmt.Errorf("child %d of node at depth %d has the wrong parent", i, depth)
+			}
+			childLo, childHi := lo, hi
+			if i > 0 {
+				childLo = node.Keys[i-1]
+			}
+			if i < len(node.Keys) {
+				childHi = node.Keys[i]
+			}
+			if err := check(child, depth+1, childLo, childHi); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := check(t.Root, 0, nil, nil); err != nil {
+		return err
 	}
+
+	// The leaf links must visit the leaves in tree order in both directions
+	for i, leaf := range leaves {
+		var prev, next *Node
+		if i > 0 {
+			prev = leaves[i-1]
+		}
+		if i < len(leaves)-1 {
+			next = leaves[i+1]
+		}
+		if leaf.Prev != prev || leaf.Next != next {
+			return fmt.Errorf("leaf %d is linked out of order", i)
+		}
+	}
+	return nil
 }
 
 // Scan retrieves all records from the B-tree
@@ -280,53 +577,43 @@ func (t *BTree) Scan() []*interfaces.Record {
 }
 
 // Search finds a record by key
-func (t *BTree) Search(key int) *interfaces.Record {
-	if t.Root == nil {
-		return nil
-	}
-
-	node := t.Root
-
-	// Find leaf node
-	for !node.IsLeaf {
-		pos := node.findPosition(key)
-		node = node.Children[pos]
-	}
-
-	// Search in leaf node
-	pos := node.findPosition(key)
-	if pos < len(node.Keys) && node.Keys[pos] == key {
+func (t *BTree) Search(key Key) *interfaces.Record {
+	node, pos := t.seek(key)
+	if node != nil && pos < len(node.Keys) && t.compare(node.Keys[pos], key) == 0 {
 		return node.Records[pos]
 	}
-
 	return nil
 }
 
-// BTreeSimple represents a simple B-tree for record storage
+// BTreeSimple represents a simple B-tree for record storage. Keys are
+// unique and ordered like those of BTree.
 type BTreeSimple struct {
-	root *NodeSimple
+	root       *NodeSimple
+	collations []Collation
 }
 
 // NodeSimple represents a node in the B-tree
 type NodeSimple struct {
-	key    int
+	key    Key
 	record *interfaces.Record
 	left   *NodeSimple
 	right  *NodeSimple
 }
 
-// NewBTreeSimple creates a new B-tree
-func NewBTreeSimple() *BTreeSimple {
+// NewBTreeSimple creates a new B-tree whose key columns compare text with
+// the given collations
+func NewBTreeSimple(collations ...Collation) *BTreeSimple {
 	return &BTreeSimple{
-		root: nil,
+		root:       nil,
+		collations: collations,
 	}
 }
 
 // Insert adds a record to the B-tree
-func (bt *BTreeSimple) Insert(key int, record *interfaces.Record) error {
+func (bt *BTreeSimple) Insert(key Key, record *interfaces.Record) error {
 	// Check if key already exists
 	if bt.Search(key) != nil {
-		return fmt.Errorf("record with key %d already exists", key)
+		return fmt.Errorf("record with key %v already exists", key)
 	}
 
 	// Create a new node
@@ -349,47 +636,49 @@ func (bt *BTreeSimple) Insert(key int, record *interfaces.Record) error {
 
 // insertNode recursively inserts a node into the B-tree
 func (bt *BTreeSimple) insertNode(root, newNode *NodeSimple) error {
-	if newNode.key < root.key {
+	cmp := compareKeys(newNode.key, root.key, bt.collations)
+	if cmp < 0 {
 		if root.left == nil {
 			root.left = newNode
 			return nil
 		}
 		return bt.insertNode(root.left, newNode)
-	} else if newNode.key > root.key {
+	} else if cmp > 0 {
 		if root.right == nil {
 			root.right = newNode
 			return nil
 		}
 		return bt.insertNode(root.right, newNode)
 	}
-	
+
 	// Key already exists (should not happen due to the check in Insert)
-	return fmt.Errorf("record with key %d already exists", newNode.key)
+	return fmt.Errorf("record with key %v already exists", newNode.key)
 }
 
 // Search finds a record by key
-func (bt *BTreeSimple) Search(key int) *interfaces.Record {
+func (bt *BTreeSimple) Search(key Key) *interfaces.Record {
 	if bt.root == nil {
 		return nil
 	}
-	
+
 	node := bt.searchNode(bt.root, key)
 	if node == nil {
 		return nil
 	}
-	
+
 	return node.record
 }
 
 // searchNode recursively searches for a node by key
-func (bt *BTreeSimple) searchNode(root *NodeSimple, key int) *NodeSimple {
+func (bt *BTreeSimple) searchNode(root *NodeSimple, key Key) *NodeSimple {
 	if root == nil {
 		return nil
 	}
-	
-	if key == root.key {
+
+	cmp := compareKeys(key, root.key, bt.collations)
+	if cmp == 0 {
 		return root
-	} else if key < root.key {
+	} else if cmp < 0 {
 		return bt.searchNode(root.left, key)
 	} else {
 		return bt.searchNode(root.right, key)
@@ -408,7 +697,7 @@ func (bt *BTreeSimple) inOrderTraversal(root *NodeSimple, records *[]*interfaces
 	if root == nil {
 		return
 	}
-	
+
 	bt.inOrderTraversal(root.left, records)
 	*records = append(*records, root.record)
 	bt.inOrderTraversal(root.right, records)
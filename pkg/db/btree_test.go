@@ -0,0 +1,178 @@
+package db
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"sqlight/pkg/interfaces"
+)
+
+func TestBTreeSearchFindsEveryKey(t *testing.T) {
+	tree := NewBTreeWithFanOut(4)
+	records := make(map[int]*interfaces.Record)
+	for i := 1; i <= 50; i++ {
+		records[i] = &interfaces.Record{Columns: map[string]interface{}{"id": i}}
+		tree.Insert(Key{i}, records[i])
+	}
+
+	// Separator keys are the first keys of their right-hand leaves
+	for i := 1; i <= 50; i++ {
+		if got := tree.Search(Key{i}); got != records[i] {
+			t.Errorf("Search(%d) = %v, expected record %d", i, got, i)
+		}
+	}
+	if got := tree.Search(Key{51}); got != nil {
+		t.Errorf("Search(51) = %v, expected nil", got)
+	}
+	if got := len(tree.Scan()); got != 50 {
+		t.Errorf("Scan returned %d records, expected 50", got)
+	}
+}
+
+func TestBTreeDuplicateKeys(t *testing.T) {
+	tree := NewBTreeWithFanOut(4)
+	var records []*interfaces.Record
+	for i := 0; i < 20; i++ {
+		record := &interfaces.Record{Columns: map[string]interface{}{"n": i}}
+		records = append(records, record)
+		tree.Insert(Key{i % 3, "x"}, record)
+	}
+
+	// Equal keys keep their insertion order
+	count := 0
+	cursor := NewCursor(tree)
+	cursor.SetBounds(Key{1}, Key{1})
+	for cursor.Next() {
+		if expected := records[1+3*count]; cursor.Current() != expected {
+			t.Errorf("Entry %d for key 1 is %v, expected %v", count, cursor.Current().Columns, expected.Columns)
+		}
+		count++
+	}
+	if count != 7 {
+		t.Errorf("Found %d entries for key 1, expected 7", count)
+	}
+
+	// DeleteRecord removes only the given record
+	if !tree.DeleteRecord(Key{1, "x"}, records[10]) {
+		t.Fatal("DeleteRecord did not find record 10")
+	}
+	if tree.DeleteRecord(Key{1, "x"}, records[10]) {
+		t.Error("DeleteRecord found record 10 twice")
+	}
+	if got := len(tree.Scan()); got != 19 {
+		t.Errorf("Scan returned %d records after delete, expected 19", got)
+	}
+}
+
+func TestBTreeRandomInsertAndDelete(t *testing.T) {
+	for _, fanOut := range []int{3, 4, 5, 8, DefaultFanOut} {
+		t.Run(fmt.Sprintf("fan-out %d", fanOut), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(int64(fanOut)))
+			tree := NewBTreeWithFanOut(fanOut)
+
+			// The model holds the records in tree order: by key, then by
+			// insertion for equal keys
+			var model []*interfaces.Record
+			keyOf := func(record *interfaces.Record) Key {
+				return Key{record.Columns["k"]}
+			}
+
+			for op := 0; op < 3000; op++ {
+				if len(model) == 0 || rng.Intn(100) < 55 {
+					record := &interfaces.Record{Columns: map[string]interface{}{"k": rng.Intn(200), "op": op}}
+					tree.Insert(keyOf(record), record)
+					pos := len(model)
+					for pos > 0 && compareKeys(keyOf(model[pos-1]), keyOf(record), nil) > 0 {
+						pos--
+					}
+					model = append(model[:pos], append([]*interfaces.Record{record}, model[pos:]...)...)
+				} else {
+					pos := rng.Intn(len(model))
+					if !tree.DeleteRecord(keyOf(model[pos]), model[pos]) {
+						t.Fatalf("op %d: DeleteRecord did not find %v", op, model[pos].Columns)
+					}
+					model = append(model[:pos], model[pos+1:]...)
+				}
+
+				if err := tree.Check(); err != nil {
+					t.Fatalf("op %d: %v", op, err)
+				}
+				if op%100 == 0 {
+					got := tree.Scan()
+					if len(got) != len(model) {
+						t.Fatalf("op %d: Scan returned %d records, expected %d", op, len(got), len(model))
+					}
+					for i := range got {
+						if got[i] != model[i] {
+							t.Fatalf("op %d: record %d is %v, expected %v", op, i, got[i].Columns, model[i].Columns)
+						}
+					}
+				}
+			}
+
+			// Drain the tree down to an empty root
+			for len(model) > 0 {
+				tree.Delete(keyOf(model[0]))
+				model = model[1:]
+				if err := tree.Check(); err != nil {
+					t.Fatalf("draining with %d records left: %v", len(model), err)
+				}
+				if got := tree.Scan(); len(got) != len(model) || (len(got) > 0 && got[0] != model[0]) {
+					t.Fatalf("Scan returned %d records, expected %d", len(got), len(model))
+				}
+			}
+			if !tree.Root.IsLeaf || len(tree.Root.Keys) != 0 {
+				t.Errorf("Expected an empty leaf root, got %d keys", len(tree.Root.Keys))
+			}
+		})
+	}
+}
+
+func TestBTreeCheckFindsCorruption(t *testing.T) {
+	build := func() *BTree {
+		tree := NewBTreeWithFanOut(4)
+		for i := 0; i < 40; i++ {
+			tree.Insert(Key{i}, &interfaces.Record{})
+		}
+		if err := tree.Check(); err != nil {
+			t.Fatalf("Check failed on a valid tree: %v", err)
+		}
+		return tree
+	}
+	firstLeaf := func(tree *BTree) *Node {
+		node := tree.Root
+		for !node.IsLeaf {
+			node = node.Children[0]
+		}
+		return node
+	}
+
+	corruptions := map[string]func(tree *BTree){
+		"unordered keys": func(tree *BTree) {
+			leaf := firstLeaf(tree)
+			leaf.Keys[0], leaf.Keys[1] = leaf.Keys[1], leaf.Keys[0]
+		},
+		"key outside separators": func(tree *BTree) {
+			firstLeaf(tree).Next.Keys[0] = Key{-1}
+		},
+		"underfull leaf": func(tree *BTree) {
+			leaf := firstLeaf(tree)
+			leaf.Keys, leaf.Records = leaf.Keys[:0], leaf.Records[:0]
+		},
+		"broken leaf link": func(tree *BTree) {
+			leaf := firstLeaf(tree)
+			leaf.Next = leaf.Next.Next
+		},
+		"wrong parent": func(tree *BTree) {
+			firstLeaf(tree).Parent = tree.Root.Children[1]
+		},
+	}
+	for name, corrupt := range corruptions {
+		tree := build()
+		corrupt(tree)
+		if err := tree.Check(); err == nil {
+			t.Errorf("Check did not detect %s", name)
+		}
+	}
+}
@@ -2,32 +2,154 @@ package db
 
 import "sqlight/pkg/interfaces"
 
-// Cursor represents a database cursor
+// Cursor iterates over the entries of a B+ tree in key order, following the
+// links between leaves, so that a range of keys can be read without visiting
+// the rest of the tree. Bounds are inclusive and compare by prefix: an upper
+// bound of {5} includes the key {5, "x"}.
 type Cursor struct {
-	table  *Table
-	pos    int
+	tree       *BTree
+	node       *Node
+	pos        int
+	lo, hi     Key
+	positioned bool
 }
 
-// NewCursor creates a new cursor for the table
-func NewCursor(t *Table) *Cursor {
+// NewCursor creates a new cursor over the tree. It is positioned before the
+// first entry, so Next moves to the first entry and Prev to the last.
+func NewCursor(tree *BTree) *Cursor {
 	return &Cursor{
-		table: t,
-		pos:   -1,
+		tree: tree,
+		pos:  -1,
 	}
 }
 
-// Next moves the cursor to the next record
+// SetBounds limits the cursor to the keys from lo to hi; a nil bound leaves
+// that end open. The cursor is moved back before the first entry.
+func (c *Cursor) SetBounds(lo, hi Key) {
+	c.lo, c.hi = lo, hi
+	c.node, c.pos, c.positioned = nil, -1, false
+}
+
+// First moves to the first entry within the bounds
+func (c *Cursor) First() bool {
+	if c.lo != nil {
+		return c.SeekGE(c.lo)
+	}
+	node := c.tree.Root
+	for node != nil && !node.IsLeaf {
+		node = node.Children[0]
+	}
+	return c.forward(node, 0)
+}
+
+// Last moves to the last entry within the bounds
+func (c *Cursor) Last() bool {
+	if c.hi != nil {
+		node, pos := c.tree.seekAfter(c.hi)
+		return c.backward(node, pos-1)
+	}
+	node := c.tree.Root
+	for node != nil && !node.IsLeaf {
+		node = node.Children[len(node.Children)-1]
+	}
+	if node == nil {
+		return c.backward(nil, -1)
+	}
+	return c.backward(node, len(node.Keys)-1)
+}
+
+// SeekGE moves to the first entry whose key is not less than key
+func (c *Cursor) SeekGE(key Key) bool {
+	if c.lo != nil && c.tree.compare(key, c.lo) < 0 {
+		key = c.lo
+	}
+	node, pos := c.tree.seek(key)
+	return c.forward(node, pos)
+}
+
+// Seek moves to the first entry whose key starts with key, reporting whether
+// there is one. When there is not, the cursor is left at the entry SeekGE
+// would find.
+func (c *Cursor) Seek(key Key) bool {
+	return c.SeekGE(key) && c.tree.compare(prefix(c.Key(), len(key)), key) == 0
+}
+
+// Next moves to the next entry. An unpositioned cursor moves to the first.
 func (c *Cursor) Next() bool {
-	records := c.table.GetRecords()
-	c.pos++
-	return c.pos < len(records)
+	if !c.positioned {
+		return c.First()
+	}
+	if c.node == nil {
+		return false
+	}
+	return c.forward(c.node, c.pos+1)
+}
+
+// Prev moves to the previous entry. An unpositioned cursor moves to the last.
+func (c *Cursor) Prev() bool {
+	if !c.positioned {
+		return c.Last()
+	}
+	if c.node == nil {
+		return false
+	}
+	return c.backward(c.node, c.pos-1)
+}
+
+// Valid reports whether the cursor is on an entry
+func (c *Cursor) Valid() bool {
+	return c.node != nil
 }
 
-// Current returns the current record
+// Key returns the key of the current entry, or nil
+func (c *Cursor) Key() Key {
+	if c.node == nil {
+		return nil
+	}
+	return c.node.Keys[c.pos]
+}
+
+// Current returns the current record, or nil
 func (c *Cursor) Current() *interfaces.Record {
-	records := c.table.GetRecords()
-	if c.pos >= 0 && c.pos < len(records) {
-		return records[c.pos]
+	if c.node == nil {
+		return nil
+	}
+	return c.node.Records[c.pos]
+}
+
+// forward settles on the entry at pos of a leaf or, if pos is past its end,
+// the first entry of a following leaf, then checks the upper bound
+func (c *Cursor) forward(node *Node, pos int) bool {
+	for node != nil && pos >= len(node.Keys) {
+		node, pos = node.Next, 0
+	}
+	c.node, c.pos, c.positioned = node, pos, true
+	if node != nil && c.hi != nil && c.tree.compare(prefix(c.Key(), len(c.hi)), c.hi) > 0 {
+		c.node = nil
+	}
+	return c.node != nil
+}
+
+// backward settles on the entry at pos of a leaf or, if pos is before its
+// start, the last entry of a preceding leaf, then checks the lower bound
+func (c *Cursor) backward(node *Node, pos int) bool {
+	for node != nil && pos < 0 {
+		node = node.Prev
+		if node != nil {
+			pos = len(node.Keys) - 1
+		}
+	}
+	c.node, c.pos, c.positioned = node, pos, true
+	if node != nil && c.lo != nil && c.tree.compare(c.Key(), c.lo) < 0 {
+		c.node = nil
+	}
+	return c.node != nil
+}
+
+// prefix returns the first n values of a key, or the whole key if it is shorter
+func prefix(key Key, n int) Key {
+	if len(key) > n {
+		return key[:n]
 	}
-	return nil
+	return key
 }
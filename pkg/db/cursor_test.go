@@ -0,0 +1,119 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"sqlight/pkg/interfaces"
+)
+
+// cursorTree builds a tree of small nodes holding the even keys from 2 to
+// 40, less a run of deleted keys
+func cursorTree() *BTree {
+	tree := NewBTreeWithFanOut(3)
+	for i := 2; i <= 40; i += 2 {
+		tree.Insert(Key{i}, &interfaces.Record{Columns: map[string]interface{}{"id": i}})
+	}
+	for _, i := range []int{10, 12, 14, 16, 30} {
+		tree.Delete(Key{i})
+	}
+	return tree
+}
+
+// collect steps a cursor with step until it stops, returning the keys seen
+func collect(c *Cursor, step func() bool) []int {
+	var keys []int
+	for step() {
+		keys = append(keys, c.Key()[0].(int))
+	}
+	return keys
+}
+
+func TestCursorIteration(t *testing.T) {
+	tree := cursorTree()
+	forward := []int{2, 4, 6, 8, 18, 20, 22, 24, 26, 28, 32, 34, 36, 38, 40}
+
+	c := NewCursor(tree)
+	if got := collect(c, c.Next); !reflect.DeepEqual(got, forward) {
+		t.Errorf("Next visited %v, expected %v", got, forward)
+	}
+	if c.Valid() || c.Next() || c.Prev() {
+		t.Error("Expected an exhausted cursor to stay invalid")
+	}
+
+	c = NewCursor(tree)
+	backward := collect(c, c.Prev)
+	for i, j := 0, len(backward)-1; i < j; i, j = i+1, j-1 {
+		backward[i], backward[j] = backward[j], backward[i]
+	}
+	if !reflect.DeepEqual(backward, forward) {
+		t.Errorf("Prev visited %v in reverse, expected %v", backward, forward)
+	}
+
+	// Stepping back and forth across the deleted keys
+	if !c.Seek(Key{18}) || !c.Prev() || c.Key()[0] != 8 || !c.Next() || c.Key()[0] != 18 {
+		t.Errorf("Expected to step from 18 back to 8 and forward to 18, at %v", c.Key())
+	}
+}
+
+func TestCursorSeek(t *testing.T) {
+	tree := cursorTree()
+	c := NewCursor(tree)
+
+	if !c.Seek(Key{20}) || c.Current().Columns["id"] != 20 {
+		t.Errorf("Seek(20) is at %v, expected 20", c.Key())
+	}
+	if c.Seek(Key{12}) || c.Key()[0] != 18 {
+		t.Errorf("Seek(12) found a key or is not at 18: %v", c.Key())
+	}
+	if !c.SeekGE(Key{29}) || c.Key()[0] != 32 {
+		t.Errorf("SeekGE(29) is at %v, expected 32", c.Key())
+	}
+	if c.SeekGE(Key{41}) || c.Valid() || c.Current() != nil {
+		t.Errorf("SeekGE(41) is at %v, expected no entry", c.Key())
+	}
+}
+
+func TestCursorBounds(t *testing.T) {
+	tree := cursorTree()
+	c := NewCursor(tree)
+
+	c.SetBounds(Key{7}, Key{24})
+	if got, expected := collect(c, c.Next), []int{8, 18, 20, 22, 24}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Next within [7, 24] visited %v, expected %v", got, expected)
+	}
+	c.SetBounds(Key{7}, Key{24})
+	if got, expected := collect(c, c.Prev), []int{24, 22, 20, 18, 8}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Prev within [7, 24] visited %v, expected %v", got, expected)
+	}
+	if !c.SeekGE(Key{1}) || c.Key()[0] != 8 {
+		t.Errorf("SeekGE below the lower bound is at %v, expected 8", c.Key())
+	}
+
+	c.SetBounds(nil, Key{5})
+	if got, expected := collect(c, c.Next), []int{2, 4}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Next up to 5 visited %v, expected %v", got, expected)
+	}
+}
+
+func TestCursorPrefixBounds(t *testing.T) {
+	tree := NewBTree()
+	for _, key := range []Key{{1, "a"}, {2, "b"}, {2, "a"}, {3, "c"}, {2, nil}, {1, "z"}} {
+		tree.Insert(key, &interfaces.Record{Columns: map[string]interface{}{"key": key.String()}})
+	}
+
+	c := NewCursor(tree)
+	c.SetBounds(Key{2}, Key{2})
+	var got []string
+	for c.Next() {
+		got = append(got, c.Current().Columns["key"].(string))
+	}
+	if expected := []string{"(2, <nil>)", "(2, a)", "(2, b)"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Keys with prefix 2 are %v, expected %v", got, expected)
+	}
+
+	c.SetBounds(nil, nil)
+	if !c.Seek(Key{1}) || c.Key()[1] != "a" {
+		t.Errorf("Seek(1) is at %v, expected (1, a)", c.Key())
+	}
+}
@@ -1,43 +1,172 @@
 package db
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
 
 	"sqlight/pkg/interfaces"
+	"sqlight/pkg/storage"
 )
 
 // Database represents a SQLite database
 type Database struct {
 	mutex         sync.RWMutex
 	tables        map[string]*interfaces.Table
-	path          string
+	path          string // the file the database was opened from, if any
 	inTransaction bool
 	snapshot      map[string]*interfaces.Table
+	stores        map[*interfaces.Table]*rowStore
+	indexCache    map[*interfaces.Table][]*tableIndex
+	unloaded      map[*interfaces.Table]string // tables not yet read, by saved name
+	clock         uint64                       // counts row store uses
+	backend       storage.Backend              // nil once the database is closed
+	backup        bool
 }
 
-// NewDatabase creates a new database instance
-func NewDatabase(path string) (*Database, error) {
+// NewDatabase opens a database stored in a backend, reading its catalog;
+// the rows of each table are read when it is first used
+func NewDatabase(backend storage.Backend) (*Database, error) {
 	db := &Database{
-		tables: make(map[string]*interfaces.Table),
-		path:   path,
+		tables:   make(map[string]*interfaces.Table),
+		unloaded: make(map[*interfaces.Table]string),
+		backend:  backend,
 	}
+	if err := backend.Open(); err != nil {
+		return nil, err
+	}
+	if err := db.load(); err != nil {
+		backend.Close()
+		return nil, err
+	}
+	return db, nil
+}
 
-	// Load existing database if file exists
-	if _, err := os.Stat(path); err == nil {
-		if err := db.load(); err != nil {
-			return nil, err
+// Open opens the database in the file at path, with the backend for the
+// format it was written in (see storage.ForPath)
+func Open(path string) (*Database, error) {
+	return OpenWith(path, Options{})
+}
+
+// OpenEncrypted opens the database in the file at path like Open, with the
+// passphrase it is encrypted under: a new file is created encrypted, and an
+// existing file must have been encrypted under the passphrase. An empty
+// passphrase opens a file that is not encrypted.
+func OpenEncrypted(path, passphrase string) (*Database, error) {
+	return OpenWith(path, Options{Passphrase: passphrase})
+}
+
+// Options select how a database file is created and opened
+type Options struct {
+	// Passphrase encrypts a new file, and opens an encrypted one (see
+	// OpenEncrypted)
+	Passphrase string
+
+	// Compress creates a new JSON file compressed. An existing file is
+	// read, and kept, in the format it was written in whatever the option.
+	Compress bool
+}
+
+// OpenWith opens the database in the file at path like Open, with options
+func OpenWith(path string, options Options) (*Database, error) {
+	backend := storage.ForPath(path)
+	if options.Passphrase != "" {
+		b, ok := backend.(interface{ SetPassphrase(string) })
+		if !ok {
+			return nil, fmt.Errorf("%s cannot be encrypted", path)
 		}
+		b.SetPassphrase(options.Passphrase)
 	}
-
+	if options.Compress {
+		if b, ok := backend.(interface{ SetCompression(bool) }); ok {
+			b.SetCompression(true)
+		} else if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s cannot be compressed: only JSON files can", path)
+		}
+	}
+	db, err := NewDatabase(backend)
+	if err != nil {
+		return nil, err
+	}
+	db.path = path
 	return db, nil
 }
 
+// KeepBackups sets whether saving a JSON database keeps the previous
+// version of the file in a ".bak" file next to it
+func (d *Database) KeepBackups(keep bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.backup = keep
+	if b, ok := d.backend.(interface{ KeepBackups(bool) }); ok {
+		b.KeepBackups(keep)
+	}
+}
+
+// Rekey changes the passphrase the database file is encrypted under,
+// rewriting the file; an empty passphrase leaves it unencrypted
+func (d *Database) Rekey(passphrase string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.rekey(passphrase)
+}
+
+// rekey changes the passphrase of the database file
+func (d *Database) rekey(passphrase string) error {
+	if d.backend == nil {
+		return fmt.Errorf("database is closed")
+	}
+	if d.inTransaction {
+		return fmt.Errorf("cannot rekey inside a transaction")
+	}
+	rekeyer, ok := d.backend.(storage.Rekeyer)
+	if !ok {
+		return fmt.Errorf("the database is not stored in a file that can be encrypted")
+	}
+	return rekeyer.Rekey(passphrase)
+}
+
+// ReadOnly returns why the database cannot be changed, such as a file that
+// cannot be written or that failed to upgrade from an older format version,
+// or nil if it can
+func (d *Database) ReadOnly() error {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.readOnly()
+}
+
+// readOnly returns why the database cannot be changed
+func (d *Database) readOnly() error {
+	if b, ok := d.backend.(interface{ ReadOnly() error }); ok {
+		return b.ReadOnly()
+	}
+	return nil
+}
+
+// writes reports whether a statement changes the database
+func writes(stmt interfaces.Statement) bool {
+	switch stmt.(type) {
+	case *interfaces.SelectStatement, *interfaces.DescribeStatement, *interfaces.PragmaStatement:
+		return false
+	}
+	return true
+}
+
+// Close closes the database's backend
+func (d *Database) Close() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.backend == nil {
+		return nil
+	}
+	err := d.backend.Close()
+	d.backend = nil
+	return err
+}
+
 // Execute executes a SQL statement
 func (d *Database) Execute(stmt interfaces.Statement) (*interfaces.Result, error) {
 	switch stmt.(type) {
@@ -50,7 +179,11 @@ func (d *Database) Execute(stmt interfaces.Statement) (*interfaces.Result, error
 	default:
 		d.mutex.Lock()
 		defer d.mutex.Unlock()
+		defer d.evict()
 
+		if err := d.readOnly(); err != nil && writes(stmt) {
+			return nil, err
+		}
 		switch s := stmt.(type) {
 		case *interfaces.CreateStatement:
 			return d.executeCreate(s)
@@ -60,10 +193,20 @@ func (d *Database) Execute(stmt interfaces.Statement) (*interfaces.Result, error
 			return d.executeSelect(s)
 		case *interfaces.DropStatement:
 			return d.executeDrop(s)
+		case *interfaces.AlterTableStatement:
+			return d.executeAlterTable(s)
+		case *interfaces.CreateIndexStatement:
+			return d.executeCreateIndex(s)
+		case *interfaces.DropIndexStatement:
+			return d.executeDropIndex(s)
 		case *interfaces.DescribeStatement:
 			return d.executeDescribe(s)
 		case *interfaces.DeleteStatement:
 			return d.executeDelete(s)
+		case *interfaces.UpdateStatement:
+			return d.executeUpdate(s)
+		case *interfaces.PragmaStatement:
+			return d.executePragma(s)
 		default:
 			return nil, fmt.Errorf("unsupported statement type: %T", stmt)
 		}
@@ -74,28 +217,29 @@ func (d *Database) Execute(stmt interfaces.Statement) (*interfaces.Result, error
 func (d *Database) executeBeginTransaction() (*interfaces.Result, error) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
+	defer d.evict()
 
 	if d.inTransaction {
 		return nil, fmt.Errorf("transaction already in progress")
 	}
 
-	// Create a deep copy of current database state
+	// Create a deep copy of current database state; the copied rows keep
+	// their rowids so that committing saves only the rows that changed
 	d.snapshot = make(map[string]*interfaces.Table)
 	for name, table := range d.tables {
 		newTable := &interfaces.Table{
 			Name:    table.Name,
 			Columns: make([]interfaces.Column, len(table.Columns)),
-			Records: make([]*interfaces.Record, len(table.Records)),
 		}
 		copy(newTable.Columns, table.Columns)
-		for i, record := range table.Records {
-			newRecord := &interfaces.Record{
-				Columns: make(map[string]interface{}),
-			}
-			for k, v := range record.Columns {
-				newRecord.Columns[k] = v
-			}
-			newTable.Records[i] = newRecord
+		for _, index := range table.Indexes {
+			index.Columns = append([]string(nil), index.Columns...)
+			newTable.Indexes = append(newTable.Indexes, index)
+		}
+		if err := d.cloneStore(table, newTable); err != nil {
+			d.snapshot = nil
+			d.pruneTables()
+			return nil, fmt.Errorf("reading table %s: %v", name, err)
 		}
 		d.snapshot[name] = newTable
 	}
@@ -111,18 +255,21 @@ func (d *Database) executeBeginTransaction() (*interfaces.Result, error) {
 func (d *Database) executeCommit() (*interfaces.Result, error) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
+	defer d.evict()
 
 	if !d.inTransaction {
 		return nil, fmt.Errorf("no transaction in progress")
 	}
 
-	// Clear snapshot and commit by saving to disk
+	// Save the transaction's tables before they replace the database's, so
+	// that a failed save leaves the transaction open to retry or roll back
+	if err := d.saveTables(d.snapshot); err != nil {
+		return nil, err
+	}
 	d.tables = d.snapshot
 	d.snapshot = nil
 	d.inTransaction = false
-	if err := d.save(); err != nil {
-		return nil, err
-	}
+	d.pruneTables()
 
 	return &interfaces.Result{
 		Success: true,
@@ -134,6 +281,7 @@ func (d *Database) executeCommit() (*interfaces.Result, error) {
 func (d *Database) executeRollback() (*interfaces.Result, error) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
+	defer d.evict()
 
 	if !d.inTransaction {
 		return nil, fmt.Errorf("no transaction in progress")
@@ -142,6 +290,7 @@ func (d *Database) executeRollback() (*interfaces.Result, error) {
 	// Restore from snapshot
 	d.snapshot = nil
 	d.inTransaction = false
+	d.pruneTables()
 
 	return &interfaces.Result{
 		Success: true,
@@ -151,19 +300,26 @@ func (d *Database) executeRollback() (*interfaces.Result, error) {
 
 // executeCreate handles CREATE TABLE statements
 func (d *Database) executeCreate(stmt *interfaces.CreateStatement) (*interfaces.Result, error) {
-	if _, exists := d.tables[stmt.TableName]; exists {
+	if _, _, err := d.getTable(stmt.TableName, true); err == nil {
 		return nil, fmt.Errorf("table %s already exists", stmt.TableName)
 	}
 
 	// Validate constraints
 	primaryKeyCount := 0
-	for _, col := range stmt.Columns {
+	for i, col := range stmt.Columns {
 		if col.PrimaryKey {
 			primaryKeyCount++
 			if primaryKeyCount > 1 {
 				return nil, fmt.Errorf("table can only have one PRIMARY KEY")
 			}
 		}
+
+		// Store defaults with the column's type
+		value, err := getColumnValue(&col, col.Default)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default for column %s: %v", col.Name, err)
+		}
+		stmt.Columns[i].Default = value
 	}
 
 	// Create table
@@ -175,7 +331,7 @@ func (d *Database) executeCreate(stmt *interfaces.CreateStatement) (*interfaces.
 
 	// Add table to transaction if in transaction, otherwise add to database
 	if d.inTransaction {
-		d.tables[stmt.TableName] = table
+		d.snapshot[stmt.TableName] = table
 	} else {
 		d.tables[stmt.TableName] = table
 		if err := d.save(); err != nil {
@@ -191,6 +347,11 @@ func (d *Database) executeCreate(stmt *interfaces.CreateStatement) (*interfaces.
 
 // getColumnValue converts a value to the appropriate type based on column definition
 func getColumnValue(colDef *interfaces.Column, value interface{}) (interface{}, error) {
+	// NULL is valid for every type; NOT NULL is enforced separately
+	if value == nil {
+		return nil, nil
+	}
+
 	switch colDef.Type {
 	case "INT", "INTEGER":
 		switch v := value.(type) {
@@ -210,149 +371,106 @@ func getColumnValue(colDef *interfaces.Column, value interface{}) (interface{},
 	}
 }
 
-// compareValues compares two values based on their types
-func compareValues(v1, v2 interface{}) bool {
-	// Handle nil values
-	if v1 == nil && v2 == nil {
-		return true
-	}
-	if v1 == nil || v2 == nil {
-		return false
-	}
-
-	switch val1 := v1.(type) {
-	case int:
-		switch val2 := v2.(type) {
-		case int:
-			return val1 == val2
-		case float64:
-			return float64(val1) == val2
-		case string:
-			if num, err := strconv.Atoi(val2); err == nil {
-				return val1 == num
-			}
-		}
-	case float64:
-		switch val2 := v2.(type) {
-		case float64:
-			return val1 == val2
-		case int:
-			return val1 == float64(val2)
-		case string:
-			if num, err := strconv.ParseFloat(val2, 64); err == nil {
-				return val1 == num
-			}
-		}
-	case string:
-		switch val2 := v2.(type) {
-		case string:
-			// For string comparison, use exact matching
-			return val1 == val2
-		case int:
-			if num, err := strconv.Atoi(val1); err == nil {
-				return num == val2
-			}
-		case float64:
-			if num, err := strconv.ParseFloat(val1, 64); err == nil {
-				return num == val2
-			}
-		}
+// executeInsert handles INSERT statements. Every row is validated before
+// any is added, so a failing row leaves the table unchanged.
+func (d *Database) executeInsert(stmt *interfaces.InsertStatement) (result *interfaces.Result, err error) {
+	table, tableName, err := d.getTable(stmt.TableName, true)
+	if err != nil {
+		return nil, err
 	}
-	return false
-}
 
-// executeInsert handles INSERT statements
-func (d *Database) executeInsert(stmt *interfaces.InsertStatement) (*interfaces.Result, error) {
-	table, tableName, err := d.getTable(stmt.TableName, true)
+	// Rows enter the table and its indexes as they are validated, so that
+	// later rows are checked against earlier ones; if a row fails, the rows
+	// added so far are removed again and the indexes are rebuilt from the
+	// restored table when next used
+	st, err := d.store(table)
 	if err != nil {
 		return nil, err
 	}
+	var newRecords []*interfaces.Record
+	defer func() {
+		if err != nil {
+			for _, record := range newRecords {
+				st.delete(record)
+			}
+			d.invalidateIndexes(table)
+		}
+	}()
 
 	// Create column name mapping for case-insensitive comparison
 	columnMap := d.getColumnMap(table)
 
-	// Create a new record with the provided values
-	record := &interfaces.Record{
-		Columns: make(map[string]interface{}),
-	}
-
-	// Validate column count
-	if len(stmt.Columns) != len(stmt.Values) {
-		return nil, fmt.Errorf("column count (%d) does not match value count (%d)", len(stmt.Columns), len(stmt.Values))
+	// Resolve the target columns; without a column list every column is
+	// set, in table order
+	targets := make([]*interfaces.Column, 0, len(table.Columns))
+	if len(stmt.Columns) == 0 {
+		for i := range table.Columns {
+			targets = append(targets, &table.Columns[i])
+		}
 	}
-
-	// First pass: validate and set column values
-	for i, col := range stmt.Columns {
+	for _, col := range stmt.Columns {
 		actualCol, exists := columnMap[strings.ToLower(col)]
 		if !exists {
 			return nil, fmt.Errorf("column %s does not exist", col)
 		}
-
-		// Get column definition
-		var colDef *interfaces.Column
-		for _, c := range table.Columns {
-			if c.Name == actualCol {
-				colDef = &c
+		for i := range table.Columns {
+			if table.Columns[i].Name == actualCol {
+				targets = append(targets, &table.Columns[i])
 				break
 			}
 		}
-		if colDef == nil {
-			return nil, fmt.Errorf("column %s not found in table definition", actualCol)
-		}
+	}
 
-		// Convert and validate value
-		value, err := getColumnValue(colDef, stmt.Values[i])
-		if err != nil {
-			return nil, fmt.Errorf("invalid value for column %s: %v", actualCol, err)
+	rows := stmt.Rows
+	if stmt.Select != nil {
+		if rows, err = d.selectValues(stmt.Select); err != nil {
+			return nil, err
 		}
-		record.Columns[actualCol] = value
 	}
 
-	// Second pass: validate constraints
-	for _, col := range table.Columns {
-		value, exists := record.Columns[col.Name]
-
-		// Check NOT NULL constraint
-		if !col.Nullable && (!exists || value == nil) {
-			return nil, fmt.Errorf("column %s cannot be null", col.Name)
+	for _, values := range rows {
+		// Validate column count
+		if len(targets) != len(values) {
+			return nil, fmt.Errorf("column count (%d) does not match value count (%d)", len(targets), len(values))
 		}
 
-		// Check PRIMARY KEY and UNIQUE constraints
-		if (col.PrimaryKey || col.Unique) && exists && value != nil {
-			for _, existingRecord := range table.Records {
-				existingValue := existingRecord.Columns[col.Name]
-				if existingValue == nil {
-					continue
-				}
-
-				// For string values, do exact comparison
-				if strValue, ok := value.(string); ok {
-					if strExistingValue, ok := existingValue.(string); ok {
-						if strValue == strExistingValue {
-							constraint := "UNIQUE"
-							if col.PrimaryKey {
-								constraint = "PRIMARY KEY"
-							}
-							return nil, fmt.Errorf("duplicate value in %s column %s", constraint, col.Name)
-						}
-						continue
-					}
-				}
+		// Create a new record with the provided values, starting from the
+		// column defaults
+		record := &interfaces.Record{
+			Columns: make(map[string]interface{}),
+		}
+		for _, col := range table.Columns {
+			if col.Default != nil {
+				record.Columns[col.Name] = col.Default
+			}
+		}
+		for i, colDef := range targets {
+			// Convert and validate value
+			value, err := getColumnValue(colDef, values[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for column %s: %v", colDef.Name, err)
+			}
+			record.Columns[colDef.Name] = value
+		}
 
-				// For other types use compareValues
-				if compareValues(value, existingValue) {
-					constraint := "UNIQUE"
-					if col.PrimaryKey {
-						constraint = "PRIMARY KEY"
-					}
-					return nil, fmt.Errorf("duplicate value in %s column %s", constraint, col.Name)
-				}
+		// Check NOT NULL constraints
+		for _, col := range table.Columns {
+			if value, exists := record.Columns[col.Name]; !col.Nullable && (!exists || value == nil) {
+				return nil, fmt.Errorf("column %s cannot be null", col.Name)
 			}
 		}
-	}
 
-	// Add record to table
-	table.Records = append(table.Records, record)
+		// Check PRIMARY KEY and UNIQUE constraints against the table and
+		// the rows before this one
+		if err := d.indexInsert(table, record); err != nil {
+			return nil, err
+		}
+		st.insert(record)
+		newRecords = append(newRecords, record)
+		if err := d.checkUnique(table, record); err != nil {
+			return nil, err
+		}
+	}
 
 	// Update the appropriate table map
 	if d.inTransaction {
@@ -364,204 +482,34 @@ func (d *Database) executeInsert(stmt *interfaces.InsertStatement) (*interfaces.
 		}
 	}
 
+	message := fmt.Sprintf("%d record(s) inserted successfully", len(newRecords))
+	if len(newRecords) == 1 {
+		message = "Record inserted successfully"
+	}
 	return &interfaces.Result{
-		Success: true,
-		Message: "Record inserted successfully",
+		Success:      true,
+		Message:      message,
+		RowsAffected: len(newRecords),
 	}, nil
 }
 
-// executeSelect handles SELECT statements
-func (d *Database) executeSelect(stmt *interfaces.SelectStatement) (*interfaces.Result, error) {
-	table, _, err := d.getTable(stmt.TableName, true)
+// selectValues runs the SELECT of an INSERT ... SELECT and returns its rows
+// as value lists in result column order
+func (d *Database) selectValues(stmt *interfaces.SelectStatement) ([][]interface{}, error) {
+	result, err := d.query(stmt, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get column names case-insensitively
-	columnMap := d.getColumnMap(table)
-
-	// Prepare result columns
-	columns := make([]string, 0)
-	if len(stmt.Columns) == 0 || stmt.Columns[0] == "*" {
-		// For SELECT *, use the original column order from table definition
-		for _, col := range table.Columns {
-			columns = append(columns, col.Name)
-		}
-	} else {
-		for _, col := range stmt.Columns {
-			actualCol, exists := columnMap[strings.ToLower(col)]
-			if !exists {
-				return nil, fmt.Errorf("column %s does not exist", col)
-			}
-			columns = append(columns, actualCol)
-		}
-	}
-
-	// Filter records based on WHERE conditions
-	var filteredRecords []*interfaces.Record
-	if len(stmt.Where) == 0 {
-		// If no WHERE clause, include all records
-		filteredRecords = make([]*interfaces.Record, len(table.Records))
-		copy(filteredRecords, table.Records)
-	} else {
-		// Apply WHERE conditions
-		for _, record := range table.Records {
-			matches := true
-			for col, value := range stmt.Where {
-				actualCol, exists := columnMap[strings.ToLower(col)]
-				if !exists {
-					return nil, fmt.Errorf("column %s does not exist", col)
-				}
-
-				recordValue := record.Columns[actualCol]
-				if !compareValues(recordValue, value) {
-					matches = false
-					break
-				}
-			}
-			if matches {
-				filteredRecords = append(filteredRecords, record)
-			}
-		}
-	}
-
-	// Create result records with only the requested columns
-	resultRecords := make([]*interfaces.Record, 0, len(filteredRecords))
-	for _, record := range filteredRecords {
-		resultRecord := &interfaces.Record{
-			Columns: make(map[string]interface{}),
-		}
-		for _, col := range columns {
-			resultRecord.Columns[col] = record.Columns[col]
-		}
-		resultRecords = append(resultRecords, resultRecord)
-	}
-
-	return &interfaces.Result{
-		Success:  true,
-		Message:  fmt.Sprintf("Found %d record(s)", len(resultRecords)),
-		Records:  resultRecords,
-		Columns:  columns,
-		IsSelect: true,
-	}, nil
-}
-
-// compareWithOperator compares two values using the specified operator
-func compareWithOperator(v1, v2 interface{}, operator string) bool {
-	// Handle nil values
-	if v1 == nil && v2 == nil {
-		return operator == "="
-	}
-	if v1 == nil || v2 == nil {
-		return operator == "!="
-	}
-
-	// v1 is the value from the WHERE condition
-	// v2 is the value from the record
-	// So the comparison should be: record_value operator condition_value
-	// For example: if WHERE age > 30, then we check if record.age > 30
-
-	switch val1 := v1.(type) {
-	case int:
-		switch val2 := v2.(type) {
-		case int:
-			return compareInts(val2, val1, operator)
-		case float64:
-			return compareFloats(val2, float64(val1), operator)
-		case string:
-			if num, err := strconv.Atoi(val2); err == nil {
-				return compareInts(num, val1, operator)
-			}
-			if num, err := strconv.ParseFloat(val2, 64); err == nil {
-				return compareFloats(num, float64(val1), operator)
-			}
+	rows := make([][]interface{}, len(result.Records))
+	for i, record := range result.Records {
+		values := make([]interface{}, len(result.Columns))
+		for j, col := range result.Columns {
+			values[j] = record.Columns[col]
 		}
-	case float64:
-		switch val2 := v2.(type) {
-		case float64:
-			return compareFloats(val2, val1, operator)
-		case int:
-			return compareFloats(float64(val2), val1, operator)
-		case string:
-			if num, err := strconv.ParseFloat(val2, 64); err == nil {
-				return compareFloats(num, val1, operator)
-			}
-		}
-	case string:
-		switch val2 := v2.(type) {
-		case string:
-			return compareStrings(val2, val1, operator)
-		case int:
-			if num, err := strconv.Atoi(val1); err == nil {
-				return compareInts(val2, num, operator)
-			}
-		case float64:
-			if num, err := strconv.ParseFloat(val1, 64); err == nil {
-				return compareFloats(val2, num, operator)
-			}
-		}
-	}
-	return false
-}
-
-// compareInts compares two integers using the specified operator
-func compareInts(a, b int, operator string) bool {
-	switch operator {
-	case "=":
-		return a == b
-	case "!=":
-		return a != b
-	case ">":
-		return a > b
-	case "<":
-		return a < b
-	case ">=":
-		return a >= b
-	case "<=":
-		return a <= b
-	default:
-		return false
-	}
-}
-
-// compareFloats compares two floats using the specified operator
-func compareFloats(a, b float64, operator string) bool {
-	switch operator {
-	case "=":
-		return a == b
-	case "!=":
-		return a != b
-	case ">":
-		return a > b
-	case "<":
-		return a < b
-	case ">=":
-		return a >= b
-	case "<=":
-		return a <= b
-	default:
-		return false
-	}
-}
-
-// compareStrings compares two strings using the specified operator
-func compareStrings(a, b string, operator string) bool {
-	switch operator {
-	case "=":
-		return strings.EqualFold(a, b)
-	case "!=":
-		return !strings.EqualFold(a, b)
-	case ">":
-		return a > b
-	case "<":
-		return a < b
-	case ">=":
-		return a >= b
-	case "<=":
-		return a <= b
-	default:
-		return false
+		rows[i] = values
 	}
+	return rows, nil
 }
 
 // executeDescribe handles DESCRIBE statements
@@ -586,6 +534,9 @@ func (d *Database) executeDescribe(stmt *interfaces.DescribeStatement) (*interfa
 		if col.Unique {
 			constraints = append(constraints, "UNIQUE")
 		}
+		if col.Default != nil {
+			constraints = append(constraints, "DEFAULT "+(&interfaces.Literal{Value: col.Default}).String())
+		}
 
 		record := &interfaces.Record{
 			Columns: map[string]interface{}{
@@ -607,10 +558,12 @@ func (d *Database) executeDescribe(stmt *interfaces.DescribeStatement) (*interfa
 
 // executeDrop handles DROP TABLE statements
 func (d *Database) executeDrop(stmt *interfaces.DropStatement) (*interfaces.Result, error) {
-	_, tableName, err := d.getTable(stmt.TableName, true)
+	table, tableName, err := d.getTable(stmt.TableName, true)
 	if err != nil {
 		return nil, err
 	}
+	d.invalidateIndexes(table)
+	delete(d.stores, table)
 
 	// Remove table from the appropriate map
 	if d.inTransaction {
@@ -635,60 +588,184 @@ func (d *Database) executeDelete(stmt *interfaces.DeleteStatement) (*interfaces.
 		return nil, err
 	}
 
+	s := newTableScope(table, tableName)
+	s.db = d
+	if err := checkColumns(stmt.Where, s); err != nil {
+		return nil, err
+	}
+
+	// Find the records that match WHERE conditions
+	candidates, err := d.scanRecords(s, stmt.Where)
+	if err != nil {
+		return nil, err
+	}
+	deleted := make(map[*interfaces.Record]bool)
+	for _, record := range candidates {
+		match, err := matchesWhere(s.row(record), stmt.Where)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			deleted[record] = true
+		}
+	}
+	deletedCount := len(deleted)
+
+	// Remove the records from the table and its indexes
+	st, err := d.store(table)
+	if err != nil {
+		return nil, err
+	}
+	for record := range deleted {
+		if err := d.indexDelete(table, record); err != nil {
+			return nil, err
+		}
+		st.delete(record)
+	}
+
+	// Update the appropriate table map
+	if d.inTransaction {
+		d.snapshot[tableName] = table
+	} else {
+		d.tables[tableName] = table
+		if err := d.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &interfaces.Result{
+		Success:      true,
+		Message:      fmt.Sprintf("%d record(s) deleted successfully", deletedCount),
+		RowsAffected: deletedCount,
+	}, nil
+}
+
+// matchesWhere reports whether a row satisfies a WHERE expression; a nil
+// expression matches every row and NULL counts as not matching
+func matchesWhere(r *row, where interfaces.Expr) (bool, error) {
+	if where == nil {
+		return true, nil
+	}
+	value, err := evalExpr(where, r)
+	if err != nil {
+		return false, err
+	}
+	return isTrue(value), nil
+}
+
+// executeUpdate handles UPDATE statements
+func (d *Database) executeUpdate(stmt *interfaces.UpdateStatement) (*interfaces.Result, error) {
+	table, tableName, err := d.getTable(stmt.TableName, true)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create column name mapping for case-insensitive comparison
 	columnMap := d.getColumnMap(table)
+	s := newTableScope(table, tableName)
+	s.db = d
+	if err := checkColumns(stmt.Where, s); err != nil {
+		return nil, err
+	}
 
-	// Filter records that match WHERE conditions
-	newRecords := make([]*interfaces.Record, 0)
-	deletedCount := 0
+	// Resolve the target columns up front
+	targets := make([]*interfaces.Column, len(stmt.Assignments))
+	for i, assignment := range stmt.Assignments {
+		actualCol, exists := columnMap[strings.ToLower(assignment.Column)]
+		if !exists {
+			return nil, fmt.Errorf("column %s does not exist", assignment.Column)
+		}
+		for j := range table.Columns {
+			if table.Columns[j].Name == actualCol {
+				targets[i] = &table.Columns[j]
+				break
+			}
+		}
+	}
 
-	// If no WHERE clause, delete all records
-	if len(stmt.Where) == 0 {
-		deletedCount = len(table.Records)
-		newRecords = make([]*interfaces.Record, 0) // Empty the records
-	} else {
-		// Process records with WHERE clause
-		for _, record := range table.Records {
-			match := true
-			for whereCol, whereCondition := range stmt.Where {
-				// Get actual column name from case-insensitive map
-				actualCol, exists := columnMap[strings.ToLower(whereCol)]
-				if !exists {
-					return nil, fmt.Errorf("column %s does not exist", whereCol)
-				}
+	// Build the updated rows without touching the table, so that a failed
+	// constraint check leaves it unchanged
+	candidates, err := d.scanRecords(s, stmt.Where)
+	if err != nil {
+		return nil, err
+	}
+	updates := make(map[*interfaces.Record]*interfaces.Record)
+	for _, record := range candidates {
+		r := s.row(record)
+		match, err := matchesWhere(r, stmt.Where)
+		if err != nil {
+			return nil, err
+		}
+		if !match {
+			continue
+		}
 
-				recordValue := record.Columns[actualCol]
-				if recordValue == nil {
-					match = false
-					break
-				}
+		updated := &interfaces.Record{
+			Columns: make(map[string]interface{}, len(record.Columns)),
+		}
+		for k, v := range record.Columns {
+			updated.Columns[k] = v
+		}
 
-				// Extract operator and value from the condition
-				condMap, ok := whereCondition.(map[string]interface{})
-				if !ok {
-					return nil, fmt.Errorf("invalid where condition format")
-				}
+		// Expressions see the row as it was before the update
+		for j, assignment := range stmt.Assignments {
+			value, err := evalExpr(assignment.Value, r)
+			if err != nil {
+				return nil, err
+			}
+			value, err = getColumnValue(targets[j], value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for column %s: %v", targets[j].Name, err)
+			}
+			updated.Columns[targets[j].Name] = value
+		}
+
+		// Check NOT NULL constraints on the assigned columns
+		for _, col := range targets {
+			if !col.Nullable && updated.Columns[col.Name] == nil {
+				return nil, fmt.Errorf("column %s cannot be null", col.Name)
+			}
+		}
 
-				operator := condMap["operator"].(string)
-				whereVal := condMap["value"]
+		updates[record] = updated
+	}
+	updatedCount := len(updates)
 
-				// Compare based on operator
-				if !compareWithOperator(whereVal, recordValue, operator) {
-					match = false
-					break
+	// Replace the updated rows in the table and its indexes, then check
+	// PRIMARY KEY and UNIQUE constraints once every row has its new values.
+	// Rows are visited in table order so that errors are deterministic.
+	st, err := d.store(table)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := d.indexes(table)
+	if err != nil {
+		return nil, err
+	}
+	records := st.tree.Scan()
+	for _, record := range records {
+		if updated, exists := updates[record]; exists {
+			for _, idx := range indexes {
+				if !idx.clustered {
+					idx.tree.DeleteRecord(idx.key(record), record)
+					idx.tree.Insert(idx.key(updated), updated)
 				}
 			}
-			if !match {
-				newRecords = append(newRecords, record)
-			} else {
-				deletedCount++
+			st.replace(record, updated)
+		}
+	}
+	for _, record := range records {
+		if updated, exists := updates[record]; exists {
+			if err := d.checkUnique(table, updated); err != nil {
+				for old, updated := range updates {
+					st.replace(updated, old)
+				}
+				d.invalidateIndexes(table)
+				return nil, err
 			}
 		}
 	}
 
-	// Update table with filtered records
-	table.Records = newRecords
-
 	// Update the appropriate table map
 	if d.inTransaction {
 		d.snapshot[tableName] = table
@@ -700,8 +777,9 @@ func (d *Database) executeDelete(stmt *interfaces.DeleteStatement) (*interfaces.
 	}
 
 	return &interfaces.Result{
-		Success: true,
-		Message: fmt.Sprintf("%d record(s) deleted successfully", deletedCount),
+		Success:      true,
+		Message:      fmt.Sprintf("%d record(s) updated successfully", updatedCount),
+		RowsAffected: updatedCount,
 	}, nil
 }
 
@@ -727,6 +805,9 @@ func (d *Database) getTable(tableName string, useSnapshot bool) (*interfaces.Tab
 	if table == nil {
 		return nil, "", fmt.Errorf("table %s does not exist", tableName)
 	}
+	if err := d.loadTable(table); err != nil {
+		return nil, "", fmt.Errorf("reading table %s: %v", actualName, err)
+	}
 
 	return table, actualName, nil
 }
@@ -740,22 +821,74 @@ func (d *Database) getColumnMap(table *interfaces.Table) map[string]string {
 	return columnMap
 }
 
-// save saves the database to a file
+// save writes the changes to the database's tables since the last save to
+// its backend and syncs it
 func (d *Database) save() error {
-	data, err := json.MarshalIndent(d.tables, "", "  ")
-	if err != nil {
+	return d.saveTables(d.tables)
+}
+
+// saveTables saves tables as the database's tables, such as the tables of
+// a transaction being committed, which become d.tables only once saved
+func (d *Database) saveTables(tables map[string]*interfaces.Table) error {
+	if d.backend == nil {
+		return fmt.Errorf("database is closed")
+	}
+
+	data := make([]*storage.TableData, 0, len(tables))
+	for name, table := range tables {
+		if saved, unloaded := d.unloaded[table]; unloaded {
+			data = append(data, d.unloadedData(name, table, saved))
+		} else {
+			st, err := d.store(table)
+			if err != nil {
+				return err
+			}
+			data = append(data, st.data(name))
+		}
+	}
+	if err := d.backend.WriteTables(data); err != nil {
 		return err
 	}
-	return ioutil.WriteFile(d.path, data, 0644)
+	if err := d.backend.Sync(); err != nil {
+		return err
+	}
+
+	for name, table := range tables {
+		if _, unloaded := d.unloaded[table]; unloaded {
+			d.unloaded[table] = name
+		} else {
+			d.stores[table].clean(name)
+		}
+	}
+	return nil
 }
 
-// load loads the database from a file
+// unloadedData returns a table that has not been loaded to write to the
+// backend: it has no changes, and its rows are read from the backend if the
+// backend needs them
+func (d *Database) unloadedData(name string, table *interfaces.Table, saved string) *storage.TableData {
+	return &storage.TableData{
+		Name:  name,
+		Table: table,
+		Rows: func(fn func(rowid int64, record *interfaces.Record) error) error {
+			return d.backend.ReadTable(saved, fn)
+		},
+		Saved: saved,
+	}
+}
+
+// load reads the catalog of the database's backend, leaving each table to
+// be loaded when first used
 func (d *Database) load() error {
-	data, err := ioutil.ReadFile(d.path)
+	tables, err := d.backend.LoadCatalog()
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &d.tables)
+	for name, table := range tables {
+		d.tables[name] = table
+		d.unloaded[table] = name
+	}
+	return nil
 }
 
 // GetTables returns a list of all table names in the database
@@ -771,29 +904,78 @@ func (d *Database) GetTables() []string {
 	return tableNames
 }
 
-// Save saves the database to the specified file
-func (d *Database) Save(path string) error {
-	d.mutex.RLock()
-	defer d.mutex.RUnlock()
+// Convert copies the database in the file at from into a new file at to,
+// in the format storage.ForPath picks for it, such as a page file for a
+// name not ending in ".json". Options apply to both files: the passphrase
+// opens the source and encrypts the copy. The source is left as it was,
+// and a copy that fails is removed.
+func Convert(from, to string, options Options) (err error) {
+	if _, err := os.Stat(to); err == nil {
+		return fmt.Errorf("%s already exists", to)
+	}
+	src, err := OpenEncrypted(from, options.Passphrase)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := OpenWith(to, options)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(to)
+			os.Remove(to + "-wal")
+		}
+	}()
 
-	// Use the provided path or the default one
-	savePath := path
-	if savePath == "" {
-		savePath = d.path
+	src.mutex.Lock()
+	defer src.mutex.Unlock()
+	dst.mutex.Lock()
+	defer dst.mutex.Unlock()
+	if dst.tables, err = src.serialize(src.tables); err != nil {
+		return err
 	}
+	return dst.save()
+}
 
-	// Create a serializable representation of the database
-	serialized := make(map[string]interfaces.Table)
-	for name, table := range d.tables {
-		serialized[name] = *table
+// Migrate copies the JSON database at legacy, written by earlier versions,
+// into a new database at path when path does not exist and legacy does,
+// and reports whether it did. Options apply as for Convert. The JSON file is
+// left as it was.
+func Migrate(path, legacy string, options Options) (bool, error) {
+	if path == legacy {
+		return false, nil
 	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return false, nil
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		return false, nil
+	}
+	if err := Convert(legacy, path, options); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Save saves the database to the specified file as JSON. Saving to the
+// file the database was opened from, or to "", saves any unsaved changes
+// to its backend instead. A copy saved to another file is not encrypted.
+func (d *Database) Save(path string) error {
+	// Serialising may build row stores, so this takes the write lock
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
 
-	// Marshal to JSON
-	data, err := json.MarshalIndent(serialized, "", "  ")
+	if path == "" || path == d.path {
+		return d.save()
+	}
+	tables, err := d.serialize(d.tables)
 	if err != nil {
 		return err
 	}
-
-	// Write to file
-	return ioutil.WriteFile(savePath, data, 0644)
+	return storage.SaveToFile(path, tables, d.backup)
 }
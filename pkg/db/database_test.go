@@ -0,0 +1,39 @@
+package db
+
+import (
+	"testing"
+
+	"sqlight/pkg/interfaces"
+	"sqlight/pkg/sql"
+)
+
+// execute parses and runs a single statement
+func execute(d *Database, query string) (*interfaces.Result, error) {
+	stmt, err := sql.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return d.Execute(stmt)
+}
+
+// parseSelect parses a SELECT statement
+func parseSelect(query string) (*interfaces.SelectStatement, error) {
+	stmt, err := sql.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.(*interfaces.SelectStatement), nil
+}
+
+// execAll runs each statement against the database, failing the test on error
+func execAll(t *testing.T, d *Database, statements ...string) *interfaces.Result {
+	t.Helper()
+	var result *interfaces.Result
+	for _, query := range statements {
+		var err error
+		if result, err = execute(d, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	return result
+}
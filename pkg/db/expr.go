@@ -0,0 +1,314 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"sqlight/pkg/interfaces"
+)
+
+// evalExpr evaluates an expression against a row. Column references are
+// resolved case-insensitively through the row's scope.
+func evalExpr(expr interfaces.Expr, r *row) (interface{}, error) {
+	switch e := expr.(type) {
+	case *interfaces.Literal:
+		return e.Value, nil
+
+	case *interfaces.ColumnRef:
+		return r.value(e)
+
+	case *interfaces.UnaryExpr:
+		value, err := evalExpr(e.Expr, r)
+		if err != nil {
+			return nil, err
+		}
+		return evalUnary(e.Op, value)
+
+	case *interfaces.BinaryExpr:
+		if e.Op == "AND" || e.Op == "OR" {
+			return evalLogical(e, r)
+		}
+
+		left, err := evalExpr(e.Left, r)
+		if err != nil {
+			return nil, err
+		}
+		right, err := evalExpr(e.Right, r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch e.Op {
+		case "=", "!=", "<", ">", "<=", ">=":
+			return evalComparison(e.Op, left, right)
+		}
+		return evalArithmetic(e.Op, left, right)
+
+	case *interfaces.SubqueryExpr:
+		return evalScalarSubquery(e, r)
+
+	case *interfaces.InExpr:
+		return evalIn(e, r)
+
+	case *interfaces.BetweenExpr:
+		return evalBetween(e, r)
+
+	case *interfaces.IsNullExpr:
+		value, err := evalExpr(e.Expr, r)
+		if err != nil {
+			return nil, err
+		}
+		return (value == nil) != e.Not, nil
+
+	case *interfaces.ExistsExpr:
+		result, err := runSubquery(e.Subquery, r)
+		if err != nil {
+			return nil, err
+		}
+		return len(result.Records) > 0, nil
+
+	case *interfaces.FuncCall:
+		if isAggregate(e) {
+			return nil, fmt.Errorf("misuse of aggregate function %s()", strings.ToUpper(e.Name))
+		}
+		return nil, fmt.Errorf("unknown function %s()", e.Name)
+
+	default:
+		return nil, fmt.Errorf("unsupported expression: %s", expr)
+	}
+}
+
+// walkExpr calls fn for expr and, while fn returns true, its sub-expressions.
+// Subqueries are not entered since they have a scope of their own.
+func walkExpr(expr interfaces.Expr, fn func(interfaces.Expr) bool) {
+	if expr == nil || !fn(expr) {
+		return
+	}
+	switch e := expr.(type) {
+	case *interfaces.BinaryExpr:
+		walkExpr(e.Left, fn)
+		walkExpr(e.Right, fn)
+	case *interfaces.UnaryExpr:
+		walkExpr(e.Expr, fn)
+	case *interfaces.FuncCall:
+		for _, arg := range e.Args {
+			walkExpr(arg, fn)
+		}
+	case *interfaces.InExpr:
+		walkExpr(e.Expr, fn)
+		for _, item := range e.List {
+			walkExpr(item, fn)
+		}
+	case *interfaces.BetweenExpr:
+		walkExpr(e.Expr, fn)
+		walkExpr(e.Low, fn)
+		walkExpr(e.High, fn)
+	case *interfaces.IsNullExpr:
+		walkExpr(e.Expr, fn)
+	}
+}
+
+// evalBetween evaluates x BETWEEN low AND high as x >= low AND x <= high,
+// evaluating x once
+func evalBetween(e *interfaces.BetweenExpr, r *row) (interface{}, error) {
+	value, err := evalExpr(e.Expr, r)
+	if err != nil {
+		return nil, err
+	}
+	x := &interfaces.Literal{Value: value}
+	result, err := evalExpr(&interfaces.BinaryExpr{
+		Op:    "AND",
+		Left:  &interfaces.BinaryExpr{Op: ">=", Left: x, Right: e.Low},
+		Right: &interfaces.BinaryExpr{Op: "<=", Left: x, Right: e.High},
+	}, r)
+	if err != nil || !e.Not {
+		return result, err
+	}
+	return evalUnary("NOT", result)
+}
+
+// checkColumns returns an error for the first column reference in expr that
+// cannot be resolved in scope s
+func checkColumns(expr interfaces.Expr, s *scope) error {
+	var err error
+	walkExpr(expr, func(e interfaces.Expr) bool {
+		if ref, ok := e.(*interfaces.ColumnRef); ok {
+			err = s.check(ref)
+		}
+		return err == nil
+	})
+	return err
+}
+
+// evalLogical evaluates AND / OR with SQL three-valued logic, where nil
+// stands for UNKNOWN. The right operand is skipped when the left decides.
+func evalLogical(e *interfaces.BinaryExpr, r *row) (interface{}, error) {
+	leftValue, err := evalExpr(e.Left, r)
+	if err != nil {
+		return nil, err
+	}
+	left := toBool(leftValue)
+
+	if e.Op == "AND" && left != nil && !*left {
+		return false, nil
+	}
+	if e.Op == "OR" && left != nil && *left {
+		return true, nil
+	}
+
+	rightValue, err := evalExpr(e.Right, r)
+	if err != nil {
+		return nil, err
+	}
+	right := toBool(rightValue)
+
+	if e.Op == "AND" {
+		if right != nil && !*right {
+			return false, nil
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return true, nil
+	}
+
+	if right != nil && *right {
+		return true, nil
+	}
+	if left == nil || right == nil {
+		return nil, nil
+	}
+	return false, nil
+}
+
+// toBool interprets a value as a truth value; nil means UNKNOWN
+func toBool(value interface{}) *bool {
+	if value == nil {
+		return nil
+	}
+	var b bool
+	switch v := value.(type) {
+	case bool:
+		b = v
+	default:
+		f, ok := toFloat(v)
+		b = ok && f != 0
+	}
+	return &b
+}
+
+// isTrue reports whether a value is definitely true
+func isTrue(value interface{}) bool {
+	b := toBool(value)
+	return b != nil && *b
+}
+
+// evalUnary applies a unary operator to a value
+func evalUnary(op string, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch op {
+	case "NOT":
+		return !*toBool(value), nil
+	case "+":
+		if _, ok := toFloat(value); !ok {
+			return nil, fmt.Errorf("cannot apply unary + to %v", value)
+		}
+		return value, nil
+	case "-":
+		switch v := value.(type) {
+		case int:
+			return -v, nil
+		case float64:
+			return -v, nil
+		}
+		if f, ok := toFloat(value); ok {
+			return -f, nil
+		}
+		return nil, fmt.Errorf("cannot negate %v", value)
+	default:
+		return nil, fmt.Errorf("unsupported operator %s", op)
+	}
+}
+
+// evalArithmetic applies an arithmetic or concatenation operator. Integer
+// operands produce an integer result, otherwise both sides are promoted to
+// float64. Any NULL operand yields NULL.
+func evalArithmetic(op string, left, right interface{}) (interface{}, error) {
+	if left == nil || right == nil {
+		return nil, nil
+	}
+
+	if op == "||" {
+		return fmt.Sprintf("%v%v", left, right), nil
+	}
+
+	li, lIsInt := left.(int)
+	ri, rIsInt := right.(int)
+	if lIsInt && rIsInt {
+		switch op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		case "/":
+			if ri == 0 {
+				return nil, nil
+			}
+			return li / ri, nil
+		case "%":
+			if ri == 0 {
+				return nil, nil
+			}
+			return li % ri, nil
+		}
+		return nil, fmt.Errorf("unsupported operator %s", op)
+	}
+
+	lf, lok := toFloat(left)
+	rf, rok := toFloat(right)
+	if !lok || !rok {
+		return nil, fmt.Errorf("cannot apply %s to %v and %v", op, left, right)
+	}
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, nil
+		}
+		return lf / rf, nil
+	case "%":
+		if rf == 0 {
+			return nil, nil
+		}
+		return math.Mod(lf, rf), nil
+	}
+	return nil, fmt.Errorf("unsupported operator %s", op)
+}
+
+// toFloat converts a numeric value (or numeric string) to float64
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return f, true
+		}
+	}
+	return 0, false
+}
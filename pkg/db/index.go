@@ -0,0 +1,535 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sqlight/pkg/interfaces"
+)
+
+// tableIndex is an index of a table together with the B+ tree holding its
+// entries. Besides the indexes created with CREATE INDEX, every PRIMARY KEY
+// and UNIQUE column has an implicit unique index enforcing its constraint.
+type tableIndex struct {
+	def        interfaces.Index
+	constraint string // "PRIMARY KEY" or "UNIQUE" for implicit indexes
+	tree       *BTree
+	clustered  bool // the tree is the table's row store, which maintains it
+}
+
+// keyRange bounds the first column of an index key; both ends are inclusive
+type keyRange struct {
+	lo, hi       interface{}
+	hasLo, hasHi bool
+	equal        bool
+}
+
+// keyValue normalises a value for use in an index key. Numeric strings are
+// stored as numbers, so that values equal under "=" always have equal keys.
+func keyValue(value interface{}) interface{} {
+	if s, ok := value.(string); ok {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
+// key returns the index key of a record
+func (idx *tableIndex) key(record *interfaces.Record) Key {
+	key := make(Key, len(idx.def.Columns))
+	for i, col := range idx.def.Columns {
+		key[i] = keyValue(record.Columns[col])
+	}
+	return key
+}
+
+// indexes returns the indexes of a table, building their trees from the
+// table's records on first use. The trees are cached per table until the
+// table's schema changes. The PRIMARY KEY index is the row store itself.
+func (d *Database) indexes(table *interfaces.Table) ([]*tableIndex, error) {
+	if cached, exists := d.indexCache[table]; exists {
+		return cached, nil
+	}
+	st, err := d.store(table)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []*tableIndex
+	for _, col := range table.Columns {
+		if !col.PrimaryKey && !col.Unique {
+			continue
+		}
+		constraint := "UNIQUE"
+		if col.PrimaryKey {
+			constraint = "PRIMARY KEY"
+		}
+		idx := &tableIndex{
+			def: interfaces.Index{
+				Name:    fmt.Sprintf("sqlight_autoindex_%s_%s", table.Name, col.Name),
+				Columns: []string{col.Name},
+				Unique:  true,
+			},
+			constraint: constraint,
+		}
+		if col.PrimaryKey {
+			idx.tree, idx.clustered = st.tree, true
+		}
+		indexes = append(indexes, idx)
+	}
+	for _, def := range table.Indexes {
+		indexes = append(indexes, &tableIndex{def: def})
+	}
+
+	records := st.tree.Scan()
+	for _, idx := range indexes {
+		if idx.clustered {
+			continue
+		}
+		idx.tree = NewBTree()
+		for _, record := range records {
+			idx.tree.Insert(idx.key(record), record)
+		}
+	}
+
+	if d.indexCache == nil {
+		d.indexCache = make(map[*interfaces.Table][]*tableIndex)
+	}
+	d.indexCache[table] = indexes
+	return indexes, nil
+}
+
+// indexInsert adds a record to every index of its table other than the
+// row store. Indexes are built from the row store on first use, so they
+// must be updated before the row store is.
+func (d *Database) indexInsert(table *interfaces.Table, record *interfaces.Record) error {
+	indexes, err := d.indexes(table)
+	if err != nil {
+		return err
+	}
+	for _, idx := range indexes {
+		if !idx.clustered {
+			idx.tree.Insert(idx.key(record), record)
+		}
+	}
+	return nil
+}
+
+// indexDelete removes a record from every index of its table other than the
+// row store
+func (d *Database) indexDelete(table *interfaces.Table, record *interfaces.Record) error {
+	indexes, err := d.indexes(table)
+	if err != nil {
+		return err
+	}
+	for _, idx := range indexes {
+		if !idx.clustered {
+			idx.tree.DeleteRecord(idx.key(record), record)
+		}
+	}
+	return nil
+}
+
+// invalidateIndexes drops the cached index trees of a table; they are
+// rebuilt from its records when next needed
+func (d *Database) invalidateIndexes(table *interfaces.Table) {
+	delete(d.indexCache, table)
+}
+
+// pruneTables drops the row stores, cached index trees and unloaded entries
+// of tables that are no longer part of the database, such as those of a
+// finished transaction's snapshot
+func (d *Database) pruneTables() {
+	live := make(map[*interfaces.Table]bool, len(d.tables))
+	for _, table := range d.tables {
+		live[table] = true
+	}
+	for table := range d.indexCache {
+		if !live[table] {
+			delete(d.indexCache, table)
+		}
+	}
+	for table := range d.stores {
+		if !live[table] {
+			delete(d.stores, table)
+		}
+	}
+	for table := range d.unloaded {
+		if !live[table] {
+			delete(d.unloaded, table)
+		}
+	}
+}
+
+// checkUnique returns an error if a record already in the indexes of its
+// table has the same key as another record in one of the unique indexes.
+// Keys containing NULL never conflict.
+func (d *Database) checkUnique(table *interfaces.Table, record *interfaces.Record) error {
+	indexes, err := d.indexes(table)
+	if err != nil {
+		return err
+	}
+	for _, idx := range indexes {
+		if !idx.def.Unique || !idx.hasDuplicate(record) {
+			continue
+		}
+		if idx.constraint != "" {
+			return fmt.Errorf("duplicate value in %s column %s", idx.constraint, idx.def.Columns[0])
+		}
+		return fmt.Errorf("duplicate value in UNIQUE index %s", idx.def.Name)
+	}
+	return nil
+}
+
+// hasDuplicate reports whether another record in the index has the same
+// values as record in every indexed column
+func (idx *tableIndex) hasDuplicate(record *interfaces.Record) bool {
+	key := idx.key(record)
+	for _, value := range key {
+		if value == nil {
+			return false
+		}
+	}
+
+	cursor := NewCursor(idx.tree)
+	cursor.SetBounds(key, key)
+	for cursor.Next() {
+		other := cursor.Current()
+		if other != record && idx.sameValues(record, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameValues reports whether two records conflict in every indexed column.
+// Keys compare text case-insensitively, so this rechecks with sameValue.
+func (idx *tableIndex) sameValues(a, b *interfaces.Record) bool {
+	for _, col := range idx.def.Columns {
+		if !sameValue(a.Columns[col], b.Columns[col]) {
+			return false
+		}
+	}
+	return true
+}
+
+// scan returns the records whose first key column lies in r, in key order.
+// Records with a NULL first column are skipped since they satisfy no
+// comparison.
+func (idx *tableIndex) scan(r keyRange) []*interfaces.Record {
+	var records []*interfaces.Record
+	cursor := idx.cursor(r)
+	for cursor.Next() {
+		if cursor.Key()[0] != nil {
+			records = append(records, cursor.Current())
+		}
+	}
+	return records
+}
+
+// cursor returns a cursor over the entries whose first key column lies in r
+func (idx *tableIndex) cursor(r keyRange) *Cursor {
+	var lo, hi Key
+	if r.hasLo {
+		lo = Key{r.lo}
+	}
+	if r.hasHi {
+		hi = Key{r.hi}
+	}
+	cursor := NewCursor(idx.tree)
+	cursor.SetBounds(lo, hi)
+	return cursor
+}
+
+// scanRecords returns the records of the first table in scope s that may
+// satisfy where. When where requires a column to equal, or for INTEGER
+// columns to be above or below, a constant and an index starts with that
+// column, only the matching part of the index is read; otherwise every
+// record is returned. Callers still evaluate where on each record.
+func (d *Database) scanRecords(s *scope, where interfaces.Expr) ([]*interfaces.Record, error) {
+	table := s.tables[0].table
+	conditions := conjuncts(where)
+	if len(conditions) == 0 {
+		return d.records(table)
+	}
+	indexes, err := d.indexes(table)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *tableIndex
+	var bestRange keyRange
+	for _, idx := range indexes {
+		i, _ := findColumn(table, idx.def.Columns[0])
+		r, ok := indexRange(conditions, s, &table.Columns[i])
+		if ok && (best == nil || (r.equal && !bestRange.equal)) {
+			best, bestRange = idx, r
+		}
+	}
+
+	if best == nil {
+		return d.records(table)
+	}
+	return best.scan(bestRange), nil
+}
+
+// orderedScan reads the rows of a single-table SELECT with LIMIT and an
+// ORDER BY on one INTEGER column through a unique index on that column, in
+// ORDER BY order, and stops once OFFSET + LIMIT rows have matched where. It
+// reports false when the query does not have that shape.
+func (d *Database) orderedScan(stmt *interfaces.SelectStatement, items []interfaces.SelectColumn, s *scope) ([]*interfaces.Record, bool, error) {
+	if len(stmt.Joins) > 0 || stmt.Limit == nil || *stmt.Limit < 0 || len(stmt.OrderBy) != 1 || isAggregateQuery(stmt, items) {
+		return nil, false, nil
+	}
+	item := stmt.OrderBy[0]
+	ref, ok := item.Expr.(*interfaces.ColumnRef)
+	if !ok || findAlias(items, ref) >= 0 || !s.defines(ref) {
+		return nil, false, nil
+	}
+	// The index keeps NULLs first, so they come first ascending and last descending
+	if item.NullsOrder != "" && (item.NullsOrder == "FIRST") == item.Desc {
+		return nil, false, nil
+	}
+	binding, err := s.resolve(ref)
+	if err != nil || binding.table != 0 {
+		return nil, false, nil
+	}
+	table := s.tables[0].table
+	i, _ := findColumn(table, binding.column)
+	col := &table.Columns[i]
+	if col.Type != "INT" && col.Type != "INTEGER" {
+		return nil, false, nil
+	}
+
+	// Without duplicate keys, index order is the order a stable sort gives
+	indexes, err := d.indexes(table)
+	if err != nil {
+		return nil, false, err
+	}
+	var idx *tableIndex
+	for _, candidate := range indexes {
+		if candidate.def.Unique && len(candidate.def.Columns) == 1 && candidate.def.Columns[0] == col.Name {
+			idx = candidate
+			break
+		}
+	}
+	if idx == nil {
+		return nil, false, nil
+	}
+
+	want := *stmt.Limit + stmt.Offset
+	var records []*interfaces.Record
+	add := func(record *interfaces.Record) error {
+		match, err := matchesWhere(s.row(record), stmt.Where)
+		if match {
+			records = append(records, record)
+		}
+		return err
+	}
+
+	// NULL keys may repeat, so rows with a NULL key are read from the table
+	// in its own order, as a stable sort would leave them
+	addNulls := func() error {
+		all, err := d.records(table)
+		if err != nil {
+			return err
+		}
+		for _, record := range all {
+			if len(records) >= want {
+				break
+			}
+			if record.Columns[col.Name] == nil {
+				if err := add(record); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	r, _ := indexRange(conjuncts(stmt.Where), s, col)
+	cursor := idx.cursor(r)
+	step := cursor.Next
+	if item.Desc {
+		step = cursor.Prev
+	}
+	nullsDone := false
+	for len(records) < want && step() {
+		if cursor.Key()[0] != nil {
+			if err := add(cursor.Current()); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		if !nullsDone {
+			if err := addNulls(); err != nil {
+				return nil, false, err
+			}
+			nullsDone = true
+		}
+		if item.Desc {
+			break
+		}
+	}
+	return records, true, nil
+}
+
+// indexRange works out the range of values of column col of the first table
+// in scope s allowed by a list of AND-ed conditions. Only comparisons between
+// the column and a non-NULL literal are used, and ranges only for INTEGER
+// columns, whose values all compare as numbers.
+func indexRange(conditions []interfaces.Expr, s *scope, col *interfaces.Column) (keyRange, bool) {
+	ordered := col.Type == "INT" || col.Type == "INTEGER"
+
+	var r keyRange
+	for _, cond := range conditions {
+		e, ok := cond.(*interfaces.BinaryExpr)
+		if !ok {
+			continue
+		}
+		ref, refOk := e.Left.(*interfaces.ColumnRef)
+		lit, litOk := e.Right.(*interfaces.Literal)
+		op := e.Op
+		if !refOk || !litOk {
+			// Put the column on the left: 5 < x is x > 5
+			ref, refOk = e.Right.(*interfaces.ColumnRef)
+			lit, litOk = e.Left.(*interfaces.Literal)
+			op = map[string]string{"=": "=", "<": ">", ">": "<", "<=": ">=", ">=": "<="}[op]
+		}
+		if !refOk || !litOk || lit.Value == nil || !s.defines(ref) {
+			continue
+		}
+		if binding, err := s.resolve(ref); err != nil || binding.table != 0 || binding.column != col.Name {
+			continue
+		}
+
+		value := keyValue(lit.Value)
+		switch {
+		case op == "=":
+			return keyRange{lo: value, hi: value, hasLo: true, hasHi: true, equal: true}, true
+		case ordered && (op == ">" || op == ">="):
+			if !r.hasLo || compareValues(value, r.lo) > 0 {
+				r.lo, r.hasLo = value, true
+			}
+		case ordered && (op == "<" || op == "<="):
+			if !r.hasHi || compareValues(value, r.hi) < 0 {
+				r.hi, r.hasHi = value, true
+			}
+		}
+	}
+	return r, r.hasLo || r.hasHi
+}
+
+// conjuncts splits an expression into its AND-ed terms. x BETWEEN a AND b
+// contributes the terms x >= a and x <= b.
+func conjuncts(expr interfaces.Expr) []interfaces.Expr {
+	switch e := expr.(type) {
+	case nil:
+		return nil
+	case *interfaces.BinaryExpr:
+		if e.Op == "AND" {
+			return append(conjuncts(e.Left), conjuncts(e.Right)...)
+		}
+	case *interfaces.BetweenExpr:
+		if !e.Not {
+			return []interfaces.Expr{
+				&interfaces.BinaryExpr{Op: ">=", Left: e.Expr, Right: e.Low},
+				&interfaces.BinaryExpr{Op: "<=", Left: e.Expr, Right: e.High},
+			}
+		}
+	}
+	return []interfaces.Expr{expr}
+}
+
+// executeCreateIndex handles CREATE INDEX statements
+func (d *Database) executeCreateIndex(stmt *interfaces.CreateIndexStatement) (*interfaces.Result, error) {
+	table, tableName, err := d.getTable(stmt.TableName, true)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := d.findIndex(stmt.IndexName); err == nil {
+		return nil, fmt.Errorf("index %s already exists", stmt.IndexName)
+	}
+
+	def := interfaces.Index{Name: stmt.IndexName, Unique: stmt.Unique}
+	for _, name := range stmt.Columns {
+		i, exists := findColumn(table, name)
+		if !exists {
+			return nil, fmt.Errorf("column %s does not exist", name)
+		}
+		def.Columns = append(def.Columns, table.Columns[i].Name)
+	}
+
+	// A unique index cannot be created over existing duplicates
+	if def.Unique {
+		idx := &tableIndex{def: def, tree: NewBTree()}
+		records, err := d.records(table)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			idx.tree.Insert(idx.key(record), record)
+		}
+		for _, record := range records {
+			if idx.hasDuplicate(record) {
+				return nil, fmt.Errorf("cannot create UNIQUE index %s: table %s has duplicate values", def.Name, tableName)
+			}
+		}
+	}
+
+	table.Indexes = append(table.Indexes, def)
+	d.invalidateIndexes(table)
+
+	if !d.inTransaction {
+		if err := d.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &interfaces.Result{
+		Success: true,
+		Message: fmt.Sprintf("Index %s created successfully", stmt.IndexName),
+	}, nil
+}
+
+// executeDropIndex handles DROP INDEX statements
+func (d *Database) executeDropIndex(stmt *interfaces.DropIndexStatement) (*interfaces.Result, error) {
+	table, i, err := d.findIndex(stmt.IndexName)
+	if err != nil {
+		return nil, err
+	}
+
+	table.Indexes = append(table.Indexes[:i:i], table.Indexes[i+1:]...)
+	d.invalidateIndexes(table)
+
+	if !d.inTransaction {
+		if err := d.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &interfaces.Result{
+		Success: true,
+		Message: fmt.Sprintf("Index %s dropped successfully", stmt.IndexName),
+	}, nil
+}
+
+// findIndex finds an index by name case-insensitively, returning its table
+// and its position in the table's index list
+func (d *Database) findIndex(name string) (*interfaces.Table, int, error) {
+	tables := d.tables
+	if d.inTransaction {
+		tables = d.snapshot
+	}
+
+	for _, table := range tables {
+		for i, def := range table.Indexes {
+			if strings.EqualFold(def.Name, name) {
+				return table, i, nil
+			}
+		}
+	}
+	return nil, -1, fmt.Errorf("index %s does not exist", name)
+}
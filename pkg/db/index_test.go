@@ -0,0 +1,260 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"sqlight/pkg/storage"
+)
+
+// selectIDs runs a query returning an id column and returns the ids sorted
+func selectIDs(t *testing.T, d *Database, query string) []int {
+	t.Helper()
+	result := execAll(t, d, query)
+	ids := make([]int, 0, len(result.Records))
+	for _, record := range result.Records {
+		ids = append(ids, record.Columns["id"].(int))
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func TestIndexedQueriesMatchFullScans(t *testing.T) {
+	indexed, err := NewDatabase(storage.NewMemoryBackend())
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	plain, err := NewDatabase(storage.NewMemoryBackend())
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+
+	statements := []string{"CREATE TABLE items (id INTEGER PRIMARY KEY, qty INTEGER, name TEXT)"}
+	names := []string{"apple", "Apple", "10", "banana", "9", "cherry"}
+	for i := 1; i <= 60; i++ {
+		qty := "NULL"
+		if i%7 != 0 {
+			qty = fmt.Sprint((i * 37) % 11)
+		}
+		statements = append(statements, fmt.Sprintf("INSERT INTO items VALUES (%d, %s, '%s')", i, qty, names[i%len(names)]))
+	}
+	execAll(t, plain, statements...)
+	execAll(t, indexed, statements...)
+	execAll(t, indexed,
+		"CREATE INDEX idx_qty ON items (qty, name)",
+		"CREATE INDEX idx_name ON items (name)",
+	)
+
+	queries := []string{
+		"SELECT id FROM items WHERE qty = 3",
+		"SELECT id FROM items WHERE qty = '3'",
+		"SELECT id FROM items WHERE qty > 4 AND qty <= 8",
+		"SELECT id FROM items WHERE 5 > qty",
+		"SELECT id FROM items WHERE qty >= 2 AND qty >= 6 AND name = 'apple'",
+		"SELECT id FROM items WHERE qty < 'x'",
+		"SELECT id FROM items WHERE name = 'APPLE'",
+		"SELECT id FROM items WHERE name = 10",
+		"SELECT id FROM items WHERE name > 'b'",
+		"SELECT id FROM items WHERE id >= 50 OR qty = 1",
+		"SELECT id FROM items WHERE qty BETWEEN 3 AND 6",
+		"SELECT id FROM items WHERE id BETWEEN 10 AND 20 AND qty NOT BETWEEN 2 AND 5",
+	}
+
+	check := func(stage string) {
+		for _, query := range queries {
+			expected := selectIDs(t, plain, query)
+			if got := selectIDs(t, indexed, query); !reflect.DeepEqual(got, expected) {
+				t.Errorf("%s: %s returned %v with indexes, expected %v", stage, query, got, expected)
+			}
+		}
+	}
+	check("after insert")
+
+	changes := []string{
+		"UPDATE items SET qty = qty + 3 WHERE qty < 4",
+		"DELETE FROM items WHERE qty = 6 OR name = 'cherry'",
+		"UPDATE items SET name = 'apple' WHERE id > 40",
+	}
+	execAll(t, plain, changes...)
+	execAll(t, indexed, changes...)
+	check("after update and delete")
+
+	table, _, err := indexed.getTable("items", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes, err := indexed.indexes(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, idx := range indexes {
+		if err := idx.tree.Check(); err != nil {
+			t.Errorf("Index %s: %v", idx.def.Name, err)
+		}
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	d, err := NewDatabase(storage.NewMemoryBackend())
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, team TEXT)",
+		"INSERT INTO users VALUES (1, 'a@x', 'red'), (2, 'b@x', 'red'), (3, NULL, 'blue'), (4, NULL, NULL)",
+		"CREATE UNIQUE INDEX idx_email ON users (email)",
+	)
+
+	for _, query := range []string{
+		"INSERT INTO users VALUES (5, 'a@x', NULL)",
+		"INSERT INTO users VALUES (5, 'c@x', NULL), (6, 'c@x', NULL)",
+		"UPDATE users SET email = 'b@x' WHERE id = 1",
+		"CREATE UNIQUE INDEX idx_team ON users (team)",
+	} {
+		if _, err := execute(d, query); err == nil {
+			t.Errorf("Expected %q to violate the unique index", query)
+		}
+	}
+
+	// Failed statements leave the table and its indexes unchanged
+	if ids := selectIDs(t, d, "SELECT id FROM users WHERE email = 'c@x'"); len(ids) != 0 {
+		t.Errorf("Expected no rows for c@x, got %v", ids)
+	}
+	if ids := selectIDs(t, d, "SELECT id FROM users WHERE email = 'a@x'"); !reflect.DeepEqual(ids, []int{1}) {
+		t.Errorf("Expected [1] for a@x, got %v", ids)
+	}
+	execAll(t, d,
+		"INSERT INTO users VALUES (5, 'c@x', NULL), (6, NULL, NULL)",
+		"CREATE UNIQUE INDEX idx_team_email ON users (team, email)",
+	)
+}
+
+func TestScanRecordsUsesIndex(t *testing.T) {
+	d, err := NewDatabase(storage.NewMemoryBackend())
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE items (id INTEGER PRIMARY KEY, qty INTEGER, name TEXT)",
+		"INSERT INTO items VALUES (1, 5, 'a'), (2, 7, 'b'), (3, 5, 'c'), (4, NULL, 'd'), (5, 9, 'e')",
+		"CREATE INDEX idx_qty ON items (qty)",
+	)
+	table, _, err := d.getTable("items", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTableScope(table, "items")
+
+	tests := []struct {
+		where    string
+		expected []int
+	}{
+		{"qty = 5", []int{1, 3}},
+		{"qty > 6 AND name != 'x'", []int{2, 5}},
+		{"id <= 2", []int{1, 2}},
+		{"name = 'c' AND id = 3", []int{3}},
+		{"qty = 5 OR qty = 7", []int{1, 2, 3, 4, 5}},
+		{"qty BETWEEN 6 AND 9", []int{2, 5}},
+		{"qty NOT BETWEEN 6 AND 9", []int{1, 2, 3, 4, 5}},
+	}
+	for _, tt := range tests {
+		stmt, err := parseSelect("SELECT * FROM items WHERE " + tt.where)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := d.scanRecords(s, stmt.Where)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for _, record := range records {
+			ids = append(ids, record.Columns["id"].(int))
+		}
+		sort.Ints(ids)
+		if !reflect.DeepEqual(ids, tt.expected) {
+			t.Errorf("WHERE %s scanned %v, expected %v", tt.where, ids, tt.expected)
+		}
+	}
+}
+
+// orderedIDs runs a query returning an id column and returns the ids in order
+func orderedIDs(t *testing.T, d *Database, query string) []int {
+	t.Helper()
+	var ids []int
+	for _, record := range execAll(t, d, query).Records {
+		ids = append(ids, record.Columns["id"].(int))
+	}
+	return ids
+}
+
+func TestOrderedScanMatchesSort(t *testing.T) {
+	d, err := NewDatabase(storage.NewMemoryBackend())
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	statements := []string{"CREATE TABLE items (id INTEGER PRIMARY KEY, rank INTEGER UNIQUE, qty INTEGER)"}
+	for i := 1; i <= 40; i++ {
+		rank := "NULL"
+		if i%5 != 0 {
+			rank = fmt.Sprint((i * 17) % 41)
+		}
+		statements = append(statements, fmt.Sprintf("INSERT INTO items VALUES (%d, %s, %d)", i, rank, i%7))
+	}
+	statements = append(statements,
+		"UPDATE items SET rank = NULL WHERE id = 3",
+		"UPDATE items SET rank = 100 WHERE id = 20",
+		"DELETE FROM items WHERE qty = 6",
+	)
+	execAll(t, d, statements...)
+
+	// Each query must return the rows the same query without LIMIT does
+	tests := []struct {
+		query  string
+		limit  int
+		offset int
+	}{
+		{"SELECT id FROM items ORDER BY id", 10, 0},
+		{"SELECT id FROM items ORDER BY id DESC", 5, 3},
+		{"SELECT id FROM items WHERE id BETWEEN 12 AND 30 ORDER BY id", 5, 0},
+		{"SELECT id FROM items WHERE qty > 3 ORDER BY rank", 8, 0},
+		{"SELECT id FROM items ORDER BY rank", 12, 2},
+		{"SELECT id FROM items ORDER BY rank DESC", 50, 0},
+		{"SELECT id FROM items ORDER BY rank DESC NULLS LAST", 40, 0},
+		{"SELECT id FROM items ORDER BY rank NULLS LAST", 5, 0},
+		{"SELECT id FROM items WHERE rank BETWEEN 5 AND 30 ORDER BY rank DESC", 3, 1},
+		{"SELECT id FROM items WHERE rank = 100 ORDER BY rank", 1, 0},
+		{"SELECT id FROM items ORDER BY id", 0, 0},
+	}
+	for _, tt := range tests {
+		all := orderedIDs(t, d, tt.query)
+		var expected []int
+		for i := tt.offset; i < len(all) && i < tt.offset+tt.limit; i++ {
+			expected = append(expected, all[i])
+		}
+
+		query := fmt.Sprintf("%s LIMIT %d OFFSET %d", tt.query, tt.limit, tt.offset)
+		if got := orderedIDs(t, d, query); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s returned %v, expected %v", query, got, expected)
+		}
+	}
+
+	// Only the rows needed for the page are read
+	stmt, err := parseSelect("SELECT * FROM items WHERE qty != 2 ORDER BY id DESC LIMIT 4 OFFSET 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := d.selectScope(stmt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, _, err := expandSelectColumns(stmt.Columns, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, ordered, err := d.orderedScan(stmt, items, s)
+	if err != nil || !ordered || len(records) != 6 {
+		t.Errorf("orderedScan returned %d records (ordered %v, error %v), expected 6", len(records), ordered, err)
+	}
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"sqlight/pkg/interfaces"
+	"sqlight/pkg/storage"
+)
+
+// executePragma handles PRAGMA statements
+func (d *Database) executePragma(stmt *interfaces.PragmaStatement) (*interfaces.Result, error) {
+	switch strings.ToLower(stmt.Name) {
+	case "integrity_check":
+		limit := 0
+		if stmt.Value != nil {
+			n, ok := stmt.Value.(int)
+			if !ok || n <= 0 {
+				return nil, fmt.Errorf("PRAGMA integrity_check takes a positive number of rows")
+			}
+			limit = n
+		}
+		problems, err := d.integrityCheck()
+		if err != nil {
+			return nil, err
+		}
+		if len(problems) == 0 {
+			problems = []string{"ok"}
+		}
+		if limit > 0 && len(problems) > limit {
+			problems = problems[:limit]
+		}
+
+		records := make([]*interfaces.Record, len(problems))
+		for i, problem := range problems {
+			records[i] = &interfaces.Record{Columns: map[string]interface{}{"integrity_check": problem}}
+		}
+		return &interfaces.Result{
+			Success:  true,
+			Columns:  []string{"integrity_check"},
+			Records:  records,
+			IsSelect: true,
+		}, nil
+
+	case "rekey":
+		passphrase, ok := stmt.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("PRAGMA rekey takes the new passphrase as a string")
+		}
+		if err := d.rekey(passphrase); err != nil {
+			return nil, err
+		}
+		message := "Database rekeyed"
+		if passphrase == "" {
+			message = "Database decrypted"
+		}
+		return &interfaces.Result{Success: true, Message: message}, nil
+	}
+	return nil, fmt.Errorf("unknown pragma %s", stmt.Name)
+}
+
+// integrityCheck returns the problems found in the database: data the
+// backend finds damaged, tables that cannot be read, row and index trees
+// out of order, and rows breaking a NOT NULL, PRIMARY KEY or UNIQUE
+// constraint
+func (d *Database) integrityCheck() ([]string, error) {
+	if d.backend == nil {
+		return nil, fmt.Errorf("database is closed")
+	}
+
+	var problems []string
+	seen := make(map[string]bool)
+	report := func(format string, args ...interface{}) {
+		problem := fmt.Sprintf(format, args...)
+		if !seen[problem] {
+			seen[problem] = true
+			problems = append(problems, problem)
+		}
+	}
+
+	if checker, ok := d.backend.(storage.Checker); ok {
+		for _, err := range checker.Check() {
+			report("%v", err)
+		}
+	}
+
+	// Inside a transaction the snapshot holds the working copy of every table
+	tables := d.tables
+	if d.inTransaction {
+		tables = d.snapshot
+	}
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		table := tables[name]
+		st, err := d.store(table)
+		if err != nil {
+			report("%v", err)
+			continue
+		}
+		if err := st.tree.Check(); err != nil {
+			report("table %s: row tree: %v", name, err)
+		}
+		indexes, err := d.indexes(table)
+		if err != nil {
+			report("%v", err)
+			continue
+		}
+		for _, idx := range indexes {
+			if !idx.clustered {
+				if err := idx.tree.Check(); err != nil {
+					report("table %s: index %s: %v", name, idx.def.Name, err)
+				}
+			}
+		}
+
+		records := st.tree.Scan()
+		for _, record := range records {
+			for _, col := range table.Columns {
+				if !col.Nullable && record.Columns[col.Name] == nil {
+					report("table %s: row %d: NULL in NOT NULL column %s", name, st.rowids[record], col.Name)
+				}
+			}
+		}
+
+		for _, idx := range indexes {
+			if !idx.def.Unique {
+				continue
+			}
+			constraint := fmt.Sprintf("UNIQUE index %s", idx.def.Name)
+			if idx.constraint != "" {
+				constraint = fmt.Sprintf("%s column %s", idx.constraint, idx.def.Columns[0])
+			}
+			for _, record := range records {
+				if idx.hasDuplicate(record) {
+					report("table %s: duplicate value %s in %s", name, indexValues(idx, record), constraint)
+				}
+			}
+		}
+	}
+	return problems, nil
+}
+
+// indexValues returns the values of a record's indexed columns as SQL
+// literals
+func indexValues(idx *tableIndex, record *interfaces.Record) string {
+	values := make([]string, len(idx.def.Columns))
+	for i, col := range idx.def.Columns {
+		values[i] = (&interfaces.Literal{Value: record.Columns[col]}).String()
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sqlight/pkg/interfaces"
+	"sqlight/pkg/storage"
+)
+
+// integrityCheck runs PRAGMA integrity_check, returning the rows
+func integrityCheck(t *testing.T, d *Database) []string {
+	t.Helper()
+	result := execAll(t, d, "PRAGMA integrity_check")
+	rows := make([]string, len(result.Records))
+	for i, record := range result.Records {
+		rows[i] = record.Columns["integrity_check"].(string)
+	}
+	return rows
+}
+
+func TestIntegrityCheckOfHealthyDatabases(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{filepath.Join(dir, "test.db"), filepath.Join(dir, "test.json")} {
+		d, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		execAll(t, d,
+			"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE, name TEXT NOT NULL)",
+			"CREATE UNIQUE INDEX idx_name ON users (name)",
+			"CREATE TABLE notes (body TEXT)",
+			"BEGIN TRANSACTION",
+		)
+		for i := 0; i < 300; i++ {
+			execAll(t, d,
+				fmt.Sprintf("INSERT INTO users VALUES (%d, 'user%d@example.com', 'user %d')", i, i, i),
+				fmt.Sprintf("INSERT INTO notes VALUES ('%s')", strings.Repeat("x", i)),
+			)
+		}
+		execAll(t, d,
+			"COMMIT",
+			"DELETE FROM users WHERE id > 100",
+			"INSERT INTO users (id, email, name) VALUES (1000, NULL, 'no email'), (1001, NULL, 'none either')",
+			"DROP TABLE notes",
+		)
+		if got := integrityCheck(t, d); !reflect.DeepEqual(got, []string{"ok"}) {
+			t.Errorf("%s: integrity_check found %v", filepath.Base(path), got)
+		}
+		d.Close()
+
+		d, err = Open(path)
+		if err != nil {
+			t.Fatalf("Open failed on reopening: %v", err)
+		}
+		if got := integrityCheck(t, d); !reflect.DeepEqual(got, []string{"ok"}) {
+			t.Errorf("%s: integrity_check found %v after reopening", filepath.Base(path), got)
+		}
+		d.Close()
+	}
+}
+
+func TestIntegrityCheckFindsBrokenConstraints(t *testing.T) {
+	// Write rows no statement would accept straight to the backend, as a
+	// damaged or hand-edited file could hold them
+	table := &interfaces.Table{
+		Name: "users",
+		Columns: []interfaces.Column{
+			{Name: "id", Type: "INTEGER", PrimaryKey: true},
+			{Name: "email", Type: "TEXT", Unique: true, Nullable: true},
+			{Name: "name", Type: "TEXT"},
+		},
+		Indexes: []interfaces.Index{{Name: "idx_name", Columns: []string{"name"}, Unique: true}},
+	}
+	rows := []map[string]interface{}{
+		{"id": 1, "email": "a@example.com", "name": "alice"},
+		{"id": 1, "email": "b@example.com", "name": "bob"},
+		{"id": 2, "email": "a@example.com", "name": nil},
+		{"id": 3, "email": nil, "name": "alice"},
+	}
+	backend := storage.NewMemoryBackend()
+	err := backend.WriteTables([]*storage.TableData{{
+		Name:  "users",
+		Table: table,
+		Rows: func(fn func(rowid int64, record *interfaces.Record) error) error {
+			for i, row := range rows {
+				if err := fn(int64(i+1), &interfaces.Record{Columns: row}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}})
+	if err == nil {
+		err = backend.Sync()
+	}
+	if err != nil {
+		t.Fatalf("Writing the table failed: %v", err)
+	}
+
+	d, err := NewDatabase(backend)
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	expected := []string{
+		"table users: row 3: NULL in NOT NULL column name",
+		"table users: duplicate value 1 in PRIMARY KEY column id",
+		"table users: duplicate value 'a@example.com' in UNIQUE column email",
+		"table users: duplicate value 'alice' in UNIQUE index idx_name",
+	}
+	if got := integrityCheck(t, d); !reflect.DeepEqual(got, expected) {
+		t.Errorf("integrity_check found:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+	if got := rowValues(execAll(t, d, "PRAGMA integrity_check(2)")); strings.Count(got, "integrity_check") != 2 {
+		t.Errorf("integrity_check(2) returned %s", got)
+	}
+	if _, err := execute(d, "PRAGMA no_such_pragma"); err == nil {
+		t.Error("Expected an unknown pragma to fail")
+	}
+}
+
+func TestIntegrityCheckFindsDamagedPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO users VALUES (1, 'alice')",
+		"CREATE TABLE notes (body TEXT)",
+		"INSERT INTO notes VALUES ('a note to damage')",
+	)
+	d.Close()
+
+	// Change one character of the note in the file
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := bytes.Index(data, []byte("a note to damage"))
+	if at < 0 {
+		t.Fatal("The note is not in the file")
+	}
+	data[at] = 'A'
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer d.Close()
+	if _, err := execute(d, "SELECT * FROM notes"); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Reading the damaged table returned %v, expected a checksum error", err)
+	}
+	execAll(t, d, "SELECT * FROM users")
+
+	got := integrityCheck(t, d)
+	if len(got) != 2 || !strings.Contains(got[0], "checksum mismatch") || !strings.Contains(got[1], "notes") {
+		t.Errorf("integrity_check found %v, expected the damaged page and table", got)
+	}
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// B+ tree keys
+//
+// A Key is a tuple of column values. Keys compare column by column, and a
+// key that is a prefix of another sorts first, so a prefix can be used to
+// seek to the first key starting with it. Key comparison is a total order
+// over the storage classes of SQL values:
+//   - NULL sorts before everything else and equals NULL.
+//   - int, int64, bool (as 0/1) and float64 are numbers and compare exactly
+//     by value across types; NaN sorts before every other number.
+//   - Text sorts after numbers and compares by the column's collation.
+//   - Blobs ([]byte) sort after text and compare byte by byte. Values of any
+//     other type compare as blobs of their textual representation.
+//
+// Numeric strings are text here; callers wanting them to match numbers, as
+// "=" and ORDER BY do, normalise them first (see keyValue).
+
+// Collation determines how text values of a key column compare
+type Collation int
+
+const (
+	// CollateNoCase compares text case-insensitively, matching "=" and
+	// ORDER BY. It is the default.
+	CollateNoCase Collation = iota
+	// CollateBinary compares text byte by byte
+	CollateBinary
+)
+
+// Key is a B+ tree key holding one value per key column
+type Key []interface{}
+
+// String formats a key for messages: the value itself for single-column
+// keys and a parenthesised list otherwise
+func (k Key) String() string {
+	if len(k) == 1 {
+		return fmt.Sprintf("%v", k[0])
+	}
+	values := make([]string, len(k))
+	for i, v := range k {
+		values[i] = fmt.Sprintf("%v", v)
+	}
+	return "(" + strings.Join(values, ", ") + ")"
+}
+
+// compareKeys orders two keys, comparing the text in column i with
+// collations[i]; columns without a collation use CollateNoCase
+func compareKeys(a, b Key, collations []Collation) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		collation := CollateNoCase
+		if i < len(collations) {
+			collation = collations[i]
+		}
+		if cmp := compareKeyValues(a[i], b[i], collation); cmp != 0 {
+			return cmp
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+// compareKeyValues orders two key column values
+func compareKeyValues(a, b interface{}, collation Collation) int {
+	ca, cb := valueClass(a), valueClass(b)
+	if ca != cb {
+		if ca < cb {
+			return -1
+		}
+		return 1
+	}
+
+	switch ca {
+	case classNull:
+		return 0
+	case classNumeric:
+		return compareNumbers(a, b)
+	case classText:
+		if collation == CollateBinary {
+			return strings.Compare(a.(string), b.(string))
+		}
+		return strings.Compare(strings.ToLower(a.(string)), strings.ToLower(b.(string)))
+	default:
+		return bytes.Compare(blobValue(a), blobValue(b))
+	}
+}
+
+// blobValue returns the bytes of a blob, or the textual representation of
+// a value of another type
+func blobValue(value interface{}) []byte {
+	if b, ok := value.([]byte); ok {
+		return b
+	}
+	return []byte(fmt.Sprintf("%v", value))
+}
+
+// integerValue returns the value of an int, int64 or bool
+func integerValue(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// compareNumbers orders two numeric values without losing the precision of
+// integers too large for a float64
+func compareNumbers(a, b interface{}) int {
+	ai, aInt := integerValue(a)
+	bi, bInt := integerValue(b)
+	switch {
+	case aInt && bInt:
+		return compareInts(ai, bi)
+	case aInt:
+		return -compareFloatInt(b.(float64), ai)
+	case bInt:
+		return compareFloatInt(a.(float64), bi)
+	}
+
+	fa, fb := a.(float64), b.(float64)
+	if math.IsNaN(fa) || math.IsNaN(fb) {
+		return compareInts(nanRank(fa), nanRank(fb))
+	}
+	return compareFloats(fa, fb)
+}
+
+// compareFloatInt orders a float64 against an integer exactly
+func compareFloatInt(f float64, i int64) int {
+	switch {
+	case math.IsNaN(f), f < math.MinInt64:
+		return -1
+	case f >= math.MaxInt64:
+		return 1
+	}
+
+	// f is within the int64 range, so its integer part converts exactly
+	whole := math.Trunc(f)
+	if cmp := compareInts(int64(whole), i); cmp != 0 {
+		return cmp
+	}
+	return compareFloats(f, whole)
+}
+
+// nanRank places NaN before every other float64
+func nanRank(f float64) int64 {
+	if math.IsNaN(f) {
+		return 0
+	}
+	return 1
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
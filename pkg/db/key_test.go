@@ -0,0 +1,121 @@
+package db
+
+import (
+	"math"
+	"testing"
+
+	"sqlight/pkg/interfaces"
+)
+
+func TestCompareKeysOrdering(t *testing.T) {
+	// Ascending order: NULL, numbers, text, blobs
+	ordered := []Key{
+		{nil},
+		{math.NaN()},
+		{math.Inf(-1)},
+		{-3},
+		{0.5},
+		{true},
+		{int64(2)},
+		{2.5},
+		{math.MaxInt64 - 1},
+		{int64(math.MaxInt64)},
+		{math.Inf(1)},
+		{"10"},
+		{"apple"},
+		{"Banana"},
+		{[]byte{0x00}},
+		{[]byte{0x00, 0x01}},
+		{[]byte("a")},
+	}
+	for i := 0; i < len(ordered)-1; i++ {
+		if c := compareKeys(ordered[i], ordered[i+1], nil); c >= 0 {
+			t.Errorf("Expected %v < %v, compareKeys returned %d", ordered[i], ordered[i+1], c)
+		}
+		if c := compareKeys(ordered[i+1], ordered[i], nil); c <= 0 {
+			t.Errorf("Expected %v > %v, compareKeys returned %d", ordered[i+1], ordered[i], c)
+		}
+	}
+}
+
+func TestCompareKeysEquality(t *testing.T) {
+	tests := []struct {
+		a, b       Key
+		collations []Collation
+		expected   int
+	}{
+		{Key{nil}, Key{nil}, nil, 0},
+		{Key{2}, Key{2.0}, nil, 0},
+		{Key{1}, Key{true}, nil, 0},
+		{Key{1 << 53}, Key{float64(1 << 53)}, nil, 0},
+		{Key{1<<53 + 1}, Key{float64(1 << 53)}, nil, 1},
+		{Key{"Alice"}, Key{"alice"}, nil, 0},
+		{Key{"Alice"}, Key{"alice"}, []Collation{CollateBinary}, -1},
+		{Key{"2"}, Key{2}, nil, 1},
+		{Key{[]byte("ab")}, Key{[]byte("ab")}, nil, 0},
+	}
+
+	for _, tt := range tests {
+		if got := compareKeys(tt.a, tt.b, tt.collations); got != tt.expected {
+			t.Errorf("compareKeys(%v, %v, %v) = %d, expected %d", tt.a, tt.b, tt.collations, got, tt.expected)
+		}
+	}
+}
+
+func TestCompareCompositeKeys(t *testing.T) {
+	collations := []Collation{CollateNoCase, CollateBinary}
+	ordered := []Key{
+		{},
+		{nil, "z"},
+		{1},
+		{1, nil},
+		{1, "B"},
+		{1, "a"},
+		{"a", "B"},
+		{"A", "b"},
+	}
+	for i := 0; i < len(ordered)-1; i++ {
+		if c := compareKeys(ordered[i], ordered[i+1], collations); c >= 0 {
+			t.Errorf("Expected %v < %v, compareKeys returned %d", ordered[i], ordered[i+1], c)
+		}
+	}
+}
+
+func TestBTreeTextAndCompositeKeys(t *testing.T) {
+	names := []string{"carol", "Alice", "dave", "bob", "alice"}
+
+	// Binary collation keeps keys differing only in case apart
+	tree := NewBTree(CollateBinary, CollateNoCase)
+	simple := NewBTreeSimple(CollateBinary, CollateNoCase)
+	for i, name := range names {
+		record := &interfaces.Record{Columns: map[string]interface{}{"name": name}}
+		tree.Insert(Key{name, i}, record)
+		if err := simple.Insert(Key{name, i}, record); err != nil {
+			t.Fatalf("BTreeSimple.Insert failed: %v", err)
+		}
+	}
+
+	expected := []string{"Alice", "alice", "bob", "carol", "dave"}
+	for _, records := range [][]*interfaces.Record{tree.Scan(), simple.Scan()} {
+		for i, record := range records {
+			if record.Columns["name"] != expected[i] {
+				t.Errorf("Record %d is %v, expected %s", i, record.Columns["name"], expected[i])
+			}
+		}
+	}
+
+	if record := tree.Search(Key{"alice", 4}); record == nil || record.Columns["name"] != "alice" {
+		t.Errorf("Search found %v, expected alice", record)
+	}
+	if record := simple.Search(Key{"ALICE", 4}); record != nil {
+		t.Errorf("Binary collation Search found %v for ALICE", record.Columns)
+	}
+	if err := simple.Insert(Key{"bob", 3}, nil); err == nil {
+		t.Error("Expected error inserting a duplicate key into BTreeSimple")
+	}
+
+	tree.Delete(Key{"bob", 3})
+	if record := tree.Search(Key{"bob", 3}); record != nil {
+		t.Errorf("Found %v after delete", record.Columns)
+	}
+}
@@ -0,0 +1,123 @@
+package db
+
+import "fmt"
+
+// Predicate evaluation
+//
+// Every comparison in SELECT, UPDATE and DELETE goes through evalComparison,
+// and every ordering decision goes through compareValues, so that WHERE,
+// ORDER BY and constraint checks agree on what "equal" and "less" mean.
+//
+// NULL handling follows SQL three-valued logic: a comparison with a NULL
+// operand is UNKNOWN (represented as nil), NOT UNKNOWN is UNKNOWN, and
+// AND / OR only produce UNKNOWN when the known operand does not decide the
+// result. A WHERE clause keeps a row only when it evaluates to TRUE.
+//
+// Coercion rules for non-NULL operands:
+//   - int, int64, float64 and bool (as 0/1) are numeric and compare by value.
+//   - A string that parses as a number is that number, as in index keys
+//     (see keyValue), so '10' = 10 and '9' < '10'; any other string sorts
+//     after every number, so it is never equal to one.
+//   - Two other strings compare case-insensitively; strings that differ
+//     only in case are equal.
+//   - Values of any other type compare by their textual representation and
+//     sort after strings.
+//
+// For sorting, NULL sorts before every other value. Each value falls in one
+// type class before comparing, as key comparison does, so the order is
+// total and ORDER BY agrees with index scans.
+
+// Type classes in ascending sort order
+const (
+	classNull = iota
+	classNumeric
+	classText
+	classOther
+)
+
+// valueClass returns the sort class of a value
+func valueClass(value interface{}) int {
+	switch value.(type) {
+	case nil:
+		return classNull
+	case int, int64, float64, bool:
+		return classNumeric
+	case string:
+		return classText
+	default:
+		return classOther
+	}
+}
+
+// numericValue returns the numeric value of an int, int64, float64 or bool
+func numericValue(value interface{}) float64 {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+	}
+	return 0
+}
+
+// compareValues returns -1, 0 or 1 depending on whether a sorts before,
+// equal to, or after b under the coercion rules above
+func compareValues(a, b interface{}) int {
+	return compareKeyValues(keyValue(a), keyValue(b), CollateNoCase)
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// evalComparison applies a comparison operator, returning true, false, or
+// nil when either operand is NULL
+func evalComparison(op string, left, right interface{}) (interface{}, error) {
+	if left == nil || right == nil {
+		return nil, nil
+	}
+
+	cmp := compareValues(left, right)
+	switch op {
+	case "=":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case ">":
+		return cmp > 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return nil, fmt.Errorf("unsupported comparison operator %s", op)
+}
+
+// sameValue reports whether two non-NULL values conflict in a PRIMARY KEY or
+// UNIQUE column. Unlike "=", strings must match exactly, so 'Bob' and 'bob'
+// are distinct keys.
+func sameValue(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	if sa, ok := a.(string); ok {
+		if sb, ok := b.(string); ok {
+			return sa == sb
+		}
+	}
+	return compareValues(a, b) == 0
+}
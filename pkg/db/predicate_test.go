@@ -0,0 +1,266 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"sqlight/pkg/interfaces"
+	"sqlight/pkg/storage"
+)
+
+func TestEvalComparison(t *testing.T) {
+	tests := []struct {
+		name        string
+		left, right interface{}
+		// expected results for =, !=, <, >, <=, >= (nil means UNKNOWN)
+		expected [6]interface{}
+	}{
+		{"int int less", 1, 2, [6]interface{}{false, true, true, false, true, false}},
+		{"int int equal", 5, 5, [6]interface{}{true, false, false, false, true, true}},
+		{"int float equal", 2, 2.0, [6]interface{}{true, false, false, false, true, true}},
+		{"float int greater", 2.5, 2, [6]interface{}{false, true, false, true, false, true}},
+		{"float float less", 1.25, 1.5, [6]interface{}{false, true, true, false, true, false}},
+		{"int numeric string", 10, "9", [6]interface{}{false, true, false, true, false, true}},
+		{"numeric string float", "1.5", 1.5, [6]interface{}{true, false, false, false, true, true}},
+		{"int text", 10, "abc", [6]interface{}{false, true, true, false, true, false}},
+		{"text int", "abc", 10, [6]interface{}{false, true, false, true, false, true}},
+		{"text text less", "apple", "banana", [6]interface{}{false, true, true, false, true, false}},
+		{"text text case", "Alice", "alice", [6]interface{}{true, false, false, false, true, true}},
+		{"bool int", true, 1, [6]interface{}{true, false, false, false, true, true}},
+		{"null int", nil, 1, [6]interface{}{nil, nil, nil, nil, nil, nil}},
+		{"text null", "a", nil, [6]interface{}{nil, nil, nil, nil, nil, nil}},
+		{"null null", nil, nil, [6]interface{}{nil, nil, nil, nil, nil, nil}},
+	}
+
+	operators := []string{"=", "!=", "<", ">", "<=", ">="}
+	for _, tt := range tests {
+		for i, op := range operators {
+			got, err := evalComparison(op, tt.left, tt.right)
+			if err != nil {
+				t.Fatalf("%s: %v %s %v returned error: %v", tt.name, tt.left, op, tt.right, err)
+			}
+			if got != tt.expected[i] {
+				t.Errorf("%s: %v %s %v = %v, expected %v", tt.name, tt.left, op, tt.right, got, tt.expected[i])
+			}
+		}
+
+		// IS [NOT] NULL is never UNKNOWN, even where = NULL is
+		for _, not := range []bool{false, true} {
+			expr := &interfaces.IsNullExpr{Expr: &interfaces.Literal{Value: tt.left}, Not: not}
+			got, err := evalExpr(expr, nil)
+			if err != nil {
+				t.Fatalf("%s: %s returned error: %v", tt.name, expr, err)
+			}
+			if expected := (tt.left == nil) != not; got != expected {
+				t.Errorf("%s: %s = %v, expected %v", tt.name, expr, got, expected)
+			}
+		}
+	}
+}
+
+func TestCompareValuesOrdering(t *testing.T) {
+	// Ascending order: NULL, numbers, text
+	ordered := []interface{}{nil, -3, 0.5, 2, "9", "10.5", 11, "apple", "Banana", "cherry"}
+	for i := 0; i < len(ordered)-1; i++ {
+		if c := compareValues(ordered[i], ordered[i+1]); c >= 0 {
+			t.Errorf("Expected %v < %v, compareValues returned %d", ordered[i], ordered[i+1], c)
+		}
+		if c := compareValues(ordered[i+1], ordered[i]); c <= 0 {
+			t.Errorf("Expected %v > %v, compareValues returned %d", ordered[i+1], ordered[i], c)
+		}
+	}
+}
+
+func TestOrderByMixedTypes(t *testing.T) {
+	d, err := NewDatabase(storage.NewMemoryBackend())
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE vals (id INTEGER PRIMARY KEY, v TEXT)",
+		"INSERT INTO vals VALUES (1, '10'), (2, 2), (3, '9'), (4, 'apple'), (5, 1.5)",
+		"INSERT INTO vals VALUES (6, 'Banana'), (7, NULL), (8, 11), (9, '2')",
+	)
+
+	// Numeric strings sort among the numbers, and '2' ties with 2
+	expected := []int{7, 5, 2, 9, 3, 1, 8, 4, 6}
+	if got := orderedIDs(t, d, "SELECT id FROM vals ORDER BY v, id"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("ORDER BY v returned %v, expected %v", got, expected)
+	}
+	reversed := make([]int, len(expected))
+	for i, id := range expected {
+		reversed[len(expected)-1-i] = id
+	}
+	if got := orderedIDs(t, d, "SELECT id FROM vals ORDER BY v DESC, id DESC"); !reflect.DeepEqual(got, reversed) {
+		t.Errorf("ORDER BY v DESC returned %v, expected %v", got, reversed)
+	}
+}
+
+func TestThreeValuedLogic(t *testing.T) {
+	trueExpr := &interfaces.Literal{Value: true}
+	falseExpr := &interfaces.Literal{Value: false}
+	nullExpr := &interfaces.Literal{Value: nil}
+
+	tests := []struct {
+		name     string
+		expr     interfaces.Expr
+		expected interface{}
+	}{
+		{"TRUE AND NULL", &interfaces.BinaryExpr{Op: "AND", Left: trueExpr, Right: nullExpr}, nil},
+		{"FALSE AND NULL", &interfaces.BinaryExpr{Op: "AND", Left: falseExpr, Right: nullExpr}, false},
+		{"NULL AND FALSE", &interfaces.BinaryExpr{Op: "AND", Left: nullExpr, Right: falseExpr}, false},
+		{"TRUE AND TRUE", &interfaces.BinaryExpr{Op: "AND", Left: trueExpr, Right: trueExpr}, true},
+		{"TRUE OR NULL", &interfaces.BinaryExpr{Op: "OR", Left: trueExpr, Right: nullExpr}, true},
+		{"NULL OR TRUE", &interfaces.BinaryExpr{Op: "OR", Left: nullExpr, Right: trueExpr}, true},
+		{"FALSE OR NULL", &interfaces.BinaryExpr{Op: "OR", Left: falseExpr, Right: nullExpr}, nil},
+		{"FALSE OR FALSE", &interfaces.BinaryExpr{Op: "OR", Left: falseExpr, Right: falseExpr}, false},
+		{"NOT NULL", &interfaces.UnaryExpr{Op: "NOT", Expr: nullExpr}, nil},
+		{"NOT FALSE", &interfaces.UnaryExpr{Op: "NOT", Expr: falseExpr}, true},
+		{"NULL IS NULL", &interfaces.IsNullExpr{Expr: nullExpr}, true},
+		{"NULL IS NOT NULL", &interfaces.IsNullExpr{Expr: nullExpr, Not: true}, false},
+		{"FALSE IS NULL", &interfaces.IsNullExpr{Expr: falseExpr}, false},
+		{"NOT (NULL IS NULL)", &interfaces.UnaryExpr{Op: "NOT", Expr: &interfaces.IsNullExpr{Expr: nullExpr}}, false},
+		{"(NULL = 1) IS NULL", &interfaces.IsNullExpr{Expr: &interfaces.BinaryExpr{Op: "=", Left: nullExpr, Right: &interfaces.Literal{Value: 1}}}, true},
+		{"NULL IS NULL AND NULL", &interfaces.BinaryExpr{Op: "AND", Left: &interfaces.IsNullExpr{Expr: nullExpr}, Right: nullExpr}, nil},
+	}
+
+	for _, tt := range tests {
+		got, err := evalExpr(tt.expr, nil)
+		if err != nil {
+			t.Fatalf("%s returned error: %v", tt.name, err)
+		}
+		if got != tt.expected {
+			t.Errorf("%s = %v, expected %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestMatchesWhereWithNullColumn(t *testing.T) {
+	s := newTableScope(&interfaces.Table{Name: "t", Columns: []interfaces.Column{{Name: "age"}}}, "t")
+	r := s.row(&interfaces.Record{Columns: map[string]interface{}{"age": nil}})
+
+	// Neither age > 30 nor NOT (age > 30) keeps a row whose age is NULL
+	cmp := &interfaces.BinaryExpr{Op: ">", Left: &interfaces.ColumnRef{Name: "age"}, Right: &interfaces.Literal{Value: 30}}
+	for _, where := range []interfaces.Expr{cmp, &interfaces.UnaryExpr{Op: "NOT", Expr: cmp}} {
+		match, err := matchesWhere(r, where)
+		if err != nil {
+			t.Fatalf("matchesWhere returned error: %v", err)
+		}
+		if match {
+			t.Errorf("Expected %s not to match a NULL age", where)
+		}
+	}
+
+	// IS NULL selects it, and IS NOT NULL excludes it
+	for _, not := range []bool{false, true} {
+		where := &interfaces.IsNullExpr{Expr: &interfaces.ColumnRef{Name: "age"}, Not: not}
+		match, err := matchesWhere(r, where)
+		if err != nil {
+			t.Fatalf("matchesWhere returned error: %v", err)
+		}
+		if match == not {
+			t.Errorf("%s matched a NULL age: %v", where, match)
+		}
+	}
+}
+
+func TestDeleteWithFailingWhereRemovesNothing(t *testing.T) {
+	d, err := NewDatabase(storage.NewMemoryBackend())
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE items (id INTEGER PRIMARY KEY, qty TEXT)",
+		"INSERT INTO items VALUES (1, '5'), (2, '7'), (3, 'many'), (4, '9')",
+	)
+
+	// WHERE fails on the third row, after matching the first two
+	if _, err := execute(d, "DELETE FROM items WHERE qty + 1 > 0"); err == nil {
+		t.Fatal("Expected DELETE to fail")
+	}
+	if got := orderedIDs(t, d, "SELECT id FROM items"); !reflect.DeepEqual(got, []int{1, 2, 3, 4}) {
+		t.Errorf("Rows after the failed DELETE are %v, expected all of them", got)
+	}
+}
+
+func TestEvalInList(t *testing.T) {
+	list := func(values ...interface{}) []interfaces.Expr {
+		exprs := make([]interfaces.Expr, len(values))
+		for i, v := range values {
+			exprs[i] = &interfaces.Literal{Value: v}
+		}
+		return exprs
+	}
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		list     []interfaces.Expr
+		expected interface{} // result of IN; NOT IN is its negation
+	}{
+		{"match", 2, list(1, 2, 3), true},
+		{"numeric string match", "2", list(1, 2), true},
+		{"no match", 5, list(1, 2, 3), false},
+		{"no match with NULL item", 5, list(1, nil), nil},
+		{"match with NULL item", 1, list(1, nil), true},
+		{"NULL value", nil, list(1, 2), nil},
+	}
+
+	for _, tt := range tests {
+		for _, not := range []bool{false, true} {
+			expr := &interfaces.InExpr{Expr: &interfaces.Literal{Value: tt.value}, List: tt.list, Not: not}
+			expected := tt.expected
+			if not && expected != nil {
+				expected = !expected.(bool)
+			}
+
+			got, err := evalExpr(expr, nil)
+			if err != nil {
+				t.Fatalf("%s: %s returned error: %v", tt.name, expr, err)
+			}
+			if got != expected {
+				t.Errorf("%s: %s = %v, expected %v", tt.name, expr, got, expected)
+			}
+		}
+	}
+}
+
+func TestEvalBetween(t *testing.T) {
+	tests := []struct {
+		value, low, high interface{}
+		expected         interface{} // result of BETWEEN; NOT BETWEEN is its negation
+	}{
+		{5, 1, 10, true},
+		{1, 1, 10, true},
+		{10, 1, 10, true},
+		{11, 1, 10, false},
+		{"b", "a", "c", true},
+		{5, 10, 1, false},
+		{nil, 1, 10, nil},
+		{5, nil, 10, nil},
+		{20, nil, 10, false},
+	}
+
+	for _, tt := range tests {
+		for _, not := range []bool{false, true} {
+			expr := &interfaces.BetweenExpr{
+				Expr: &interfaces.Literal{Value: tt.value},
+				Low:  &interfaces.Literal{Value: tt.low},
+				High: &interfaces.Literal{Value: tt.high},
+				Not:  not,
+			}
+			expected := tt.expected
+			if not && expected != nil {
+				expected = !expected.(bool)
+			}
+
+			got, err := evalExpr(expr, nil)
+			if err != nil {
+				t.Fatalf("%s returned error: %v", expr, err)
+			}
+			if got != expected {
+				t.Errorf("%s = %v, expected %v", expr, got, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package db
+
+import (
+	"fmt"
+
+	"sqlight/pkg/interfaces"
+	"sqlight/pkg/storage"
+)
+
+// Row storage
+//
+// The rows of a table live in a clustered B+ tree, its row store, keyed by
+// the table's PRIMARY KEY or, for a table without one, by a rowid assigned
+// when the row is inserted. Full scans return rows in key order, and for a
+// table with a PRIMARY KEY the row store also serves as the index enforcing
+// it. Table.Records is only the serialised form of the rows: it seeds the
+// row store when the table is first used, after which it is left empty, and
+// it is filled in from the row store when the database is exported as JSON.
+//
+// A table read from a backend has no row store until it is first used: its
+// rows stay in the backend, and the database records the name they are
+// saved under in its unloaded set. Opening a database therefore reads only
+// its catalog, and a table's rows are read when a statement first uses it.
+//
+// A backend with a cache budget, such as a page file, also bounds the row
+// stores kept between statements: after each statement the least recently
+// used stores without unsaved changes are dropped, and their tables
+// unloaded again, until the rest fit the budget. A statement still holds
+// every row of the tables it uses, but a database may be larger than
+// memory as long as no one table is.
+//
+// Every row has a rowid, including the rows of a table with a PRIMARY KEY,
+// and it stays with the row when the row is updated. A page file stores
+// rows by rowid, so the store records which rowids changed since the table
+// was last saved, which lets a backend write only those rows.
+
+// rowStore holds the rows of a table
+type rowStore struct {
+	table     *interfaces.Table
+	tree      *BTree
+	rowids    map[*interfaces.Record]int64
+	lastRowid int64
+	keyed     bool   // keyed by PRIMARY KEY rather than rowid
+	size      int    // estimated bytes held by the rows
+	used      uint64 // when the store was last used, for eviction
+
+	// Changes since the table was last saved to a page file: the rows
+	// inserted or updated by rowid, nil for deleted ones, and whether every
+	// row must be rewritten. saved is the name the table was saved under,
+	// or "" if it has not been saved.
+	changed map[int64]*interfaces.Record
+	rewrite bool
+	saved   string
+}
+
+// store returns the row store of a table, building it from the table's
+// records or reading its rows from the backend on first use
+func (d *Database) store(table *interfaces.Table) (*rowStore, error) {
+	if st, exists := d.stores[table]; exists {
+		d.clock++
+		st.used = d.clock
+		return st, nil
+	}
+	if _, unloaded := d.unloaded[table]; unloaded {
+		if err := d.loadTable(table); err != nil {
+			return nil, err
+		}
+		return d.stores[table], nil
+	}
+
+	st := d.newStore(table)
+	for _, record := range table.Records {
+		st.lastRowid++
+		st.add(record, st.lastRowid)
+	}
+	table.Records = nil
+	st.rewrite = true
+	return st, nil
+}
+
+// newStore creates an empty row store for a table
+func (d *Database) newStore(table *interfaces.Table) *rowStore {
+	st := &rowStore{
+		table:   table,
+		tree:    NewBTree(),
+		rowids:  make(map[*interfaces.Record]int64),
+		keyed:   primaryKey(table) != nil,
+		changed: make(map[int64]*interfaces.Record),
+	}
+	if d.stores == nil {
+		d.stores = make(map[*interfaces.Table]*rowStore)
+	}
+	d.stores[table] = st
+	d.clock++
+	st.used = d.clock
+	return st
+}
+
+// cloneStore copies the row store of a table for a copy of the table,
+// copying each record and keeping its rowid
+func (d *Database) cloneStore(table, copied *interfaces.Table) error {
+	// A table that has not been loaded is copied by reading it again
+	if saved, unloaded := d.unloaded[table]; unloaded {
+		d.unloaded[copied] = saved
+		return nil
+	}
+
+	st, err := d.store(table)
+	if err != nil {
+		return err
+	}
+	clone := d.newStore(copied)
+	clone.lastRowid, clone.rewrite, clone.saved = st.lastRowid, st.rewrite, st.saved
+
+	for _, record := range st.tree.Scan() {
+		newRecord := &interfaces.Record{
+			Columns: make(map[string]interface{}, len(record.Columns)),
+		}
+		for k, v := range record.Columns {
+			newRecord.Columns[k] = v
+		}
+		rowid := st.rowids[record]
+		clone.add(newRecord, rowid)
+		if _, changed := st.changed[rowid]; changed {
+			clone.changed[rowid] = newRecord
+		}
+	}
+	for rowid, record := range st.changed {
+		if record == nil {
+			clone.changed[rowid] = nil
+		}
+	}
+	return nil
+}
+
+// loadTable reads the rows of a table that has not been loaded from the
+// backend into a new row store
+func (d *Database) loadTable(table *interfaces.Table) error {
+	saved, unloaded := d.unloaded[table]
+	if !unloaded {
+		return nil
+	}
+	if d.backend == nil {
+		return fmt.Errorf("database is closed")
+	}
+	st := d.newStore(table)
+	err := d.backend.ReadTable(saved, func(rowid int64, record *interfaces.Record) error {
+		st.add(record, rowid)
+		return nil
+	})
+	if err != nil {
+		delete(d.stores, table)
+		return err
+	}
+	st.clean(saved)
+	delete(d.unloaded, table)
+	return nil
+}
+
+// evict drops the least recently used row stores without unsaved changes
+// until the rows held fit the backend's cache budget; their tables are read
+// from the backend again when next used. Backends without a budget keep
+// every table loaded.
+func (d *Database) evict() {
+	b, ok := d.backend.(interface{ CacheSize() int })
+	if !ok {
+		return
+	}
+	size := 0
+	for _, st := range d.stores {
+		size += d.held(st)
+	}
+	for size > b.CacheSize() {
+		var oldest *rowStore
+		for _, st := range d.stores {
+			if st.unchanged() && (oldest == nil || st.used < oldest.used) {
+				oldest = st
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		size -= d.held(oldest)
+		d.unloaded[oldest.table] = oldest.saved
+		delete(d.stores, oldest.table)
+		delete(d.indexCache, oldest.table)
+	}
+}
+
+// held estimates the memory a row store and the cached index trees of its
+// table take, in bytes
+func (d *Database) held(st *rowStore) int {
+	size := st.size
+	for _, idx := range d.indexCache[st.table] {
+		if !idx.clustered {
+			size += 64 * len(st.rowids)
+		}
+	}
+	return size
+}
+
+// records returns the rows of a table in key order
+func (d *Database) records(table *interfaces.Table) ([]*interfaces.Record, error) {
+	st, err := d.store(table)
+	if err != nil {
+		return nil, err
+	}
+	return st.tree.Scan(), nil
+}
+
+// serialize returns a copy of each table with its records filled in from
+// its row store, in key order, for saving
+func (d *Database) serialize(tables map[string]*interfaces.Table) (map[string]*interfaces.Table, error) {
+	serialized := make(map[string]*interfaces.Table, len(tables))
+	for name, table := range tables {
+		records, err := d.records(table)
+		if err != nil {
+			return nil, fmt.Errorf("reading table %s: %v", name, err)
+		}
+		copied := *table
+		copied.Records = append(make([]*interfaces.Record, 0), records...)
+		serialized[name] = &copied
+	}
+	return serialized, nil
+}
+
+// primaryKey returns the PRIMARY KEY column of a table, or nil
+func primaryKey(table *interfaces.Table) *interfaces.Column {
+	for i := range table.Columns {
+		if table.Columns[i].PrimaryKey {
+			return &table.Columns[i]
+		}
+	}
+	return nil
+}
+
+// key returns the row store key of a record in the store. PRIMARY KEY
+// values are normalised like index keys, so the store can stand in for the
+// PRIMARY KEY index.
+func (st *rowStore) key(record *interfaces.Record) Key {
+	if !st.keyed {
+		return Key{st.rowids[record]}
+	}
+	return Key{keyValue(record.Columns[primaryKey(st.table).Name])}
+}
+
+// recordSize estimates the memory a record takes in a row store, in bytes:
+// the record, its column map and its entries in the tree and rowid map, and
+// the bytes of its values
+func recordSize(record *interfaces.Record) int {
+	size := 128
+	for name, value := range record.Columns {
+		size += 48 + len(name)
+		switch v := value.(type) {
+		case string:
+			size += len(v)
+		case []byte:
+			size += len(v)
+		}
+	}
+	return size
+}
+
+// measure recomputes the size of the rows, after they were changed in place
+func (st *rowStore) measure() {
+	st.size = 0
+	for record := range st.rowids {
+		st.size += recordSize(record)
+	}
+}
+
+// add places a record with the given rowid without recording a change
+func (st *rowStore) add(record *interfaces.Record, rowid int64) {
+	st.rowids[record] = rowid
+	st.size += recordSize(record)
+	if rowid > st.lastRowid {
+		st.lastRowid = rowid
+	}
+	st.tree.Insert(st.key(record), record)
+}
+
+// insert adds a record, giving it the next rowid
+func (st *rowStore) insert(record *interfaces.Record) {
+	st.add(record, st.lastRowid+1)
+	st.changed[st.lastRowid] = record
+}
+
+// delete removes a record
+func (st *rowStore) delete(record *interfaces.Record) {
+	st.tree.DeleteRecord(st.key(record), record)
+	st.changed[st.rowids[record]] = nil
+	delete(st.rowids, record)
+	st.size -= recordSize(record)
+}
+
+// replace swaps a record for its updated version, which keeps the rowid of
+// the old record and moves if its PRIMARY KEY changed
+func (st *rowStore) replace(old, updated *interfaces.Record) {
+	st.tree.DeleteRecord(st.key(old), old)
+	rowid := st.rowids[old]
+	delete(st.rowids, old)
+	st.rowids[updated] = rowid
+	st.changed[rowid] = updated
+	st.tree.Insert(st.key(updated), updated)
+	st.size += recordSize(updated) - recordSize(old)
+}
+
+// unchanged reports whether every row is saved, so that the rows can be
+// read again from the backend
+func (st *rowStore) unchanged() bool {
+	return st.saved != "" && !st.rewrite && len(st.changed) == 0
+}
+
+// clean forgets the changes made since the table was last saved
+func (st *rowStore) clean(name string) {
+	st.changed = make(map[int64]*interfaces.Record)
+	st.rewrite = false
+	st.saved = name
+}
+
+// data returns the table to write to the database's backend under name,
+// with the changes since it was last saved
+func (st *rowStore) data(name string) *storage.TableData {
+	return &storage.TableData{
+		Name:  name,
+		Table: st.table,
+		Rows: func(fn func(rowid int64, record *interfaces.Record) error) error {
+			for _, record := range st.tree.Scan() {
+				if err := fn(st.rowids[record], record); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Saved:   st.saved,
+		Changed: st.changed,
+		Rewrite: st.rewrite,
+	}
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sqlight/pkg/interfaces"
+	"sqlight/pkg/storage"
+)
+
+// columnValues runs a query and returns the values of one column in order,
+// formatted so that numbers read back from a file compare equal
+func columnValues(t *testing.T, d *Database, query, column string) []string {
+	t.Helper()
+	var values []string
+	for _, record := range execAll(t, d, query).Records {
+		values = append(values, fmt.Sprint(record.Columns[column]))
+	}
+	return values
+}
+
+func TestRowStoreKeyOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO users VALUES (30, 'carol'), (10, 'alice'), (20, 'bob')",
+		"INSERT INTO users VALUES (25, 'dave')",
+		"UPDATE users SET id = 5 WHERE name = 'carol'",
+		"DELETE FROM users WHERE id = 20",
+		"CREATE TABLE notes (body TEXT)",
+		"INSERT INTO notes VALUES ('c'), ('a'), ('b')",
+		"UPDATE notes SET body = 'z' WHERE body = 'c'",
+		"DELETE FROM notes WHERE body = 'a'",
+		"INSERT INTO notes VALUES ('d')",
+	)
+
+	// Rows come back by PRIMARY KEY, or in insertion order without one
+	check := func(d *Database, stage string) {
+		if got, expected := columnValues(t, d, "SELECT * FROM users", "id"), []string{"5", "10", "25"}; !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: users ids are %v, expected %v", stage, got, expected)
+		}
+		if got, expected := columnValues(t, d, "SELECT * FROM notes", "body"), []string{"z", "b", "d"}; !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: notes are %v, expected %v", stage, got, expected)
+		}
+	}
+	check(d, "before reopening")
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed on reopening: %v", err)
+	}
+	check(reopened, "after reopening")
+
+	table, _, err := d.getTable("users", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := d.store(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.tree.Check(); err != nil {
+		t.Errorf("Row store: %v", err)
+	}
+	indexes, err := d.indexes(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx := indexes[0]; !idx.clustered || idx.tree != st.tree {
+		t.Error("Expected the PRIMARY KEY index to be the row store")
+	}
+}
+
+func TestRowStoreFailedStatements(t *testing.T) {
+	d, err := NewDatabase(storage.NewMemoryBackend())
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE)",
+		"INSERT INTO users VALUES (1, 'a'), (2, 'b'), (3, 'c')",
+	)
+
+	for _, query := range []string{
+		"INSERT INTO users VALUES (4, 'd'), (5, 'e'), (4, 'f')",
+		"INSERT INTO users VALUES (6, 'g'), (7, 'a')",
+		"UPDATE users SET id = 3 WHERE id = 1",
+		"UPDATE users SET id = 10, email = 'x' WHERE id = 1 OR id = 2",
+	} {
+		if _, err := execute(d, query); err == nil {
+			t.Errorf("Expected %q to fail", query)
+		}
+	}
+
+	expected := []string{"1", "2", "3"}
+	if got := columnValues(t, d, "SELECT * FROM users", "id"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("After failed statements ids are %v, expected %v", got, expected)
+	}
+	if got := columnValues(t, d, "SELECT * FROM users WHERE id = 2", "email"); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("Lookup of id 2 returned %v, expected [b]", got)
+	}
+	execAll(t, d, "INSERT INTO users VALUES (4, 'd')")
+}
+
+// unreadableBackend is a memory backend whose tables cannot be read while
+// err is set, like a damaged file
+type unreadableBackend struct {
+	*storage.MemoryBackend
+	err error
+}
+
+func (b *unreadableBackend) ReadTable(name string, fn func(rowid int64, record *interfaces.Record) error) error {
+	if b.err != nil {
+		return fmt.Errorf("table %s: %v", name, b.err)
+	}
+	return b.MemoryBackend.ReadTable(name, fn)
+}
+
+func TestUnreadableTableFailsStatements(t *testing.T) {
+	backend := &unreadableBackend{MemoryBackend: storage.NewMemoryBackend()}
+	d, err := NewDatabase(backend)
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE)",
+		"CREATE TABLE notes (user_id INTEGER, body TEXT)",
+		"INSERT INTO users VALUES (1, 'a'), (2, 'b')",
+		"INSERT INTO notes VALUES (1, 'hello')",
+	)
+
+	d, err = NewDatabase(backend)
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	backend.err = fmt.Errorf("checksum mismatch")
+	for _, query := range []string{
+		"SELECT * FROM users",
+		"SELECT * FROM notes JOIN users ON users.id = notes.user_id",
+		"INSERT INTO users VALUES (3, 'c')",
+		"UPDATE users SET email = 'x' WHERE id = 1",
+		"DELETE FROM users",
+		"CREATE INDEX idx_body ON notes (body)",
+		"ALTER TABLE users ADD COLUMN name TEXT",
+	} {
+		if _, err := execute(d, query); err == nil {
+			t.Errorf("Expected %q to fail", query)
+		}
+	}
+	if err := d.Save(filepath.Join(t.TempDir(), "copy.json")); err == nil {
+		t.Error("Expected saving a copy to fail")
+	}
+	if got := integrityCheck(t, d); len(got) != 2 {
+		t.Errorf("integrity_check found %v, expected both tables unreadable", got)
+	}
+
+	backend.err = nil
+	if got := columnValues(t, d, "SELECT * FROM users", "email"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Once readable users hold %v, expected [a b]", got)
+	}
+}
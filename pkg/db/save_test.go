@@ -0,0 +1,833 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sqlight/pkg/crypt"
+	"sqlight/pkg/interfaces"
+	"sqlight/pkg/pager"
+	"sqlight/pkg/storage"
+)
+
+// dump returns the schema and rows of every table, with rows in key order
+func dump(t *testing.T, d *Database) map[string]*interfaces.Table {
+	t.Helper()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	tables, err := d.serialize(d.tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tables
+}
+
+func TestPageFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL, score FLOAT, active BOOLEAN DEFAULT TRUE)",
+		"CREATE UNIQUE INDEX idx_name ON users (name)",
+		"INSERT INTO users VALUES (3, 'carol', 2.5, FALSE), (1, 'alice', NULL, TRUE), (2, 'bob', 7, TRUE)",
+		"CREATE TABLE notes (body TEXT, n INT DEFAULT 5)",
+		"INSERT INTO notes (body) VALUES ('a'), ('b'), ('c')",
+		"CREATE TABLE scratch (x INT)",
+		"INSERT INTO scratch VALUES (1)",
+		"UPDATE users SET id = 4 WHERE name = 'alice'",
+		"DELETE FROM notes WHERE body = 'b'",
+		"ALTER TABLE notes ADD COLUMN tag TEXT DEFAULT 'new'",
+		"ALTER TABLE users RENAME COLUMN score TO points",
+		"ALTER TABLE notes RENAME TO memos",
+		"DROP TABLE scratch",
+		"BEGIN TRANSACTION",
+		"INSERT INTO memos (body) VALUES ('d')",
+		"ALTER TABLE memos DROP COLUMN n",
+		"CREATE TABLE scratch (y TEXT)",
+		"INSERT INTO scratch VALUES ('kept')",
+		"COMMIT",
+		"BEGIN TRANSACTION",
+		"DELETE FROM users",
+		"DROP TABLE memos",
+		"ROLLBACK",
+	)
+	expected := dump(t, d)
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed on reopening: %v", err)
+	}
+	defer reopened.Close()
+	if got := dump(t, reopened); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Reopened database differs:\n got %v\nwant %v", got, expected)
+	}
+
+	// Integers come back as integers, and later changes still save
+	execAll(t, reopened, "INSERT INTO users (id, name) VALUES (5, 'erin')")
+	if _, err := execute(reopened, "INSERT INTO users (id, name) VALUES (6, 'bob')"); err == nil {
+		t.Error("Expected the unique index to survive reopening")
+	}
+	result := execAll(t, reopened, "SELECT id FROM users WHERE name = 'erin'")
+	if id := result.Records[0].Columns["id"]; id != 5 {
+		t.Errorf("Reopened id is %#v, expected 5", id)
+	}
+}
+
+func TestPageFileSingleInsertWritesFewPages(t *testing.T) {
+	d, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer d.Close()
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE events (body TEXT)",
+		"BEGIN TRANSACTION",
+	)
+	for i := 0; i < 2000; i++ {
+		execAll(t, d,
+			"INSERT INTO users (name) VALUES ('a reasonably long user name')",
+			"INSERT INTO events VALUES ('an event that happened')",
+		)
+	}
+	execAll(t, d, "COMMIT")
+
+	for _, query := range []string{
+		"INSERT INTO events VALUES ('one more')",
+		"UPDATE events SET body = 'changed' WHERE body = 'one more'",
+		"DELETE FROM events WHERE body = 'changed'",
+	} {
+		before := d.backend.(*storage.PageBackend).Pager().Stats().PagesWritten
+		execAll(t, d, query)
+		if written := d.backend.(*storage.PageBackend).Pager().Stats().PagesWritten - before; written > 4 {
+			t.Errorf("%s wrote %d of %d pages", query, written, d.backend.(*storage.PageBackend).Pager().PageCount())
+		}
+	}
+}
+
+func TestJSONFilesStayJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.json")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO users VALUES (1, 'alice')",
+	)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables map[string]*interfaces.Table
+	if err := json.Unmarshal(data, &tables); err != nil {
+		t.Fatalf("Expected a JSON file: %v", err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed on reopening: %v", err)
+	}
+	if _, ok := reopened.backend.(*storage.JSONBackend); !ok {
+		t.Error("Expected a JSON database to stay JSON")
+	}
+	if got := columnValues(t, reopened, "SELECT * FROM users", "name"); !reflect.DeepEqual(got, []string{"alice"}) {
+		t.Errorf("Reopened names are %v, expected [alice]", got)
+	}
+}
+
+// rowValues formats the rows of a result with the type of each value
+func rowValues(result *interfaces.Result) string {
+	rows := make([]string, len(result.Records))
+	for i, record := range result.Records {
+		rows[i] = fmt.Sprintf("%#v", record.Columns)
+	}
+	return fmt.Sprint(rows)
+}
+
+func TestJSONReloadKeepsValueTypes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.json")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE items (id INTEGER PRIMARY KEY, n INT UNIQUE, f FLOAT DEFAULT 1.0, s TEXT, b BOOLEAN)",
+	)
+	rng := rand.New(rand.NewSource(1))
+	literals := []string{"NULL", "0", "-7", "2.0", "2.5", "'2'", "TRUE", "9007199254740993"}
+	for id := 1; id <= 200; id++ {
+		execAll(t, d, fmt.Sprintf("INSERT INTO items (id, n, f, s, b) VALUES (%d, %d, %s, %s, %s)", id, id*3,
+			literals[rng.Intn(len(literals))], literals[rng.Intn(len(literals))], literals[rng.Intn(len(literals))]))
+	}
+	execAll(t, d, "INSERT INTO items (id, n) VALUES (201, 4)")
+	expected := dump(t, d)
+	query := "SELECT * FROM items WHERE f > 1 ORDER BY f, id"
+	before := rowValues(execAll(t, d, query))
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed on reopening: %v", err)
+	}
+	if got := dump(t, reopened); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Reopened database differs:\n got %v\nwant %v", got, expected)
+	}
+	if after := rowValues(execAll(t, reopened, query)); after != before {
+		t.Errorf("Query results changed on reopening:\n got %s\nwant %s", after, before)
+	}
+	if _, err := execute(reopened, "INSERT INTO items (id, n) VALUES (202, 3)"); err == nil {
+		t.Error("Expected the UNIQUE constraint to hold after reopening")
+	}
+}
+
+func TestPageFileRecoversFromLog(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer d.Close()
+	execAll(t, d, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")
+	for i := 1; i <= 10; i++ {
+		execAll(t, d, fmt.Sprintf("INSERT INTO users VALUES (%d, 'user %d')", i, i))
+	}
+
+	// Copy the files as a crash would leave them, without closing the
+	// database, and cut the log short at a range of offsets
+	main, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := os.ReadFile(path + "-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := -1
+	for cut := 0; cut <= len(log); cut += 1000 {
+		if cut+1000 > len(log) {
+			cut = len(log)
+		}
+		crashed := filepath.Join(dir, fmt.Sprintf("crash%d.db", cut))
+		if err := os.WriteFile(crashed, main, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(crashed+"-wal", log[:cut], 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		recovered, err := Open(crashed)
+		if err != nil {
+			t.Fatalf("Cut at %d: Open failed: %v", cut, err)
+		}
+		rows := -1
+		if result, err := execute(recovered, "SELECT id FROM users"); err == nil {
+			rows = len(result.Records)
+			for i, record := range result.Records {
+				if id := record.Columns["id"]; id != i+1 {
+					t.Fatalf("Cut at %d: row %d has id %v", cut, i, id)
+				}
+			}
+		}
+		recovered.Close()
+
+		if rows < last {
+			t.Fatalf("Cut at %d: recovered %d rows, fewer than a shorter log", cut, rows)
+		}
+		last = rows
+	}
+	if last != 10 {
+		t.Errorf("Recovered %d rows from the whole log, expected 10", last)
+	}
+}
+
+func TestMemoryBackendKeepsSavedTables(t *testing.T) {
+	backend := storage.NewMemoryBackend()
+	d, err := NewDatabase(backend)
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO users VALUES (2, 'bob'), (1, 'alice')",
+		"BEGIN TRANSACTION",
+		"INSERT INTO users VALUES (3, 'carol')",
+		"COMMIT",
+	)
+	expected := dump(t, d)
+	execAll(t, d, "BEGIN TRANSACTION", "DELETE FROM users")
+	d.Close()
+
+	reopened, err := NewDatabase(backend)
+	if err != nil {
+		t.Fatalf("NewDatabase failed on reopening: %v", err)
+	}
+	if got := dump(t, reopened); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Reopened database differs:\n got %v\nwant %v", got, expected)
+	}
+}
+
+func TestTablesLoadOnFirstUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE events (body TEXT)",
+		"INSERT INTO users VALUES (1, 'alice'), (2, 'bob')",
+		"BEGIN TRANSACTION",
+	)
+	for i := 0; i < 2000; i++ {
+		execAll(t, d, "INSERT INTO events VALUES ('an event that happened')")
+	}
+	execAll(t, d, "COMMIT")
+	d.Close()
+
+	d, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	pages := d.backend.(*storage.PageBackend).Pager()
+	if read := pages.Stats().PagesRead; read > 4 {
+		t.Errorf("Opening read %d of %d pages, expected only the catalog", read, pages.PageCount())
+	}
+	if len(d.unloaded) != 2 {
+		t.Errorf("%d tables unloaded after opening, expected 2", len(d.unloaded))
+	}
+
+	// Using one table, and saving changes to it, leaves the other unread
+	before := pages.Stats().PagesRead
+	execAll(t, d,
+		"INSERT INTO users VALUES (3, 'carol')",
+		"BEGIN TRANSACTION",
+		"UPDATE users SET name = 'bobby' WHERE id = 2",
+		"ROLLBACK",
+		"BEGIN TRANSACTION",
+		"DELETE FROM users WHERE id = 1",
+		"COMMIT",
+	)
+	if read := pages.Stats().PagesRead - before; read > 8 {
+		t.Errorf("Using the small table read %d pages", read)
+	}
+	if len(d.unloaded) != 1 {
+		t.Errorf("%d tables unloaded, expected only events", len(d.unloaded))
+	}
+	if got := rowValues(execAll(t, d, "SELECT COUNT(*) FROM events")); got != `[map[string]interface {}{"COUNT(*)":2000}]` {
+		t.Errorf("Counted %s events", got)
+	}
+	d.Close()
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed on reopening: %v", err)
+	}
+	defer reopened.Close()
+	if got := rowValues(execAll(t, reopened, "SELECT * FROM users")); got != `[map[string]interface {}{"id":2, "name":"bob"} map[string]interface {}{"id":3, "name":"carol"}]` {
+		t.Errorf("Reopened users hold %s", got)
+	}
+}
+
+func TestTablesLargerThanCacheSize(t *testing.T) {
+	const budget = 64 << 10
+	path := filepath.Join(t.TempDir(), "test.db")
+	open := func() *Database {
+		t.Helper()
+		b := storage.NewPageBackend(path)
+		b.SetCacheSize(budget)
+		d, err := NewDatabase(b)
+		if err != nil {
+			t.Fatalf("NewDatabase failed: %v", err)
+		}
+		return d
+	}
+	// held returns the bytes of rows kept in memory between statements
+	held := func(d *Database) int {
+		size := 0
+		for _, st := range d.stores {
+			size += d.held(st)
+		}
+		return size
+	}
+
+	d := open()
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE events (id INTEGER PRIMARY KEY, user_id INTEGER, body TEXT)",
+		"CREATE INDEX idx_user ON events (user_id)",
+		"INSERT INTO users VALUES (1, 'alice'), (2, 'bob')",
+		"BEGIN TRANSACTION",
+	)
+	body := strings.Repeat("x", 200)
+	for i := 1; i <= 2000; i++ {
+		execAll(t, d, fmt.Sprintf("INSERT INTO events VALUES (%d, %d, '%s %d')", i, i%2+1, body, i))
+	}
+	execAll(t, d, "COMMIT")
+	if size := held(d); size > budget {
+		t.Errorf("%d bytes of rows held after COMMIT, over the budget of %d", size, budget)
+	}
+	d.Close()
+
+	d = open()
+	defer d.Close()
+	for _, tt := range []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT COUNT(*) FROM events", `[map[string]interface {}{"COUNT(*)":2000}]`},
+		{"SELECT name FROM users WHERE id = 2", `[map[string]interface {}{"name":"bob"}]`},
+		{"SELECT id FROM events WHERE id = 1234", `[map[string]interface {}{"id":1234}]`},
+		{"SELECT COUNT(*) FROM events WHERE user_id = 1", `[map[string]interface {}{"COUNT(*)":1000}]`},
+		{"UPDATE events SET body = 'changed' WHERE id = 1500", ""},
+		{"SELECT body FROM events WHERE id = 1500", `[map[string]interface {}{"body":"changed"}]`},
+		{"SELECT COUNT(*) FROM events JOIN users ON users.id = events.user_id WHERE users.name = 'bob'", `[map[string]interface {}{"COUNT(*)":1000}]`},
+	} {
+		result := execAll(t, d, tt.query)
+		if got := rowValues(result); tt.expected != "" && got != tt.expected {
+			t.Errorf("%s returned %s, expected %s", tt.query, got, tt.expected)
+		}
+		if size := held(d); size > budget {
+			t.Errorf("%d bytes of rows held after %s, over the budget of %d", size, tt.query, budget)
+		}
+	}
+
+	// The small table stays loaded while the large one is read again
+	if _, loaded := d.stores[d.tables["users"]]; !loaded {
+		t.Error("Expected users to stay loaded")
+	}
+	if len(d.unloaded) != 1 {
+		t.Errorf("%d tables unloaded, expected only events", len(d.unloaded))
+	}
+}
+
+// unreadablePageBackend is a page file backend whose tables cannot be read
+// while err is set
+type unreadablePageBackend struct {
+	*storage.PageBackend
+	err error
+}
+
+func (b *unreadablePageBackend) ReadTable(name string, fn func(rowid int64, record *interfaces.Record) error) error {
+	if b.err != nil {
+		return fmt.Errorf("table %s: %v", name, b.err)
+	}
+	return b.PageBackend.ReadTable(name, fn)
+}
+
+func TestCacheSizeBelowWorkingSetReturnsReadErrors(t *testing.T) {
+	b := &unreadablePageBackend{PageBackend: storage.NewPageBackend(filepath.Join(t.TempDir(), "test.db"))}
+	d, err := NewDatabase(b)
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	defer d.Close()
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE events (id INTEGER PRIMARY KEY, user_id INTEGER, body TEXT)",
+		"CREATE INDEX idx_user ON events (user_id)",
+		"INSERT INTO users VALUES (1, 'alice'), (2, 'bob')",
+		"INSERT INTO events VALUES (1, 1, 'a'), (2, 2, 'b'), (3, 1, 'c')",
+	)
+
+	// A budget smaller than any table unloads every table a statement reads
+	// once it finishes
+	b.SetCacheSize(1)
+	execAll(t, d, "SELECT * FROM users", "SELECT * FROM events WHERE user_id = 1")
+	if len(d.stores) != 0 {
+		t.Fatalf("%d tables still loaded, expected both unloaded", len(d.stores))
+	}
+
+	b.err = fmt.Errorf("checksum mismatch")
+	for _, query := range []string{
+		"SELECT * FROM users",
+		"SELECT * FROM events WHERE user_id = 2",
+		"SELECT COUNT(*) FROM events JOIN users ON users.id = events.user_id",
+		"INSERT INTO events VALUES (4, 2, 'd')",
+		"UPDATE users SET name = 'carol' WHERE id = 2",
+		"DELETE FROM events WHERE id = 1",
+	} {
+		if _, err := execute(d, query); err == nil {
+			t.Errorf("Expected %q to fail", query)
+		}
+	}
+
+	b.err = nil
+	if got := columnValues(t, d, "SELECT * FROM events", "body"); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("Once readable events hold %v, expected [a b c]", got)
+	}
+}
+
+func TestRolledBackAlterLeavesSavedRows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.json")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	execAll(t, d, "CREATE TABLE users (id INT, name TEXT)", "INSERT INTO users VALUES (1, 'alice')")
+	d.Close()
+
+	// The rolled back ALTER changes the rows the transaction read, which
+	// must not be the ones the table is read from again afterwards
+	d, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer d.Close()
+	execAll(t, d,
+		"BEGIN TRANSACTION",
+		"ALTER TABLE users ADD COLUMN tag TEXT DEFAULT 'new'",
+		"ALTER TABLE users DROP COLUMN name",
+		"ROLLBACK",
+	)
+	if got := rowValues(execAll(t, d, "SELECT * FROM users")); got != `[map[string]interface {}{"id":1, "name":"alice"}]` {
+		t.Errorf("Rows after rolling back are %s", got)
+	}
+}
+
+// unwritableBackend is a memory backend that fails to write tables while
+// err is set, like a full disk
+type unwritableBackend struct {
+	*storage.MemoryBackend
+	err error
+}
+
+func (b *unwritableBackend) WriteTables(tables []*storage.TableData) error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.MemoryBackend.WriteTables(tables)
+}
+
+func TestFailedCommitKeepsTransactionOpen(t *testing.T) {
+	backend := &unwritableBackend{MemoryBackend: storage.NewMemoryBackend()}
+	d, err := NewDatabase(backend)
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO users VALUES (1, 'alice')",
+	)
+	expected := dump(t, d)
+
+	// A failed COMMIT changes nothing, and can be rolled back
+	execAll(t, d, "BEGIN TRANSACTION", "INSERT INTO users VALUES (2, 'bob')")
+	backend.err = fmt.Errorf("no space left on device")
+	if _, err := execute(d, "COMMIT"); err == nil {
+		t.Fatal("Expected COMMIT to fail")
+	}
+	backend.err = nil
+	execAll(t, d, "ROLLBACK")
+	if got := dump(t, d); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Database after rolling back holds %v, expected %v", got, expected)
+	}
+
+	// or committed again once the backend can be written
+	execAll(t, d, "BEGIN TRANSACTION", "INSERT INTO users VALUES (2, 'bob')")
+	backend.err = fmt.Errorf("no space left on device")
+	if _, err := execute(d, "COMMIT"); err == nil {
+		t.Fatal("Expected COMMIT to fail")
+	}
+	backend.err = nil
+	execAll(t, d, "COMMIT")
+	d, err = NewDatabase(backend)
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	if got := columnValues(t, d, "SELECT * FROM users", "name"); !reflect.DeepEqual(got, []string{"alice", "bob"}) {
+		t.Errorf("Saved users are %v, expected [alice bob]", got)
+	}
+}
+
+func TestEncryptedDatabaseRekey(t *testing.T) {
+	cost := crypt.DefaultCost
+	crypt.DefaultCost = 10
+	defer func() { crypt.DefaultCost = cost }()
+
+	dir := t.TempDir()
+	for _, path := range []string{filepath.Join(dir, "test.db"), filepath.Join(dir, "test.json")} {
+		d, err := OpenEncrypted(path, "secret")
+		if err != nil {
+			t.Fatalf("OpenEncrypted failed: %v", err)
+		}
+		execAll(t, d,
+			"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)",
+			"INSERT INTO users VALUES (1, 'alice@example.com'), (2, 'bob@example.com')",
+		)
+		expected := dump(t, d)
+		d.Close()
+
+		if _, err := Open(path); !errors.Is(err, crypt.ErrPassphraseRequired) {
+			t.Errorf("%s: Open without a passphrase returned %v", filepath.Base(path), err)
+		}
+		d, err = OpenEncrypted(path, "secret")
+		if err != nil {
+			t.Fatalf("OpenEncrypted failed on reopening: %v", err)
+		}
+		execAll(t, d, "BEGIN TRANSACTION")
+		if _, err := execute(d, "PRAGMA rekey = 'changed'"); err == nil {
+			t.Errorf("%s: Expected rekeying inside a transaction to fail", filepath.Base(path))
+		}
+		execAll(t, d, "ROLLBACK", "PRAGMA rekey = 'changed'", "INSERT INTO users VALUES (3, 'carol@example.com')")
+		d.Close()
+
+		if _, err := OpenEncrypted(path, "secret"); !errors.Is(err, crypt.ErrWrongPassphrase) {
+			t.Errorf("%s: Opening with the old passphrase returned %v", filepath.Base(path), err)
+		}
+		d, err = OpenEncrypted(path, "changed")
+		if err != nil {
+			t.Fatalf("OpenEncrypted failed with the new passphrase: %v", err)
+		}
+		if got := rowValues(execAll(t, d, "SELECT email FROM users WHERE id = 3")); got != `[map[string]interface {}{"email":"carol@example.com"}]` {
+			t.Errorf("%s: Row inserted after rekeying reads %s", filepath.Base(path), got)
+		}
+		execAll(t, d, "DELETE FROM users WHERE id = 3")
+		if got := dump(t, d); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: Rekeyed database holds %v, expected %v", filepath.Base(path), got, expected)
+		}
+		d.Close()
+	}
+
+	d, err := NewDatabase(storage.NewMemoryBackend())
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	if err := d.Rekey("secret"); err == nil {
+		t.Error("Expected rekeying an in-memory database to fail")
+	}
+}
+
+func TestConvertJSONToPageFile(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "database.json"), filepath.Join(dir, "database.db")
+	d, err := Open(from)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, score REAL)",
+		"INSERT INTO users VALUES (1, 'alice', 2.5), (2, 'bob', NULL)",
+		"CREATE INDEX idx_name ON users (name)",
+	)
+	d.Close()
+
+	// Compare with the database as read back from JSON
+	d, err = Open(from)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	expected := dump(t, d)
+	before, err := os.ReadFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Convert(from, to, Options{}); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if !pager.IsPageFile(to) {
+		t.Errorf("%s is not a page file", to)
+	}
+	if after, err := os.ReadFile(from); err != nil || !bytes.Equal(after, before) {
+		t.Errorf("Converting changed the source file: %v", err)
+	}
+	d, err = Open(to)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer d.Close()
+	if got := dump(t, d); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Converted database holds %v, expected %v", got, expected)
+	}
+	execAll(t, d, "INSERT INTO users VALUES (3, 'carol', 1.0)")
+
+	if err := Convert(from, to, Options{}); err == nil {
+		t.Error("Expected converting onto an existing file to fail")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	legacy, path := filepath.Join(dir, "database.json"), filepath.Join(dir, "database.db")
+
+	// Without a JSON database there is nothing to copy
+	if copied, err := Migrate(path, legacy, Options{}); err != nil || copied {
+		t.Fatalf("Migrate without a JSON database = %v, %v", copied, err)
+	}
+
+	d, err := Open(legacy)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO users VALUES (1, 'alice')",
+	)
+	d.Close()
+
+	if copied, err := Migrate(path, legacy, Options{}); err != nil || !copied {
+		t.Fatalf("Migrate = %v, %v, expected the JSON database to be copied", copied, err)
+	}
+	if !pager.IsPageFile(path) {
+		t.Errorf("%s is not a page file", path)
+	}
+
+	// Once the page file exists, or when the database is the JSON file
+	// itself, it is used as it is
+	for _, target := range []string{path, legacy} {
+		if copied, err := Migrate(target, legacy, Options{}); err != nil || copied {
+			t.Errorf("Migrate(%s) = %v, %v, expected nothing to be copied", target, copied, err)
+		}
+	}
+}
+
+func TestCompressedJSONDatabase(t *testing.T) {
+	cost := crypt.DefaultCost
+	crypt.DefaultCost = 10
+	defer func() { crypt.DefaultCost = cost }()
+
+	dir := t.TempDir()
+	for _, passphrase := range []string{"", "secret"} {
+		path := filepath.Join(dir, "compressed"+passphrase+".json")
+		d, err := OpenWith(path, Options{Passphrase: passphrase, Compress: true})
+		if err != nil {
+			t.Fatalf("OpenWith failed: %v", err)
+		}
+		execAll(t, d, "CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)")
+		for i := 0; i < 100; i++ {
+			execAll(t, d, fmt.Sprintf("INSERT INTO users VALUES (%d, 'user%d@example.com')", i, i))
+		}
+		d.Close()
+		checkCompressed := func() {
+			t.Helper()
+			if passphrase != "" {
+				return
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(data, []byte("SQLight JSON\x00")) || !bytes.Contains(data[:128], []byte(`"Compressed":true`)) {
+				t.Errorf("The file starts with %q, expected it compressed", data[:16])
+			}
+		}
+		checkCompressed()
+
+		// The file is read, and written again, compressed without asking
+		d, err = OpenEncrypted(path, passphrase)
+		if err != nil {
+			t.Fatalf("OpenEncrypted failed on reopening: %v", err)
+		}
+		execAll(t, d, "DELETE FROM users WHERE id >= 50")
+		expected := dump(t, d)
+		d.Close()
+		checkCompressed()
+		d, err = OpenEncrypted(path, passphrase)
+		if err != nil {
+			t.Fatalf("OpenEncrypted failed on reopening: %v", err)
+		}
+		if got := dump(t, d); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Reopened database holds %v, expected %v", got, expected)
+		}
+		d.Close()
+	}
+
+	if _, err := OpenWith(filepath.Join(dir, "test.db"), Options{Compress: true}); err == nil {
+		t.Error("Expected compressing a new page file to fail")
+	}
+}
+
+// readOnlyBackend is a memory backend that reports being read-only once
+// its reason is set
+type readOnlyBackend struct {
+	*storage.MemoryBackend
+	reason error
+}
+
+func (b *readOnlyBackend) ReadOnly() error {
+	return b.reason
+}
+
+func TestReadOnlyDatabaseRejectsWrites(t *testing.T) {
+	backend := &readOnlyBackend{MemoryBackend: storage.NewMemoryBackend()}
+	d, err := NewDatabase(backend)
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	execAll(t, d,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO users VALUES (1, 'alice')",
+	)
+	expected := dump(t, d)
+
+	backend.reason = fmt.Errorf("%w: upgrading it failed", storage.ErrReadOnly)
+	if err := d.ReadOnly(); !errors.Is(err, storage.ErrReadOnly) {
+		t.Fatalf("ReadOnly returned %v, expected %v", err, storage.ErrReadOnly)
+	}
+	for _, query := range []string{
+		"INSERT INTO users VALUES (2, 'bob')",
+		"UPDATE users SET name = 'bob'",
+		"DELETE FROM users",
+		"CREATE TABLE notes (id INTEGER PRIMARY KEY)",
+		"DROP TABLE users",
+	} {
+		if _, err := execute(d, query); !errors.Is(err, storage.ErrReadOnly) {
+			t.Errorf("%s returned %v, expected %v", query, err, storage.ErrReadOnly)
+		}
+	}
+	execAll(t, d, "SELECT * FROM users", "PRAGMA integrity_check")
+	if got := dump(t, d); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Read-only database holds %v, expected %v", got, expected)
+	}
+}
+
+func TestLegacyJSONFileUpgradesOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.json")
+	data, err := storage.MarshalTables(map[string]*interfaces.Table{
+		"users": {
+			Name:    "users",
+			Columns: []interfaces.Column{{Name: "id", Type: "INTEGER", PrimaryKey: true}},
+			Records: []*interfaces.Record{{Columns: map[string]interface{}{"id": 1}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("MarshalTables failed: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer d.Close()
+	if err := d.ReadOnly(); err != nil {
+		t.Fatalf("The upgraded database is read-only: %v", err)
+	}
+	execAll(t, d, "INSERT INTO users VALUES (2)")
+	upgraded, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document struct{ Header struct{ Magic string } }
+	if err := json.Unmarshal(upgraded, &document); err != nil || document.Header.Magic != "SQLight" {
+		t.Errorf("The upgraded file has no header: %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"sqlight/pkg/interfaces"
+)
+
+// scopeTable is a table visible to expressions under a name, which is its
+// alias if one was given and the table name otherwise
+type scopeTable struct {
+	name  string
+	table *interfaces.Table
+}
+
+// columnBinding locates a column within a row of a scope
+type columnBinding struct {
+	table     int
+	column    string
+	ambiguous bool
+}
+
+// scope is the set of tables visible to expressions, in FROM clause order.
+// Its column map extends getColumnMap to several tables: it is keyed by the
+// lower-cased "column" and "table.column" names. Columns that a subquery's
+// scope does not define are looked up in the row of the enclosing query.
+type scope struct {
+	db      *Database // runs subqueries; nil when there is no database
+	outer   *row      // current row of the enclosing query, if any
+	tables  []scopeTable
+	columns map[string]columnBinding
+}
+
+// newScope builds a scope over the given tables
+func newScope(tables ...scopeTable) *scope {
+	s := &scope{
+		tables:  tables,
+		columns: make(map[string]columnBinding),
+	}
+	for i, t := range tables {
+		for _, col := range t.table.Columns {
+			qualified := strings.ToLower(t.name + "." + col.Name)
+			s.columns[qualified] = columnBinding{table: i, column: col.Name}
+
+			unqualified := strings.ToLower(col.Name)
+			if _, exists := s.columns[unqualified]; exists {
+				s.columns[unqualified] = columnBinding{ambiguous: true}
+			} else {
+				s.columns[unqualified] = columnBinding{table: i, column: col.Name}
+			}
+		}
+	}
+	return s
+}
+
+// newTableScope builds a scope over a single table
+func newTableScope(table *interfaces.Table, name string) *scope {
+	return newScope(scopeTable{name: name, table: table})
+}
+
+// columnKey returns the column map key of a column reference
+func columnKey(ref *interfaces.ColumnRef) string {
+	if ref.Table != "" {
+		return strings.ToLower(ref.Table + "." + ref.Name)
+	}
+	return strings.ToLower(ref.Name)
+}
+
+// defines reports whether a column reference names a column of this scope,
+// even if ambiguously
+func (s *scope) defines(ref *interfaces.ColumnRef) bool {
+	_, exists := s.columns[columnKey(ref)]
+	return exists
+}
+
+// check returns an error if a column reference cannot be resolved in this
+// scope or, for columns it does not define, in the enclosing scopes
+func (s *scope) check(ref *interfaces.ColumnRef) error {
+	if !s.defines(ref) && s.outer != nil {
+		return s.outer.scope.check(ref)
+	}
+	_, err := s.resolve(ref)
+	return err
+}
+
+// resolve finds the binding for a column reference
+func (s *scope) resolve(ref *interfaces.ColumnRef) (columnBinding, error) {
+	binding, exists := s.columns[columnKey(ref)]
+	if !exists {
+		if ref.Table != "" && s.findTable(ref.Table) < 0 {
+			return columnBinding{}, fmt.Errorf("no such table: %s", ref.Table)
+		}
+		return columnBinding{}, fmt.Errorf("column %s does not exist", ref)
+	}
+	if binding.ambiguous {
+		return columnBinding{}, fmt.Errorf("ambiguous column name: %s", ref)
+	}
+	return binding, nil
+}
+
+// findTable returns the index of the table visible under name, or -1
+func (s *scope) findTable(name string) int {
+	for i, t := range s.tables {
+		if strings.EqualFold(t.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// row returns a row of a single-table scope holding record
+func (s *scope) row(record *interfaces.Record) *row {
+	return &row{scope: s, records: []*interfaces.Record{record}}
+}
+
+// nullRow returns a row whose columns are all NULL
+func (s *scope) nullRow() *row {
+	return &row{scope: s, records: make([]*interfaces.Record, len(s.tables))}
+}
+
+// row holds the current record of every table in a scope. A nil record
+// stands for the all-NULL row that a LEFT JOIN produces when nothing matches.
+type row struct {
+	scope   *scope
+	records []*interfaces.Record
+}
+
+// with returns a copy of the row with the record of table i replaced
+func (r *row) with(i int, record *interfaces.Record) *row {
+	records := make([]*interfaces.Record, len(r.records))
+	copy(records, r.records)
+	records[i] = record
+	return &row{scope: r.scope, records: records}
+}
+
+// value returns the value of a column reference in this row
+func (r *row) value(ref *interfaces.ColumnRef) (interface{}, error) {
+	if r == nil {
+		return nil, fmt.Errorf("column %s does not exist", ref)
+	}
+	if !r.scope.defines(ref) && r.scope.outer != nil {
+		return r.scope.outer.value(ref)
+	}
+	binding, err := r.scope.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	record := r.records[binding.table]
+	if record == nil {
+		return nil, nil
+	}
+	return record.Columns[binding.column], nil
+}
@@ -0,0 +1,395 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"sqlight/pkg/interfaces"
+)
+
+// selectRow is one output row of a SELECT together with its ORDER BY keys
+type selectRow struct {
+	record *interfaces.Record
+	keys   []interface{}
+}
+
+// executeSelect handles SELECT statements
+func (d *Database) executeSelect(stmt *interfaces.SelectStatement) (*interfaces.Result, error) {
+	return d.query(stmt, nil)
+}
+
+// query runs a SELECT statement. For a subquery, outer is the current row of
+// the enclosing query, whose columns the subquery may reference.
+func (d *Database) query(stmt *interfaces.SelectStatement, outer *row) (*interfaces.Result, error) {
+	s, err := d.selectScope(stmt, outer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Expand * and work out the result column names
+	items, columns, err := expandSelectColumns(stmt.Columns, s)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateSelect(stmt, items, s); err != nil {
+		return nil, err
+	}
+
+	records, ordered, err := d.orderedScan(stmt, items, s)
+	if err != nil {
+		return nil, err
+	}
+	if !ordered {
+		if records, err = d.scanRecords(s, stmt.Where); err != nil {
+			return nil, err
+		}
+	}
+
+	joined, err := d.joinRows(records, stmt.Joins, s)
+	if err != nil {
+		return nil, err
+	}
+
+	// Filter rows based on WHERE conditions
+	filteredRows := make([]*row, 0, len(joined))
+	for _, r := range joined {
+		match, err := matchesWhere(r, stmt.Where)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			filteredRows = append(filteredRows, r)
+		}
+	}
+
+	var rows []selectRow
+	if isAggregateQuery(stmt, items) {
+		rows, err = aggregateRows(stmt, items, columns, filteredRows, s)
+	} else {
+		if stmt.Having != nil {
+			return nil, fmt.Errorf("HAVING clause requires GROUP BY or an aggregate function")
+		}
+		rows = make([]selectRow, 0, len(filteredRows))
+		for _, r := range filteredRows {
+			eval := func(expr interfaces.Expr) (interface{}, error) {
+				return evalExpr(expr, r)
+			}
+			result, err := buildSelectRow(stmt, items, columns, eval)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, result)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply ORDER BY before paging so LIMIT/OFFSET see the sorted rows
+	if len(stmt.OrderBy) > 0 {
+		sortRows(rows, stmt.OrderBy)
+	}
+	rows = pageRows(rows, stmt.Limit, stmt.Offset)
+
+	resultRecords := make([]*interfaces.Record, len(rows))
+	for i, result := range rows {
+		resultRecords[i] = result.record
+	}
+
+	return &interfaces.Result{
+		Success:  true,
+		Message:  fmt.Sprintf("Found %d record(s)", len(resultRecords)),
+		Records:  resultRecords,
+		Columns:  columns,
+		IsSelect: true,
+	}, nil
+}
+
+// selectScope looks up the FROM and JOIN tables of a SELECT. Each table is
+// visible under its alias, or under its name when it has no alias.
+func (d *Database) selectScope(stmt *interfaces.SelectStatement, outer *row) (*scope, error) {
+	refs := []interfaces.JoinClause{{TableName: stmt.TableName, Alias: stmt.Alias}}
+	refs = append(refs, stmt.Joins...)
+
+	tables := make([]scopeTable, 0, len(refs))
+	for _, ref := range refs {
+		table, _, err := d.getTable(ref.TableName, true)
+		if err != nil {
+			return nil, err
+		}
+
+		name := ref.Alias
+		if name == "" {
+			name = ref.TableName
+		}
+		for _, t := range tables {
+			if strings.EqualFold(t.name, name) {
+				return nil, fmt.Errorf("duplicate table name or alias: %s", name)
+			}
+		}
+		tables = append(tables, scopeTable{name: name, table: table})
+	}
+
+	s := newScope(tables...)
+	s.db = d
+	s.outer = outer
+	return s, nil
+}
+
+// joinRows builds the rows of the FROM clause with nested loop joins. The
+// given records of the first table seed the rows, and each join extends
+// every row with the records of the next table that satisfy its ON
+// condition; a LEFT JOIN keeps rows without a match by pairing them with a
+// NULL record.
+func (d *Database) joinRows(records []*interfaces.Record, joins []interfaces.JoinClause, s *scope) ([]*row, error) {
+	rows := make([]*row, 0, len(records))
+	for _, record := range records {
+		r := s.nullRow()
+		r.records[0] = record
+		rows = append(rows, r)
+	}
+
+	for i, join := range joins {
+		tableIndex := i + 1
+		right, err := d.records(s.tables[tableIndex].table)
+		if err != nil {
+			return nil, err
+		}
+
+		joined := make([]*row, 0, len(rows))
+		for _, left := range rows {
+			matched := false
+			for _, record := range right {
+				candidate := left.with(tableIndex, record)
+				match, err := matchesWhere(candidate, join.On)
+				if err != nil {
+					return nil, err
+				}
+				if match {
+					matched = true
+					joined = append(joined, candidate)
+				}
+			}
+			if !matched && join.Type == "LEFT" {
+				joined = append(joined, left.with(tableIndex, nil))
+			}
+		}
+		rows = joined
+	}
+
+	return rows, nil
+}
+
+// expandSelectColumns replaces * and table.* with column references in
+// table and definition order, and names each result column: the alias if
+// given, the column name for column references, and the expression text
+// otherwise. Column names that would collide are qualified with their table.
+func expandSelectColumns(selectColumns []interfaces.SelectColumn, s *scope) ([]interfaces.SelectColumn, []string, error) {
+	items := make([]interfaces.SelectColumn, 0, len(selectColumns))
+	columns := make([]string, 0, len(selectColumns))
+	qualified := make([]string, 0, len(selectColumns))
+
+	for _, col := range selectColumns {
+		switch e := col.Expr.(type) {
+		case *interfaces.StarExpr:
+			matched := false
+			for _, t := range s.tables {
+				if e.Table != "" && !strings.EqualFold(e.Table, t.name) {
+					continue
+				}
+				matched = true
+				for _, c := range t.table.Columns {
+					items = append(items, interfaces.SelectColumn{Expr: &interfaces.ColumnRef{Table: t.name, Name: c.Name}})
+					columns = append(columns, c.Name)
+					qualified = append(qualified, t.name+"."+c.Name)
+				}
+			}
+			if !matched {
+				return nil, nil, fmt.Errorf("no such table: %s", e.Table)
+			}
+			continue
+
+		case *interfaces.ColumnRef:
+			if err := s.check(e); err != nil {
+				return nil, nil, err
+			}
+			// Columns of an enclosing query keep the name they were written with
+			name, full := e.Name, e.String()
+			if s.defines(e) {
+				binding, _ := s.resolve(e)
+				name, full = binding.column, s.tables[binding.table].name+"."+binding.column
+			}
+			if col.Alias != "" {
+				name, full = col.Alias, col.Alias
+			}
+			items = append(items, col)
+			columns = append(columns, name)
+			qualified = append(qualified, full)
+			continue
+		}
+
+		name := col.Alias
+		if name == "" {
+			name = col.Expr.String()
+		}
+		items = append(items, col)
+		columns = append(columns, name)
+		qualified = append(qualified, name)
+	}
+
+	// Result records are keyed by column name, so names must be unique
+	counts := make(map[string]int)
+	for _, name := range columns {
+		counts[name]++
+	}
+	seen := make(map[string]int)
+	for i, name := range columns {
+		if counts[name] == 1 {
+			continue
+		}
+		if qualified[i] != name {
+			columns[i] = qualified[i]
+			continue
+		}
+		if seen[name]++; seen[name] > 1 {
+			columns[i] = fmt.Sprintf("%s:%d", name, seen[name]-1)
+		}
+	}
+
+	return items, columns, nil
+}
+
+// validateSelect checks that every column referenced by the statement exists,
+// so that errors are reported even when no rows reach the evaluator
+func validateSelect(stmt *interfaces.SelectStatement, items []interfaces.SelectColumn, s *scope) error {
+	exprs := []interfaces.Expr{stmt.Where, stmt.Having}
+	for _, join := range stmt.Joins {
+		exprs = append(exprs, join.On)
+	}
+	for _, item := range items {
+		exprs = append(exprs, item.Expr)
+	}
+	exprs = append(exprs, stmt.GroupBy...)
+	for _, item := range stmt.OrderBy {
+		// ORDER BY may also name a result column alias
+		if ref, ok := item.Expr.(*interfaces.ColumnRef); ok && findAlias(items, ref) >= 0 {
+			continue
+		}
+		exprs = append(exprs, item.Expr)
+	}
+
+	for _, expr := range exprs {
+		if err := checkColumns(expr, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildSelectRow projects one output row and evaluates its sort keys using
+// eval, which evaluates an expression in the context of the current row or group
+func buildSelectRow(stmt *interfaces.SelectStatement, items []interfaces.SelectColumn, columns []string, eval func(interfaces.Expr) (interface{}, error)) (selectRow, error) {
+	values := make([]interface{}, len(items))
+	record := &interfaces.Record{
+		Columns: make(map[string]interface{}, len(items)),
+	}
+	for i, item := range items {
+		value, err := eval(item.Expr)
+		if err != nil {
+			return selectRow{}, err
+		}
+		values[i] = value
+		record.Columns[columns[i]] = value
+	}
+
+	keys := make([]interface{}, len(stmt.OrderBy))
+	for i, item := range stmt.OrderBy {
+		// An integer literal refers to a result column by its 1-based position
+		if lit, ok := item.Expr.(*interfaces.Literal); ok {
+			if pos, ok := lit.Value.(int); ok {
+				if pos < 1 || pos > len(items) {
+					return selectRow{}, fmt.Errorf("ORDER BY term %d out of range", pos)
+				}
+				keys[i] = values[pos-1]
+				continue
+			}
+		}
+
+		// A bare name matching an alias refers to that result column
+		if ref, ok := item.Expr.(*interfaces.ColumnRef); ok {
+			if pos := findAlias(items, ref); pos >= 0 {
+				keys[i] = values[pos]
+				continue
+			}
+		}
+
+		value, err := eval(item.Expr)
+		if err != nil {
+			return selectRow{}, err
+		}
+		keys[i] = value
+	}
+
+	return selectRow{record: record, keys: keys}, nil
+}
+
+// findAlias returns the position of the select item whose alias matches an
+// unqualified column reference, or -1
+func findAlias(items []interfaces.SelectColumn, ref *interfaces.ColumnRef) int {
+	if ref.Table != "" {
+		return -1
+	}
+	for i, item := range items {
+		if item.Alias != "" && strings.EqualFold(item.Alias, ref.Name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sortRows performs a stable multi-key sort using the same ordering as the
+// predicate evaluator
+func sortRows(rows []selectRow, orderBy []interfaces.OrderByItem) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		for k, item := range orderBy {
+			if cmp := compareSortKeys(rows[i].keys[k], rows[j].keys[k], item); cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+}
+
+// compareSortKeys compares two sort key values for one ORDER BY item,
+// applying the direction and any explicit NULLS FIRST / NULLS LAST
+func compareSortKeys(a, b interface{}, item interfaces.OrderByItem) int {
+	if item.NullsOrder != "" && (a == nil) != (b == nil) {
+		nullFirst := item.NullsOrder == "FIRST"
+		if (a == nil) == nullFirst {
+			return -1
+		}
+		return 1
+	}
+
+	cmp := compareValues(a, b)
+	if item.Desc {
+		return -cmp
+	}
+	return cmp
+}
+
+// pageRows applies OFFSET and LIMIT to the result rows
+func pageRows(rows []selectRow, limit *int, offset int) []selectRow {
+	if offset > 0 {
+		if offset >= len(rows) {
+			return rows[:0]
+		}
+		rows = rows[offset:]
+	}
+	if limit != nil && *limit >= 0 && *limit < len(rows) {
+		rows = rows[:*limit]
+	}
+	return rows
+}
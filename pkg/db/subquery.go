@@ -0,0 +1,91 @@
+package db
+
+import (
+	"fmt"
+
+	"sqlight/pkg/interfaces"
+)
+
+// runSubquery runs a subquery for the current row r of the enclosing query,
+// so that correlated column references see that row's values
+func runSubquery(sub *interfaces.SubqueryExpr, r *row) (*interfaces.Result, error) {
+	if r == nil || r.scope.db == nil {
+		return nil, fmt.Errorf("subqueries are not supported here")
+	}
+	return r.scope.db.query(sub.Select, r)
+}
+
+// subqueryValues runs a subquery that must return a single column and
+// returns that column's values
+func subqueryValues(sub *interfaces.SubqueryExpr, r *row) ([]interface{}, error) {
+	result, err := runSubquery(sub, r)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Columns) != 1 {
+		return nil, fmt.Errorf("sub-select returns %d columns - expected 1", len(result.Columns))
+	}
+
+	values := make([]interface{}, len(result.Records))
+	for i, record := range result.Records {
+		values[i] = record.Columns[result.Columns[0]]
+	}
+	return values, nil
+}
+
+// evalScalarSubquery returns the first value of a single column subquery,
+// or NULL when it returns no rows
+func evalScalarSubquery(sub *interfaces.SubqueryExpr, r *row) (interface{}, error) {
+	values, err := subqueryValues(sub, r)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	return values[0], nil
+}
+
+// evalIn evaluates expr [NOT] IN (...). The result is TRUE if the value
+// equals an item, UNKNOWN if it does not but the value or an item is NULL,
+// and FALSE otherwise; an empty list never matches.
+func evalIn(e *interfaces.InExpr, r *row) (interface{}, error) {
+	left, err := evalExpr(e.Expr, r)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []interface{}
+	if e.Subquery != nil {
+		if values, err = subqueryValues(e.Subquery, r); err != nil {
+			return nil, err
+		}
+	} else {
+		values = make([]interface{}, len(e.List))
+		for i, item := range e.List {
+			if values[i], err = evalExpr(item, r); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var result interface{} = false
+	for _, value := range values {
+		equal, err := evalComparison("=", left, value)
+		if err != nil {
+			return nil, err
+		}
+		if equal == true {
+			result = true
+			break
+		}
+		if equal == nil {
+			result = nil
+		}
+	}
+
+	if e.Not {
+		return evalUnary("NOT", result)
+	}
+	return result, nil
+}
@@ -0,0 +1,217 @@
+package interfaces
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Expr represents a node in a SQL expression tree
+type Expr interface {
+	String() string
+}
+
+// Literal represents a constant value (int, float64, string, bool or nil for NULL)
+type Literal struct {
+	Value interface{}
+}
+
+func (e *Literal) String() string {
+	switch v := e.Value.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// ColumnRef represents a reference to a column, optionally qualified by a table name
+type ColumnRef struct {
+	Table string
+	Name  string
+}
+
+func (e *ColumnRef) String() string {
+	if e.Table != "" {
+		return e.Table + "." + e.Name
+	}
+	return e.Name
+}
+
+// BinaryExpr represents a binary operation such as a comparison, AND/OR or arithmetic
+type BinaryExpr struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+// String prints the expression, parenthesising operands that bind less
+// tightly than the operator, so that it parses back to the same tree
+func (e *BinaryExpr) String() string {
+	prec := precedence(e.Op)
+	left, right := e.Left.String(), e.Right.String()
+	if child, ok := e.Left.(*BinaryExpr); ok && precedence(child.Op) < prec {
+		left = "(" + left + ")"
+	}
+	// Operators are left-associative, so an operand of equal precedence on
+	// the right was parenthesised
+	if child, ok := e.Right.(*BinaryExpr); ok && precedence(child.Op) <= prec {
+		right = "(" + right + ")"
+	}
+	return fmt.Sprintf("%s %s %s", left, e.Op, right)
+}
+
+// precedence returns how tightly a binary operator binds, higher binding
+// tighter, following the parser: OR < AND < NOT < comparison < additive <
+// multiplicative
+func precedence(op string) int {
+	switch op {
+	case "OR":
+		return 1
+	case "AND":
+		return 2
+	case "+", "-", "||":
+		return 5
+	case "*", "/", "%":
+		return 6
+	}
+	return 4
+}
+
+// UnaryExpr represents a unary operation such as NOT or negation
+type UnaryExpr struct {
+	Op   string
+	Expr Expr
+}
+
+func (e *UnaryExpr) String() string {
+	operand := e.Expr.String()
+	if child, ok := e.Expr.(*BinaryExpr); ok && (e.Op != "NOT" || precedence(child.Op) < precedence("=")) {
+		operand = "(" + operand + ")"
+	}
+	if e.Op == "NOT" {
+		return "NOT " + operand
+	}
+	return e.Op + operand
+}
+
+// IsNullExpr represents expr IS [NOT] NULL, which is always TRUE or FALSE
+type IsNullExpr struct {
+	Expr Expr
+	Not  bool
+}
+
+func (e *IsNullExpr) String() string {
+	operand := e.Expr.String()
+	switch child := e.Expr.(type) {
+	case *BinaryExpr:
+		if precedence(child.Op) < precedence("=") {
+			operand = "(" + operand + ")"
+		}
+	case *UnaryExpr:
+		if child.Op == "NOT" {
+			operand = "(" + operand + ")"
+		}
+	}
+	if e.Not {
+		return operand + " IS NOT NULL"
+	}
+	return operand + " IS NULL"
+}
+
+// StarExpr represents * (or table.*) in a SELECT list or COUNT(*)
+type StarExpr struct {
+	Table string
+}
+
+func (e *StarExpr) String() string {
+	if e.Table != "" {
+		return e.Table + ".*"
+	}
+	return "*"
+}
+
+// FuncCall represents a function call such as COUNT(DISTINCT col) or MAX(price)
+type FuncCall struct {
+	Name     string
+	Args     []Expr
+	Distinct bool
+}
+
+func (e *FuncCall) String() string {
+	args := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = arg.String()
+	}
+	prefix := ""
+	if e.Distinct {
+		prefix = "DISTINCT "
+	}
+	return e.Name + "(" + prefix + strings.Join(args, ", ") + ")"
+}
+
+// SubqueryExpr represents a parenthesised SELECT used as an expression. Text
+// holds its source text, which names the result column it produces.
+type SubqueryExpr struct {
+	Select *SelectStatement
+	Text   string
+}
+
+func (e *SubqueryExpr) String() string {
+	return e.Text
+}
+
+// InExpr represents expr [NOT] IN (list) or expr [NOT] IN (SELECT ...);
+// exactly one of List and Subquery is set
+type InExpr struct {
+	Expr     Expr
+	List     []Expr
+	Subquery *SubqueryExpr
+	Not      bool
+}
+
+func (e *InExpr) String() string {
+	op := " IN "
+	if e.Not {
+		op = " NOT IN "
+	}
+	if e.Subquery != nil {
+		return e.Expr.String() + op + e.Subquery.String()
+	}
+	items := make([]string, len(e.List))
+	for i, item := range e.List {
+		items[i] = item.String()
+	}
+	return e.Expr.String() + op + "(" + strings.Join(items, ", ") + ")"
+}
+
+// BetweenExpr represents expr [NOT] BETWEEN low AND high
+type BetweenExpr struct {
+	Expr Expr
+	Low  Expr
+	High Expr
+	Not  bool
+}
+
+func (e *BetweenExpr) String() string {
+	op := " BETWEEN "
+	if e.Not {
+		op = " NOT BETWEEN "
+	}
+	return e.Expr.String() + op + e.Low.String() + " AND " + e.High.String()
+}
+
+// ExistsExpr represents EXISTS (SELECT ...); NOT EXISTS is a NOT UnaryExpr
+type ExistsExpr struct {
+	Subquery *SubqueryExpr
+}
+
+func (e *ExistsExpr) String() string {
+	return "EXISTS " + e.Subquery.String()
+}
@@ -12,6 +12,14 @@ type Column struct {
 	PrimaryKey bool
 	Nullable   bool
 	Unique     bool
+	Default    interface{} // value for rows that do not set the column, nil for NULL
+}
+
+// Index represents an index on one or more columns of a table
+type Index struct {
+	Name    string
+	Columns []string
+	Unique  bool
 }
 
 // Table represents a database table
@@ -19,6 +27,7 @@ type Table struct {
 	Name    string
 	Columns []Column
 	Records []*Record
+	Indexes []Index `json:",omitempty"`
 }
 
 // CreateStatement represents a CREATE TABLE statement
@@ -31,22 +40,90 @@ func (s *CreateStatement) Type() string {
 	return "CREATE"
 }
 
-// InsertStatement represents an INSERT statement
+// CreateIndexStatement represents a CREATE [UNIQUE] INDEX statement
+type CreateIndexStatement struct {
+	IndexName string
+	TableName string
+	Columns   []string
+	Unique    bool
+}
+
+func (s *CreateIndexStatement) Type() string {
+	return "CREATE INDEX"
+}
+
+// DropIndexStatement represents a DROP INDEX statement
+type DropIndexStatement struct {
+	IndexName string
+}
+
+func (s *DropIndexStatement) Type() string {
+	return "DROP INDEX"
+}
+
+// AlterTableStatement represents an ALTER TABLE statement. Action is one of
+// "ADD COLUMN" (Column), "DROP COLUMN" (ColumnName), "RENAME COLUMN"
+// (ColumnName to NewName) or "RENAME TABLE" (to NewName).
+type AlterTableStatement struct {
+	TableName  string
+	Action     string
+	Column     Column
+	ColumnName string
+	NewName    string
+}
+
+func (s *AlterTableStatement) Type() string {
+	return "ALTER"
+}
+
+// InsertStatement represents an INSERT statement. Columns is empty when the
+// statement has no column list, and the rows come either from Rows (VALUES)
+// or from Select (INSERT ... SELECT).
 type InsertStatement struct {
 	TableName string
 	Columns   []string
-	Values    []interface{}
+	Rows      [][]interface{}
+	Select    *SelectStatement
 }
 
 func (s *InsertStatement) Type() string {
 	return "INSERT"
 }
 
+// OrderByItem represents a single sort key in an ORDER BY clause
+type OrderByItem struct {
+	Expr       Expr
+	Desc       bool
+	NullsOrder string // "FIRST", "LAST" or "" for the default (NULLs sort lowest)
+}
+
+// SelectColumn represents one item of a SELECT list
+type SelectColumn struct {
+	Expr  Expr
+	Alias string
+}
+
+// JoinClause represents a table joined onto the FROM clause. Type is
+// "INNER", "LEFT" or "CROSS"; comma-separated tables are CROSS joins.
+type JoinClause struct {
+	Type      string
+	TableName string
+	Alias     string
+	On        Expr
+}
+
 // SelectStatement represents a SELECT statement
 type SelectStatement struct {
 	TableName string
-	Columns   []string
-	Where     map[string]interface{}
+	Alias     string
+	Joins     []JoinClause
+	Columns   []SelectColumn
+	Where     Expr
+	GroupBy   []Expr
+	Having    Expr
+	OrderBy   []OrderByItem
+	Limit     *int // nil when there is no LIMIT clause
+	Offset    int
 }
 
 func (s *SelectStatement) Type() string {
@@ -74,13 +151,30 @@ func (s *DescribeStatement) Type() string {
 // DeleteStatement represents a DELETE statement
 type DeleteStatement struct {
 	TableName string
-	Where     map[string]interface{}
+	Where     Expr
 }
 
 func (s *DeleteStatement) Type() string {
 	return "DELETE"
 }
 
+// Assignment represents a single column = expression pair in an UPDATE statement
+type Assignment struct {
+	Column string
+	Value  Expr
+}
+
+// UpdateStatement represents an UPDATE statement
+type UpdateStatement struct {
+	TableName   string
+	Assignments []Assignment
+	Where       Expr
+}
+
+func (s *UpdateStatement) Type() string {
+	return "UPDATE"
+}
+
 // BeginTransactionStatement represents a BEGIN TRANSACTION statement
 type BeginTransactionStatement struct{}
 
@@ -102,6 +196,17 @@ func (s *RollbackStatement) Type() string {
 	return "ROLLBACK"
 }
 
+// PragmaStatement represents a PRAGMA statement, which queries or sets a
+// property of the database. Value is nil when the statement has none.
+type PragmaStatement struct {
+	Name  string
+	Value interface{}
+}
+
+func (s *PragmaStatement) Type() string {
+	return "PRAGMA"
+}
+
 // Record represents a database record
 type Record struct {
 	Columns map[string]interface{}
@@ -109,11 +214,12 @@ type Record struct {
 
 // Result represents a database operation result
 type Result struct {
-	Success  bool
-	Message  string
-	Records  []*Record
-	Columns  []string
-	IsSelect bool
+	Success      bool
+	Message      string
+	Records      []*Record
+	Columns      []string
+	IsSelect     bool
+	RowsAffected int
 }
 
 // Transaction represents a database transaction
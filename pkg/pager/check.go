@@ -0,0 +1,176 @@
+package pager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// checker gathers the problems found while checking a page file, and which
+// tree or list each page was found in
+type checker struct {
+	p        *Pager
+	owners   map[PageID]string
+	bad      map[PageID]bool // pages that fail their checksum
+	problems []error
+
+	// incomplete is set when a page could not be read, so the pages below
+	// it were not seen
+	incomplete bool
+}
+
+func (c *checker) errorf(format string, args ...interface{}) {
+	c.problems = append(c.problems, fmt.Errorf(format, args...))
+}
+
+// use records that a page belongs to owner, reporting whether it can be
+// read: it must be in the file, not already in use and match its checksum
+func (c *checker) use(id PageID, owner string) bool {
+	if id == 0 || uint32(id) >= c.p.header.pageCount {
+		c.errorf("%s: page %d out of range", owner, id)
+		return false
+	}
+	if other, used := c.owners[id]; used {
+		c.errorf("%s: page %d is also used by %s", owner, id, other)
+		return false
+	}
+	c.owners[id] = owner
+	if c.bad[id] {
+		c.incomplete = true
+		return false
+	}
+	return true
+}
+
+// Check verifies the committed file: the checksum of every page, the order
+// of the keys in the trees rooted at the given pages, by name, and the
+// freelist, and that every page belongs to exactly one of them, unless a
+// damaged page hides the pages below it. It returns the problems found.
+func (p *Pager) Check(trees map[string]PageID) []error {
+	if len(p.pool.dirty) > 0 || p.header != p.committed {
+		return []error{errors.New("the page file has uncommitted changes")}
+	}
+	c := &checker{p: p, owners: make(map[PageID]string), bad: make(map[PageID]bool)}
+	for id := PageID(1); uint32(id) < p.header.pageCount; id++ {
+		if _, err := p.readPage(id); err != nil {
+			c.problems = append(c.problems, err)
+			c.bad[id] = true
+		}
+	}
+
+	names := make([]string, 0, len(trees))
+	for name := range trees {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c.node(trees[name], name, nil, nil)
+	}
+	c.freelist()
+	if c.incomplete {
+		return c.problems
+	}
+
+	// Every page reached the trees or the freelist
+	for id := PageID(1); uint32(id) < p.header.pageCount; id++ {
+		if _, used := c.owners[id]; !used {
+			c.errorf("page %d is not in use and not on the freelist", id)
+		}
+	}
+	return c.problems
+}
+
+// node checks a tree page and the pages below it, whose keys must be
+// ascending and not less than lo and, if hi is set, less than hi
+func (c *checker) node(id PageID, owner string, lo, hi []byte) {
+	if !c.use(id, owner) {
+		return
+	}
+	data, err := c.p.readPage(id)
+	if err != nil {
+		c.errorf("%s: %v", owner, err)
+		return
+	}
+	n, err := decodeNode(data)
+	if err != nil {
+		c.errorf("%s: page %d: %v", owner, id, err)
+		c.incomplete = true
+		return
+	}
+
+	for i, key := range n.keys {
+		if i > 0 && bytes.Compare(n.keys[i-1], key) >= 0 {
+			c.errorf("%s: page %d has key %x after %x", owner, id, key, n.keys[i-1])
+		}
+		if bytes.Compare(key, lo) < 0 || hi != nil && bytes.Compare(key, hi) >= 0 {
+			c.errorf("%s: page %d has key %x outside its parent's bounds", owner, id, key)
+		}
+	}
+	if n.leaf {
+		for _, value := range n.values {
+			c.overflow(value, owner)
+		}
+		return
+	}
+	for i, child := range n.children {
+		childLo, childHi := lo, hi
+		if i > 0 {
+			childLo = n.keys[i-1]
+		}
+		if i < len(n.keys) {
+			childHi = n.keys[i]
+		}
+		c.node(child, owner, childLo, childHi)
+	}
+}
+
+// overflow checks the overflow pages of a leaf cell
+func (c *checker) overflow(value cell, owner string) {
+	length := 0
+	for id := value.overflow; id != 0; {
+		if !c.use(id, owner) {
+			return
+		}
+		data, err := c.p.readPage(id)
+		if err != nil {
+			c.errorf("%s: %v", owner, err)
+			return
+		}
+		size := int(binary.BigEndian.Uint16(data[5:]))
+		if data[0] != pageOverflow || size > c.p.usable()-overflowHeader {
+			c.errorf("%s: page %d is not a valid overflow page", owner, id)
+			return
+		}
+		length += size
+		id = PageID(binary.BigEndian.Uint32(data[1:]))
+	}
+	if value.overflow != 0 && length != value.length {
+		c.errorf("%s: overflow value is %d bytes, expected %d", owner, length, value.length)
+	}
+}
+
+// freelist checks that the freelist holds as many free pages as the header
+// records
+func (c *checker) freelist() {
+	count := uint32(0)
+	for id := c.p.header.freeList; id != 0; count++ {
+		if !c.use(id, "the freelist") {
+			return
+		}
+		data, err := c.p.readPage(id)
+		if err != nil {
+			c.errorf("the freelist: %v", err)
+			return
+		}
+		if data[0] != pageFree {
+			c.errorf("the freelist: page %d is not free", id)
+			return
+		}
+		id = PageID(binary.BigEndian.Uint32(data[1:]))
+	}
+	if count != c.p.header.freeCount {
+		c.errorf("the freelist holds %d pages, the header records %d", count, c.p.header.freeCount)
+	}
+}
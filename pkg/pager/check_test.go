@@ -0,0 +1,175 @@
+package pager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// randomTrees fills a pager with trees of random keys and values, some of
+// them large enough to overflow, deleting some keys and dropping a tree,
+// and commits. It returns the surviving trees by name and their contents.
+func randomTrees(t *testing.T, p *Pager) (map[string]PageID, map[PageID]map[string][]byte) {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	trees := map[string]PageID{"catalog": p.Catalog()}
+	models := map[PageID]map[string][]byte{p.Catalog(): {}}
+	for _, name := range []string{"a", "b", "dropped"} {
+		tree, err := CreateTree(p)
+		if err != nil {
+			t.Fatalf("CreateTree: %v", err)
+		}
+		model := make(map[string][]byte)
+		for i := 0; i < 2000; i++ {
+			key := binary.BigEndian.AppendUint32(nil, uint32(rng.Intn(1000)))
+			if rng.Intn(4) == 0 {
+				if _, err := tree.Delete(key); err != nil {
+					t.Fatalf("Delete: %v", err)
+				}
+				delete(model, string(key))
+				continue
+			}
+			value := bytes.Repeat([]byte{byte(i)}, rng.Intn(200))
+			if rng.Intn(50) == 0 {
+				value = bytes.Repeat([]byte{byte(i)}, 3*PageSize)
+			}
+			if err := tree.Put(key, value); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			model[string(key)] = value
+		}
+		if name == "dropped" {
+			if err := tree.Drop(); err != nil {
+				t.Fatalf("Drop: %v", err)
+			}
+			continue
+		}
+		trees[name] = tree.Root()
+		models[tree.Root()] = model
+	}
+	if err := p.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	return trees, models
+}
+
+func TestCheckFindsNoProblems(t *testing.T) {
+	for _, version := range []uint32{1, 2, FormatVersion} {
+		path := filepath.Join(t.TempDir(), "test.db")
+		p, err := create(path, version, Options{})
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		trees, models := randomTrees(t, p)
+		if problems := p.Check(trees); len(problems) > 0 {
+			t.Errorf("Version %d: Check found %v", version, problems)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		p, err = Open(path)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if problems := p.Check(trees); len(problems) > 0 {
+			t.Errorf("Version %d: Check found %v after reopening", version, problems)
+		}
+		for _, root := range trees {
+			checkTree(t, OpenTree(p, root), models[root])
+		}
+		p.Close()
+	}
+}
+
+func TestChecksumDetectsCorruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	p, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	trees, _ := randomTrees(t, p)
+	root := trees["a"]
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Flip one bit in the middle of the tree's root page
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1)
+	offset := int64(root)*PageSize + PageSize/2
+	if _, err := f.ReadAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0x10
+	if _, err := f.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	p, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer p.Close()
+	if _, err := p.Read(root); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Reading the damaged page returned %v, expected a checksum error", err)
+	}
+	if err := OpenTree(p, trees["b"]).Scan(func(key, value []byte) error { return nil }); err != nil {
+		t.Errorf("Scanning an undamaged tree failed: %v", err)
+	}
+	problems := p.Check(trees)
+	if len(problems) != 1 || !errors.Is(problems[0], ErrChecksum) {
+		t.Errorf("Check found %v, expected only the checksum mismatch", problems)
+	}
+}
+
+func TestCheckFindsDisorderAndLostPages(t *testing.T) {
+	p, err := Create(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer p.Close()
+	tree := OpenTree(p, p.Catalog())
+	for _, key := range []string{"a", "b", "c"} {
+		if err := tree.Put([]byte(key), []byte("value")); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	// Swap two keys of the leaf, and write a page that nothing refers to
+	n, err := tree.read(tree.Root())
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	n.keys[0], n.keys[2] = n.keys[2], n.keys[0]
+	if err := tree.write(n); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := CreateTree(p); err != nil {
+		t.Fatalf("CreateTree: %v", err)
+	}
+	if err := p.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	problems := p.Check(map[string]PageID{"catalog": p.Catalog()})
+	var messages []string
+	for _, problem := range problems {
+		messages = append(messages, problem.Error())
+	}
+	got := strings.Join(messages, "\n")
+	for _, expected := range []string{"has key 62 after 63", "has key 61 after 62", "is not in use"} {
+		if !strings.Contains(got, expected) {
+			t.Errorf("Check found:\n%s\nexpected a problem containing %q", got, expected)
+		}
+	}
+}
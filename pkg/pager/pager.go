@@ -0,0 +1,773 @@
+// Package pager stores a database as a file of fixed-size pages.
+//
+// Page 0 is the header page, recording the format version, the page size,
+// the number of pages, the head of the freelist of unused pages, the root
+// page of the schema catalog, flags for how pages are encoded and the
+// version of SQLight that created the file. Every other page is a B+ tree
+// node, an overflow page holding part of a large value, or a free page. The
+// last 4 bytes of every page hold a CRC-32C checksum of the rest, which is
+// verified whenever the page is read; files of format version 1 have no
+// checksums and use the whole page.
+//
+// A file created with a passphrase is encrypted (see package crypt): every
+// page but the header is sealed with AES-GCM under a key derived from the
+// passphrase, bound to its page number so pages cannot be swapped, and the
+// header records the key's salt and cost and an authentication tag that
+// tells a wrong passphrase from a right one. The buffer pool holds pages
+// decrypted; they are encrypted as they are committed.
+//
+// Every older format version is still read and written. Upgrade rewrites
+// the header of a file in the next version when only the header changed;
+// files of version 1 must be copied into a new file to gain checksums. A
+// file of a newer version fails to open with a version.NewerFormatError.
+// Files can also be opened read-only, without writing the file or its log.
+//
+// A Pager reads pages on demand through a buffer pool (see pool.go), which
+// also holds the pages written since the last commit, so that committing a
+// change writes only the pages it touched. Commits go through a write-ahead
+// log (see wal.go), so a crash never leaves a partly written commit behind.
+package pager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+
+	"sqlight/pkg/crypt"
+	"sqlight/pkg/version"
+)
+
+// PageID is the number of a page in the file; page n starts at byte
+// n*PageSize. 0 is the header page and never a valid tree or free page.
+type PageID uint32
+
+const (
+	// PageSize is the size of every page in bytes
+	PageSize = 4096
+
+	// FormatVersion is the version of the file format written by this package
+	FormatVersion = 4
+
+	// checksumVersion is the first format version with page checksums
+	checksumVersion = 2
+	checksumSize    = 4
+
+	// flagsVersion is the first format version with header flags, and so
+	// the first that can be encrypted
+	flagsVersion = 3
+
+	// creatorVersion is the first format version recording the version of
+	// SQLight that created the file
+	creatorVersion = 4
+
+	magic = "SQLight pages\x00\x00\x00"
+)
+
+// Page types, stored in the first byte of every page but the header
+const (
+	pageLeaf     byte = 1
+	pageInternal byte = 2
+	pageOverflow byte = 3
+	pageFree     byte = 4
+)
+
+// Header page layout
+const (
+	offsetVersion   = 16
+	offsetPageSize  = 20
+	offsetPageCount = 24
+	offsetFreeList  = 28
+	offsetFreeCount = 32
+	offsetCatalog   = 36
+	offsetFlags     = 40
+	offsetCipher    = 44 // the key's crypt.Params
+	offsetTag       = offsetCipher + crypt.ParamsSize
+	offsetCreator   = 96 // a length byte and up to maxCreator bytes
+	maxCreator      = 63
+)
+
+// Header flags
+const (
+	flagEncrypted uint32 = 1 << iota
+)
+
+// ErrNotPageFile is returned when opening a file that is not a page file
+var ErrNotPageFile = errors.New("not a page file")
+
+// ErrChecksum is returned, wrapped with the page number, when a page read
+// from the file does not match its checksum
+var ErrChecksum = errors.New("page checksum mismatch")
+
+// ErrReadOnly is returned when writing to a file opened read-only
+var ErrReadOnly = errors.New("page file is open read-only")
+
+// Options configure how a page file is created or opened
+type Options struct {
+	// Passphrase encrypts a new file, and must be given to open an
+	// encrypted one
+	Passphrase string
+
+	// Version is the format version of a new file, FormatVersion if 0
+	Version uint32
+
+	// ReadOnly opens a file without writing to it or its log. Committed
+	// changes still in the log are read from it, and writes fail with
+	// ErrReadOnly.
+	ReadOnly bool
+}
+
+// header holds the fields of the header page
+type header struct {
+	pageCount uint32
+	freeList  PageID
+	freeCount uint32
+	catalog   PageID
+}
+
+// Stats counts page IO and buffer pool use since the pager was opened
+type Stats struct {
+	PagesRead    int
+	PagesWritten int
+	Commits      int
+	Checkpoints  int
+
+	CacheHits      int
+	CacheMisses    int
+	CacheEvictions int
+	CachedPages    int // pages in the buffer pool now
+}
+
+// Pager reads and writes the pages of a database file
+type Pager struct {
+	file      file
+	wal       *wal
+	walPath   string
+	version   uint32
+	creator   string
+	readOnly  bool
+	flags     uint32
+	params    crypt.Params
+	key       *crypt.Key // set if the file is encrypted
+	header    header
+	committed header
+	pool      *pool
+	stats     Stats
+}
+
+// IsPageFile reports whether the file at path starts with the page file
+// magic string
+func IsPageFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	buf := make([]byte, len(magic))
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return false
+	}
+	return string(buf) == magic
+}
+
+// Create creates a new page file at path, replacing any existing file and
+// its log, with an empty catalog tree
+func Create(path string) (*Pager, error) {
+	return CreateWith(path, Options{})
+}
+
+// CreateWith creates a new page file like Create, with options
+func CreateWith(path string, options Options) (*Pager, error) {
+	format := options.Version
+	if format == 0 {
+		format = FormatVersion
+	}
+	if format > FormatVersion {
+		return nil, fmt.Errorf("unsupported page file version %d", format)
+	}
+	return create(path, format, options)
+}
+
+// create creates a new page file in the given format version
+func create(path string, format uint32, options Options) (*Pager, error) {
+	var key *crypt.Key
+	var params crypt.Params
+	if options.Passphrase != "" {
+		if format < flagsVersion {
+			return nil, fmt.Errorf("page file version %d cannot be encrypted", format)
+		}
+		var err error
+		if params, err = crypt.NewParams(); err != nil {
+			return nil, err
+		}
+		if key, err = crypt.DeriveKey(options.Passphrase, params); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.Remove(path + "-wal"); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	p, err := open(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+
+	p.version, p.creator = format, version.String()
+	if key != nil {
+		p.flags, p.params, p.key = flagEncrypted, params, key
+	}
+	p.header = header{pageCount: 1}
+	catalog, err := CreateTree(p)
+	if err == nil {
+		p.header.catalog = catalog.Root()
+		err = p.Commit()
+	}
+	if err == nil {
+		err = p.Checkpoint()
+	}
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Open opens an existing page file, first recovering the commits in its
+// log
+func Open(path string) (*Pager, error) {
+	return OpenWith(path, Options{})
+}
+
+// OpenWith opens an existing page file like Open, with options. An
+// encrypted file needs its passphrase, failing with
+// crypt.ErrPassphraseRequired without one and crypt.ErrWrongPassphrase with
+// another, and a passphrase for a file that is not encrypted fails with
+// crypt.ErrNotEncrypted.
+func OpenWith(path string, options Options) (*Pager, error) {
+	flag := os.O_RDWR
+	if options.ReadOnly {
+		flag = os.O_RDONLY
+	}
+	p, err := open(path, flag)
+	if err != nil {
+		return nil, err
+	}
+	if !options.ReadOnly {
+		err = p.Checkpoint()
+	}
+	if err == nil {
+		err = p.readHeader(options)
+	}
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// open opens the database file and its log. An existing database file must
+// start with the page file magic string.
+func open(path string, flag int) (*Pager, error) {
+	f, err := openFile(path, flag)
+	if err != nil {
+		return nil, err
+	}
+	if flag&os.O_TRUNC == 0 {
+		buf := make([]byte, len(magic))
+		if _, err := f.ReadAt(buf, 0); err != nil && err != io.EOF {
+			f.Close()
+			return nil, err
+		}
+		if string(buf) != magic {
+			f.Close()
+			return nil, ErrNotPageFile
+		}
+	}
+
+	readOnly := flag&(os.O_RDWR|os.O_WRONLY) == 0
+	w, err := openWAL(path+"-wal", readOnly)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Pager{
+		file:     f,
+		wal:      w,
+		walPath:  path + "-wal",
+		readOnly: readOnly,
+		pool:     newPool(DefaultCacheSize),
+	}, nil
+}
+
+// readHeader reads and checks the header page, deriving the key of an
+// encrypted file. The header is read from the log if it is there, as it is
+// when the file is open read-only.
+func (p *Pager) readHeader(options Options) error {
+	buf, logged, err := p.wal.read(0)
+	if err != nil {
+		return err
+	}
+	if !logged {
+		buf = make([]byte, PageSize)
+		if _, err := p.file.ReadAt(buf, 0); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return ErrNotPageFile
+			}
+			return err
+		}
+	}
+	if string(buf[:len(magic)]) != magic {
+		return ErrNotPageFile
+	}
+	p.version = binary.BigEndian.Uint32(buf[offsetVersion:])
+	if p.version > FormatVersion {
+		// Later versions keep the creator where it is, to report it here
+		return &version.NewerFormatError{
+			Kind:    "page file",
+			Format:  p.version,
+			Newest:  FormatVersion,
+			Creator: readCreator(buf),
+		}
+	}
+	if p.version < 1 {
+		return fmt.Errorf("unsupported page file version %d", p.version)
+	}
+	if size := binary.BigEndian.Uint32(buf[offsetPageSize:]); size != PageSize {
+		return fmt.Errorf("unsupported page size %d", size)
+	}
+	if !p.verify(buf) {
+		return fmt.Errorf("header page: %w", ErrChecksum)
+	}
+
+	p.header = header{
+		pageCount: binary.BigEndian.Uint32(buf[offsetPageCount:]),
+		freeList:  PageID(binary.BigEndian.Uint32(buf[offsetFreeList:])),
+		freeCount: binary.BigEndian.Uint32(buf[offsetFreeCount:]),
+		catalog:   PageID(binary.BigEndian.Uint32(buf[offsetCatalog:])),
+	}
+	p.committed = p.header
+	if p.version >= creatorVersion {
+		p.creator = readCreator(buf)
+	}
+	if err := p.readFlags(buf, options); err != nil {
+		return err
+	}
+
+	// Pages added by commits still in the log are not in the file yet
+	if logged {
+		return nil
+	}
+	info, err := p.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < int64(p.header.pageCount)*PageSize {
+		return fmt.Errorf("page file is truncated: %d bytes for %d pages", info.Size(), p.header.pageCount)
+	}
+	return nil
+}
+
+// readCreator returns the version of SQLight recorded in a header page
+func readCreator(buf []byte) string {
+	n := int(buf[offsetCreator])
+	if n > maxCreator {
+		return ""
+	}
+	return string(buf[offsetCreator+1 : offsetCreator+1+n])
+}
+
+// readFlags reads the header flags, checking the passphrase of an
+// encrypted file against the header's tag
+func (p *Pager) readFlags(buf []byte, options Options) error {
+	if p.version >= flagsVersion {
+		p.flags = binary.BigEndian.Uint32(buf[offsetFlags:])
+	}
+	if p.flags&flagEncrypted == 0 {
+		if options.Passphrase != "" {
+			return crypt.ErrNotEncrypted
+		}
+		return nil
+	}
+	if options.Passphrase == "" {
+		return crypt.ErrPassphraseRequired
+	}
+
+	params, err := crypt.DecodeParams(buf[offsetCipher:])
+	if err != nil {
+		return err
+	}
+	key, err := crypt.DeriveKey(options.Passphrase, params)
+	if err != nil {
+		return err
+	}
+	if _, err := key.Open(buf[offsetTag:offsetTag+crypt.Overhead], buf[:offsetTag]); err != nil {
+		return crypt.ErrWrongPassphrase
+	}
+	p.params, p.key = params, key
+	return nil
+}
+
+// encodeHeader returns the contents of the header page
+func (p *Pager) encodeHeader() []byte {
+	buf := make([]byte, PageSize)
+	copy(buf, magic)
+	binary.BigEndian.PutUint32(buf[offsetVersion:], p.version)
+	binary.BigEndian.PutUint32(buf[offsetPageSize:], PageSize)
+	binary.BigEndian.PutUint32(buf[offsetPageCount:], p.header.pageCount)
+	binary.BigEndian.PutUint32(buf[offsetFreeList:], uint32(p.header.freeList))
+	binary.BigEndian.PutUint32(buf[offsetFreeCount:], p.header.freeCount)
+	binary.BigEndian.PutUint32(buf[offsetCatalog:], uint32(p.header.catalog))
+	if p.version >= flagsVersion {
+		binary.BigEndian.PutUint32(buf[offsetFlags:], p.flags)
+	}
+	if p.key != nil {
+		copy(buf[offsetCipher:], p.params.Encode())
+		copy(buf[offsetTag:], p.key.Seal(nil, buf[:offsetTag]))
+	}
+	if p.version >= creatorVersion {
+		creator := p.creator
+		if len(creator) > maxCreator {
+			creator = creator[:maxCreator]
+		}
+		buf[offsetCreator] = byte(len(creator))
+		copy(buf[offsetCreator+1:], creator)
+	}
+	p.sign(buf)
+	return buf
+}
+
+// checksums reports whether the pages of the file carry checksums
+func (p *Pager) checksums() bool {
+	return p.version >= checksumVersion
+}
+
+// Version returns the format version of the file
+func (p *Pager) Version() uint32 {
+	return p.version
+}
+
+// Creator returns the version of SQLight that created the file, or last
+// upgraded its format, if the format records it
+func (p *Pager) Creator() string {
+	return p.creator
+}
+
+// Upgrade rewrites the header page in the next format version. Only the
+// header changed between the versions it upgrades, so the pages are kept as
+// they are; a file of version 1, whose pages have no room for checksums,
+// must instead be copied into a new file.
+func (p *Pager) Upgrade() error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	if p.version < checksumVersion || p.version >= FormatVersion {
+		return fmt.Errorf("page file version %d cannot be upgraded in place", p.version)
+	}
+	if len(p.pool.dirty) > 0 || p.header != p.committed {
+		return errors.New("the page file has uncommitted changes")
+	}
+	p.version++
+	p.creator = version.String()
+	if err := p.wal.append([]PageID{0}, [][]byte{p.encodeHeader()}); err != nil {
+		p.version--
+		return err
+	}
+	p.stats.PagesWritten++
+	p.stats.Commits++
+	return nil
+}
+
+// Encrypted reports whether the file is encrypted
+func (p *Pager) Encrypted() bool {
+	return p.key != nil
+}
+
+// usable returns the number of bytes of a page available to its contents
+func (p *Pager) usable() int {
+	size := PageSize
+	if p.checksums() {
+		size -= checksumSize
+	}
+	if p.key != nil {
+		size -= crypt.Overhead
+	}
+	return size
+}
+
+// sign stores the checksum of a full page in its last bytes
+func (p *Pager) sign(page []byte) {
+	if p.checksums() {
+		end := PageSize - checksumSize
+		binary.BigEndian.PutUint32(page[end:], crc32.Checksum(page[:end], castagnoli))
+	}
+}
+
+// verify reports whether a full page matches its checksum
+func (p *Pager) verify(page []byte) bool {
+	if !p.checksums() {
+		return true
+	}
+	end := PageSize - checksumSize
+	return binary.BigEndian.Uint32(page[end:]) == crc32.Checksum(page[:end], castagnoli)
+}
+
+// Catalog returns the root page of the schema catalog tree
+func (p *Pager) Catalog() PageID {
+	return p.header.catalog
+}
+
+// PageCount returns the number of pages in the file, including the header
+// page and free pages
+func (p *Pager) PageCount() int {
+	return int(p.header.pageCount)
+}
+
+// FreeCount returns the number of pages on the freelist
+func (p *Pager) FreeCount() int {
+	return int(p.header.freeCount)
+}
+
+// Stats returns the page IO and buffer pool counters
+func (p *Pager) Stats() Stats {
+	stats := p.stats
+	stats.CacheHits = p.pool.hits
+	stats.CacheMisses = p.pool.misses
+	stats.CacheEvictions = p.pool.evictions
+	stats.CachedPages = len(p.pool.pages)
+	return stats
+}
+
+// SetCacheSize sets the memory budget of the buffer pool in bytes,
+// evicting pages to meet it. Pinned pages and pages changed since the last
+// commit are kept whatever the budget.
+func (p *Pager) SetCacheSize(bytes int) {
+	p.pool.resize(bytes)
+}
+
+// Read returns the contents of a page. The returned slice must not be
+// modified; it stays valid after the page leaves the buffer pool.
+func (p *Pager) Read(id PageID) ([]byte, error) {
+	page, err := p.Pin(id)
+	if err != nil {
+		return nil, err
+	}
+	p.Unpin(page)
+	return page.Data, nil
+}
+
+// Pin returns a page from the buffer pool, reading it if it is not there,
+// and keeps it in the pool until it is unpinned
+func (p *Pager) Pin(id PageID) (*Page, error) {
+	if id == 0 || uint32(id) >= p.header.pageCount {
+		return nil, fmt.Errorf("page %d out of range", id)
+	}
+	if page, exists := p.pool.get(id); exists {
+		p.pool.pin(page)
+		return page, nil
+	}
+
+	data, err := p.readPage(id)
+	if err != nil {
+		return nil, err
+	}
+	p.stats.PagesRead++
+	return p.pool.add(id, data), nil
+}
+
+// readPage reads the committed contents of a page from the log or the file,
+// checking its checksum and decrypting it
+func (p *Pager) readPage(id PageID) ([]byte, error) {
+	data, exists, err := p.wal.read(id)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		data = make([]byte, PageSize)
+		if _, err := p.file.ReadAt(data, int64(id)*PageSize); err != nil {
+			return nil, fmt.Errorf("reading page %d: %v", id, err)
+		}
+	}
+	if !p.verify(data) {
+		return nil, fmt.Errorf("page %d: %w", id, ErrChecksum)
+	}
+	if p.key != nil {
+		plaintext, err := p.key.Open(data[:p.usable()+crypt.Overhead], pageData(id))
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", id, err)
+		}
+		data = append(plaintext, make([]byte, PageSize-len(plaintext))...)
+	}
+	return data, nil
+}
+
+// encode returns the contents of a page as stored: encrypted, if the file
+// is, and signed
+func (p *Pager) encode(id PageID, data []byte) []byte {
+	if p.key == nil {
+		return data
+	}
+	page := make([]byte, PageSize)
+	copy(page, p.key.Seal(data[:p.usable()], pageData(id)))
+	p.sign(page)
+	return page
+}
+
+// pageData returns the additional data a page is sealed with, binding it to
+// its page number
+func pageData(id PageID) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(id))
+}
+
+// Unpin releases a page pinned by Pin
+func (p *Pager) Unpin(page *Page) {
+	p.pool.unpin(page)
+}
+
+// Write replaces the contents of a page; data is padded to the page size,
+// leaving room for the checksum, and must not be modified afterwards. The
+// page reaches the file on Commit.
+func (p *Pager) Write(id PageID, data []byte) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	if id == 0 || uint32(id) >= p.header.pageCount {
+		return fmt.Errorf("page %d out of range", id)
+	}
+	if len(data) > p.usable() {
+		return fmt.Errorf("page %d overflows: %d bytes", id, len(data))
+	}
+	if len(data) < PageSize {
+		data = append(data, make([]byte, PageSize-len(data))...)
+	}
+	p.sign(data)
+	p.pool.write(id, data)
+	return nil
+}
+
+// Allocate returns an unused page, taking it from the freelist if there is
+// one and growing the file otherwise. Its contents are undefined until
+// written.
+func (p *Pager) Allocate() (PageID, error) {
+	if p.header.freeList == 0 {
+		id := PageID(p.header.pageCount)
+		p.header.pageCount++
+		return id, nil
+	}
+
+	id := p.header.freeList
+	data, err := p.Read(id)
+	if err != nil {
+		return 0, err
+	}
+	if data[0] != pageFree {
+		return 0, fmt.Errorf("freelist page %d is not free", id)
+	}
+	p.header.freeList = PageID(binary.BigEndian.Uint32(data[1:]))
+	p.header.freeCount--
+	return id, nil
+}
+
+// Free puts a page on the freelist
+func (p *Pager) Free(id PageID) error {
+	data := make([]byte, 5)
+	data[0] = pageFree
+	binary.BigEndian.PutUint32(data[1:], uint32(p.header.freeList))
+	if err := p.Write(id, data); err != nil {
+		return err
+	}
+	p.header.freeList = id
+	p.header.freeCount++
+	return nil
+}
+
+// Commit logs the pages changed since the last commit and the header page
+// to the write-ahead log and syncs it. Once the log is large enough it is
+// checkpointed; a failed checkpoint is retried later and does not fail the
+// commit, which is already durable.
+func (p *Pager) Commit() error {
+	if len(p.pool.dirty) == 0 && p.header == p.committed {
+		return nil
+	}
+	if p.readOnly {
+		return ErrReadOnly
+	}
+
+	ids := make([]PageID, 0, len(p.pool.dirty)+1)
+	for id := range p.pool.dirty {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	pages := make([][]byte, 0, len(ids)+1)
+	for _, id := range ids {
+		pages = append(pages, p.encode(id, p.pool.dirty[id].Data))
+	}
+	ids = append(ids, 0)
+	pages = append(pages, p.encodeHeader())
+
+	if err := p.wal.append(ids, pages); err != nil {
+		return err
+	}
+	p.stats.PagesWritten += len(ids)
+	p.stats.Commits++
+	p.pool.clean()
+	p.committed = p.header
+
+	if p.wal.frames >= CheckpointFrames {
+		p.Checkpoint()
+	}
+	return nil
+}
+
+// Checkpoint copies the committed pages in the write-ahead log into the
+// database file and empties the log
+func (p *Pager) Checkpoint() error {
+	if len(p.wal.index) == 0 {
+		return nil
+	}
+	if err := p.wal.checkpoint(p.file); err != nil {
+		return err
+	}
+	p.stats.Checkpoints++
+	return nil
+}
+
+// Rollback discards the pages changed since the last commit
+func (p *Pager) Rollback() {
+	p.pool.discard()
+	p.header = p.committed
+}
+
+// Close checkpoints the log and closes the files, discarding uncommitted
+// changes. The log is removed once it is empty. A file open read-only is
+// only closed.
+func (p *Pager) Close() error {
+	p.Rollback()
+	if p.readOnly {
+		return p.close()
+	}
+	err := p.Checkpoint()
+	if closeErr := p.close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Remove(p.walPath)
+	}
+	return err
+}
+
+// close closes the files
+func (p *Pager) close() error {
+	var walErr error
+	if p.wal.file != nil {
+		walErr = p.wal.file.Close()
+	}
+	if err := p.file.Close(); err != nil {
+		return err
+	}
+	return walErr
+}
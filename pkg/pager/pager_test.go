@@ -0,0 +1,403 @@
+package pager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"sqlight/pkg/crypt"
+	"sqlight/pkg/version"
+)
+
+// checkTree compares the contents of a tree with a model
+func checkTree(t *testing.T, tree *Tree, model map[string][]byte) {
+	t.Helper()
+	var keys []string
+	err := tree.Scan(func(key, value []byte) error {
+		keys = append(keys, string(key))
+		if expected, exists := model[string(key)]; !exists || !bytes.Equal(value, expected) {
+			return fmt.Errorf("unexpected value for key %q", key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(keys) != len(model) {
+		t.Fatalf("Scan returned %d keys, expected %d", len(keys), len(model))
+	}
+	if !sort.StringsAreSorted(keys) {
+		t.Fatalf("Scan returned keys out of order")
+	}
+	for key, expected := range model {
+		value, found, err := tree.Get([]byte(key))
+		if err != nil || !found || !bytes.Equal(value, expected) {
+			t.Fatalf("Get(%q) = %d bytes, %v, %v", key, len(value), found, err)
+		}
+	}
+}
+
+func TestTreeRandomPutAndDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	p, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	tree, err := CreateTree(p)
+	if err != nil {
+		t.Fatalf("CreateTree: %v", err)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	model := make(map[string][]byte)
+	for i := 0; i < 5000; i++ {
+		key := binary.BigEndian.AppendUint32(nil, uint32(rng.Intn(2000)))
+		if rng.Intn(3) == 0 {
+			deleted, err := tree.Delete(key)
+			if err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, exists := model[string(key)]; deleted != exists {
+				t.Fatalf("Delete(%x) = %v, expected %v", key, deleted, exists)
+			}
+			delete(model, string(key))
+		} else {
+			// Mostly small values, with some that need overflow pages
+			value := make([]byte, rng.Intn(200))
+			if rng.Intn(20) == 0 {
+				value = make([]byte, rng.Intn(3*PageSize))
+			}
+			rng.Read(value)
+			if err := tree.Put(key, value); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			model[string(key)] = value
+		}
+		if i%500 == 0 {
+			if err := p.Commit(); err != nil {
+				t.Fatalf("Commit: %v", err)
+			}
+		}
+	}
+	checkTree(t, tree, model)
+	if err := p.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	root := tree.Root()
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	p, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer p.Close()
+	checkTree(t, OpenTree(p, root), model)
+}
+
+func TestTreeDropReusesPages(t *testing.T) {
+	p, err := Create(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer p.Close()
+
+	fill := func() *Tree {
+		tree, err := CreateTree(p)
+		if err != nil {
+			t.Fatalf("CreateTree: %v", err)
+		}
+		for i := 0; i < 1000; i++ {
+			key := binary.BigEndian.AppendUint32(nil, uint32(i))
+			if err := tree.Put(key, bytes.Repeat([]byte{'x'}, 100)); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+		}
+		return tree
+	}
+
+	tree := fill()
+	pages := p.PageCount()
+	if err := tree.Drop(); err != nil {
+		t.Fatalf("Drop: %v", err)
+	}
+	if p.FreeCount() != pages-2 {
+		t.Errorf("Drop freed %d pages, expected %d", p.FreeCount(), pages-2)
+	}
+
+	fill()
+	if p.PageCount() != pages || p.FreeCount() != 0 {
+		t.Errorf("Refilling grew the file to %d pages with %d free, expected %d with none", p.PageCount(), p.FreeCount(), pages)
+	}
+}
+
+func TestCommitWritesChangedPages(t *testing.T) {
+	p, err := Create(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer p.Close()
+
+	tree, err := CreateTree(p)
+	if err != nil {
+		t.Fatalf("CreateTree: %v", err)
+	}
+	for i := 0; i < 10000; i++ {
+		if err := tree.Put(binary.BigEndian.AppendUint32(nil, uint32(2*i)), []byte("value")); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if err := p.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	before := p.Stats().PagesWritten
+	if err := tree.Put(binary.BigEndian.AppendUint32(nil, 5001), []byte("value")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := p.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if written := p.Stats().PagesWritten - before; written > 4 {
+		t.Errorf("Inserting one key wrote %d of %d pages", written, p.PageCount())
+	}
+}
+
+func TestRollbackDiscardsChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	p, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer p.Close()
+
+	catalog := OpenTree(p, p.Catalog())
+	if err := catalog.Put([]byte("kept"), []byte("1")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := p.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	pages := p.PageCount()
+	if _, err := CreateTree(p); err != nil {
+		t.Fatalf("CreateTree: %v", err)
+	}
+	if err := catalog.Put([]byte("discarded"), []byte("2")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	p.Rollback()
+
+	if p.PageCount() != pages {
+		t.Errorf("PageCount after rollback = %d, expected %d", p.PageCount(), pages)
+	}
+	checkTree(t, catalog, map[string][]byte{"kept": []byte("1")})
+}
+
+func TestOpenRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.json")
+	if err := os.WriteFile(path, []byte(`{"users": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if IsPageFile(path) {
+		t.Errorf("IsPageFile reported a JSON file as a page file")
+	}
+	if _, err := Open(path); err != ErrNotPageFile {
+		t.Errorf("Open returned %v, expected ErrNotPageFile", err)
+	}
+}
+
+func TestEncryptedFile(t *testing.T) {
+	cost := crypt.DefaultCost
+	crypt.DefaultCost = 10
+	defer func() { crypt.DefaultCost = cost }()
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	p, err := CreateWith(path, Options{Passphrase: "secret"})
+	if err != nil {
+		t.Fatalf("CreateWith: %v", err)
+	}
+	trees, models := randomTrees(t, p)
+	secret := []byte("alice@example.com")
+	catalog := OpenTree(p, p.Catalog())
+	if err := catalog.Put([]byte("email"), secret); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := p.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	models[p.Catalog()]["email"] = secret
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, secret) {
+		t.Error("The encrypted file holds a stored value in plaintext")
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("The file has mode %v, expected it readable only by its owner", info.Mode().Perm())
+	}
+
+	if _, err := Open(path); !errors.Is(err, crypt.ErrPassphraseRequired) {
+		t.Errorf("Opening without a passphrase returned %v", err)
+	}
+	if _, err := OpenWith(path, Options{Passphrase: "guess"}); !errors.Is(err, crypt.ErrWrongPassphrase) {
+		t.Errorf("Opening with the wrong passphrase returned %v", err)
+	}
+	p, err = OpenWith(path, Options{Passphrase: "secret"})
+	if err != nil {
+		t.Fatalf("OpenWith: %v", err)
+	}
+	if !p.Encrypted() {
+		t.Error("Expected the reopened file to be encrypted")
+	}
+	for _, root := range trees {
+		checkTree(t, OpenTree(p, root), models[root])
+	}
+	if problems := p.Check(trees); len(problems) > 0 {
+		t.Errorf("Check found %v", problems)
+	}
+	p.Close()
+
+	// A page altered along with its checksum fails to decrypt
+	root := trees["a"]
+	data[int64(root)*PageSize+PageSize/2] ^= 1
+	page := data[int64(root)*PageSize : int64(root+1)*PageSize]
+	p.sign(page)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err = OpenWith(path, Options{Passphrase: "secret"})
+	if err != nil {
+		t.Fatalf("OpenWith: %v", err)
+	}
+	defer p.Close()
+	if _, err := p.Read(root); !errors.Is(err, crypt.ErrAuthentication) {
+		t.Errorf("Reading the altered page returned %v, expected an authentication error", err)
+	}
+
+	plain := filepath.Join(t.TempDir(), "plain.db")
+	q, err := Create(plain)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	q.Close()
+	if _, err := OpenWith(plain, Options{Passphrase: "secret"}); !errors.Is(err, crypt.ErrNotEncrypted) {
+		t.Errorf("Opening a plaintext file with a passphrase returned %v", err)
+	}
+}
+
+func TestUpgradeInPlace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	p, err := CreateWith(path, Options{Version: checksumVersion})
+	if err != nil {
+		t.Fatalf("CreateWith: %v", err)
+	}
+	trees, models := randomTrees(t, p)
+	for v := uint32(checksumVersion); v < FormatVersion; v++ {
+		if err := p.Upgrade(); err != nil {
+			t.Fatalf("Upgrade from version %d: %v", v, err)
+		}
+		if p.Version() != v+1 {
+			t.Fatalf("Upgraded to version %d, expected %d", p.Version(), v+1)
+		}
+	}
+	if err := p.Upgrade(); err == nil {
+		t.Error("Expected upgrading the current version to fail")
+	}
+	p.Close()
+
+	p, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if p.Version() != FormatVersion || p.Creator() != version.String() {
+		t.Errorf("Reopened file has version %d created by %q", p.Version(), p.Creator())
+	}
+	for _, root := range trees {
+		checkTree(t, OpenTree(p, root), models[root])
+	}
+	if problems := p.Check(trees); len(problems) > 0 {
+		t.Errorf("Check found %v", problems)
+	}
+	p.Close()
+
+	p, err = CreateWith(path, Options{Version: 1})
+	if err != nil {
+		t.Fatalf("CreateWith: %v", err)
+	}
+	defer p.Close()
+	if err := p.Upgrade(); err == nil {
+		t.Error("Expected upgrading a file without checksums in place to fail")
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	p, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer p.Close()
+	trees, models := randomTrees(t, p)
+	main, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The commits still in the log are read from it, and nothing is written
+	r, err := OpenWith(path, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("OpenWith: %v", err)
+	}
+	for _, root := range trees {
+		checkTree(t, OpenTree(r, root), models[root])
+	}
+	if err := OpenTree(r, r.Catalog()).Put([]byte("key"), []byte("value")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Writing returned %v, expected ErrReadOnly", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if after, err := os.ReadFile(path); err != nil || !bytes.Equal(after, main) {
+		t.Errorf("The file changed while open read-only")
+	}
+	if _, err := os.Stat(path + "-wal"); err != nil {
+		t.Errorf("Closing the read-only file removed the log: %v", err)
+	}
+}
+
+func TestOpenRejectsNewerVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	p, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	p.Close()
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(binary.BigEndian.AppendUint32(nil, FormatVersion+1), offsetVersion); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	_, err = Open(path)
+	var newer *version.NewerFormatError
+	if !errors.As(err, &newer) || newer.Format != FormatVersion+1 || newer.Creator != version.String() {
+		t.Errorf("Opening a newer file returned %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package pager
+
+import "container/list"
+
+// Buffer pool
+//
+// The pager reads pages through a buffer pool that keeps recently used pages
+// in memory, up to a budget. A page is pinned while it is in use and a
+// pinned page stays in the pool; unpinned pages are kept in least recently
+// used order and evicted, oldest first, whenever the pool is over budget.
+// Pages written since the last commit are dirty: the write-ahead log only
+// takes whole commits, so they stay in the pool, whatever the budget, until
+// the commit logs them, after which they are clean like any other page. A
+// rollback drops them, and the committed version is read again when next
+// needed.
+
+// DefaultCacheSize is the memory budget of a pager's buffer pool, in bytes,
+// unless set with SetCacheSize
+var DefaultCacheSize = 4 << 20
+
+// minCachePages is the smallest budget, in pages, a pool is given; it holds
+// a path from the root to a leaf of any reasonable tree
+const minCachePages = 16
+
+// Page is a page held in the buffer pool. Data must not be modified.
+type Page struct {
+	ID   PageID
+	Data []byte
+
+	pins    int
+	dirty   bool
+	element *list.Element // position in the pool's LRU list while evictable
+}
+
+// pool holds the pages of a pager that are in memory
+type pool struct {
+	pages    map[PageID]*Page
+	lru      *list.List // unpinned clean pages, least recently used first
+	dirty    map[PageID]*Page
+	capacity int // in pages
+
+	hits, misses, evictions int
+}
+
+func newPool(bytes int) *pool {
+	pl := &pool{
+		pages: make(map[PageID]*Page),
+		lru:   list.New(),
+		dirty: make(map[PageID]*Page),
+	}
+	pl.resize(bytes)
+	return pl
+}
+
+// resize sets the budget of the pool, evicting pages to meet it
+func (pl *pool) resize(bytes int) {
+	pl.capacity = bytes / PageSize
+	if pl.capacity < minCachePages {
+		pl.capacity = minCachePages
+	}
+	pl.evict()
+}
+
+// get returns the page with the given ID if it is in the pool, counting a
+// hit or a miss
+func (pl *pool) get(id PageID) (*Page, bool) {
+	page, exists := pl.pages[id]
+	if !exists {
+		pl.misses++
+		return nil, false
+	}
+	pl.hits++
+	if page.element != nil {
+		pl.lru.MoveToBack(page.element)
+	}
+	return page, true
+}
+
+// add puts a page read from disk in the pool, pinned
+func (pl *pool) add(id PageID, data []byte) *Page {
+	page := &Page{ID: id, Data: data, pins: 1}
+	pl.pages[id] = page
+	pl.evict()
+	return page
+}
+
+// pin keeps a page in the pool until it is unpinned
+func (pl *pool) pin(page *Page) {
+	page.pins++
+	if page.element != nil {
+		pl.lru.Remove(page.element)
+		page.element = nil
+	}
+}
+
+// unpin releases a pin on a page, letting it be evicted once it has no
+// pins and is clean
+func (pl *pool) unpin(page *Page) {
+	if page.pins == 0 {
+		return
+	}
+	page.pins--
+	pl.release(page)
+}
+
+// release makes a page evictable if it is in the pool, unpinned and clean
+func (pl *pool) release(page *Page) {
+	if page.pins > 0 || page.dirty || page.element != nil || pl.pages[page.ID] != page {
+		return
+	}
+	page.element = pl.lru.PushBack(page)
+	pl.evict()
+}
+
+// write replaces the contents of a page and marks it dirty
+func (pl *pool) write(id PageID, data []byte) {
+	page, exists := pl.pages[id]
+	if !exists {
+		page = &Page{ID: id}
+		pl.pages[id] = page
+	}
+	if page.element != nil {
+		pl.lru.Remove(page.element)
+		page.element = nil
+	}
+	page.Data, page.dirty = data, true
+	pl.dirty[id] = page
+}
+
+// clean marks the dirty pages clean once they are committed
+func (pl *pool) clean() {
+	for _, page := range pl.dirty {
+		page.dirty = false
+		pl.release(page)
+	}
+	pl.dirty = make(map[PageID]*Page)
+}
+
+// discard drops the dirty pages
+func (pl *pool) discard() {
+	for id, page := range pl.dirty {
+		page.dirty = false
+		delete(pl.pages, id)
+	}
+	pl.dirty = make(map[PageID]*Page)
+}
+
+// evict drops least recently used pages until the pool is within budget
+// or only pinned and dirty pages are left
+func (pl *pool) evict() {
+	for len(pl.pages) > pl.capacity {
+		front := pl.lru.Front()
+		if front == nil {
+			return
+		}
+		page := pl.lru.Remove(front).(*Page)
+		page.element = nil
+		delete(pl.pages, page.ID)
+		pl.evictions++
+	}
+}
@@ -0,0 +1,181 @@
+package pager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+)
+
+// bigTree creates a committed tree spanning many more pages than the
+// smallest buffer pool, returning its contents
+func bigTree(t *testing.T, p *Pager) (*Tree, map[string][]byte) {
+	t.Helper()
+	tree, err := CreateTree(p)
+	if err != nil {
+		t.Fatalf("CreateTree: %v", err)
+	}
+	model := make(map[string][]byte)
+	for i := 0; i < 3000; i++ {
+		key := binary.BigEndian.AppendUint32(nil, uint32(i))
+		value := bytes.Repeat([]byte{byte(i)}, 100)
+		if err := tree.Put(key, value); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		model[string(key)] = value
+	}
+	if err := p.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	return tree, model
+}
+
+func TestBufferPoolStaysWithinBudget(t *testing.T) {
+	p, err := Create(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer p.Close()
+	tree, model := bigTree(t, p)
+
+	p.SetCacheSize(minCachePages * PageSize)
+	if cached := p.Stats().CachedPages; cached > minCachePages {
+		t.Errorf("%d pages cached after shrinking the pool to %d", cached, minCachePages)
+	}
+	checkTree(t, tree, model)
+	stats := p.Stats()
+	if stats.CachedPages > minCachePages {
+		t.Errorf("%d pages cached with a budget of %d", stats.CachedPages, minCachePages)
+	}
+	if stats.CacheEvictions == 0 || stats.CacheMisses == 0 {
+		t.Errorf("Expected a scan larger than the pool to miss and evict: %+v", stats)
+	}
+
+	// Repeated lookups of one key find its pages in the pool
+	key := binary.BigEndian.AppendUint32(nil, 1234)
+	if _, _, err := tree.Get(key); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	before := p.Stats()
+	for i := 0; i < 10; i++ {
+		if _, _, err := tree.Get(key); err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+	}
+	after := p.Stats()
+	if after.PagesRead != before.PagesRead || after.CacheMisses != before.CacheMisses || after.CacheHits <= before.CacheHits {
+		t.Errorf("Expected repeated lookups to hit the pool: before %+v, after %+v", before, after)
+	}
+
+	// With a budget larger than the file, a second scan reads nothing
+	p.SetCacheSize(p.PageCount() * PageSize)
+	checkTree(t, tree, model)
+	before = p.Stats()
+	checkTree(t, tree, model)
+	if after := p.Stats(); after.PagesRead != before.PagesRead {
+		t.Errorf("Second scan read %d pages", after.PagesRead-before.PagesRead)
+	}
+}
+
+func TestPinnedPagesAreNotEvicted(t *testing.T) {
+	p, err := Create(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer p.Close()
+	tree, model := bigTree(t, p)
+	p.SetCacheSize(0)
+
+	pinned, err := p.Pin(tree.Root())
+	if err != nil {
+		t.Fatalf("Pin: %v", err)
+	}
+	checkTree(t, tree, model)
+	before := p.Stats()
+	again, err := p.Pin(tree.Root())
+	if err != nil {
+		t.Fatalf("Pin: %v", err)
+	}
+	if again != pinned || p.Stats().PagesRead != before.PagesRead {
+		t.Error("Expected the pinned page to stay in the pool")
+	}
+	p.Unpin(again)
+	p.Unpin(pinned)
+
+	// Once unpinned it is the least recently used page
+	for id := 1; id < p.PageCount(); id++ {
+		if PageID(id) == tree.Root() {
+			continue
+		}
+		if _, err := p.Read(PageID(id)); err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+	}
+	before = p.Stats()
+	if _, err := p.Read(tree.Root()); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if p.Stats().PagesRead != before.PagesRead+1 {
+		t.Error("Expected the unpinned page to be evicted")
+	}
+}
+
+// pageByte returns the byte a test wrote to a page
+func pageByte(t *testing.T, p *Pager, id PageID) byte {
+	t.Helper()
+	page, err := p.Read(id)
+	if err != nil {
+		t.Fatalf("Read(%d): %v", id, err)
+	}
+	return page[3]
+}
+
+func TestDirtyPagesStayUntilCommit(t *testing.T) {
+	p, err := Create(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer p.Close()
+	p.SetCacheSize(0)
+
+	// Write more pages than the pool's budget without committing
+	const pages = 4 * minCachePages
+	ids := make([]PageID, pages)
+	for i := range ids {
+		if ids[i], err = p.Allocate(); err != nil {
+			t.Fatalf("Allocate: %v", err)
+		}
+		if err := p.Write(ids[i], []byte{pageLeaf, 0, 0, byte(i)}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if cached := p.Stats().CachedPages; cached < pages {
+		t.Fatalf("%d pages cached, expected all %d dirty pages", cached, pages)
+	}
+	for i, id := range ids {
+		if got := pageByte(t, p, id); got != byte(i) {
+			t.Fatalf("Page %d holds %d before committing, expected %d", id, got, i)
+		}
+	}
+
+	if err := p.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if cached := p.Stats().CachedPages; cached > minCachePages {
+		t.Errorf("%d pages cached after committing, expected at most %d", cached, minCachePages)
+	}
+	for i, id := range ids {
+		if got := pageByte(t, p, id); got != byte(i) {
+			t.Fatalf("Page %d holds %d after committing, expected %d", id, got, i)
+		}
+	}
+
+	// Rolled back pages are read again from the log
+	if err := p.Write(ids[0], []byte{pageLeaf, 0, 0, 99}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	p.Rollback()
+	if got := pageByte(t, p, ids[0]); got != 0 {
+		t.Errorf("Page holds %d after rolling back, expected the committed 0", got)
+	}
+}
@@ -0,0 +1,666 @@
+package pager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Tree pages
+//
+// A Tree is a B+ tree of byte string keys and values stored one node per
+// page. Keys compare byte by byte. Each page starts with its type and the
+// number of cells:
+//   - A leaf page holds its cells in key order, each a key followed by its
+//     value. A value too large to share a page with others is moved to a
+//     chain of overflow pages and the cell records the first of them.
+//   - An internal page holds its first child followed by key and child
+//     pairs; the keys in the child after a key are not less than it, and
+//     those in the child before it are less.
+//
+// The root page of a tree never changes, so it can be recorded once in the
+// catalog: when the root splits its cells move to two new pages beneath it,
+// and when it is left with a single child the child moves up into it.
+// Deleting empty nodes keeps the tree valid, but nodes are not merged, so
+// pages emptied by deletes only return to the freelist once empty.
+
+// Page layout limits
+const (
+	nodeHeaderSize = 3
+	overflowHeader = 7
+
+	// MaxKeySize is the largest key a tree accepts
+	MaxKeySize = PageSize / 8
+
+	// maxInlineValue is the largest value stored in a leaf cell; larger
+	// values go to overflow pages
+	maxInlineValue = PageSize / 4
+)
+
+// Tree is a B+ tree stored in the pages of a Pager
+type Tree struct {
+	pager *Pager
+	root  PageID
+}
+
+// node is the decoded contents of a tree page
+type node struct {
+	id       PageID
+	leaf     bool
+	keys     [][]byte
+	values   []cell   // leaf only
+	children []PageID // internal only, one more than keys
+}
+
+// cell is the value of a leaf entry: the value itself, or the first overflow
+// page and total length of a value stored in overflow pages
+type cell struct {
+	value    []byte
+	overflow PageID
+	length   int
+}
+
+// CreateTree allocates the root page of a new, empty tree
+func CreateTree(p *Pager) (*Tree, error) {
+	root, err := p.Allocate()
+	if err != nil {
+		return nil, err
+	}
+	t := &Tree{pager: p, root: root}
+	if err := t.write(&node{id: root, leaf: true}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// OpenTree returns the tree whose root is at page root
+func OpenTree(p *Pager, root PageID) *Tree {
+	return &Tree{pager: p, root: root}
+}
+
+// Root returns the root page of the tree
+func (t *Tree) Root() PageID {
+	return t.root
+}
+
+// Get returns the value stored under key
+func (t *Tree) Get(key []byte) ([]byte, bool, error) {
+	n, err := t.read(t.root)
+	if err != nil {
+		return nil, false, err
+	}
+	for !n.leaf {
+		if n, err = t.read(n.children[n.childIndex(key)]); err != nil {
+			return nil, false, err
+		}
+	}
+
+	i, found := n.search(key)
+	if !found {
+		return nil, false, nil
+	}
+	value, err := t.value(n.values[i])
+	return value, err == nil, err
+}
+
+// Put stores value under key, replacing any value already stored there
+func (t *Tree) Put(key, value []byte) error {
+	if len(key) > MaxKeySize {
+		return fmt.Errorf("key of %d bytes exceeds the maximum of %d", len(key), MaxKeySize)
+	}
+	c, err := t.newCell(value)
+	if err != nil {
+		return err
+	}
+
+	root, err := t.read(t.root)
+	if err != nil {
+		return err
+	}
+	sep, right, err := t.insert(root, key, c)
+	if err != nil || right == 0 {
+		return err
+	}
+
+	// The root split: move its left half to a new page, so that the root
+	// page becomes the parent of both halves
+	left, err := t.read(t.root)
+	if err != nil {
+		return err
+	}
+	if left.id, err = t.pager.Allocate(); err != nil {
+		return err
+	}
+	if err := t.write(left); err != nil {
+		return err
+	}
+	return t.write(&node{
+		id:       t.root,
+		keys:     [][]byte{sep},
+		children: []PageID{left.id, right},
+	})
+}
+
+// insert adds a cell below n. If n splits, it returns the first key of the
+// new right sibling and the sibling's page.
+func (t *Tree) insert(n *node, key []byte, c cell) ([]byte, PageID, error) {
+	if n.leaf {
+		i, found := n.search(key)
+		if found {
+			if err := t.freeCell(n.values[i]); err != nil {
+				return nil, 0, err
+			}
+			n.values[i] = c
+		} else {
+			n.keys = insertAt(n.keys, i, key)
+			n.values = append(n.values, cell{})
+			copy(n.values[i+1:], n.values[i:])
+			n.values[i] = c
+		}
+		return t.store(n)
+	}
+
+	i := n.childIndex(key)
+	child, err := t.read(n.children[i])
+	if err != nil {
+		return nil, 0, err
+	}
+	sep, right, err := t.insert(child, key, c)
+	if err != nil || right == 0 {
+		return nil, 0, err
+	}
+	n.keys = insertAt(n.keys, i, sep)
+	n.children = append(n.children, 0)
+	copy(n.children[i+2:], n.children[i+1:])
+	n.children[i+1] = right
+	return t.store(n)
+}
+
+// store writes a node, first splitting it in two if it no longer fits in a
+// page. It returns the separator and page of the new right half, if any.
+func (t *Tree) store(n *node) ([]byte, PageID, error) {
+	if n.size() <= t.pager.usable() {
+		return nil, 0, t.write(n)
+	}
+
+	id, err := t.pager.Allocate()
+	if err != nil {
+		return nil, 0, err
+	}
+	right := &node{id: id, leaf: n.leaf}
+	var sep []byte
+
+	sizes := make([]int, len(n.keys))
+	for i, key := range n.keys {
+		if n.leaf {
+			sizes[i] = leafCellSize(key, n.values[i])
+		} else {
+			sizes[i] = internalCellSize(key)
+		}
+	}
+
+	if n.leaf {
+		mid := splitPoint(sizes, 0)
+		right.keys = append(right.keys, n.keys[mid:]...)
+		right.values = append(right.values, n.values[mid:]...)
+		n.keys, n.values = n.keys[:mid], n.values[:mid]
+		sep = right.keys[0]
+	} else {
+		// The key at the split point moves up to the parent
+		mid := splitPoint(sizes, 1)
+		sep = n.keys[mid]
+		right.keys = append(right.keys, n.keys[mid+1:]...)
+		right.children = append(right.children, n.children[mid+1:]...)
+		n.keys, n.children = n.keys[:mid], n.children[:mid+1]
+	}
+
+	if err := t.write(n); err != nil {
+		return nil, 0, err
+	}
+	if err := t.write(right); err != nil {
+		return nil, 0, err
+	}
+	return sep, id, nil
+}
+
+// Delete removes key, reporting whether it was present
+func (t *Tree) Delete(key []byte) (bool, error) {
+	root, err := t.read(t.root)
+	if err != nil {
+		return false, err
+	}
+	found, err := t.remove(root, key)
+	if err != nil || !found || root.leaf || len(root.keys) > 0 {
+		return found, err
+	}
+
+	// The root has a single child left: move the child up into it
+	child, err := t.read(root.children[0])
+	if err != nil {
+		return false, err
+	}
+	if err := t.pager.Free(child.id); err != nil {
+		return false, err
+	}
+	child.id = t.root
+	return true, t.write(child)
+}
+
+// remove deletes key from below n and writes n if it changed. A child left
+// empty is freed and dropped from n, and an internal child left with a
+// single child is replaced by it.
+func (t *Tree) remove(n *node, key []byte) (bool, error) {
+	if n.leaf {
+		i, found := n.search(key)
+		if !found {
+			return false, nil
+		}
+		if err := t.freeCell(n.values[i]); err != nil {
+			return false, err
+		}
+		n.keys = append(n.keys[:i], n.keys[i+1:]...)
+		n.values = append(n.values[:i], n.values[i+1:]...)
+		return true, t.write(n)
+	}
+
+	i := n.childIndex(key)
+	child, err := t.read(n.children[i])
+	if err != nil {
+		return false, err
+	}
+	found, err := t.remove(child, key)
+	if err != nil || !found {
+		return found, err
+	}
+
+	switch {
+	case child.leaf && len(child.keys) == 0:
+		if err := t.pager.Free(child.id); err != nil {
+			return false, err
+		}
+		n.children = append(n.children[:i], n.children[i+1:]...)
+		if i > 0 {
+			i--
+		}
+		n.keys = append(n.keys[:i], n.keys[i+1:]...)
+	case !child.leaf && len(child.keys) == 0:
+		if err := t.pager.Free(child.id); err != nil {
+			return false, err
+		}
+		n.children[i] = child.children[0]
+	default:
+		return true, nil
+	}
+	return true, t.write(n)
+}
+
+// Scan calls fn with every key and value in key order, stopping at the
+// first error
+func (t *Tree) Scan(fn func(key, value []byte) error) error {
+	return t.scan(t.root, fn)
+}
+
+func (t *Tree) scan(id PageID, fn func(key, value []byte) error) error {
+	// The page stays pinned while its keys, which point into it, are in use
+	n, page, err := t.pin(id)
+	if err != nil {
+		return err
+	}
+	defer t.pager.Unpin(page)
+	if !n.leaf {
+		for _, child := range n.children {
+			if err := t.scan(child, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i, key := range n.keys {
+		value, err := t.value(n.values[i])
+		if err != nil {
+			return err
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Drop frees every page of the tree, including its root
+func (t *Tree) Drop() error {
+	return t.drop(t.root)
+}
+
+func (t *Tree) drop(id PageID) error {
+	n, err := t.read(id)
+	if err != nil {
+		return err
+	}
+	for _, child := range n.children {
+		if err := t.drop(child); err != nil {
+			return err
+		}
+	}
+	for _, c := range n.values {
+		if err := t.freeCell(c); err != nil {
+			return err
+		}
+	}
+	return t.pager.Free(id)
+}
+
+// newCell stores a value for a leaf cell, writing it to overflow pages if
+// it is too large to keep in the leaf
+func (t *Tree) newCell(value []byte) (cell, error) {
+	if len(value) <= maxInlineValue {
+		return cell{value: value, length: len(value)}, nil
+	}
+
+	// Write the chain back to front so each page can link to the next
+	chunk := t.pager.usable() - overflowHeader
+	var next PageID
+	for end := len(value); end > 0; {
+		start := (end - 1) / chunk * chunk
+		id, err := t.pager.Allocate()
+		if err != nil {
+			return cell{}, err
+		}
+		page := make([]byte, overflowHeader+end-start)
+		page[0] = pageOverflow
+		binary.BigEndian.PutUint32(page[1:], uint32(next))
+		binary.BigEndian.PutUint16(page[5:], uint16(end-start))
+		copy(page[overflowHeader:], value[start:end])
+		if err := t.pager.Write(id, page); err != nil {
+			return cell{}, err
+		}
+		next, end = id, start
+	}
+	return cell{overflow: next, length: len(value)}, nil
+}
+
+// value returns the value of a leaf cell, reading it from its overflow
+// pages if needed
+func (t *Tree) value(c cell) ([]byte, error) {
+	if c.overflow == 0 {
+		return c.value, nil
+	}
+
+	value := make([]byte, 0, c.length)
+	for id := c.overflow; id != 0; {
+		page, err := t.pager.Read(id)
+		if err != nil {
+			return nil, err
+		}
+		if page[0] != pageOverflow {
+			return nil, fmt.Errorf("page %d is not an overflow page", id)
+		}
+		size := int(binary.BigEndian.Uint16(page[5:]))
+		if size > t.pager.usable()-overflowHeader {
+			return nil, fmt.Errorf("overflow page %d is corrupt", id)
+		}
+		value = append(value, page[overflowHeader:overflowHeader+size]...)
+		id = PageID(binary.BigEndian.Uint32(page[1:]))
+	}
+	if len(value) != c.length {
+		return nil, fmt.Errorf("overflow value is %d bytes, expected %d", len(value), c.length)
+	}
+	return value, nil
+}
+
+// freeCell frees the overflow pages of a leaf cell
+func (t *Tree) freeCell(c cell) error {
+	for id := c.overflow; id != 0; {
+		page, err := t.pager.Read(id)
+		if err != nil {
+			return err
+		}
+		next := PageID(binary.BigEndian.Uint32(page[1:]))
+		if err := t.pager.Free(id); err != nil {
+			return err
+		}
+		id = next
+	}
+	return nil
+}
+
+// read decodes a tree page
+func (t *Tree) read(id PageID) (*node, error) {
+	n, page, err := t.pin(id)
+	if err != nil {
+		return nil, err
+	}
+	t.pager.Unpin(page)
+	return n, nil
+}
+
+// pin decodes a tree page, keeping it in the buffer pool until it is
+// unpinned
+func (t *Tree) pin(id PageID) (*node, *Page, error) {
+	page, err := t.pager.Pin(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	n, err := decodeNode(page.Data)
+	if err != nil {
+		t.pager.Unpin(page)
+		return nil, nil, fmt.Errorf("page %d: %v", id, err)
+	}
+	n.id = id
+	return n, page, nil
+}
+
+// write encodes a node into its page
+func (t *Tree) write(n *node) error {
+	return t.pager.Write(n.id, n.encode())
+}
+
+// search returns the position of key in a leaf, or where it would be
+// inserted, and whether it is present
+func (n *node) search(key []byte) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) >= 0
+	})
+	return i, i < len(n.keys) && bytes.Equal(n.keys[i], key)
+}
+
+// childIndex returns the position of the child of an internal node whose
+// keys include key
+func (n *node) childIndex(key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) > 0
+	})
+}
+
+// size returns the encoded size of a node
+func (n *node) size() int {
+	size := nodeHeaderSize
+	if n.leaf {
+		for i, key := range n.keys {
+			size += leafCellSize(key, n.values[i])
+		}
+		return size
+	}
+	size += 4
+	for _, key := range n.keys {
+		size += internalCellSize(key)
+	}
+	return size
+}
+
+// internalCellSize returns the encoded size of an internal cell
+func internalCellSize(key []byte) int {
+	return uvarintSize(len(key)) + len(key) + 4
+}
+
+// splitPoint returns where to split a node with cells of the given sizes so
+// that the larger half is as small as possible. For an internal node, gap is
+// 1 as the cell at the split point moves to the parent; each half keeps at
+// least one cell.
+func splitPoint(sizes []int, gap int) int {
+	total := 0
+	for _, size := range sizes {
+		total += size
+	}
+
+	best, bestSize, left := 1, total, 0
+	for mid := 1; mid+gap < len(sizes); mid++ {
+		left += sizes[mid-1]
+		larger := left
+		if right := total - left - gap*sizes[mid]; right > larger {
+			larger = right
+		}
+		if larger < bestSize {
+			best, bestSize = mid, larger
+		}
+	}
+	return best
+}
+
+// leafCellSize returns the encoded size of a leaf cell
+func leafCellSize(key []byte, c cell) int {
+	size := uvarintSize(len(key)) + len(key) + uvarintSize(c.length) + 1
+	if c.overflow != 0 {
+		return size + 4
+	}
+	return size + len(c.value)
+}
+
+// encode returns the page contents of a node
+func (n *node) encode() []byte {
+	buf := make([]byte, nodeHeaderSize, n.size())
+	buf[0] = pageInternal
+	if n.leaf {
+		buf[0] = pageLeaf
+	}
+	binary.BigEndian.PutUint16(buf[1:], uint16(len(n.keys)))
+
+	if !n.leaf {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(n.children[0]))
+	}
+	for i, key := range n.keys {
+		buf = binary.AppendUvarint(buf, uint64(len(key)))
+		buf = append(buf, key...)
+		if !n.leaf {
+			buf = binary.BigEndian.AppendUint32(buf, uint32(n.children[i+1]))
+			continue
+		}
+		c := n.values[i]
+		buf = binary.AppendUvarint(buf, uint64(c.length))
+		if c.overflow != 0 {
+			buf = append(buf, 1)
+			buf = binary.BigEndian.AppendUint32(buf, uint32(c.overflow))
+		} else {
+			buf = append(buf, 0)
+			buf = append(buf, c.value...)
+		}
+	}
+	return buf
+}
+
+// decodeNode decodes the contents of a tree page
+func decodeNode(page []byte) (*node, error) {
+	n := &node{}
+	switch page[0] {
+	case pageLeaf:
+		n.leaf = true
+	case pageInternal:
+	default:
+		return nil, fmt.Errorf("not a tree page (type %d)", page[0])
+	}
+	count := int(binary.BigEndian.Uint16(page[1:]))
+
+	r := reader{buf: page, pos: nodeHeaderSize}
+	if !n.leaf {
+		n.children = append(n.children, PageID(r.uint32()))
+	}
+	for i := 0; i < count && r.err == nil; i++ {
+		n.keys = append(n.keys, r.bytes(r.uvarint()))
+		if !n.leaf {
+			n.children = append(n.children, PageID(r.uint32()))
+			continue
+		}
+		c := cell{length: r.uvarint()}
+		if r.byte() == 1 {
+			c.overflow = PageID(r.uint32())
+		} else {
+			c.value = r.bytes(c.length)
+		}
+		n.values = append(n.values, c)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return n, nil
+}
+
+// reader decodes the fields of a page, recording the first overrun
+type reader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *reader) fail() {
+	if r.err == nil {
+		r.err = fmt.Errorf("page is corrupt at offset %d", r.pos)
+	}
+	r.pos = len(r.buf)
+}
+
+func (r *reader) byte() byte {
+	if r.pos >= len(r.buf) {
+		r.fail()
+		return 0
+	}
+	r.pos++
+	return r.buf[r.pos-1]
+}
+
+func (r *reader) uint32() uint32 {
+	if r.pos+4 > len(r.buf) {
+		r.fail()
+		return 0
+	}
+	r.pos += 4
+	return binary.BigEndian.Uint32(r.buf[r.pos-4:])
+}
+
+func (r *reader) uvarint() int {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 || v > math.MaxInt32 {
+		r.fail()
+		return 0
+	}
+	r.pos += n
+	return int(v)
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.pos+n > len(r.buf) {
+		r.fail()
+		return nil
+	}
+	r.pos += n
+	return r.buf[r.pos-n : r.pos]
+}
+
+// uvarintSize returns the encoded size of a uvarint
+func uvarintSize(v int) int {
+	size := 1
+	for v >= 0x80 {
+		v >>= 7
+		size++
+	}
+	return size
+}
+
+// insertAt inserts a key at position i
+func insertAt(keys [][]byte, i int, key []byte) [][]byte {
+	keys = append(keys, nil)
+	copy(keys[i+1:], keys[i:])
+	keys[i] = key
+	return keys
+}
@@ -0,0 +1,245 @@
+package pager
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// Write-ahead log
+//
+// Commits do not write pages into the database file directly, where a crash
+// part way through would leave a mix of old and new pages. Instead each
+// commit appends a frame per changed page to the write-ahead log, the
+// database path with "-wal" appended, ending with a frame of the header page
+// marked as the commit, and syncs the log. A checkpoint later copies the
+// latest version of every logged page into the database file, syncs it and
+// empties the log.
+//
+// The log starts with a header holding a random salt. Each frame holds its
+// page number, whether it ends a commit, the salt, and a checksum chained
+// from the previous frame's over the frame and its page. On open, frames are
+// read until one is torn, has a bad checksum or belongs to an older log
+// with a different salt; the pages of complete commits are checkpointed
+// into the database file and any frames after the last commit are discarded.
+
+// WAL layout
+const (
+	walMagic       = "SQLtWAL\x00"
+	walVersion     = 1
+	walHeaderSize  = 24
+	frameHeadSize  = 16
+	frameSize      = frameHeadSize + PageSize
+	frameCommitted = 1
+)
+
+// CheckpointFrames is the number of frames after which a commit also
+// checkpoints the log
+var CheckpointFrames = 1000
+
+// file is the subset of *os.File used by the pager
+type file interface {
+	io.ReaderAt
+	io.WriterAt
+	Sync() error
+	Truncate(size int64) error
+	Stat() (os.FileInfo, error)
+	Close() error
+}
+
+// openFile opens the database and log files, creating them readable only
+// by their owner; tests replace it to inject faults
+var openFile = func(path string, flag int) (file, error) {
+	return os.OpenFile(path, flag, 0600)
+}
+
+// wal is an open write-ahead log
+type wal struct {
+	file     file
+	salt     uint32
+	checksum uint32           // checksum of the last committed frame
+	size     int64            // end of the last committed frame
+	index    map[PageID]int64 // offset of the latest committed frame of each page
+	frames   int
+}
+
+// openWAL opens the log at path, creating it if needed, and finds the
+// frames of its complete commits. A log opened read-only is not created; if
+// there is none it is empty, with no file.
+func openWAL(path string, readOnly bool) (*wal, error) {
+	flag := os.O_RDWR | os.O_CREATE
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	f, err := openFile(path, flag)
+	if readOnly && os.IsNotExist(err) {
+		return &wal{index: make(map[PageID]int64)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	w := &wal{file: f, index: make(map[PageID]int64)}
+	if err := w.recover(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// recover reads the log, keeping the frames up to the last valid commit
+func (w *wal) recover() error {
+	header := make([]byte, walHeaderSize)
+	if _, err := w.file.ReadAt(header, 0); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		return err
+	}
+	if string(header[:8]) != walMagic ||
+		binary.BigEndian.Uint32(header[8:]) != walVersion ||
+		binary.BigEndian.Uint32(header[12:]) != PageSize ||
+		crc32.Checksum(header[:20], castagnoli) != binary.BigEndian.Uint32(header[20:]) {
+		// A log whose header never reached the disk holds no commits
+		return nil
+	}
+	w.salt = binary.BigEndian.Uint32(header[16:])
+	checksum := binary.BigEndian.Uint32(header[20:])
+
+	frame := make([]byte, frameSize)
+	pending := make(map[PageID]int64)
+	for offset := int64(walHeaderSize); ; offset += frameSize {
+		if _, err := w.file.ReadAt(frame, offset); err != nil {
+			break
+		}
+		if binary.BigEndian.Uint32(frame[8:]) != w.salt {
+			break
+		}
+		sum := frameChecksum(checksum, frame)
+		if sum != binary.BigEndian.Uint32(frame[12:]) {
+			break
+		}
+		checksum = sum
+		pending[PageID(binary.BigEndian.Uint32(frame))] = offset + frameHeadSize
+
+		if binary.BigEndian.Uint32(frame[4:]) == frameCommitted {
+			for id, at := range pending {
+				w.index[id] = at
+			}
+			pending = make(map[PageID]int64)
+			w.size, w.checksum = offset+frameSize, checksum
+			w.frames = int((w.size - walHeaderSize) / frameSize)
+		}
+	}
+	return nil
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// frameChecksum chains the checksum of a frame, covering its page number,
+// commit flag, salt and page, from the previous checksum
+func frameChecksum(previous uint32, frame []byte) uint32 {
+	sum := crc32.Update(previous, castagnoli, frame[:12])
+	return crc32.Update(sum, castagnoli, frame[frameHeadSize:])
+}
+
+// append logs the given pages as one commit, the last page ending it, and
+// syncs the log. The pages are only indexed once they are durable.
+func (w *wal) append(ids []PageID, pages [][]byte) error {
+	if w.size == 0 {
+		header := make([]byte, walHeaderSize)
+		copy(header, walMagic)
+		binary.BigEndian.PutUint32(header[8:], walVersion)
+		binary.BigEndian.PutUint32(header[12:], PageSize)
+		w.salt = rand.Uint32()
+		binary.BigEndian.PutUint32(header[16:], w.salt)
+		w.checksum = crc32.Checksum(header[:20], castagnoli)
+		binary.BigEndian.PutUint32(header[20:], w.checksum)
+		if _, err := w.file.WriteAt(header, 0); err != nil {
+			return err
+		}
+		w.size = walHeaderSize
+	}
+
+	buf := make([]byte, 0, len(ids)*frameSize)
+	checksum := w.checksum
+	for i, id := range ids {
+		frame := make([]byte, frameSize)
+		binary.BigEndian.PutUint32(frame, uint32(id))
+		if i == len(ids)-1 {
+			binary.BigEndian.PutUint32(frame[4:], frameCommitted)
+		}
+		binary.BigEndian.PutUint32(frame[8:], w.salt)
+		copy(frame[frameHeadSize:], pages[i])
+		checksum = frameChecksum(checksum, frame)
+		binary.BigEndian.PutUint32(frame[12:], checksum)
+		buf = append(buf, frame...)
+	}
+	if _, err := w.file.WriteAt(buf, w.size); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		w.index[id] = w.size + int64(i)*frameSize + frameHeadSize
+	}
+	w.size += int64(len(buf))
+	w.checksum = checksum
+	w.frames += len(ids)
+	return nil
+}
+
+// read returns the latest committed version of a page in the log, if any
+func (w *wal) read(id PageID) ([]byte, bool, error) {
+	offset, exists := w.index[id]
+	if !exists {
+		return nil, false, nil
+	}
+	buf := make([]byte, PageSize)
+	if _, err := w.file.ReadAt(buf, offset); err != nil {
+		return nil, false, fmt.Errorf("reading page %d from the log: %v", id, err)
+	}
+	return buf, true, nil
+}
+
+// checkpoint copies the logged pages into the database file, syncs it and
+// empties the log. If it fails the log is left intact, so the pages are
+// copied again by the next checkpoint or on open.
+func (w *wal) checkpoint(db file) error {
+	if len(w.index) == 0 {
+		return nil
+	}
+
+	ids := make([]PageID, 0, len(w.index))
+	for id := range w.index {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		page, _, err := w.read(id)
+		if err != nil {
+			return err
+		}
+		if _, err := db.WriteAt(page, int64(id)*PageSize); err != nil {
+			return err
+		}
+	}
+	if err := db.Sync(); err != nil {
+		return err
+	}
+
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.index = make(map[PageID]int64)
+	w.size, w.frames = 0, 0
+	return nil
+}
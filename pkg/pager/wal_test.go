@@ -0,0 +1,253 @@
+package pager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var errCrash = errors.New("simulated crash")
+
+// crashingFile passes IO through to a file until the shared write budget
+// runs out. The write that exhausts it is cut short at that byte, and every
+// later write, sync or truncate fails, as if the process had died.
+type crashingFile struct {
+	*os.File
+	budget *int
+}
+
+func (f *crashingFile) WriteAt(p []byte, off int64) (int, error) {
+	if *f.budget <= 0 {
+		return 0, errCrash
+	}
+	if len(p) > *f.budget {
+		n, _ := f.File.WriteAt(p[:*f.budget], off)
+		*f.budget = 0
+		return n, errCrash
+	}
+	*f.budget -= len(p)
+	return f.File.WriteAt(p, off)
+}
+
+func (f *crashingFile) Sync() error {
+	if *f.budget <= 0 {
+		return errCrash
+	}
+	return f.File.Sync()
+}
+
+func (f *crashingFile) Truncate(size int64) error {
+	if *f.budget <= 0 {
+		return errCrash
+	}
+	return f.File.Truncate(size)
+}
+
+// crashingFiles makes the files opened by the pager share a write budget,
+// which the test sets through the returned pointer
+func crashingFiles(t *testing.T) *int {
+	budget := 1 << 30
+	original := openFile
+	openFile = func(path string, flag int) (file, error) {
+		f, err := os.OpenFile(path, flag, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return &crashingFile{File: f, budget: &budget}, nil
+	}
+	t.Cleanup(func() { openFile = original })
+	return &budget
+}
+
+// crashWorkload applies a fixed sequence of commits to the catalog tree of
+// the page file at path, with a checkpoint after every few commits. It
+// returns how many commits succeeded before the first error.
+func crashWorkload(path string, commits int) (int, error) {
+	p, err := Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer p.close()
+
+	tree := OpenTree(p, p.Catalog())
+	for i := 0; i < commits; i++ {
+		if err := applyCommit(tree, i); err != nil {
+			return i, err
+		}
+		if err := p.Commit(); err != nil {
+			return i, err
+		}
+		if i%4 == 3 {
+			if err := p.Checkpoint(); err != nil {
+				return i + 1, err
+			}
+		}
+	}
+	return commits, nil
+}
+
+// applyCommit makes the changes of the i'th commit of the workload: it adds
+// keys, some with values large enough for overflow pages, and deletes some
+// earlier ones
+func applyCommit(tree *Tree, i int) error {
+	for j := 0; j < 20; j++ {
+		key := binary.BigEndian.AppendUint32(nil, uint32(i*20+j))
+		value := []byte(fmt.Sprintf("value %d", i*20+j))
+		if j == 0 {
+			value = make([]byte, PageSize+100)
+			value[i] = byte(i)
+		}
+		if err := tree.Put(key, value); err != nil {
+			return err
+		}
+	}
+	if i > 0 {
+		if _, err := tree.Delete(binary.BigEndian.AppendUint32(nil, uint32((i-1)*20+5))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// workloadModel returns the expected contents of the tree after n commits
+func workloadModel(t *testing.T, n int) map[string][]byte {
+	t.Helper()
+	p, err := Create(filepath.Join(t.TempDir(), "model.db"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer p.Close()
+
+	tree := OpenTree(p, p.Catalog())
+	for i := 0; i < n; i++ {
+		if err := applyCommit(tree, i); err != nil {
+			t.Fatalf("applyCommit: %v", err)
+		}
+	}
+	model := make(map[string][]byte)
+	if err := tree.Scan(func(key, value []byte) error {
+		model[string(key)] = value
+		return nil
+	}); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	return model
+}
+
+// treeContents returns the contents of a tree
+func treeContents(tree *Tree) (map[string][]byte, error) {
+	contents := make(map[string][]byte)
+	err := tree.Scan(func(key, value []byte) error {
+		contents[string(key)] = value
+		return nil
+	})
+	return contents, err
+}
+
+func TestRecoveryAfterCrashAtEveryOffset(t *testing.T) {
+	const commits = 12
+	models := make([]map[string][]byte, commits+1)
+	for i := range models {
+		models[i] = workloadModel(t, i)
+	}
+
+	// Measure how many bytes the workload writes, then crash it at offsets
+	// spread over the whole run
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	p, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	p.Close()
+	budget := crashingFiles(t)
+	if n, err := crashWorkload(path, commits); err != nil || n != commits {
+		t.Fatalf("Workload without a crash: %d commits, %v", n, err)
+	}
+	total := 1<<30 - *budget
+
+	for offset := 0; offset < total; offset += 1237 {
+		path := filepath.Join(dir, fmt.Sprintf("crash%d.db", offset))
+		p, err := Create(path)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		p.Close()
+
+		*budget = offset
+		acknowledged, err := crashWorkload(path, commits)
+		if err == nil {
+			t.Fatalf("Offset %d: expected the workload to crash", offset)
+		}
+
+		*budget = 1 << 30
+		p, err = Open(path)
+		if err != nil {
+			t.Fatalf("Offset %d: recovery failed: %v", offset, err)
+		}
+		contents, err := treeContents(OpenTree(p, p.Catalog()))
+		p.Close()
+		if err != nil {
+			t.Fatalf("Offset %d: reading recovered tree: %v", offset, err)
+		}
+
+		// The commit in progress may have reached the log before the crash
+		matched := fmt.Sprint(contents) == fmt.Sprint(models[acknowledged])
+		if !matched && acknowledged < commits {
+			matched = fmt.Sprint(contents) == fmt.Sprint(models[acknowledged+1])
+		}
+		if !matched {
+			t.Fatalf("Offset %d: recovered %d keys, which is neither commit %d nor the next", offset, len(contents), acknowledged)
+		}
+	}
+}
+
+func TestRecoveryDiscardsTornCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	p, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	tree := OpenTree(p, p.Catalog())
+	if err := tree.Put([]byte("committed"), []byte("1")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := p.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if err := tree.Put([]byte("torn"), []byte("2")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := p.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	p.close()
+
+	// Cut the second commit's last frame short
+	info, err := os.Stat(path + "-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path+"-wal", info.Size()-10); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer p.Close()
+	contents, err := treeContents(OpenTree(p, p.Catalog()))
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(contents) != 1 || string(contents["committed"]) != "1" {
+		t.Errorf("Recovered %v, expected only the first commit", contents)
+	}
+	if info, err := os.Stat(path + "-wal"); err != nil || info.Size() != 0 {
+		t.Errorf("Expected recovery to empty the log")
+	}
+}
@@ -0,0 +1,282 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TokenType identifies the kind of a lexical token
+type TokenType int
+
+const (
+	TokenEOF TokenType = iota
+	TokenIdent
+	TokenKeyword
+	TokenString
+	TokenNumber
+	TokenOperator
+	TokenPunct
+)
+
+// String returns a human readable name for the token type
+func (t TokenType) String() string {
+	switch t {
+	case TokenEOF:
+		return "end of input"
+	case TokenIdent:
+		return "identifier"
+	case TokenKeyword:
+		return "keyword"
+	case TokenString:
+		return "string"
+	case TokenNumber:
+		return "number"
+	case TokenOperator:
+		return "operator"
+	case TokenPunct:
+		return "punctuation"
+	default:
+		return "unknown"
+	}
+}
+
+// Token is a single lexical token produced by the Lexer
+type Token struct {
+	Type  TokenType
+	Value string // keywords are upper-cased, strings are unquoted
+	Text  string // keyword as written in the input
+	Quote byte   // quote character for TokenString, 0 otherwise
+	Pos   int    // byte offset of the token in the input
+}
+
+// keywords lists the reserved words recognised by the lexer
+var keywords = map[string]bool{
+	"CREATE": true, "TABLE": true, "INSERT": true, "INTO": true, "VALUES": true,
+	"SELECT": true, "FROM": true, "WHERE": true, "DROP": true, "DESCRIBE": true,
+	"DELETE": true, "BEGIN": true, "TRANSACTION": true, "COMMIT": true,
+	"ROLLBACK": true, "PRIMARY": true, "KEY": true, "NOT": true, "NULL": true,
+	"UNIQUE": true, "AND": true, "OR": true, "TRUE": true, "FALSE": true,
+	"UPDATE": true, "SET": true, "ORDER": true, "BY": true, "ASC": true,
+	"DESC": true, "NULLS": true, "FIRST": true, "LAST": true, "LIMIT": true,
+	"OFFSET": true, "GROUP": true, "HAVING": true, "AS": true, "DISTINCT": true,
+	"JOIN": true, "INNER": true, "LEFT": true, "OUTER": true, "CROSS": true,
+	"ON": true, "IN": true, "EXISTS": true, "ALTER": true, "ADD": true,
+	"COLUMN": true, "RENAME": true, "TO": true, "DEFAULT": true, "INDEX": true,
+	"BETWEEN": true, "PRAGMA": true, "IS": true,
+}
+
+// Lexer splits a SQL string into tokens
+type Lexer struct {
+	input string
+	pos   int
+}
+
+// NewLexer creates a lexer for the given input
+func NewLexer(input string) *Lexer {
+	return &Lexer{input: input}
+}
+
+// Tokenize returns all tokens in the input, terminated by a TokenEOF token
+func Tokenize(input string) ([]Token, error) {
+	lexer := NewLexer(input)
+	var tokens []Token
+	for {
+		tok, err := lexer.Next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+		if tok.Type == TokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+// SplitStatements splits a script into the text of the statements in it,
+// which are separated by semicolons. Semicolons in strings, quoted
+// identifiers and comments do not end a statement, and comments between
+// statements are dropped.
+func SplitStatements(input string) ([]string, error) {
+	tokens, err := Tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	var statements []string
+	start := -1
+	for _, tok := range tokens {
+		if tok.Type == TokenEOF || (tok.Type == TokenPunct && tok.Value == ";") {
+			if start >= 0 {
+				statements = append(statements, strings.TrimSpace(input[start:tok.Pos]))
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = tok.Pos
+		}
+	}
+	return statements, nil
+}
+
+// Next returns the next token in the input
+func (l *Lexer) Next() (Token, error) {
+	if err := l.skipWhitespaceAndComments(); err != nil {
+		return Token{}, err
+	}
+
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return Token{Type: TokenEOF, Pos: start}, nil
+	}
+
+	ch := l.input[l.pos]
+	switch {
+	case isIdentStart(ch):
+		for l.pos < len(l.input) && isIdentPart(l.input[l.pos]) {
+			l.pos++
+		}
+		word := l.input[start:l.pos]
+		if upper := strings.ToUpper(word); keywords[upper] {
+			return Token{Type: TokenKeyword, Value: upper, Text: word, Pos: start}, nil
+		}
+		return Token{Type: TokenIdent, Value: word, Pos: start}, nil
+
+	case isDigit(ch) || (ch == '.' && l.pos+1 < len(l.input) && isDigit(l.input[l.pos+1])):
+		return l.lexNumber()
+
+	case ch == '\'' || ch == '"':
+		return l.lexString(ch)
+
+	case ch == '`':
+		// Backtick-quoted identifiers
+		end := strings.IndexByte(l.input[l.pos+1:], '`')
+		if end < 0 {
+			return Token{}, fmt.Errorf("unterminated quoted identifier at position %d", start)
+		}
+		l.pos += end + 2
+		return Token{Type: TokenIdent, Value: l.input[start+1 : l.pos-1], Pos: start}, nil
+	}
+
+	// Two-character operators
+	if l.pos+1 < len(l.input) {
+		switch op := l.input[l.pos : l.pos+2]; op {
+		case "<=", ">=", "!=", "<>", "||":
+			l.pos += 2
+			if op == "<>" {
+				op = "!="
+			}
+			return Token{Type: TokenOperator, Value: op, Pos: start}, nil
+		}
+	}
+
+	switch ch {
+	case '=', '<', '>', '+', '-', '*', '/', '%':
+		l.pos++
+		return Token{Type: TokenOperator, Value: string(ch), Pos: start}, nil
+	case '(', ')', ',', ';', '.':
+		l.pos++
+		return Token{Type: TokenPunct, Value: string(ch), Pos: start}, nil
+	}
+
+	return Token{}, fmt.Errorf("unexpected character %q at position %d", ch, start)
+}
+
+// skipWhitespaceAndComments advances past whitespace, -- and /* */ comments
+func (l *Lexer) skipWhitespaceAndComments() error {
+	for l.pos < len(l.input) {
+		ch := l.input[l.pos]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			l.pos++
+		case strings.HasPrefix(l.input[l.pos:], "--"):
+			end := strings.IndexByte(l.input[l.pos:], '\n')
+			if end < 0 {
+				l.pos = len(l.input)
+			} else {
+				l.pos += end + 1
+			}
+		case strings.HasPrefix(l.input[l.pos:], "/*"):
+			end := strings.Index(l.input[l.pos+2:], "*/")
+			if end < 0 {
+				return fmt.Errorf("unterminated comment at position %d", l.pos)
+			}
+			l.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// lexNumber reads an integer or decimal literal
+func (l *Lexer) lexNumber() (Token, error) {
+	start := l.pos
+	seenDot := false
+	for l.pos < len(l.input) {
+		ch := l.input[l.pos]
+		if isDigit(ch) {
+			l.pos++
+		} else if ch == '.' && !seenDot {
+			seenDot = true
+			l.pos++
+		} else {
+			break
+		}
+	}
+
+	// Optional exponent
+	if l.pos < len(l.input) && (l.input[l.pos] == 'e' || l.input[l.pos] == 'E') {
+		next := l.pos + 1
+		if next < len(l.input) && (l.input[next] == '+' || l.input[next] == '-') {
+			next++
+		}
+		if next < len(l.input) && isDigit(l.input[next]) {
+			l.pos = next
+			for l.pos < len(l.input) && isDigit(l.input[l.pos]) {
+				l.pos++
+			}
+		}
+	}
+
+	if l.pos < len(l.input) && isIdentStart(l.input[l.pos]) {
+		return Token{}, fmt.Errorf("invalid number %q at position %d", l.input[start:l.pos+1], start)
+	}
+
+	return Token{Type: TokenNumber, Value: l.input[start:l.pos], Pos: start}, nil
+}
+
+// lexString reads a quoted string; a doubled quote character is an escaped quote
+func (l *Lexer) lexString(quote byte) (Token, error) {
+	start := l.pos
+	l.pos++
+
+	var sb strings.Builder
+	for l.pos < len(l.input) {
+		ch := l.input[l.pos]
+		if ch == quote {
+			if l.pos+1 < len(l.input) && l.input[l.pos+1] == quote {
+				sb.WriteByte(quote)
+				l.pos += 2
+				continue
+			}
+			l.pos++
+			return Token{Type: TokenString, Value: sb.String(), Quote: quote, Pos: start}, nil
+		}
+		sb.WriteByte(ch)
+		l.pos++
+	}
+
+	return Token{}, fmt.Errorf("unterminated string at position %d", start)
+}
+
+func isIdentStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isIdentPart(ch byte) bool {
+	return isIdentStart(ch) || isDigit(ch)
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
@@ -1,382 +1,1269 @@
 package sql
 
 import (
-    "fmt"
-    "regexp"
-    "strconv"
-    "strings"
-    "sqlight/pkg/interfaces"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sqlight/pkg/interfaces"
 )
 
 // Parse parses a SQL statement and returns the corresponding Statement interface
 func Parse(sql string) (interfaces.Statement, error) {
-    // Trim whitespace and remove comments
-    sql = removeComments(strings.TrimSpace(sql))
-    if sql == "" {
-        return nil, nil
-    }
-
-    // Convert to uppercase for command matching
-    upperSQL := strings.ToUpper(sql)
-
-    if strings.HasPrefix(upperSQL, "CREATE TABLE") {
-        return parseCreateTable(sql)
-    } else if strings.HasPrefix(upperSQL, "INSERT INTO") {
-        return parseInsert(sql)
-    } else if strings.HasPrefix(upperSQL, "SELECT") {
-        return parseSelect(sql)
-    } else if strings.HasPrefix(upperSQL, "DROP TABLE") {
-        return parseDrop(sql)
-    } else if strings.HasPrefix(upperSQL, "DESCRIBE") {
-        return parseDescribe(sql)
-    } else if strings.HasPrefix(upperSQL, "DELETE FROM") {
-        return parseDelete(sql)
-    } else if strings.HasPrefix(upperSQL, "BEGIN TRANSACTION") || strings.HasPrefix(upperSQL, "BEGIN") {
-        return &interfaces.BeginTransactionStatement{}, nil
-    } else if strings.HasPrefix(upperSQL, "COMMIT") {
-        return &interfaces.CommitStatement{}, nil
-    } else if strings.HasPrefix(upperSQL, "ROLLBACK") {
-        return &interfaces.RollbackStatement{}, nil
-    }
-
-    return nil, fmt.Errorf("unsupported SQL statement")
-}
-
-// removeComments removes SQL comments from the input
-func removeComments(sql string) string {
-    lines := strings.Split(sql, "\n")
-    var result []string
-    for _, line := range lines {
-        // Remove inline comments
-        if idx := strings.Index(line, "--"); idx >= 0 {
-            line = strings.TrimSpace(line[:idx])
-        }
-        if line != "" {
-            result = append(result, line)
-        }
-    }
-    return strings.Join(result, "\n")
-}
-
-func parseCreateTable(sql string) (*interfaces.CreateStatement, error) {
-    // Replace newlines with spaces to handle multi-line statements
-    sql = strings.ReplaceAll(sql, "\n", " ")
-    
-    re := regexp.MustCompile(`(?i)CREATE\s+TABLE\s+(\w+)\s*\((.*)\)`)
-    matches := re.FindStringSubmatch(sql)
-    if len(matches) != 3 {
-        return nil, fmt.Errorf("invalid CREATE TABLE syntax")
-    }
-
-    tableName := matches[1]
-    columnDefs := strings.Split(matches[2], ",")
-    columns := make([]interfaces.Column, 0)
-
-    for _, colDef := range columnDefs {
-        colDef = strings.TrimSpace(colDef)
-        parts := strings.Fields(colDef)
-        if len(parts) < 2 {
-            return nil, fmt.Errorf("invalid column definition: %s", colDef)
-        }
-
-        col := interfaces.Column{
-            Name:     parts[0],
-            Type:     strings.ToUpper(parts[1]),
-            Nullable: true,
-        }
-
-        // Parse constraints
-        for i := 2; i < len(parts); i++ {
-            constraint := strings.ToUpper(parts[i])
-            switch constraint {
-            case "PRIMARY":
-                if i+1 < len(parts) && strings.ToUpper(parts[i+1]) == "KEY" {
-                    col.PrimaryKey = true
-                    i++
-                }
-            case "NOT":
-                if i+1 < len(parts) && strings.ToUpper(parts[i+1]) == "NULL" {
-                    col.Nullable = false
-                    i++
-                }
-            case "UNIQUE":
-                col.Unique = true
-            }
-        }
-
-        columns = append(columns, col)
-    }
-
-    return &interfaces.CreateStatement{
-        TableName: tableName,
-        Columns:   columns,
-    }, nil
-}
-
-func parseInsert(sql string) (*interfaces.InsertStatement, error) {
-    re := regexp.MustCompile(`(?i)INSERT\s+INTO\s+(\w+)\s*\((.*?)\)\s*VALUES\s*\((.*?)\)`)
-    matches := re.FindStringSubmatch(sql)
-    if len(matches) != 4 {
-        return nil, fmt.Errorf("invalid INSERT syntax")
-    }
-
-    tableName := matches[1]
-    columnStr := matches[2]
-    valueStr := matches[3]
-
-    columns := make([]string, 0)
-    for _, col := range strings.Split(columnStr, ",") {
-        columns = append(columns, strings.TrimSpace(col))
-    }
-
-    values := make([]interface{}, 0)
-    for _, val := range strings.Split(valueStr, ",") {
-        val = strings.TrimSpace(val)
-        
-        // Handle string values
-        if strings.HasPrefix(val, "'") && strings.HasSuffix(val, "'") {
-            values = append(values, strings.Trim(val, "'"))
-            continue
-        }
-        
-        // Handle numeric values
-        if num, err := strconv.Atoi(val); err == nil {
-            values = append(values, num)
-            continue
-        }
-        
-        // Default to string value
-        values = append(values, val)
-    }
-
-    return &interfaces.InsertStatement{
-        TableName: tableName,
-        Columns:   columns,
-        Values:    values,
-    }, nil
-}
-
-func parseSelect(sql string) (*interfaces.SelectStatement, error) {
-    // Remove trailing semicolon if present
-    sql = strings.TrimSuffix(sql, ";")
-    
-    // Parse table name and columns
-    re := regexp.MustCompile(`(?i)SELECT\s+(.*?)\s+FROM\s+(\w+)(?:\s+WHERE\s+(.*))?`)
-    matches := re.FindStringSubmatch(sql)
-    if len(matches) < 3 {
-        return nil, fmt.Errorf("invalid SELECT statement syntax")
-    }
-
-    // Parse columns
-    columns := make([]string, 0)
-    for _, col := range strings.Split(matches[1], ",") {
-        columns = append(columns, strings.TrimSpace(col))
-    }
-
-    // Parse WHERE conditions
-    where := make(map[string]interface{})
-    if len(matches) > 3 && matches[3] != "" {
-        wherePart := strings.TrimSpace(matches[3])
-        
-        // Split conditions by AND if present
-        whereConditions := strings.Split(wherePart, " AND ")
-        for _, condition := range whereConditions {
-            condition = strings.TrimSpace(condition)
-            
-            // Check for different comparison operators: =, >, <, >=, <=, !=
-            var operator string
-            var parts []string
-            
-            if strings.Contains(condition, ">=") {
-                parts = strings.Split(condition, ">=")
-                operator = ">="
-            } else if strings.Contains(condition, "<=") {
-                parts = strings.Split(condition, "<=")
-                operator = "<="
-            } else if strings.Contains(condition, "!=") {
-                parts = strings.Split(condition, "!=")
-                operator = "!="
-            } else if strings.Contains(condition, ">") {
-                parts = strings.Split(condition, ">")
-                operator = ">"
-            } else if strings.Contains(condition, "<") {
-                parts = strings.Split(condition, "<")
-                operator = "<"
-            } else if strings.Contains(condition, "=") {
-                parts = strings.Split(condition, "=")
-                operator = "="
-            } else {
-                return nil, fmt.Errorf("invalid WHERE condition: %s", condition)
-            }
-            
-            if len(parts) != 2 {
-                return nil, fmt.Errorf("invalid WHERE condition: %s", condition)
-            }
-
-            col := strings.TrimSpace(parts[0])
-            val := strings.TrimSpace(parts[1])
-            
-            // Handle quoted string values (both single and double quotes)
-            if (strings.HasPrefix(val, "'") && strings.HasSuffix(val, "'")) ||
-               (strings.HasPrefix(val, "\"") && strings.HasSuffix(val, "\"")) {
-                where[col] = map[string]interface{}{
-                    "operator": operator,
-                    "value": strings.Trim(val, "'\""),
-                }
-                continue
-            }
-            
-            // Handle numeric values
-            if num, err := strconv.Atoi(val); err == nil {
-                where[col] = map[string]interface{}{
-                    "operator": operator,
-                    "value": num,
-                }
-                continue
-            }
-            
-            // Try to parse as float if not an integer
-            if num, err := strconv.ParseFloat(val, 64); err == nil {
-                where[col] = map[string]interface{}{
-                    "operator": operator,
-                    "value": num,
-                }
-                continue
-            }
-            
-            // Default to string value without quotes
-            where[col] = map[string]interface{}{
-                "operator": operator,
-                "value": val,
-            }
-        }
-    }
-
-    return &interfaces.SelectStatement{
-        TableName: matches[2],
-        Columns:   columns,
-        Where:     where,
-    }, nil
-}
-
-func parseDrop(sql string) (*interfaces.DropStatement, error) {
-    re := regexp.MustCompile(`(?i)DROP\s+TABLE\s+(\w+)`)
-    matches := re.FindStringSubmatch(sql)
-    if len(matches) != 2 {
-        return nil, fmt.Errorf("invalid DROP TABLE syntax")
-    }
-
-    return &interfaces.DropStatement{
-        TableName: matches[1],
-    }, nil
-}
-
-func parseDescribe(sql string) (*interfaces.DescribeStatement, error) {
-    re := regexp.MustCompile(`(?i)DESCRIBE\s+(\w+)`)
-    matches := re.FindStringSubmatch(sql)
-    if len(matches) != 2 {
-        return nil, fmt.Errorf("invalid DESCRIBE syntax")
-    }
-
-    return &interfaces.DescribeStatement{
-        TableName: matches[1],
-    }, nil
-}
-
-func parseDelete(sql string) (*interfaces.DeleteStatement, error) {
-    // Remove trailing semicolon if present
-    sql = strings.TrimSuffix(sql, ";")
-    
-    // Parse table name
-    re := regexp.MustCompile(`(?i)DELETE\s+FROM\s+(\w+)(?:\s+WHERE\s+(.*))?`)
-    matches := re.FindStringSubmatch(sql)
-    if len(matches) < 2 {
-        return nil, fmt.Errorf("invalid DELETE statement syntax")
-    }
-
-    tableName := matches[1]
-    conditions := make(map[string]interface{})
-
-    // Parse WHERE conditions if present
-    if len(matches) > 2 && matches[2] != "" {
-        wherePart := strings.TrimSpace(matches[2])
-        
-        // Split conditions by AND if present
-        whereConditions := strings.Split(wherePart, " AND ")
-        for _, condition := range whereConditions {
-            condition = strings.TrimSpace(condition)
-            
-            // Check for different comparison operators: =, >, <, >=, <=, !=
-            var operator string
-            var parts []string
-            
-            if strings.Contains(condition, ">=") {
-                parts = strings.Split(condition, ">=")
-                operator = ">="
-            } else if strings.Contains(condition, "<=") {
-                parts = strings.Split(condition, "<=")
-                operator = "<="
-            } else if strings.Contains(condition, "!=") {
-                parts = strings.Split(condition, "!=")
-                operator = "!="
-            } else if strings.Contains(condition, ">") {
-                parts = strings.Split(condition, ">")
-                operator = ">"
-            } else if strings.Contains(condition, "<") {
-                parts = strings.Split(condition, "<")
-                operator = "<"
-            } else if strings.Contains(condition, "=") {
-                parts = strings.Split(condition, "=")
-                operator = "="
-            } else {
-                return nil, fmt.Errorf("invalid WHERE condition: %s", condition)
-            }
-            
-            if len(parts) != 2 {
-                return nil, fmt.Errorf("invalid WHERE condition: %s", condition)
-            }
-
-            column := strings.TrimSpace(parts[0])
-            value := strings.TrimSpace(parts[1])
-
-            // Handle quoted string values (both single and double quotes)
-            if (strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'")) ||
-               (strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"")) {
-                conditions[column] = map[string]interface{}{
-                    "operator": operator,
-                    "value": strings.Trim(value, "'\""),
-                }
-                continue
-            }
-            
-            // Handle numeric values
-            if num, err := strconv.Atoi(value); err == nil {
-                conditions[column] = map[string]interface{}{
-                    "operator": operator,
-                    "value": num,
-                }
-                continue
-            }
-            
-            // Try to parse as float if not an integer
-            if num, err := strconv.ParseFloat(value, 64); err == nil {
-                conditions[column] = map[string]interface{}{
-                    "operator": operator,
-                    "value": num,
-                }
-                continue
-            }
-            
-            // Default to string value without quotes
-            conditions[column] = map[string]interface{}{
-                "operator": operator,
-                "value": value,
-            }
-        }
-    }
-
-    return &interfaces.DeleteStatement{
-        TableName: tableName,
-        Where:     conditions,
-    }, nil
+	tokens, err := Tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Parser{tokens: tokens, input: sql}
+
+	// Skip leading semicolons; an input made only of comments is not an error
+	for p.isPunct(";") {
+		p.next()
+	}
+	if p.peek().Type == TokenEOF {
+		return nil, nil
+	}
+
+	stmt, err := p.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+
+	// Allow trailing semicolons, but nothing else after the statement
+	for p.isPunct(";") {
+		p.next()
+	}
+	if tok := p.peek(); tok.Type != TokenEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", describeToken(tok), tok.Pos)
+	}
+
+	return stmt, nil
+}
+
+// Parser is a recursive-descent parser over a token stream
+type Parser struct {
+	tokens []Token
+	pos    int
+	input  string
+}
+
+// parseStatement dispatches on the leading keyword of the statement
+func (p *Parser) parseStatement() (interfaces.Statement, error) {
+	tok := p.peek()
+	if tok.Type != TokenKeyword {
+		return nil, fmt.Errorf("unsupported SQL statement")
+	}
+
+	switch tok.Value {
+	case "CREATE":
+		if next := p.peekAt(1); next.Type == TokenKeyword && (next.Value == "INDEX" || next.Value == "UNIQUE") {
+			return p.parseCreateIndex()
+		}
+		return p.parseCreateTable()
+	case "INSERT":
+		return p.parseInsert()
+	case "SELECT":
+		return p.parseSelect()
+	case "DROP":
+		if next := p.peekAt(1); next.Type == TokenKeyword && next.Value == "INDEX" {
+			return p.parseDropIndex()
+		}
+		return p.parseDrop()
+	case "ALTER":
+		return p.parseAlterTable()
+	case "DESCRIBE":
+		return p.parseDescribe()
+	case "DELETE":
+		return p.parseDelete()
+	case "UPDATE":
+		return p.parseUpdate()
+	case "BEGIN":
+		p.next()
+		p.acceptKeyword("TRANSACTION")
+		return &interfaces.BeginTransactionStatement{}, nil
+	case "COMMIT":
+		p.next()
+		p.acceptKeyword("TRANSACTION")
+		return &interfaces.CommitStatement{}, nil
+	case "ROLLBACK":
+		p.next()
+		p.acceptKeyword("TRANSACTION")
+		return &interfaces.RollbackStatement{}, nil
+	case "PRAGMA":
+		return p.parsePragma()
+	}
+
+	return nil, fmt.Errorf("unsupported SQL statement")
+}
+
+// parseCreateTable parses CREATE TABLE name (column definitions)
+func (p *Parser) parseCreateTable() (*interfaces.CreateStatement, error) {
+	if err := p.expectKeywords("CREATE", "TABLE"); err != nil {
+		return nil, err
+	}
+
+	tableName, err := p.parseIdent("table name")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+
+	columns := make([]interfaces.Column, 0)
+	for {
+		// Table-level PRIMARY KEY (col) constraint
+		if p.isKeyword("PRIMARY") {
+			p.next()
+			if err := p.expectKeyword("KEY"); err != nil {
+				return nil, err
+			}
+			names, err := p.parseIdentList()
+			if err != nil {
+				return nil, err
+			}
+			if len(names) != 1 {
+				return nil, fmt.Errorf("composite PRIMARY KEY is not supported")
+			}
+			found := false
+			for i := range columns {
+				if strings.EqualFold(columns[i].Name, names[0]) {
+					columns[i].PrimaryKey = true
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("column %s does not exist", names[0])
+			}
+		} else {
+			col, err := p.parseColumnDef()
+			if err != nil {
+				return nil, err
+			}
+			columns = append(columns, col)
+		}
+
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+
+	return &interfaces.CreateStatement{
+		TableName: tableName,
+		Columns:   columns,
+	}, nil
+}
+
+// parseCreateIndex parses CREATE [UNIQUE] INDEX name ON table (columns)
+func (p *Parser) parseCreateIndex() (*interfaces.CreateIndexStatement, error) {
+	if err := p.expectKeyword("CREATE"); err != nil {
+		return nil, err
+	}
+	unique := p.acceptKeyword("UNIQUE")
+	if err := p.expectKeyword("INDEX"); err != nil {
+		return nil, err
+	}
+
+	indexName, err := p.parseIdent("index name")
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("ON"); err != nil {
+		return nil, err
+	}
+	tableName, err := p.parseIdent("table name")
+	if err != nil {
+		return nil, err
+	}
+	columns, err := p.parseIdentList()
+	if err != nil {
+		return nil, err
+	}
+
+	return &interfaces.CreateIndexStatement{
+		IndexName: indexName,
+		TableName: tableName,
+		Columns:   columns,
+		Unique:    unique,
+	}, nil
+}
+
+// parseColumnDef parses a column definition: name type [constraints]
+func (p *Parser) parseColumnDef() (interfaces.Column, error) {
+	name, err := p.parseIdent("column name")
+	if err != nil {
+		return interfaces.Column{}, err
+	}
+
+	typeName, err := p.parseIdent("column type")
+	if err != nil {
+		return interfaces.Column{}, err
+	}
+
+	// Ignore size arguments such as VARCHAR(255) or DECIMAL(10, 2)
+	if p.isPunct("(") {
+		p.next()
+		for !p.isPunct(")") {
+			if p.peek().Type != TokenNumber && !p.isPunct(",") {
+				return interfaces.Column{}, p.errorf("type size")
+			}
+			p.next()
+		}
+		p.next()
+	}
+
+	col := interfaces.Column{
+		Name:     name,
+		Type:     strings.ToUpper(typeName),
+		Nullable: true,
+	}
+
+	// Parse constraints
+	for {
+		switch {
+		case p.isKeyword("PRIMARY"):
+			p.next()
+			if err := p.expectKeyword("KEY"); err != nil {
+				return interfaces.Column{}, err
+			}
+			col.PrimaryKey = true
+		case p.isKeyword("NOT"):
+			p.next()
+			if err := p.expectKeyword("NULL"); err != nil {
+				return interfaces.Column{}, err
+			}
+			col.Nullable = false
+		case p.isKeyword("NULL"):
+			p.next()
+		case p.isKeyword("UNIQUE"):
+			p.next()
+			col.Unique = true
+		case p.isKeyword("DEFAULT"):
+			p.next()
+			expr, err := p.parseUnary()
+			if err != nil {
+				return interfaces.Column{}, err
+			}
+			if col.Default, err = literalValue(expr); err != nil {
+				return interfaces.Column{}, err
+			}
+		default:
+			return col, nil
+		}
+	}
+}
+
+// parseInsert parses INSERT INTO name [(columns)] VALUES (values), ...
+// or INSERT INTO name [(columns)] SELECT ...
+func (p *Parser) parseInsert() (*interfaces.InsertStatement, error) {
+	if err := p.expectKeywords("INSERT", "INTO"); err != nil {
+		return nil, err
+	}
+
+	tableName, err := p.parseIdent("table name")
+	if err != nil {
+		return nil, err
+	}
+	stmt := &interfaces.InsertStatement{TableName: tableName}
+
+	if p.isPunct("(") {
+		if stmt.Columns, err = p.parseIdentList(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.isKeyword("SELECT") {
+		if stmt.Select, err = p.parseSelect(); err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	}
+
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	for {
+		values, err := p.parseValues()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Rows = append(stmt.Rows, values)
+
+		if !p.isPunct(",") {
+			return stmt, nil
+		}
+		p.next()
+	}
+}
+
+// parseValues parses one parenthesised tuple of literal values
+func (p *Parser) parseValues() ([]interface{}, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, 0)
+	for {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		value, err := literalValue(expr)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// parseSelect parses SELECT columns FROM name [WHERE condition]
+func (p *Parser) parseSelect() (*interfaces.SelectStatement, error) {
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+
+	columns, err := p.parseSelectColumns()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+
+	stmt := &interfaces.SelectStatement{Columns: columns}
+	if stmt.TableName, stmt.Alias, err = p.parseTableRef(); err != nil {
+		return nil, err
+	}
+	if stmt.Joins, err = p.parseJoins(); err != nil {
+		return nil, err
+	}
+
+	if stmt.Where, err = p.parseWhere(); err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			stmt.GroupBy = append(stmt.GroupBy, expr)
+			if !p.isPunct(",") {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if p.acceptKeyword("HAVING") {
+		if stmt.Having, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		if stmt.OrderBy, err = p.parseOrderBy(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		limit, err := p.parseInteger("LIMIT")
+		if err != nil {
+			return nil, err
+		}
+		stmt.Limit = &limit
+	}
+
+	if p.acceptKeyword("OFFSET") {
+		if stmt.Offset, err = p.parseInteger("OFFSET"); err != nil {
+			return nil, err
+		}
+	}
+
+	return stmt, nil
+}
+
+// parseTableRef parses a table name with an optional [AS] alias
+func (p *Parser) parseTableRef() (string, string, error) {
+	name, err := p.parseIdent("table name")
+	if err != nil {
+		return "", "", err
+	}
+
+	alias := ""
+	if p.acceptKeyword("AS") {
+		if alias, err = p.parseIdent("table alias"); err != nil {
+			return "", "", err
+		}
+	} else if p.peek().Type == TokenIdent {
+		alias, _ = p.parseIdent("table alias")
+	}
+	return name, alias, nil
+}
+
+// parseJoins parses any number of JOIN clauses and comma-separated tables
+func (p *Parser) parseJoins() ([]interfaces.JoinClause, error) {
+	var joins []interfaces.JoinClause
+	for {
+		join := interfaces.JoinClause{}
+		switch {
+		case p.isPunct(","):
+			p.next()
+			join.Type = "CROSS"
+		case p.acceptKeyword("CROSS"):
+			join.Type = "CROSS"
+			if err := p.expectKeyword("JOIN"); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("LEFT"):
+			join.Type = "LEFT"
+			p.acceptKeyword("OUTER")
+			if err := p.expectKeyword("JOIN"); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("INNER"):
+			join.Type = "INNER"
+			if err := p.expectKeyword("JOIN"); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("JOIN"):
+			join.Type = "INNER"
+		default:
+			return joins, nil
+		}
+
+		var err error
+		if join.TableName, join.Alias, err = p.parseTableRef(); err != nil {
+			return nil, err
+		}
+
+		if join.Type != "CROSS" {
+			if err := p.expectKeyword("ON"); err != nil {
+				return nil, err
+			}
+			if join.On, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		}
+		joins = append(joins, join)
+	}
+}
+
+// parseSelectColumns parses the SELECT list: * or expr [[AS] alias], ...
+func (p *Parser) parseSelectColumns() ([]interfaces.SelectColumn, error) {
+	columns := make([]interfaces.SelectColumn, 0)
+	for {
+		if p.isOperator("*") {
+			p.next()
+			columns = append(columns, interfaces.SelectColumn{Expr: &interfaces.StarExpr{}})
+		} else if tok := p.peek(); tok.Type == TokenIdent && p.peekAt(1).Type == TokenPunct && p.peekAt(1).Value == "." &&
+			p.peekAt(2).Type == TokenOperator && p.peekAt(2).Value == "*" {
+			// table.*
+			p.pos += 3
+			columns = append(columns, interfaces.SelectColumn{Expr: &interfaces.StarExpr{Table: tok.Value}})
+		} else {
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			col := interfaces.SelectColumn{Expr: expr}
+
+			if p.acceptKeyword("AS") {
+				if col.Alias, err = p.parseIdent("column alias"); err != nil {
+					return nil, err
+				}
+			} else if tok := p.peek(); tok.Type == TokenIdent || (tok.Type == TokenString && tok.Quote == '"') {
+				col.Alias, _ = p.parseIdent("column alias")
+			}
+			columns = append(columns, col)
+		}
+
+		if !p.isPunct(",") {
+			return columns, nil
+		}
+		p.next()
+	}
+}
+
+// parseOrderBy parses expr [ASC|DESC] [NULLS FIRST|LAST], ...
+func (p *Parser) parseOrderBy() ([]interfaces.OrderByItem, error) {
+	items := make([]interfaces.OrderByItem, 0)
+	for {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		item := interfaces.OrderByItem{Expr: expr}
+
+		if p.acceptKeyword("DESC") {
+			item.Desc = true
+		} else {
+			p.acceptKeyword("ASC")
+		}
+
+		if p.acceptKeyword("NULLS") {
+			switch {
+			case p.acceptKeyword("FIRST"):
+				item.NullsOrder = "FIRST"
+			case p.acceptKeyword("LAST"):
+				item.NullsOrder = "LAST"
+			default:
+				return nil, p.errorf("FIRST or LAST")
+			}
+		}
+
+		items = append(items, item)
+		if !p.isPunct(",") {
+			return items, nil
+		}
+		p.next()
+	}
+}
+
+// parseInteger parses a non-negative integer literal for the named clause
+func (p *Parser) parseInteger(clause string) (int, error) {
+	tok := p.peek()
+	if tok.Type != TokenNumber {
+		return 0, p.errorf(fmt.Sprintf("integer after %s", clause))
+	}
+	value, err := strconv.Atoi(tok.Value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value %s", clause, tok.Value)
+	}
+	p.next()
+	return value, nil
+}
+
+// parseDrop parses DROP TABLE name
+func (p *Parser) parseDrop() (*interfaces.DropStatement, error) {
+	if err := p.expectKeywords("DROP", "TABLE"); err != nil {
+		return nil, err
+	}
+
+	tableName, err := p.parseIdent("table name")
+	if err != nil {
+		return nil, err
+	}
+
+	return &interfaces.DropStatement{
+		TableName: tableName,
+	}, nil
+}
+
+// parseAlterTable parses ALTER TABLE name followed by one of
+// ADD [COLUMN] definition, DROP [COLUMN] name, RENAME [COLUMN] name TO name
+// or RENAME TO name
+func (p *Parser) parseAlterTable() (*interfaces.AlterTableStatement, error) {
+	if err := p.expectKeywords("ALTER", "TABLE"); err != nil {
+		return nil, err
+	}
+
+	tableName, err := p.parseIdent("table name")
+	if err != nil {
+		return nil, err
+	}
+	stmt := &interfaces.AlterTableStatement{TableName: tableName}
+
+	switch {
+	case p.acceptKeyword("ADD"):
+		p.acceptKeyword("COLUMN")
+		stmt.Action = "ADD COLUMN"
+		if stmt.Column, err = p.parseColumnDef(); err != nil {
+			return nil, err
+		}
+
+	case p.acceptKeyword("DROP"):
+		p.acceptKeyword("COLUMN")
+		stmt.Action = "DROP COLUMN"
+		if stmt.ColumnName, err = p.parseIdent("column name"); err != nil {
+			return nil, err
+		}
+
+	case p.acceptKeyword("RENAME"):
+		if p.acceptKeyword("TO") {
+			stmt.Action = "RENAME TABLE"
+		} else {
+			p.acceptKeyword("COLUMN")
+			stmt.Action = "RENAME COLUMN"
+			if stmt.ColumnName, err = p.parseIdent("column name"); err != nil {
+				return nil, err
+			}
+			if err := p.expectKeyword("TO"); err != nil {
+				return nil, err
+			}
+		}
+		if stmt.NewName, err = p.parseIdent("new name"); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, p.errorf("ADD, DROP or RENAME")
+	}
+
+	return stmt, nil
+}
+
+// parseDropIndex parses DROP INDEX name
+func (p *Parser) parseDropIndex() (*interfaces.DropIndexStatement, error) {
+	if err := p.expectKeywords("DROP", "INDEX"); err != nil {
+		return nil, err
+	}
+
+	indexName, err := p.parseIdent("index name")
+	if err != nil {
+		return nil, err
+	}
+
+	return &interfaces.DropIndexStatement{
+		IndexName: indexName,
+	}, nil
+}
+
+// parseDescribe parses DESCRIBE name
+func (p *Parser) parseDescribe() (*interfaces.DescribeStatement, error) {
+	if err := p.expectKeyword("DESCRIBE"); err != nil {
+		return nil, err
+	}
+	p.acceptKeyword("TABLE")
+
+	tableName, err := p.parseIdent("table name")
+	if err != nil {
+		return nil, err
+	}
+
+	return &interfaces.DescribeStatement{
+		TableName: tableName,
+	}, nil
+}
+
+// parsePragma parses PRAGMA name [= value | (value)]
+func (p *Parser) parsePragma() (*interfaces.PragmaStatement, error) {
+	if err := p.expectKeyword("PRAGMA"); err != nil {
+		return nil, err
+	}
+	name, err := p.parseIdent("pragma name")
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &interfaces.PragmaStatement{Name: name}
+	switch {
+	case p.isOperator("="):
+		p.next()
+		stmt.Value, err = p.parsePragmaValue()
+	case p.isPunct("("):
+		p.next()
+		if stmt.Value, err = p.parsePragmaValue(); err == nil {
+			err = p.expectPunct(")")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// parsePragmaValue parses the value of a PRAGMA: a literal, or a bare word
+// taken as text
+func (p *Parser) parsePragmaValue() (interface{}, error) {
+	if tok := p.peek(); tok.Type == TokenIdent || tok.Type == TokenKeyword && nonReserved[tok.Value] {
+		return p.parseIdent("pragma value")
+	}
+	expr, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return literalValue(expr)
+}
+
+// parseDelete parses DELETE FROM name [WHERE condition]
+func (p *Parser) parseDelete() (*interfaces.DeleteStatement, error) {
+	if err := p.expectKeywords("DELETE", "FROM"); err != nil {
+		return nil, err
+	}
+
+	tableName, err := p.parseIdent("table name")
+	if err != nil {
+		return nil, err
+	}
+
+	where, err := p.parseWhere()
+	if err != nil {
+		return nil, err
+	}
+
+	return &interfaces.DeleteStatement{
+		TableName: tableName,
+		Where:     where,
+	}, nil
+}
+
+// parseUpdate parses UPDATE name SET column = expr, ... [WHERE condition]
+func (p *Parser) parseUpdate() (*interfaces.UpdateStatement, error) {
+	if err := p.expectKeyword("UPDATE"); err != nil {
+		return nil, err
+	}
+
+	tableName, err := p.parseIdent("table name")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+
+	assignments := make([]interfaces.Assignment, 0)
+	for {
+		col, err := p.parseIdent("column name")
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOperator("=") {
+			return nil, p.errorf("'='")
+		}
+		p.next()
+		value, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, interfaces.Assignment{Column: col, Value: value})
+
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+
+	where, err := p.parseWhere()
+	if err != nil {
+		return nil, err
+	}
+
+	return &interfaces.UpdateStatement{
+		TableName:   tableName,
+		Assignments: assignments,
+		Where:       where,
+	}, nil
+}
+
+// parseWhere parses an optional WHERE clause, returning nil when absent
+func (p *Parser) parseWhere() (interfaces.Expr, error) {
+	if !p.acceptKeyword("WHERE") {
+		return nil, nil
+	}
+	return p.parseExpr()
+}
+
+// parseExpr parses an expression using the precedence
+// OR < AND < NOT < comparison < additive < multiplicative < unary
+func (p *Parser) parseExpr() (interfaces.Expr, error) {
+	return p.parseOr()
+}
+
+func (p *Parser) parseOr() (interfaces.Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &interfaces.BinaryExpr{Op: "OR", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseAnd() (interfaces.Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &interfaces.BinaryExpr{Op: "AND", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseNot() (interfaces.Expr, error) {
+	if p.isKeyword("NOT") {
+		p.next()
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &interfaces.UnaryExpr{Op: "NOT", Expr: expr}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *Parser) parseComparison() (interfaces.Expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.Type == TokenOperator && isComparison(tok.Value) {
+			p.next()
+			right, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			left = &interfaces.BinaryExpr{Op: tok.Value, Left: left, Right: right}
+			continue
+		}
+		if p.isKeyword("IS") {
+			p.next()
+			not := p.isKeyword("NOT")
+			if not {
+				p.next()
+			}
+			if err := p.expectKeyword("NULL"); err != nil {
+				return nil, err
+			}
+			left = &interfaces.IsNullExpr{Expr: left, Not: not}
+			continue
+		}
+
+		not := p.isKeyword("NOT") && p.peekAt(1).Type == TokenKeyword &&
+			(p.peekAt(1).Value == "IN" || p.peekAt(1).Value == "BETWEEN")
+		if !not && !p.isKeyword("IN") && !p.isKeyword("BETWEEN") {
+			return left, nil
+		}
+		if not {
+			p.next()
+		}
+		if p.next().Value == "BETWEEN" {
+			left, err = p.parseBetween(left, not)
+		} else {
+			left, err = p.parseIn(left, not)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseBetween parses the bounds of [NOT] BETWEEN low AND high. The bounds
+// are additive expressions, so the AND separating them is not taken as a
+// logical operator.
+func (p *Parser) parseBetween(left interfaces.Expr, not bool) (interfaces.Expr, error) {
+	low, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("AND"); err != nil {
+		return nil, err
+	}
+	high, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	return &interfaces.BetweenExpr{Expr: left, Low: low, High: high, Not: not}, nil
+}
+
+// parseIn parses the right-hand side of [NOT] IN: a parenthesised list of
+// expressions or a subquery
+func (p *Parser) parseIn(left interfaces.Expr, not bool) (interfaces.Expr, error) {
+	if p.isPunct("(") && p.peekAt(1).Type == TokenKeyword && p.peekAt(1).Value == "SELECT" {
+		subquery, err := p.parseSubquery()
+		if err != nil {
+			return nil, err
+		}
+		return &interfaces.InExpr{Expr: left, Subquery: subquery, Not: not}, nil
+	}
+
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	in := &interfaces.InExpr{Expr: left, Not: not}
+	for {
+		item, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		in.List = append(in.List, item)
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// parseSubquery parses a parenthesised SELECT statement
+func (p *Parser) parseSubquery() (*interfaces.SubqueryExpr, error) {
+	start := p.peek().Pos
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	stmt, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	end := p.peek().Pos + 1
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return &interfaces.SubqueryExpr{Select: stmt, Text: p.input[start:end]}, nil
+}
+
+func (p *Parser) parseAdditive() (interfaces.Expr, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("+") || p.isOperator("-") || p.isOperator("||") {
+		op := p.next().Value
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &interfaces.BinaryExpr{Op: op, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseMultiplicative() (interfaces.Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("*") || p.isOperator("/") || p.isOperator("%") {
+		op := p.next().Value
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &interfaces.BinaryExpr{Op: op, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseUnary() (interfaces.Expr, error) {
+	if p.isOperator("-") || p.isOperator("+") {
+		op := p.next().Value
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		// Fold signed numeric literals so that -5 stays a literal
+		if lit, ok := expr.(*interfaces.Literal); ok {
+			switch v := lit.Value.(type) {
+			case int:
+				if op == "-" {
+					v = -v
+				}
+				return &interfaces.Literal{Value: v}, nil
+			case float64:
+				if op == "-" {
+					v = -v
+				}
+				return &interfaces.Literal{Value: v}, nil
+			}
+		}
+		return &interfaces.UnaryExpr{Op: op, Expr: expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *Parser) parsePrimary() (interfaces.Expr, error) {
+	tok := p.peek()
+	switch tok.Type {
+	case TokenNumber:
+		p.next()
+		return parseNumber(tok)
+	case TokenString:
+		p.next()
+		return &interfaces.Literal{Value: tok.Value}, nil
+	case TokenKeyword:
+		switch tok.Value {
+		case "NULL":
+			p.next()
+			return &interfaces.Literal{Value: nil}, nil
+		case "TRUE":
+			p.next()
+			return &interfaces.Literal{Value: true}, nil
+		case "FALSE":
+			p.next()
+			return &interfaces.Literal{Value: false}, nil
+		case "EXISTS":
+			p.next()
+			subquery, err := p.parseSubquery()
+			if err != nil {
+				return nil, err
+			}
+			return &interfaces.ExistsExpr{Subquery: subquery}, nil
+		}
+		if nonReserved[tok.Value] {
+			p.next()
+			return &interfaces.ColumnRef{Name: tok.Text}, nil
+		}
+	case TokenIdent:
+		p.next()
+		if p.isPunct("(") {
+			return p.parseFuncCall(tok.Value)
+		}
+		if p.isPunct(".") {
+			p.next()
+			name, err := p.parseIdent("column name")
+			if err != nil {
+				return nil, err
+			}
+			return &interfaces.ColumnRef{Table: tok.Value, Name: name}, nil
+		}
+		return &interfaces.ColumnRef{Name: tok.Value}, nil
+	case TokenPunct:
+		if tok.Value == "(" && p.peekAt(1).Type == TokenKeyword && p.peekAt(1).Value == "SELECT" {
+			subquery, err := p.parseSubquery()
+			if err != nil {
+				return nil, err
+			}
+			return subquery, nil
+		}
+		if tok.Value == "(" {
+			p.next()
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(")"); err != nil {
+				return nil, err
+			}
+			return expr, nil
+		}
+	}
+	return nil, p.errorf("expression")
+}
+
+// parseFuncCall parses the argument list of a function call:
+// name([DISTINCT] args) or name(*)
+func (p *Parser) parseFuncCall(name string) (interfaces.Expr, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+
+	call := &interfaces.FuncCall{Name: name, Args: make([]interfaces.Expr, 0)}
+	if p.isOperator("*") {
+		p.next()
+		call.Args = append(call.Args, &interfaces.StarExpr{})
+	} else if !p.isPunct(")") {
+		call.Distinct = p.acceptKeyword("DISTINCT")
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+			if !p.isPunct(",") {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// parseIdentList parses a parenthesised, comma separated list of identifiers
+func (p *Parser) parseIdentList() ([]string, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for {
+		name, err := p.parseIdent("column name")
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// parseIdent consumes an identifier; double-quoted strings and non-reserved
+// keywords are accepted as identifiers too
+func (p *Parser) parseIdent(what string) (string, error) {
+	tok := p.peek()
+	switch {
+	case tok.Type == TokenIdent:
+	case tok.Type == TokenString && tok.Quote == '"':
+	case tok.Type == TokenKeyword && nonReserved[tok.Value]:
+		// Keep the original spelling of keywords used as names
+		tok.Value = tok.Text
+	default:
+		return "", p.errorf(what)
+	}
+	p.next()
+	return tok.Value, nil
+}
+
+// nonReserved lists keywords that may also be used as table or column names
+var nonReserved = map[string]bool{
+	"KEY": true, "TRANSACTION": true, "NULLS": true, "FIRST": true, "LAST": true,
+	"ADD": true, "COLUMN": true, "RENAME": true, "TO": true,
+}
+
+// Token stream helpers
+
+func (p *Parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+// peekAt returns the token offset positions ahead without consuming anything
+func (p *Parser) peekAt(offset int) Token {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *Parser) next() Token {
+	tok := p.tokens[p.pos]
+	if tok.Type != TokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *Parser) isKeyword(kw string) bool {
+	tok := p.peek()
+	return tok.Type == TokenKeyword && tok.Value == kw
+}
+
+func (p *Parser) isPunct(punct string) bool {
+	tok := p.peek()
+	return tok.Type == TokenPunct && tok.Value == punct
+}
+
+func (p *Parser) isOperator(op string) bool {
+	tok := p.peek()
+	return tok.Type == TokenOperator && tok.Value == op
+}
+
+// acceptKeyword consumes the keyword if it is next and reports whether it did
+func (p *Parser) acceptKeyword(kw string) bool {
+	if p.isKeyword(kw) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *Parser) expectKeyword(kw string) error {
+	if !p.acceptKeyword(kw) {
+		return p.errorf(kw)
+	}
+	return nil
+}
+
+func (p *Parser) expectKeywords(kws ...string) error {
+	for _, kw := range kws {
+		if err := p.expectKeyword(kw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Parser) expectPunct(punct string) error {
+	if !p.isPunct(punct) {
+		return p.errorf(fmt.Sprintf("'%s'", punct))
+	}
+	p.next()
+	return nil
+}
+
+// errorf reports that something else was found where `expected` was required
+func (p *Parser) errorf(expected string) error {
+	tok := p.peek()
+	return fmt.Errorf("expected %s but found %s at position %d", expected, describeToken(tok), tok.Pos)
+}
+
+func describeToken(tok Token) string {
+	switch tok.Type {
+	case TokenEOF:
+		return "end of input"
+	case TokenString:
+		return fmt.Sprintf("string %c%s%c", tok.Quote, tok.Value, tok.Quote)
+	default:
+		return fmt.Sprintf("%s %q", tok.Type, tok.Value)
+	}
+}
+
+// parseNumber converts a number token into an int or float64 literal
+func parseNumber(tok Token) (interfaces.Expr, error) {
+	if !strings.ContainsAny(tok.Value, ".eE") {
+		if num, err := strconv.Atoi(tok.Value); err == nil {
+			return &interfaces.Literal{Value: num}, nil
+		}
+	}
+	num, err := strconv.ParseFloat(tok.Value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %s at position %d", tok.Value, tok.Pos)
+	}
+	return &interfaces.Literal{Value: num}, nil
+}
+
+// literalValue returns the constant value of a literal expression
+func literalValue(expr interfaces.Expr) (interface{}, error) {
+	lit, ok := expr.(*interfaces.Literal)
+	if !ok {
+		return nil, fmt.Errorf("expected a literal value but found %s", expr)
+	}
+	return lit.Value, nil
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "=", "!=", "<", ">", "<=", ">=":
+		return true
+	}
+	return false
 }
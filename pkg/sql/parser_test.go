@@ -0,0 +1,469 @@
+package sql
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"sqlight/pkg/interfaces"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens, err := Tokenize("SELECT name FROM t WHERE note = 'it''s, AND ok' AND n >= -1.5 -- trailing")
+	if err != nil {
+		t.Fatalf("Tokenize failed: %v", err)
+	}
+
+	var values []string
+	for _, tok := range tokens {
+		values = append(values, tok.Value)
+	}
+	expected := []string{"SELECT", "name", "FROM", "t", "WHERE", "note", "=", "it's, AND ok", "AND", "n", ">=", "-", "1.5", ""}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected tokens %q, got %q", expected, values)
+	}
+
+	if _, err := Tokenize("SELECT 'unterminated"); err == nil {
+		t.Error("Expected error for unterminated string")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- Create the table
+CREATE TABLE notes (id INTEGER, body TEXT);;
+INSERT INTO notes VALUES (1, 'a; b -- not a comment'); /* done; */
+SELECT * FROM notes -- no semicolon at the end
+`
+	statements, err := SplitStatements(script)
+	if err != nil {
+		t.Fatalf("SplitStatements failed: %v", err)
+	}
+	expected := []string{
+		"CREATE TABLE notes (id INTEGER, body TEXT)",
+		"INSERT INTO notes VALUES (1, 'a; b -- not a comment')",
+		"SELECT * FROM notes -- no semicolon at the end",
+	}
+	if !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+
+	if _, err := SplitStatements("SELECT 'unterminated; SELECT 1;"); err == nil {
+		t.Error("Expected error for unterminated string")
+	}
+}
+
+func TestParseInsertWithCommasInStrings(t *testing.T) {
+	stmt, err := Parse("INSERT INTO users (id, name) VALUES (1, 'Doe, John');")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	insert, ok := stmt.(*interfaces.InsertStatement)
+	if !ok {
+		t.Fatalf("Expected *InsertStatement, got %T", stmt)
+	}
+	if !reflect.DeepEqual(insert.Rows, [][]interface{}{{1, "Doe, John"}}) {
+		t.Errorf("Unexpected values: %v", insert.Rows)
+	}
+}
+
+func TestParseInsertForms(t *testing.T) {
+	stmt, err := Parse("INSERT INTO users VALUES (1, 'Alice'), (2, NULL), (-3, 'Carol')")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	insert := stmt.(*interfaces.InsertStatement)
+	if len(insert.Columns) != 0 {
+		t.Errorf("Expected no column list, got %v", insert.Columns)
+	}
+	expected := [][]interface{}{{1, "Alice"}, {2, nil}, {-3, "Carol"}}
+	if !reflect.DeepEqual(insert.Rows, expected) {
+		t.Errorf("Expected rows %v, got %v", expected, insert.Rows)
+	}
+
+	stmt, err = Parse("INSERT INTO archive (id, name) SELECT id, name FROM users WHERE id < 10")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	insert = stmt.(*interfaces.InsertStatement)
+	if insert.Select == nil || insert.Select.TableName != "users" || insert.Rows != nil {
+		t.Errorf("Expected INSERT ... SELECT from users, got %+v", insert)
+	}
+	if !reflect.DeepEqual(insert.Columns, []string{"id", "name"}) {
+		t.Errorf("Unexpected columns: %v", insert.Columns)
+	}
+}
+
+func TestParseCreateTable(t *testing.T) {
+	stmt, err := Parse(`CREATE TABLE users (
+		id INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		email TEXT UNIQUE
+	)`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	create := stmt.(*interfaces.CreateStatement)
+	expected := []interfaces.Column{
+		{Name: "id", Type: "INTEGER", PrimaryKey: true, Nullable: true},
+		{Name: "name", Type: "VARCHAR", Nullable: false},
+		{Name: "email", Type: "TEXT", Nullable: true, Unique: true},
+	}
+	if create.TableName != "users" || !reflect.DeepEqual(create.Columns, expected) {
+		t.Errorf("Unexpected CREATE TABLE result: %+v", create)
+	}
+}
+
+func TestParseUpdate(t *testing.T) {
+	stmt, err := Parse("UPDATE products SET price = price + 10, name = 'x' WHERE id = 1")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	update, ok := stmt.(*interfaces.UpdateStatement)
+	if !ok {
+		t.Fatalf("Expected *UpdateStatement, got %T", stmt)
+	}
+	if len(update.Assignments) != 2 || update.Assignments[0].Column != "price" {
+		t.Fatalf("Unexpected assignments: %+v", update.Assignments)
+	}
+	if got := update.Assignments[0].Value.String(); got != "price + 10" {
+		t.Errorf("Expected 'price + 10', got %q", got)
+	}
+	if update.Where == nil || update.Where.String() != "id = 1" {
+		t.Errorf("Unexpected WHERE clause: %v", update.Where)
+	}
+}
+
+func TestParseWhereExpressionTree(t *testing.T) {
+	stmt, err := Parse("SELECT * FROM t WHERE NOT (a > 1 AND a < 10) OR b = 'x AND y'")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	where := stmt.(*interfaces.SelectStatement).Where
+	or, ok := where.(*interfaces.BinaryExpr)
+	if !ok || or.Op != "OR" {
+		t.Fatalf("Expected OR at the root, got %v", where)
+	}
+	not, ok := or.Left.(*interfaces.UnaryExpr)
+	if !ok || not.Op != "NOT" {
+		t.Fatalf("Expected NOT on the left, got %v", or.Left)
+	}
+	if and, ok := not.Expr.(*interfaces.BinaryExpr); !ok || and.Op != "AND" {
+		t.Errorf("Expected parenthesised AND under NOT, got %v", not.Expr)
+	}
+	if cmp, ok := or.Right.(*interfaces.BinaryExpr); !ok || cmp.Right.(*interfaces.Literal).Value != "x AND y" {
+		t.Errorf("Expected comparison with quoted AND, got %v", or.Right)
+	}
+}
+
+func TestParseIsNull(t *testing.T) {
+	stmt, err := Parse("SELECT * FROM t WHERE a IS NULL OR b + 1 IS NOT NULL AND NOT c IS NULL")
+	if err != nil {
+		t.Fatalf("Failed to parse IS NULL: %v", err)
+	}
+	where := stmt.(*interfaces.SelectStatement).Where
+
+	expected := "a IS NULL OR b + 1 IS NOT NULL AND NOT c IS NULL"
+	if got := where.String(); got != expected {
+		t.Errorf("Expected WHERE %s, got %s", expected, got)
+	}
+	or := where.(*interfaces.BinaryExpr)
+	if is, ok := or.Left.(*interfaces.IsNullExpr); !ok || is.Not {
+		t.Errorf("Expected IS NULL on the left, got %#v", or.Left)
+	}
+	and := or.Right.(*interfaces.BinaryExpr)
+	if is, ok := and.Left.(*interfaces.IsNullExpr); !ok || !is.Not || is.Expr.String() != "b + 1" {
+		t.Errorf("Expected b + 1 IS NOT NULL, got %#v", and.Left)
+	}
+	if not, ok := and.Right.(*interfaces.UnaryExpr); !ok || not.Op != "NOT" {
+		t.Errorf("Expected NOT applied to c IS NULL, got %#v", and.Right)
+	}
+
+	if _, err := Parse("SELECT * FROM t WHERE a IS 1"); err == nil {
+		t.Error("Expected error for IS without NULL")
+	}
+}
+
+func TestParseOrderByLimitOffset(t *testing.T) {
+	stmt, err := Parse("SELECT * FROM t ORDER BY a DESC NULLS LAST, b LIMIT 10 OFFSET 5")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	sel := stmt.(*interfaces.SelectStatement)
+	if len(sel.OrderBy) != 2 {
+		t.Fatalf("Expected 2 ORDER BY items, got %d", len(sel.OrderBy))
+	}
+	if first := sel.OrderBy[0]; !first.Desc || first.NullsOrder != "LAST" || first.Expr.String() != "a" {
+		t.Errorf("Unexpected first ORDER BY item: %+v", first)
+	}
+	if second := sel.OrderBy[1]; second.Desc || second.NullsOrder != "" {
+		t.Errorf("Unexpected second ORDER BY item: %+v", second)
+	}
+	if sel.Limit == nil || *sel.Limit != 10 || sel.Offset != 5 {
+		t.Errorf("Expected LIMIT 10 OFFSET 5, got %v %d", sel.Limit, sel.Offset)
+	}
+}
+
+func TestParseGroupBy(t *testing.T) {
+	stmt, err := Parse("SELECT cat, COUNT(DISTINCT price) AS n, SUM(qty) FROM p GROUP BY cat HAVING COUNT(*) > 1")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	sel := stmt.(*interfaces.SelectStatement)
+	if len(sel.Columns) != 3 || sel.Columns[1].Alias != "n" {
+		t.Fatalf("Unexpected select list: %+v", sel.Columns)
+	}
+	if call, ok := sel.Columns[1].Expr.(*interfaces.FuncCall); !ok || !call.Distinct || call.Name != "COUNT" {
+		t.Errorf("Expected COUNT(DISTINCT ...), got %v", sel.Columns[1].Expr)
+	}
+	if len(sel.GroupBy) != 1 || sel.GroupBy[0].String() != "cat" {
+		t.Errorf("Unexpected GROUP BY: %v", sel.GroupBy)
+	}
+	if sel.Having == nil || sel.Having.String() != "COUNT(*) > 1" {
+		t.Errorf("Unexpected HAVING: %v", sel.Having)
+	}
+}
+
+func TestParseJoins(t *testing.T) {
+	stmt, err := Parse("SELECT u.name, p.* FROM users AS u LEFT OUTER JOIN posts p ON p.user_id = u.id JOIN tags t ON t.id = p.tag_id, sizes CROSS JOIN colors")
+	if err != nil {
+		t.Fatalf("Failed to parse join: %v", err)
+	}
+	sel := stmt.(*interfaces.SelectStatement)
+
+	if sel.TableName != "users" || sel.Alias != "u" {
+		t.Errorf("Unexpected FROM table: %s %s", sel.TableName, sel.Alias)
+	}
+	if got := sel.Columns[0].Expr.String(); got != "u.name" {
+		t.Errorf("Expected u.name, got %s", got)
+	}
+	if star, ok := sel.Columns[1].Expr.(*interfaces.StarExpr); !ok || star.Table != "p" {
+		t.Errorf("Expected p.*, got %v", sel.Columns[1].Expr)
+	}
+
+	expected := []struct {
+		joinType, table, alias, on string
+	}{
+		{"LEFT", "posts", "p", "p.user_id = u.id"},
+		{"INNER", "tags", "t", "t.id = p.tag_id"},
+		{"CROSS", "sizes", "", ""},
+		{"CROSS", "colors", "", ""},
+	}
+	if len(sel.Joins) != len(expected) {
+		t.Fatalf("Expected %d joins, got %d", len(expected), len(sel.Joins))
+	}
+	for i, e := range expected {
+		join := sel.Joins[i]
+		on := ""
+		if join.On != nil {
+			on = join.On.String()
+		}
+		if join.Type != e.joinType || join.TableName != e.table || join.Alias != e.alias || on != e.on {
+			t.Errorf("Join %d: got %s %s %s ON %s, expected %s %s %s ON %s", i, join.Type, join.TableName, join.Alias, on, e.joinType, e.table, e.alias, e.on)
+		}
+	}
+}
+
+func TestParseSubqueries(t *testing.T) {
+	stmt, err := Parse("SELECT name, (SELECT COUNT(*) FROM posts p WHERE p.user_id = u.id) AS n FROM users u WHERE id NOT IN (SELECT user_id FROM bans) AND EXISTS (SELECT 1 FROM posts) AND id IN (1, 2)")
+	if err != nil {
+		t.Fatalf("Failed to parse subqueries: %v", err)
+	}
+	sel := stmt.(*interfaces.SelectStatement)
+
+	sub, ok := sel.Columns[1].Expr.(*interfaces.SubqueryExpr)
+	if !ok || sub.Select.TableName != "posts" || sub.Select.Alias != "p" {
+		t.Fatalf("Expected scalar subquery on posts, got %v", sel.Columns[1].Expr)
+	}
+	if sub.String() != "(SELECT COUNT(*) FROM posts p WHERE p.user_id = u.id)" {
+		t.Errorf("Unexpected subquery text: %s", sub)
+	}
+
+	expected := "id NOT IN (SELECT user_id FROM bans) AND EXISTS (SELECT 1 FROM posts) AND id IN (1, 2)"
+	if got := sel.Where.String(); got != expected {
+		t.Errorf("Expected WHERE %s, got %s", expected, got)
+	}
+	in := sel.Where.(*interfaces.BinaryExpr).Left.(*interfaces.BinaryExpr).Left.(*interfaces.InExpr)
+	if !in.Not || in.Subquery == nil || in.Subquery.Select.TableName != "bans" {
+		t.Errorf("Expected NOT IN subquery on bans, got %v", in)
+	}
+}
+
+func TestParseBetween(t *testing.T) {
+	stmt, err := Parse("SELECT * FROM t WHERE a BETWEEN 1 AND b + 2 AND c NOT BETWEEN 'x' AND 'y'")
+	if err != nil {
+		t.Fatalf("Failed to parse BETWEEN: %v", err)
+	}
+	where := stmt.(*interfaces.SelectStatement).Where
+
+	expected := "a BETWEEN 1 AND b + 2 AND c NOT BETWEEN 'x' AND 'y'"
+	if got := where.String(); got != expected {
+		t.Errorf("Expected WHERE %s, got %s", expected, got)
+	}
+	and, ok := where.(*interfaces.BinaryExpr)
+	if !ok || and.Op != "AND" {
+		t.Fatalf("Expected an AND of two BETWEENs, got %#v", where)
+	}
+	if between, ok := and.Left.(*interfaces.BetweenExpr); !ok || between.Not {
+		t.Errorf("Expected a BETWEEN on the left, got %#v", and.Left)
+	}
+	if between, ok := and.Right.(*interfaces.BetweenExpr); !ok || !between.Not {
+		t.Errorf("Expected a NOT BETWEEN on the right, got %#v", and.Right)
+	}
+
+	if _, err := Parse("SELECT * FROM t WHERE a BETWEEN 1"); err == nil {
+		t.Error("Expected error for BETWEEN without AND")
+	}
+}
+
+func TestParseAlterTable(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interfaces.AlterTableStatement
+	}{
+		{"ALTER TABLE users ADD COLUMN age INTEGER NOT NULL DEFAULT -1", interfaces.AlterTableStatement{
+			TableName: "users", Action: "ADD COLUMN",
+			Column: interfaces.Column{Name: "age", Type: "INTEGER", Default: -1},
+		}},
+		{"ALTER TABLE users ADD nick TEXT", interfaces.AlterTableStatement{
+			TableName: "users", Action: "ADD COLUMN",
+			Column: interfaces.Column{Name: "nick", Type: "TEXT", Nullable: true},
+		}},
+		{"ALTER TABLE users DROP COLUMN nick", interfaces.AlterTableStatement{TableName: "users", Action: "DROP COLUMN", ColumnName: "nick"}},
+		{"ALTER TABLE users RENAME COLUMN name TO full_name", interfaces.AlterTableStatement{TableName: "users", Action: "RENAME COLUMN", ColumnName: "name", NewName: "full_name"}},
+		{"ALTER TABLE users RENAME TO people", interfaces.AlterTableStatement{TableName: "users", Action: "RENAME TABLE", NewName: "people"}},
+	}
+
+	for _, tt := range tests {
+		stmt, err := Parse(tt.input)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.input, err)
+		}
+		if alter, ok := stmt.(*interfaces.AlterTableStatement); !ok || !reflect.DeepEqual(*alter, tt.expected) {
+			t.Errorf("Parse(%q) = %+v, expected %+v", tt.input, stmt, tt.expected)
+		}
+	}
+}
+
+func TestParseIndexStatements(t *testing.T) {
+	stmt, err := Parse("CREATE UNIQUE INDEX idx_name ON users (last, first)")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	expected := &interfaces.CreateIndexStatement{IndexName: "idx_name", TableName: "users", Columns: []string{"last", "first"}, Unique: true}
+	if !reflect.DeepEqual(stmt, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, stmt)
+	}
+
+	stmt, err = Parse("DROP INDEX idx_name")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if drop, ok := stmt.(*interfaces.DropIndexStatement); !ok || drop.IndexName != "idx_name" {
+		t.Errorf("Expected DROP INDEX idx_name, got %+v", stmt)
+	}
+}
+
+func TestParsePragma(t *testing.T) {
+	for input, expected := range map[string]*interfaces.PragmaStatement{
+		"PRAGMA integrity_check":      {Name: "integrity_check"},
+		"pragma integrity_check(10);": {Name: "integrity_check", Value: 10},
+		"PRAGMA cache_size = -2000":   {Name: "cache_size", Value: -2000},
+		"PRAGMA mode = 'fast'":        {Name: "mode", Value: "fast"},
+		"PRAGMA journal_mode = wal":   {Name: "journal_mode", Value: "wal"},
+		"PRAGMA foreign_keys = FALSE": {Name: "foreign_keys", Value: false},
+	} {
+		stmt, err := Parse(input)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", input, err)
+		}
+		if !reflect.DeepEqual(stmt, expected) {
+			t.Errorf("Parse(%q) = %+v, expected %+v", input, stmt, expected)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, input := range []string{
+		"INVALID SQL",
+		"SELECT FROM users",
+		"INSERT INTO users (id) VALUES (1",
+		"DROP TABLE",
+		"SELECT * FROM users u extra",
+		"SELECT * FROM users JOIN posts",
+		"SELECT * FROM users LEFT posts ON 1 = 1",
+		"SELECT * FROM users WHERE id IN (SELECT id FROM posts",
+		"SELECT * FROM users WHERE EXISTS posts",
+		"INSERT INTO users VALUES (1), ",
+		"INSERT INTO users (id) VALUES (id)",
+		"ALTER TABLE users MODIFY id TEXT",
+		"ALTER TABLE users RENAME COLUMN a b",
+		"PRAGMA",
+		"PRAGMA integrity_check(10",
+		"PRAGMA cache_size = id + 1",
+	} {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("Expected error parsing %q", input)
+		}
+	}
+
+	stmt, err := Parse("-- only a comment")
+	if err != nil || stmt != nil {
+		t.Errorf("Expected nil statement for comment-only input, got %v, %v", stmt, err)
+	}
+}
+
+// parseExpression parses a standalone expression
+func parseExpression(input string) (interfaces.Expr, error) {
+	tokens, err := Tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &Parser{tokens: tokens}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.Type != TokenEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", describeToken(tok), tok.Pos)
+	}
+	return expr, nil
+}
+
+func TestExpressionStringParsesBack(t *testing.T) {
+	for expr, expected := range map[string]string{
+		"(a + b) * c":           "(a + b) * c",
+		"a + b * c":             "a + b * c",
+		"a - (b - c)":           "a - (b - c)",
+		"(a - b) - c":           "a - b - c",
+		"(a OR b) AND c":        "(a OR b) AND c",
+		"NOT (a = 1 OR b = 2)":  "NOT (a = 1 OR b = 2)",
+		"-(a + 1)":              "-(a + 1)",
+		"(a > 1) = (b < 2)":     "a > 1 = (b < 2)",
+		"a || (b || 'x') || c":  "a || (b || 'x') || c",
+		"x * (y % 3) / (z + 1)": "x * (y % 3) / (z + 1)",
+		"(a AND b) IS NULL":     "(a AND b) IS NULL",
+		"(NOT a) IS NOT NULL":   "(NOT a) IS NOT NULL",
+		"a = b IS NULL":         "a = b IS NULL",
+	} {
+		parsed, err := parseExpression(expr)
+		if err != nil {
+			t.Fatalf("parseExpression(%q) failed: %v", expr, err)
+		}
+		if got := parsed.String(); got != expected {
+			t.Errorf("%s printed as %s, expected %s", expr, got, expected)
+		}
+		again, err := parseExpression(parsed.String())
+		if err != nil {
+			t.Fatalf("parseExpression(%q) failed: %v", parsed.String(), err)
+		}
+		if !reflect.DeepEqual(again, parsed) {
+			t.Errorf("%s parsed back as a different expression", parsed)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// failpoint is called before each step of WriteFile, and of a format
+// migration, with the step's name; tests replace it to simulate a failure
+// at that point
+var failpoint = func(step string) error { return nil }
+
+// WriteFile replaces the file at path with data so that a crash at any
+// point leaves either the old or the new contents, never a mix: data is
+// written to a temporary file in the same directory and synced, renamed
+// over path, and the directory is synced so the rename is durable. With
+// backup set, the previous contents are kept in path + ".bak" first. The
+// new file keeps the permissions of the file it replaces; a file that did
+// not exist is created readable only by its owner.
+func WriteFile(path string, data []byte, backup bool) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	if err := failpoint("create"); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp*")
+	if err != nil {
+		return err
+	}
+	// The temporary file is removed unless it was renamed into place
+	renamed := false
+	defer func() {
+		if !renamed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if err := failpoint("write"); err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := failpoint("sync"); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := failpoint("close"); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if backup {
+		if err := failpoint("backup"); err != nil {
+			return err
+		}
+		if err := linkBackup(path); err != nil {
+			return err
+		}
+	}
+
+	if err := failpoint("rename"); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	renamed = true
+
+	if err := failpoint("sync dir"); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// linkBackup makes path + ".bak" a link to the current file at path, if
+// there is one. The rename that follows gives path a new file, leaving the
+// backup with the previous contents.
+func linkBackup(path string) error {
+	tmp := path + ".bak.tmp"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(path, tmp); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.Rename(tmp, path+".bak")
+}
+
+// syncDir syncs a directory, making renames within it durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sqlight/pkg/interfaces"
+)
+
+var errInjected = errors.New("injected failure")
+
+// failAt makes WriteFile fail before the named step
+func failAt(t *testing.T, step string) {
+	original := failpoint
+	failpoint = func(s string) error {
+		if s == step {
+			return errInjected
+		}
+		return nil
+	}
+	t.Cleanup(func() { failpoint = original })
+}
+
+// generation returns the tables saved by the n'th save of a test
+func generation(n int) map[string]*interfaces.Table {
+	records := make([]*interfaces.Record, n)
+	for i := range records {
+		records[i] = &interfaces.Record{Columns: map[string]interface{}{"name": "row"}}
+	}
+	return map[string]*interfaces.Table{
+		"users": {
+			Name:    "users",
+			Columns: []interfaces.Column{{Name: "name", Type: "TEXT", Nullable: true}},
+			Records: records,
+		},
+	}
+}
+
+func TestSaveToFileFailureAtEachStep(t *testing.T) {
+	for _, step := range []string{"create", "write", "sync", "close", "backup", "rename", "sync dir"} {
+		t.Run(step, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "database.json")
+			for n := 1; n <= 2; n++ {
+				if err := SaveToFile(path, generation(n), true); err != nil {
+					t.Fatalf("SaveToFile: %v", err)
+				}
+			}
+
+			failAt(t, step)
+			if err := SaveToFile(path, generation(3), true); err != errInjected {
+				t.Fatalf("SaveToFile returned %v, expected the injected failure", err)
+			}
+
+			// Until the rename the last committed save is in place; after it
+			// the new one is
+			committed := 2
+			if step == "sync dir" {
+				committed = 3
+			}
+			tables, err := LoadFromFile(path)
+			if err != nil {
+				t.Fatalf("LoadFromFile: %v", err)
+			}
+			if got := len(tables["users"].Records); got != committed {
+				t.Errorf("Loaded %d records, expected save %d", got, committed)
+			}
+
+			// The backup holds a committed save too, which is the current one
+			// if the failure came between linking it and the rename
+			backup, err := LoadFromFile(path + ".bak")
+			if err != nil {
+				t.Fatalf("LoadFromFile of the backup: %v", err)
+			}
+			if got := len(backup["users"].Records); got < 1 || got > committed {
+				t.Errorf("Backup has %d records, expected a committed save", got)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				if name := entry.Name(); name != "database.json" && name != "database.json.bak" {
+					t.Errorf("Failed save left %s behind", name)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteFileKeepsBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	for _, contents := range []string{"first", "second", "third"} {
+		if err := WriteFile(path, []byte(contents), true); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	for file, expected := range map[string]string{path: "third", path + ".bak": "second"} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("%s contains %q, expected %q", filepath.Base(file), data, expected)
+		}
+	}
+
+	// A new file is readable only by its owner, and a replaced file keeps
+	// its mode
+	for _, expected := range []os.FileMode{0600, 0640} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != expected {
+			t.Errorf("File mode is %v, expected %v", mode, expected)
+		}
+		if err := os.Chmod(path, 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteFile(path, []byte("fourth"), false); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+}
+
+func TestSaveToFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	if err := SaveToFile(path, generation(2), false); err != nil {
+		t.Fatalf("SaveToFile: %v", err)
+	}
+	tables, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadFromFile: %v", err)
+	}
+	if !reflect.DeepEqual(tables, generation(2)) {
+		t.Errorf("Loaded %v, expected %v", tables, generation(2))
+	}
+	if _, err := os.Stat(path + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("Expected no backup without asking for one")
+	}
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"sqlight/pkg/interfaces"
+	"sqlight/pkg/pager"
+)
+
+// Backend persists the tables of a database. The database opens its backend
+// and reads the catalog when it starts, reads the rows of each table when
+// the table is first used, and after each change writes its tables and syncs
+// the backend, which makes the write durable. A write that fails, or is not
+// synced, is discarded.
+type Backend interface {
+	// Open opens the store, creating it if it does not exist
+	Open() error
+	// LoadCatalog returns the definition of each saved table, without rows
+	LoadCatalog() (map[string]*interfaces.Table, error)
+	// ReadTable calls fn with each saved row of a table and its rowid
+	ReadTable(name string, fn func(rowid int64, record *interfaces.Record) error) error
+	// WriteTables writes the tables of the database, replacing the saved
+	// tables; tables that are not given are dropped
+	WriteTables(tables []*TableData) error
+	// Sync makes the last write durable
+	Sync() error
+	// Close closes the store
+	Close() error
+}
+
+// ErrReadOnly is returned when writing to a database that was opened
+// read-only, because its file cannot be written or upgraded
+var ErrReadOnly = errors.New("database is read-only")
+
+// Checker is implemented by backends that can verify the data they store
+type Checker interface {
+	// Check reads every saved table, returning the problems found, such as
+	// data that fails its checksum
+	Check() []error
+}
+
+// Rekeyer is implemented by backends that can encrypt the file they store
+// tables in
+type Rekeyer interface {
+	// Rekey rewrites the stored tables encrypted under a new passphrase, or
+	// not encrypted if it is empty
+	Rekey(passphrase string) error
+}
+
+// TableData is a table to write to a backend: its definition and rows,
+// and the changes to its rows since it was last written, which lets a
+// backend write only those
+type TableData struct {
+	Name  string
+	Table *interfaces.Table // the definition; Records is ignored
+
+	// Rows calls fn with each row and its rowid, in key order
+	Rows func(fn func(rowid int64, record *interfaces.Record) error) error
+
+	// Saved is the name the table was last written under, or "" if it has
+	// not been written. Changed holds the rows inserted or updated since,
+	// by rowid, and nil for deleted ones. Rewrite is set when every row
+	// must be written, as after a change to the table's columns.
+	Saved   string
+	Changed map[int64]*interfaces.Record
+	Rewrite bool
+}
+
+// ForPath returns the backend for a database file. An existing file is
+// opened in the format it was written in: a page file or JSON. A new file
+// is a page file unless its name ends in ".json".
+func ForPath(path string) Backend {
+	_, err := os.Stat(path)
+	switch {
+	case pager.IsPageFile(path):
+		return NewPageBackend(path)
+	case err == nil, strings.HasSuffix(strings.ToLower(path), ".json"):
+		return NewJSONBackend(path)
+	}
+	return NewPageBackend(path)
+}
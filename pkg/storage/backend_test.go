@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"sqlight/pkg/crypt"
+	"sqlight/pkg/interfaces"
+)
+
+// testTable is a table as a backend test writes it, with its rows by rowid
+type testTable struct {
+	schema  *interfaces.Table
+	rows    map[int64]*interfaces.Record
+	saved   string
+	changed map[int64]*interfaces.Record
+}
+
+func newTestTable(name string) *testTable {
+	return &testTable{
+		schema: &interfaces.Table{
+			Name: name,
+			Columns: []interfaces.Column{
+				{Name: "id", Type: "INTEGER", PrimaryKey: true},
+				{Name: "name", Type: "TEXT", Nullable: true, Default: "none"},
+			},
+			Indexes: []interfaces.Index{{Name: "idx_" + name, Columns: []string{"name"}}},
+		},
+		rows:    make(map[int64]*interfaces.Record),
+		changed: make(map[int64]*interfaces.Record),
+	}
+}
+
+func (tt *testTable) put(rowid int64, name interface{}) {
+	record := &interfaces.Record{Columns: map[string]interface{}{"id": int(rowid), "name": name}}
+	tt.rows[rowid] = record
+	tt.changed[rowid] = record
+}
+
+func (tt *testTable) delete(rowid int64) {
+	delete(tt.rows, rowid)
+	tt.changed[rowid] = nil
+}
+
+func (tt *testTable) data(name string) *TableData {
+	return &TableData{
+		Name:  name,
+		Table: tt.schema,
+		Rows: func(fn func(rowid int64, record *interfaces.Record) error) error {
+			for _, rowid := range sortedRowids(tt.rows) {
+				if err := fn(rowid, tt.rows[rowid]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Saved:   tt.saved,
+		Changed: tt.changed,
+	}
+}
+
+func sortedRowids(rows map[int64]*interfaces.Record) []int64 {
+	rowids := make([]int64, 0, len(rows))
+	for rowid := range rows {
+		rowids = append(rowids, rowid)
+	}
+	sort.Slice(rowids, func(i, j int) bool { return rowids[i] < rowids[j] })
+	return rowids
+}
+
+// write writes and syncs the tables, marking them saved
+func write(t *testing.T, b Backend, tables map[string]*testTable) {
+	t.Helper()
+	data := make([]*TableData, 0, len(tables))
+	for name, tt := range tables {
+		data = append(data, tt.data(name))
+	}
+	if err := b.WriteTables(data); err != nil {
+		t.Fatalf("WriteTables: %v", err)
+	}
+	if err := b.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	for name, tt := range tables {
+		tt.saved = name
+		tt.changed = make(map[int64]*interfaces.Record)
+	}
+}
+
+// contents returns the schema and rows of every table in a backend
+func contents(t *testing.T, b Backend) map[string]*interfaces.Table {
+	t.Helper()
+	tables, err := b.LoadCatalog()
+	if err != nil {
+		t.Fatalf("LoadCatalog: %v", err)
+	}
+	for name, table := range tables {
+		err := b.ReadTable(name, func(rowid int64, record *interfaces.Record) error {
+			table.Records = append(table.Records, record)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ReadTable: %v", err)
+		}
+	}
+	return tables
+}
+
+// expected returns the contents a backend should hold after writing tables
+func expected(tables map[string]*testTable) map[string]*interfaces.Table {
+	contents := make(map[string]*interfaces.Table, len(tables))
+	for name, tt := range tables {
+		table := *tt.schema
+		for _, rowid := range sortedRowids(tt.rows) {
+			table.Records = append(table.Records, tt.rows[rowid])
+		}
+		contents[name] = &table
+	}
+	return contents
+}
+
+func TestBackends(t *testing.T) {
+	backends := map[string]func(dir string) func() Backend{
+		"memory": func(string) func() Backend {
+			b := NewMemoryBackend()
+			return func() Backend { return b }
+		},
+		"json": func(dir string) func() Backend {
+			return func() Backend { return NewJSONBackend(filepath.Join(dir, "test.json")) }
+		},
+		"compressed json": func(dir string) func() Backend {
+			return func() Backend {
+				b := NewJSONBackend(filepath.Join(dir, "test.json"))
+				b.SetCompression(true)
+				return b
+			}
+		},
+		"page": func(dir string) func() Backend {
+			return func() Backend { return NewPageBackend(filepath.Join(dir, "test.db")) }
+		},
+	}
+
+	for kind, backend := range backends {
+		t.Run(kind, func(t *testing.T) {
+			reopen := backend(t.TempDir())
+			open := func() Backend {
+				t.Helper()
+				b := reopen()
+				if err := b.Open(); err != nil {
+					t.Fatalf("Open: %v", err)
+				}
+				return b
+			}
+
+			b := open()
+			if got := contents(t, b); len(got) != 0 {
+				t.Fatalf("New backend holds %v", got)
+			}
+			users, notes := newTestTable("users"), newTestTable("notes")
+			for i := int64(1); i <= 50; i++ {
+				users.put(i, "user")
+				notes.put(i, nil)
+			}
+			tables := map[string]*testTable{"users": users, "notes": notes}
+			write(t, b, tables)
+			b.Close()
+
+			b = open()
+			if got := contents(t, b); !reflect.DeepEqual(got, expected(tables)) {
+				t.Fatalf("Reopened backend holds %v, expected %v", got, expected(tables))
+			}
+
+			// Change some rows, rename one table and drop the other
+			users.put(7, "changed")
+			users.put(51, "added")
+			users.delete(3)
+			users.schema.Name = "people"
+			tables = map[string]*testTable{"people": users, "extra": newTestTable("extra")}
+			tables["extra"].put(1, "only")
+			write(t, b, tables)
+
+			// A write that is not synced is discarded
+			users.put(52, "lost")
+			if err := b.WriteTables([]*TableData{users.data("people")}); err != nil {
+				t.Fatalf("WriteTables: %v", err)
+			}
+			users.delete(52)
+			b.Close()
+
+			b = open()
+			defer b.Close()
+			if got := contents(t, b); !reflect.DeepEqual(got, expected(tables)) {
+				t.Errorf("Reopened backend holds %v, expected %v", got, expected(tables))
+			}
+			if checker, ok := b.(Checker); ok {
+				if problems := checker.Check(); len(problems) > 0 {
+					t.Errorf("Check found %v", problems)
+				}
+			}
+		})
+	}
+}
+
+func TestForPath(t *testing.T) {
+	dir := t.TempDir()
+	for path, expected := range map[string]Backend{
+		filepath.Join(dir, "new.db"):   &PageBackend{},
+		filepath.Join(dir, "new.JSON"): &JSONBackend{},
+	} {
+		if got := ForPath(path); reflect.TypeOf(got) != reflect.TypeOf(expected) {
+			t.Errorf("ForPath(%s) is a %T, expected a %T", filepath.Base(path), got, expected)
+		}
+	}
+
+	// An existing file keeps its format, whatever its name
+	path := filepath.Join(dir, "data.db")
+	if err := SaveToFile(path, map[string]*interfaces.Table{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := ForPath(path); reflect.TypeOf(got) != reflect.TypeOf(&JSONBackend{}) {
+		t.Errorf("ForPath of a JSON file is a %T", got)
+	}
+}
+
+func TestEncryptedBackends(t *testing.T) {
+	cost := crypt.DefaultCost
+	crypt.DefaultCost = 10
+	defer func() { crypt.DefaultCost = cost }()
+
+	type encrypted interface {
+		Backend
+		Rekeyer
+		SetPassphrase(passphrase string)
+	}
+	for _, name := range []string{"test.json", "test.db"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			open := func(passphrase string) (encrypted, error) {
+				b := ForPath(path).(encrypted)
+				b.SetPassphrase(passphrase)
+				return b, b.Open()
+			}
+			// read checks that a passphrase opens the file with the
+			// expected contents, or fails with the expected error
+			read := func(passphrase string, expectedErr error) encrypted {
+				t.Helper()
+				b, err := open(passphrase)
+				if !errors.Is(err, expectedErr) {
+					t.Fatalf("Opening with passphrase %q returned %v, expected %v", passphrase, err, expectedErr)
+				}
+				if err != nil {
+					return nil
+				}
+				return b
+			}
+			// stored reports whether the file holds a row in plaintext
+			stored := func() bool {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return bytes.Contains(data, []byte("alice@example.com"))
+			}
+
+			b := read("secret", nil)
+			users := newTestTable("users")
+			users.put(1, "alice@example.com")
+			users.put(2, "bob@example.com")
+			tables := map[string]*testTable{"users": users}
+			write(t, b, tables)
+			b.Close()
+			if stored() {
+				t.Error("The encrypted file holds a row in plaintext")
+			}
+
+			read("", crypt.ErrPassphraseRequired)
+			read("guess", crypt.ErrWrongPassphrase)
+			b = read("secret", nil)
+			if got := contents(t, b); !reflect.DeepEqual(got, expected(tables)) {
+				t.Fatalf("Reopened backend holds %v, expected %v", got, expected(tables))
+			}
+			if problems := b.(Checker).Check(); len(problems) > 0 {
+				t.Errorf("Check found %v", problems)
+			}
+
+			if err := b.Rekey("changed"); err != nil {
+				t.Fatalf("Rekey: %v", err)
+			}
+			if got := contents(t, b); !reflect.DeepEqual(got, expected(tables)) {
+				t.Fatalf("Rekeyed backend holds %v, expected %v", got, expected(tables))
+			}
+			b.Close()
+			read("secret", crypt.ErrWrongPassphrase)
+			b = read("changed", nil)
+			if got := contents(t, b); !reflect.DeepEqual(got, expected(tables)) {
+				t.Fatalf("Backend reopened after rekeying holds %v, expected %v", got, expected(tables))
+			}
+
+			// An empty passphrase decrypts the file
+			if err := b.Rekey(""); err != nil {
+				t.Fatalf("Rekey: %v", err)
+			}
+			b.Close()
+			if !stored() {
+				t.Error("The decrypted file does not hold the rows in plaintext")
+			}
+			read("changed", crypt.ErrNotEncrypted)
+			b = read("", nil)
+			defer b.Close()
+			if got := contents(t, b); !reflect.DeepEqual(got, expected(tables)) {
+				t.Errorf("Decrypted backend holds %v, expected %v", got, expected(tables))
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"sqlight/pkg/interfaces"
+)
+
+// Binary encoding of rows and schemas for page files
+//
+// A row is encoded as the number of values followed by the value of each
+// column in table order, so renaming a column leaves its rows unchanged
+// while adding or dropping one rewrites them. Each value starts with a tag
+// giving its type, which keeps integers distinct from floats across a save
+// and load. A schema is encoded as the table name, its columns and its
+// indexes, with column defaults encoded like row values.
+
+// Value tags
+const (
+	tagNull  byte = 0
+	tagInt   byte = 1
+	tagFloat byte = 2
+	tagText  byte = 3
+	tagBlob  byte = 4
+	tagFalse byte = 5
+	tagTrue  byte = 6
+)
+
+// Column flags
+const (
+	flagPrimaryKey byte = 1 << iota
+	flagNullable
+	flagUnique
+)
+
+// rowidKey encodes a rowid as a page file key that sorts in rowid order
+func rowidKey(rowid int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(rowid)^1<<63)
+}
+
+// keyRowid decodes a page file key made by rowidKey
+func keyRowid(key []byte) (int64, error) {
+	if len(key) != 8 {
+		return 0, fmt.Errorf("invalid rowid key of %d bytes", len(key))
+	}
+	return int64(binary.BigEndian.Uint64(key) ^ 1<<63), nil
+}
+
+// appendValue encodes a column value
+func appendValue(buf []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, tagNull), nil
+	case int:
+		return binary.AppendVarint(append(buf, tagInt), int64(v)), nil
+	case int64:
+		return binary.AppendVarint(append(buf, tagInt), v), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(buf, tagFloat), math.Float64bits(v)), nil
+	case string:
+		return appendString(append(buf, tagText), v), nil
+	case []byte:
+		return appendString(append(buf, tagBlob), string(v)), nil
+	case bool:
+		if v {
+			return append(buf, tagTrue), nil
+		}
+		return append(buf, tagFalse), nil
+	}
+	return nil, fmt.Errorf("cannot store value of type %T", value)
+}
+
+// appendString encodes a length-prefixed string
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// encodeRecord encodes the values of a record in table column order
+func encodeRecord(table *interfaces.Table, record *interfaces.Record) ([]byte, error) {
+	buf := binary.AppendUvarint(nil, uint64(len(table.Columns)))
+	for _, col := range table.Columns {
+		var err error
+		if buf, err = appendValue(buf, record.Columns[col.Name]); err != nil {
+			return nil, fmt.Errorf("column %s: %v", col.Name, err)
+		}
+	}
+	return buf, nil
+}
+
+// decodeRecord decodes a record encoded by encodeRecord. Columns missing
+// from the encoding are NULL.
+func decodeRecord(table *interfaces.Table, data []byte) (*interfaces.Record, error) {
+	dec := &decoder{buf: data}
+	count := dec.uvarint()
+	if count > len(table.Columns) {
+		return nil, fmt.Errorf("row has %d values for %d columns", count, len(table.Columns))
+	}
+
+	record := &interfaces.Record{
+		Columns: make(map[string]interface{}, len(table.Columns)),
+	}
+	for i, col := range table.Columns {
+		var value interface{}
+		if i < count {
+			value = dec.value()
+		}
+		record.Columns[col.Name] = value
+	}
+	return record, dec.finish()
+}
+
+// encodeSchema encodes the definition of a table, without its rows
+func encodeSchema(table *interfaces.Table) ([]byte, error) {
+	buf := appendString(nil, table.Name)
+	buf = binary.AppendUvarint(buf, uint64(len(table.Columns)))
+	for _, col := range table.Columns {
+		var flags byte
+		if col.PrimaryKey {
+			flags |= flagPrimaryKey
+		}
+		if col.Nullable {
+			flags |= flagNullable
+		}
+		if col.Unique {
+			flags |= flagUnique
+		}
+		buf = appendString(buf, col.Name)
+		buf = appendString(buf, col.Type)
+		buf = append(buf, flags)
+
+		var err error
+		if buf, err = appendValue(buf, col.Default); err != nil {
+			return nil, fmt.Errorf("default of column %s: %v", col.Name, err)
+		}
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(table.Indexes)))
+	for _, index := range table.Indexes {
+		var flags byte
+		if index.Unique {
+			flags |= flagUnique
+		}
+		buf = appendString(buf, index.Name)
+		buf = append(buf, flags)
+		buf = binary.AppendUvarint(buf, uint64(len(index.Columns)))
+		for _, col := range index.Columns {
+			buf = appendString(buf, col)
+		}
+	}
+	return buf, nil
+}
+
+// decodeSchema decodes a table definition encoded by encodeSchema
+func decodeSchema(data []byte) (*interfaces.Table, error) {
+	dec := &decoder{buf: data}
+	table := &interfaces.Table{Name: dec.string()}
+
+	for i, count := 0, dec.uvarint(); i < count && dec.err == nil; i++ {
+		col := interfaces.Column{
+			Name: dec.string(),
+			Type: dec.string(),
+		}
+		flags := dec.byte()
+		col.PrimaryKey = flags&flagPrimaryKey != 0
+		col.Nullable = flags&flagNullable != 0
+		col.Unique = flags&flagUnique != 0
+		col.Default = dec.value()
+		table.Columns = append(table.Columns, col)
+	}
+
+	for i, count := 0, dec.uvarint(); i < count && dec.err == nil; i++ {
+		index := interfaces.Index{Name: dec.string()}
+		index.Unique = dec.byte()&flagUnique != 0
+		for j, columns := 0, dec.uvarint(); j < columns && dec.err == nil; j++ {
+			index.Columns = append(index.Columns, dec.string())
+		}
+		table.Indexes = append(table.Indexes, index)
+	}
+	return table, dec.finish()
+}
+
+// decoder reads encoded values, recording the first error
+type decoder struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (dec *decoder) fail(format string, args ...interface{}) {
+	if dec.err == nil {
+		dec.err = fmt.Errorf(format, args...)
+	}
+	dec.pos = len(dec.buf)
+}
+
+// finish returns the first error, or an error if data is left over
+func (dec *decoder) finish() error {
+	if dec.err == nil && dec.pos != len(dec.buf) {
+		dec.fail("%d bytes of trailing data", len(dec.buf)-dec.pos)
+	}
+	return dec.err
+}
+
+func (dec *decoder) byte() byte {
+	if dec.pos >= len(dec.buf) {
+		dec.fail("unexpected end of data")
+		return 0
+	}
+	dec.pos++
+	return dec.buf[dec.pos-1]
+}
+
+func (dec *decoder) uvarint() int {
+	v, n := binary.Uvarint(dec.buf[dec.pos:])
+	if n <= 0 || v > uint64(len(dec.buf)) {
+		dec.fail("invalid length at offset %d", dec.pos)
+		return 0
+	}
+	dec.pos += n
+	return int(v)
+}
+
+func (dec *decoder) bytes(n int) []byte {
+	if dec.pos+n > len(dec.buf) {
+		dec.fail("unexpected end of data")
+		return nil
+	}
+	dec.pos += n
+	return dec.buf[dec.pos-n : dec.pos]
+}
+
+func (dec *decoder) string() string {
+	return string(dec.bytes(dec.uvarint()))
+}
+
+// value decodes a value encoded by appendValue
+func (dec *decoder) value() interface{} {
+	switch tag := dec.byte(); tag {
+	case tagNull:
+		return nil
+	case tagInt:
+		v, n := binary.Varint(dec.buf[dec.pos:])
+		if n <= 0 {
+			dec.fail("invalid integer at offset %d", dec.pos)
+			return nil
+		}
+		dec.pos += n
+		return int(v)
+	case tagFloat:
+		if b := dec.bytes(8); b != nil {
+			return math.Float64frombits(binary.BigEndian.Uint64(b))
+		}
+		return nil
+	case tagText:
+		return dec.string()
+	case tagBlob:
+		return append([]byte(nil), dec.bytes(dec.uvarint())...)
+	case tagFalse:
+		return false
+	case tagTrue:
+		return true
+	default:
+		if dec.err == nil {
+			dec.fail("invalid value tag %d", tag)
+		}
+		return nil
+	}
+}
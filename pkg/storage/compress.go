@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"sqlight/pkg/interfaces"
+)
+
+// Compressed JSON files
+//
+// Most of a JSON file is the column names repeated in every row, which
+// compress well. The tables of a compressed file (see format.go) are one
+// block per table in name order: the table's name and its compact JSON
+// encoding, with its checksum, compressed with DEFLATE, each written as a
+// uvarint length and the bytes. Each block decompresses on its own, so a
+// damaged block loses only its table, which is listed without columns.
+
+// compressTables encodes tables as the tables of a compressed file
+func compressTables(tables map[string]*interfaces.Table) ([]byte, error) {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	var block bytes.Buffer
+	w, err := flate.NewWriter(&block, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		encoded, err := signTable(tables[name])
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(encoded)
+		if err != nil {
+			return nil, err
+		}
+		block.Reset()
+		w.Reset(&block)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		writeBytes(&buf, []byte(name))
+		writeBytes(&buf, block.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressTables decodes tables encoded by compressTables, returning the
+// error of each table that is damaged, by name, separately
+func decompressTables(data []byte) (map[string]*interfaces.Table, map[string]error, error) {
+	r := bytes.NewReader(data)
+	tables := make(map[string]*interfaces.Table)
+	damaged := make(map[string]error)
+	for r.Len() > 0 {
+		name, err := readBytes(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		block, err := readBytes(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("table %s: %v", name, err)
+		}
+
+		var encoded *jsonTable
+		plain, err := io.ReadAll(flate.NewReader(bytes.NewReader(block)))
+		if err == nil {
+			err = json.Unmarshal(plain, &encoded)
+		}
+		if err == nil && encoded == nil {
+			err = errors.New("no definition")
+		}
+		if err != nil {
+			damaged[string(name)] = fmt.Errorf("table %s is damaged: %v", name, err)
+			tables[string(name)] = &interfaces.Table{Name: string(name)}
+			continue
+		}
+		if err := checkTable(string(name), encoded); err != nil {
+			damaged[string(name)] = err
+		}
+		tables[string(name)] = decodeTable(encoded)
+	}
+	return tables, damaged, nil
+}
+
+// writeBytes writes data preceded by its length
+func writeBytes(buf *bytes.Buffer, data []byte) {
+	buf.Write(binary.AppendUvarint(nil, uint64(len(data))))
+	buf.Write(data)
+}
+
+// readBytes reads data written by writeBytes
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return nil, errors.New("file is truncated")
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
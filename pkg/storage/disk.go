@@ -1,45 +1,310 @@
 package storage
 
 import (
-	"encoding/json"
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
-	"sqlight/pkg/db"
+
+	"sqlight/pkg/crypt"
+	"sqlight/pkg/interfaces"
 )
 
-// TableData represents the structure we want to save
-type TableData struct {
-	Tables map[string]*db.Table `json:"tables"`
+// SaveToFile saves tables to a JSON file with WriteFile, so a failed save
+// leaves the previous file in place, optionally keeping it as a backup
+func SaveToFile(filename string, tables map[string]*interfaces.Table, backup bool) error {
+	encoded, err := MarshalTables(tables)
+	if err != nil {
+		return err
+	}
+	data, err := encodeFile(encoded, false, nil, crypt.Params{})
+	if err != nil {
+		return err
+	}
+	return WriteFile(filename, data, backup)
 }
 
-func SaveToFile(filename string, database *db.Database) error {
-	data := TableData{
-		Tables: database.Tables(),
+// LoadFromFile loads the tables saved to a file by SaveToFile, or to a
+// JSON file that is not encrypted
+func LoadFromFile(filename string) (map[string]*interfaces.Table, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
 	}
+	return UnmarshalTables(data)
+}
+
+// JSONBackend stores tables in a JSON file, which is rewritten whole by
+// every sync, optionally compressed, and encrypted whole if the backend has
+// a passphrase
+type JSONBackend struct {
+	path     string
+	backup   bool
+	compress bool                         // set by SetCompression for a new file, or as the file was written
+	tables   map[string]*interfaces.Table // the tables read from the file
+	damaged  map[string]error             // tables that failed their checksum
+	written  map[string]*interfaces.Table // the tables to save on sync
+	readOnly error                        // why the file cannot be written, if it cannot
 
-	file, err := os.Create(filename)
+	passphrase string
+	key        *crypt.Key // set if the file is encrypted
+	params     crypt.Params
+}
+
+// NewJSONBackend returns a backend for the JSON file at path
+func NewJSONBackend(path string) *JSONBackend {
+	return &JSONBackend{path: path}
+}
+
+// KeepBackups sets whether a sync keeps the previous version of the file
+// in a ".bak" file next to it
+func (b *JSONBackend) KeepBackups(keep bool) {
+	b.backup = keep
+}
+
+// SetCompression sets whether a new file is created compressed. An
+// existing file is always written in the format it was read in.
+func (b *JSONBackend) SetCompression(compress bool) {
+	b.compress = compress
+}
+
+// SetPassphrase sets the passphrase of the file, which must be set before
+// Open: a new file is created encrypted under it, and an existing file must
+// have been encrypted under it
+func (b *JSONBackend) SetPassphrase(passphrase string) {
+	b.passphrase = passphrase
+}
+
+// Open reads the file, if it exists; a new file is created by the first
+// sync. A table that fails its checksum is listed, but reading its rows
+// fails. A file of an older format version is upgraded, and is read-only
+// if that fails.
+func (b *JSONBackend) Open() error {
+	b.tables, b.damaged, b.readOnly = make(map[string]*interfaces.Table), nil, nil
+	data, err := os.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		b.key, b.params, err = newKey(b.passphrase)
+		return err
+	}
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	file, err := b.read(data)
+	if err != nil {
+		return err
+	}
+	b.compress = file.header.Compressed
+	if b.tables, b.damaged, err = file.decode(); err != nil {
+		return err
+	}
+	if from := file.header.Version; from < JSONFormatVersion {
+		if err := b.migrate(file); err != nil {
+			b.readOnly = fmt.Errorf("%w: upgrading it from format version %d failed: %v", ErrReadOnly, from, err)
+		}
+	}
+	return nil
+}
+
+// ReadOnly returns why the file cannot be written, or nil if it can
+func (b *JSONBackend) ReadOnly() error {
+	return b.readOnly
+}
 
-	encoder := json.NewEncoder(file)
-	return encoder.Encode(data)
+// newKey derives a key for a passphrase with new parameters, returning no
+// key for an empty passphrase
+func newKey(passphrase string) (*crypt.Key, crypt.Params, error) {
+	if passphrase == "" {
+		return nil, crypt.Params{}, nil
+	}
+	params, err := crypt.NewParams()
+	if err != nil {
+		return nil, params, err
+	}
+	key, err := crypt.DeriveKey(passphrase, params)
+	return key, params, err
 }
 
-func LoadFromFile(filename string) (*db.Database, error) {
-	file, err := os.Open(filename)
+// read parses the contents of the file and decrypts its tables, deriving
+// the key of an encrypted file from the passphrase
+func (b *JSONBackend) read(data []byte) (*jsonFile, error) {
+	file, err := parseFile(data)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	if !file.header.Encrypted {
+		if b.passphrase != "" {
+			return nil, crypt.ErrNotEncrypted
+		}
+		return file, nil
+	}
+	if b.passphrase == "" {
+		return nil, crypt.ErrPassphraseRequired
+	}
 
-	var data TableData
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&data); err != nil {
+	params, err := crypt.DecodeParams(file.header.Key)
+	if err != nil {
 		return nil, err
 	}
+	key := b.key
+	if key == nil || params != b.params {
+		if key, err = crypt.DeriveKey(b.passphrase, params); err != nil {
+			return nil, err
+		}
+	}
+	tables, err := key.Open(file.tables, file.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("%w, or the file is damaged", crypt.ErrWrongPassphrase)
+	}
+	// Encrypted files of version 1 say they are compressed inside
+	if file.header.Version == 1 && bytes.HasPrefix(tables, []byte(legacyCompressedMagic)) {
+		file.header.Compressed = true
+		tables = tables[len(legacyCompressedMagic):]
+	}
+	file.tables = tables
+	b.key, b.params = key, params
+	return file, nil
+}
 
-	database := db.NewDatabase(filename)
-	database.SetTables(data.Tables)
-	return database, nil
+// LoadCatalog returns the definition of each table in the file
+func (b *JSONBackend) LoadCatalog() (map[string]*interfaces.Table, error) {
+	tables := make(map[string]*interfaces.Table, len(b.tables))
+	for name, table := range b.tables {
+		copied := *table
+		copied.Records = nil
+		tables[name] = &copied
+	}
+	return tables, nil
+}
+
+// ReadTable returns a copy of each row of a table in the order they were
+// saved, numbering them from 1
+func (b *JSONBackend) ReadTable(name string, fn func(rowid int64, record *interfaces.Record) error) error {
+	table, exists := b.tables[name]
+	if !exists {
+		return fmt.Errorf("table %s is not in the file", name)
+	}
+	if err := b.damaged[name]; err != nil {
+		return err
+	}
+	for i, record := range table.Records {
+		if err := fn(int64(i+1), copyRecord(record)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTables gathers the rows of every table to save on the next sync
+func (b *JSONBackend) WriteTables(tables []*TableData) error {
+	b.written = nil
+	if b.readOnly != nil {
+		return b.readOnly
+	}
+	written, err := collectTables(tables)
+	if err != nil {
+		return err
+	}
+	b.written = written
+	return nil
+}
+
+// Sync replaces the file with the tables last written
+func (b *JSONBackend) Sync() error {
+	if b.written == nil {
+		return nil
+	}
+	written := b.written
+	b.written = nil
+	encoded, err := encodeTables(written, b.compress)
+	if err != nil {
+		return err
+	}
+	data, err := encodeFile(encoded, b.compress, b.key, b.params)
+	if err != nil {
+		return err
+	}
+	return WriteFile(b.path, data, b.backup)
+}
+
+// Rekey rewrites the file encrypted under a new passphrase, or not
+// encrypted if it is empty. The backup of the file, which holds it as
+// encrypted under the old passphrase, is removed.
+func (b *JSONBackend) Rekey(passphrase string) error {
+	if b.readOnly != nil {
+		return b.readOnly
+	}
+	if b.written != nil {
+		return errors.New("cannot rekey with unsynced writes")
+	}
+	data, err := os.ReadFile(b.path)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	key, params, err := newKey(passphrase)
+	if err != nil {
+		return err
+	}
+	if exists {
+		file, err := b.read(data)
+		if err != nil {
+			return err
+		}
+		if data, err = encodeFile(file.tables, file.header.Compressed, key, params); err != nil {
+			return err
+		}
+		if err := WriteFile(b.path, data, false); err != nil {
+			return err
+		}
+		if err := os.Remove(b.path + ".bak"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	b.passphrase, b.key, b.params = passphrase, key, params
+	return nil
+}
+
+// Check reads the file again, verifying the checksum of every table
+func (b *JSONBackend) Check() []error {
+	data, err := os.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	var file *jsonFile
+	if err == nil {
+		file, err = b.read(data)
+	}
+	if err != nil {
+		return []error{err}
+	}
+	_, damaged, err := file.decode()
+	if err != nil {
+		return []error{err}
+	}
+	return sortedErrors(damaged)
+}
+
+// Close discards any unsynced write
+func (b *JSONBackend) Close() error {
+	b.written = nil
+	return nil
+}
+
+// collectTables returns a copy of each table with its rows, in key order
+func collectTables(tables []*TableData) (map[string]*interfaces.Table, error) {
+	collected := make(map[string]*interfaces.Table, len(tables))
+	for _, data := range tables {
+		table := *data.Table
+		table.Records = make([]*interfaces.Record, 0)
+		err := data.Rows(func(rowid int64, record *interfaces.Record) error {
+			table.Records = append(table.Records, record)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %v", data.Name, err)
+		}
+		collected[data.Name] = &table
+	}
+	return collected, nil
 }
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"sqlight/pkg/crypt"
+	"sqlight/pkg/interfaces"
+	"sqlight/pkg/version"
+)
+
+// JSON file format
+//
+// A JSON file starts with a header recording the format version, the
+// version of SQLight that created the file or last upgraded its format, the
+// page size, which is 0 as JSON files are not paged, and how the tables are
+// encoded. A file holding its tables as plain JSON stays a JSON document,
+// an object holding the header and the tables:
+//
+//	{"Header": {"Magic": "SQLight", "Version": 2, ...}, "Tables": {...}}
+//
+// A compressed or encrypted file starts with jsonMagic, followed by the
+// header's JSON as a uvarint length and the bytes, and then the tables,
+// compressed (see compress.go) if the header says so. An encrypted file's
+// header holds the parameters of its key, and the tables are sealed with
+// the bytes before them as additional data.
+//
+// Files of format version 1 have no header: they hold a bare JSON object of
+// tables, or the same compressed or encrypted behind a magic string of
+// their own. Opening one upgrades it (see migrate.go).
+
+const (
+	// JSONFormatVersion is the version of the JSON file format written by
+	// this package
+	JSONFormatVersion = 2
+
+	jsonMagic   = "SQLight JSON\x00\x00\x00\x00"
+	headerMagic = "SQLight"
+
+	// The magic strings of compressed and encrypted files of version 1
+	legacyCompressedMagic = "SQLight deflate\x00"
+	legacyEncryptedMagic  = "SQLight crypt\x00\x00\x00"
+)
+
+// fileHeader is the header of a JSON file
+type fileHeader struct {
+	Magic      string
+	Version    uint32
+	Creator    string `json:",omitempty"`
+	PageSize   int
+	Compressed bool   `json:",omitempty"`
+	Encrypted  bool   `json:",omitempty"`
+	Key        []byte `json:",omitempty"` // the crypt.Params of an encrypted file
+}
+
+// jsonFile is the contents of a JSON file split into its header and tables
+type jsonFile struct {
+	header fileHeader
+	prefix []byte // the bytes before the tables, which they are sealed with
+	tables []byte // the tables, compressed or sealed as the header says
+}
+
+// parseFile splits the contents of a JSON file into its header and tables,
+// failing for a file of a newer format version
+func parseFile(data []byte) (*jsonFile, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte(jsonMagic)):
+		r := bytes.NewReader(data[len(jsonMagic):])
+		raw, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		start := len(data) - r.Len()
+		file := &jsonFile{prefix: data[:start], tables: data[start:]}
+		if err := json.Unmarshal(raw, &file.header); err != nil || file.header.Magic != headerMagic {
+			return nil, errors.New("invalid file header")
+		}
+		return file, file.checkVersion()
+
+	case bytes.HasPrefix(data, []byte(legacyCompressedMagic)):
+		return &jsonFile{
+			header: fileHeader{Version: 1, Compressed: true},
+			tables: data[len(legacyCompressedMagic):],
+		}, nil
+
+	case bytes.HasPrefix(data, []byte(legacyEncryptedMagic)):
+		start := len(legacyEncryptedMagic) + crypt.ParamsSize
+		if len(data) < start {
+			return nil, errors.New("encrypted file is truncated")
+		}
+		return &jsonFile{
+			header: fileHeader{Version: 1, Encrypted: true, Key: data[len(legacyEncryptedMagic):start]},
+			prefix: data[:start],
+			tables: data[start:],
+		}, nil
+	}
+
+	var document struct {
+		Header *fileHeader
+		Tables json.RawMessage
+	}
+	if json.Unmarshal(data, &document) == nil && document.Header != nil && document.Header.Magic == headerMagic {
+		file := &jsonFile{header: *document.Header, tables: document.Tables}
+		return file, file.checkVersion()
+	}
+	return &jsonFile{header: fileHeader{Version: 1}, tables: data}, nil
+}
+
+// checkVersion checks the format version recorded in a file's header
+func (f *jsonFile) checkVersion() error {
+	if f.header.Version > JSONFormatVersion {
+		return &version.NewerFormatError{
+			Kind:    "JSON file",
+			Format:  f.header.Version,
+			Newest:  JSONFormatVersion,
+			Creator: f.header.Creator,
+		}
+	}
+	if f.header.Version < 2 {
+		return fmt.Errorf("invalid JSON file version %d", f.header.Version)
+	}
+	return nil
+}
+
+// decode decodes the tables of a file, which must be in the clear,
+// returning the error of each damaged table separately
+func (f *jsonFile) decode() (map[string]*interfaces.Table, map[string]error, error) {
+	if f.header.Compressed {
+		return decompressTables(f.tables)
+	}
+	return decodeTables(f.tables)
+}
+
+// encodeFile returns the contents of a JSON file in the current format
+// holding tables encoded by encodeTables, sealed under key if it is set
+func encodeFile(tables []byte, compressed bool, key *crypt.Key, params crypt.Params) ([]byte, error) {
+	header := fileHeader{
+		Magic:      headerMagic,
+		Version:    JSONFormatVersion,
+		Creator:    version.String(),
+		Compressed: compressed,
+		Encrypted:  key != nil,
+	}
+	if !compressed && key == nil {
+		return json.MarshalIndent(struct {
+			Header fileHeader
+			Tables json.RawMessage
+		}{header, tables}, "", "  ")
+	}
+
+	if key != nil {
+		header.Key = params.Encode()
+	}
+	raw, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBufferString(jsonMagic)
+	writeBytes(buf, raw)
+	if key != nil {
+		tables = key.Seal(tables, buf.Bytes())
+	}
+	buf.Write(tables)
+	return buf.Bytes(), nil
+}
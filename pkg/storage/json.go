@@ -0,0 +1,357 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sqlight/pkg/crypt"
+	"sqlight/pkg/interfaces"
+)
+
+// JSON encoding of tables
+//
+// Tables are saved as a JSON object mapping each table name to its schema
+// and rows. JSON has a single number type, so integers and floats are
+// written as an object tagging the value with its type, {"int": 5} or
+// {"float": 2.5}, as are blobs, which would otherwise read back as text:
+// {"blob": "<base64>"}. Floats JSON cannot represent, NaN and the
+// infinities, are written as strings. Text, booleans and NULL are written
+// as themselves.
+//
+// Files written before values were tagged hold bare numbers, which read
+// back through the type of their column: as a float64 in a REAL, FLOAT or
+// DOUBLE column, as an int in an INT column when they are whole, even if
+// written as 2.0, and in any other column as an int when they are written
+// without a fraction and as a float64 otherwise.
+//
+// Each table records a checksum, the CRC-32C of its compact encoding
+// without the checksum, which is verified when the file is read, so a
+// damaged table is found even when it is still valid JSON. Tables saved
+// before checksums were added have none and are read unchecked.
+//
+// A file holds the tables after a header, either as JSON or compressed
+// (see format.go).
+
+// jsonTable is the saved form of a table
+type jsonTable struct {
+	Name    string
+	Columns []jsonColumn
+	Records []*jsonRecord
+	Indexes []interfaces.Index `json:",omitempty"`
+
+	Checksum string `json:",omitempty"`
+}
+
+// jsonColumn is the saved form of a column
+type jsonColumn struct {
+	Name       string
+	Type       string
+	PrimaryKey bool
+	Nullable   bool
+	Unique     bool
+	Default    jsonValue
+}
+
+// jsonRecord is the saved form of a row
+type jsonRecord struct {
+	Columns map[string]jsonValue
+}
+
+// jsonValue is a column value that keeps its type through JSON
+type jsonValue struct {
+	value interface{}
+	bare  json.Number // the number read, if it was not tagged
+}
+
+// MarshalTables encodes tables as indented JSON
+func MarshalTables(tables map[string]*interfaces.Table) ([]byte, error) {
+	encoded := make(map[string]*jsonTable, len(tables))
+	for name, table := range tables {
+		var err error
+		if encoded[name], err = signTable(table); err != nil {
+			return nil, err
+		}
+	}
+	return json.MarshalIndent(encoded, "", "  ")
+}
+
+// encodeTables encodes tables as MarshalTables does, or compressed
+func encodeTables(tables map[string]*interfaces.Table, compress bool) ([]byte, error) {
+	if compress {
+		return compressTables(tables)
+	}
+	return MarshalTables(tables)
+}
+
+// UnmarshalTables decodes the tables of a file that is not encrypted, as
+// encoded by MarshalTables and SaveToFile, compressed, or saved in an older
+// format. A table that fails its checksum is an error.
+func UnmarshalTables(data []byte) (map[string]*interfaces.Table, error) {
+	file, err := parseFile(data)
+	if err != nil {
+		return nil, err
+	}
+	if file.header.Encrypted {
+		return nil, crypt.ErrPassphraseRequired
+	}
+	tables, damaged, err := file.decode()
+	if err != nil {
+		return nil, err
+	}
+	if problems := sortedErrors(damaged); len(problems) > 0 {
+		return nil, problems[0]
+	}
+	return tables, nil
+}
+
+// decodeTables decodes tables encoded by MarshalTables, returning the
+// error of each table that fails its checksum, by name, separately
+func decodeTables(data []byte) (map[string]*interfaces.Table, map[string]error, error) {
+	var encoded map[string]*jsonTable
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, nil, err
+	}
+	tables := make(map[string]*interfaces.Table, len(encoded))
+	damaged := make(map[string]error)
+	for name, table := range encoded {
+		if table == nil {
+			return nil, nil, fmt.Errorf("table %s has no definition", name)
+		}
+		if err := checkTable(name, table); err != nil {
+			damaged[name] = err
+		}
+		tables[name] = decodeTable(table)
+	}
+	return tables, damaged, nil
+}
+
+// signTable returns the saved form of a table with its checksum
+func signTable(table *interfaces.Table) (*jsonTable, error) {
+	encoded := encodeTable(table)
+	sum, err := encoded.checksum()
+	if err != nil {
+		return nil, err
+	}
+	encoded.Checksum = sum
+	return encoded, nil
+}
+
+// checkTable verifies the checksum of a saved table, if it has one
+func checkTable(name string, table *jsonTable) error {
+	if table.Checksum == "" {
+		return nil
+	}
+	if sum, err := table.checksum(); err != nil || sum != table.Checksum {
+		return fmt.Errorf("table %s fails its checksum", name)
+	}
+	return nil
+}
+
+// checksum returns the checksum of a saved table
+func (t *jsonTable) checksum() (string, error) {
+	unsigned := *t
+	unsigned.Checksum = ""
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%08x", crc32.Checksum(data, castagnoli)), nil
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// sortedErrors returns the errors of a map in the order of their keys
+func sortedErrors(errs map[string]error) []error {
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sorted := make([]error, len(names))
+	for i, name := range names {
+		sorted[i] = errs[name]
+	}
+	return sorted
+}
+
+// encodeTable returns the saved form of a table
+func encodeTable(table *interfaces.Table) *jsonTable {
+	encoded := &jsonTable{Name: table.Name, Indexes: table.Indexes}
+	if table.Columns != nil {
+		encoded.Columns = make([]jsonColumn, len(table.Columns))
+		for i, col := range table.Columns {
+			encoded.Columns[i] = jsonColumn{
+				Name:       col.Name,
+				Type:       col.Type,
+				PrimaryKey: col.PrimaryKey,
+				Nullable:   col.Nullable,
+				Unique:     col.Unique,
+				Default:    jsonValue{value: col.Default},
+			}
+		}
+	}
+	if table.Records != nil {
+		encoded.Records = make([]*jsonRecord, len(table.Records))
+		for i, record := range table.Records {
+			encoded.Records[i] = &jsonRecord{}
+			if record.Columns != nil {
+				encoded.Records[i].Columns = make(map[string]jsonValue, len(record.Columns))
+				for name, value := range record.Columns {
+					encoded.Records[i].Columns[name] = jsonValue{value: value}
+				}
+			}
+		}
+	}
+	return encoded
+}
+
+// decodeTable returns the table saved as encoded
+func decodeTable(encoded *jsonTable) *interfaces.Table {
+	table := &interfaces.Table{Name: encoded.Name, Indexes: encoded.Indexes}
+	if encoded.Columns != nil {
+		table.Columns = make([]interfaces.Column, len(encoded.Columns))
+		for i, col := range encoded.Columns {
+			table.Columns[i] = interfaces.Column{
+				Name:       col.Name,
+				Type:       col.Type,
+				PrimaryKey: col.PrimaryKey,
+				Nullable:   col.Nullable,
+				Unique:     col.Unique,
+				Default:    col.Default.typed(col.Type),
+			}
+		}
+	}
+	if encoded.Records != nil {
+		types := make(map[string]string, len(encoded.Columns))
+		for _, col := range encoded.Columns {
+			types[col.Name] = col.Type
+		}
+		table.Records = make([]*interfaces.Record, len(encoded.Records))
+		for i, record := range encoded.Records {
+			table.Records[i] = &interfaces.Record{}
+			if record != nil && record.Columns != nil {
+				table.Records[i].Columns = make(map[string]interface{}, len(record.Columns))
+				for name, value := range record.Columns {
+					table.Records[i].Columns[name] = value.typed(types[name])
+				}
+			}
+		}
+	}
+	return table
+}
+
+// MarshalJSON encodes the value, tagging numbers and blobs with their type
+func (v jsonValue) MarshalJSON() ([]byte, error) {
+	switch value := v.value.(type) {
+	case nil, string, bool:
+		return json.Marshal(value)
+	case int:
+		return []byte(`{"int":` + strconv.FormatInt(int64(value), 10) + `}`), nil
+	case int64:
+		return []byte(`{"int":` + strconv.FormatInt(value, 10) + `}`), nil
+	case float64:
+		text := strconv.FormatFloat(value, 'g', -1, 64)
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			text = `"` + text + `"`
+		}
+		return []byte(`{"float":` + text + `}`), nil
+	case []byte:
+		return json.Marshal(map[string][]byte{"blob": value})
+	}
+	return nil, fmt.Errorf("cannot save value of type %T", v.value)
+}
+
+// UnmarshalJSON decodes a value encoded by MarshalJSON, or a bare number
+// saved before values were tagged
+func (v *jsonValue) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var raw interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return err
+	}
+
+	switch value := raw.(type) {
+	case nil, string, bool:
+		v.value = value
+		return nil
+	case json.Number:
+		v.bare = value
+		if i, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+			v.value = int(i)
+			return nil
+		}
+		f, err := strconv.ParseFloat(string(value), 64)
+		v.value = f
+		return err
+	case map[string]interface{}:
+		if len(value) == 1 {
+			return v.decodeTagged(value)
+		}
+	}
+	return fmt.Errorf("invalid value %s", data)
+}
+
+// typed returns the value as read from a column of the given type,
+// converting a bare number to the type's kind of number
+func (v jsonValue) typed(columnType string) interface{} {
+	if v.bare == "" {
+		return v.value
+	}
+	f, err := v.bare.Float64()
+	if err != nil {
+		return v.value
+	}
+	upper := strings.ToUpper(columnType)
+	switch {
+	case strings.Contains(upper, "INT"):
+		if _, isInt := v.value.(int); !isInt && f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return int(f)
+		}
+	case strings.Contains(upper, "REAL"), strings.Contains(upper, "FLOA"), strings.Contains(upper, "DOUB"):
+		return f
+	}
+	return v.value
+}
+
+// decodeTagged decodes a value tagged with its type
+func (v *jsonValue) decodeTagged(tagged map[string]interface{}) error {
+	for tag, raw := range tagged {
+		switch tag {
+		case "int":
+			if n, ok := raw.(json.Number); ok {
+				i, err := strconv.ParseInt(string(n), 10, 64)
+				v.value = int(i)
+				return err
+			}
+		case "float":
+			switch f := raw.(type) {
+			case json.Number:
+				value, err := strconv.ParseFloat(string(f), 64)
+				v.value = value
+				return err
+			case string:
+				value, err := strconv.ParseFloat(f, 64)
+				v.value = value
+				return err
+			}
+		case "blob":
+			if s, ok := raw.(string); ok {
+				value, err := base64.StdEncoding.DecodeString(s)
+				v.value = value
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown value type %q", tag)
+		}
+		return fmt.Errorf("invalid %s value %v", tag, raw)
+	}
+	return nil
+}
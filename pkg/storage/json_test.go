@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sqlight/pkg/interfaces"
+)
+
+// randomValue returns a value of any type a column can hold, favouring the
+// ones JSON could confuse: whole floats, large integers and text that looks
+// like a number
+func randomValue(rng *rand.Rand) interface{} {
+	switch rng.Intn(12) {
+	case 0:
+		return nil
+	case 1:
+		return rng.Intn(2) == 0
+	case 2:
+		return rng.Intn(2000) - 1000
+	case 3:
+		return []int{math.MaxInt64, math.MinInt64, 1 << 53, 1<<53 + 1, 0}[rng.Intn(5)]
+	case 4:
+		return float64(rng.Intn(2000) - 1000)
+	case 5:
+		return rng.NormFloat64() * math.Pow(10, float64(rng.Intn(40)-20))
+	case 6:
+		return []float64{math.NaN(), math.Inf(1), math.Inf(-1), math.Copysign(0, -1), math.SmallestNonzeroFloat64, math.MaxFloat64}[rng.Intn(6)]
+	case 7:
+		return fmt.Sprint(rng.Intn(100))
+	case 8:
+		return []string{"", "naïve ☃", `{"int": 1}`, "line\nbreak"}[rng.Intn(4)]
+	case 9:
+		blob := make([]byte, rng.Intn(8))
+		rng.Read(blob)
+		return blob
+	default:
+		return fmt.Sprintf("text %d", rng.Int())
+	}
+}
+
+// sameValue reports whether two values have the same type and value,
+// treating NaN as equal to itself and telling 0 from -0
+func sameValue(a, b interface{}) bool {
+	if fa, ok := a.(float64); ok {
+		fb, ok := b.(float64)
+		return ok && (math.Float64bits(fa) == math.Float64bits(fb) || math.IsNaN(fa) && math.IsNaN(fb))
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func TestTablesRoundTripKeepsValueTypes(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for round := 0; round < 200; round++ {
+		table := &interfaces.Table{
+			Name:    "t",
+			Columns: []interfaces.Column{{Name: "a", Type: "INT", Default: randomValue(rng)}, {Name: "b", Type: "FLOAT", Nullable: true}},
+			Indexes: []interfaces.Index{{Name: "idx_a", Columns: []string{"a"}, Unique: true}},
+		}
+		for i := rng.Intn(10); i > 0; i-- {
+			table.Records = append(table.Records, &interfaces.Record{Columns: map[string]interface{}{
+				"a": randomValue(rng),
+				"b": randomValue(rng),
+			}})
+		}
+
+		data, err := MarshalTables(map[string]*interfaces.Table{"t": table})
+		if err != nil {
+			t.Fatalf("MarshalTables: %v", err)
+		}
+		tables, err := UnmarshalTables(data)
+		if err != nil {
+			t.Fatalf("UnmarshalTables: %v\n%s", err, data)
+		}
+
+		loaded := tables["t"]
+		if !sameValue(loaded.Columns[0].Default, table.Columns[0].Default) {
+			t.Errorf("Default %#v loaded as %#v", table.Columns[0].Default, loaded.Columns[0].Default)
+		}
+		if !reflect.DeepEqual(loaded.Indexes, table.Indexes) {
+			t.Errorf("Indexes %v loaded as %v", table.Indexes, loaded.Indexes)
+		}
+		if len(loaded.Records) != len(table.Records) {
+			t.Fatalf("Loaded %d records, expected %d", len(loaded.Records), len(table.Records))
+		}
+		for i, record := range table.Records {
+			for name, value := range record.Columns {
+				if got := loaded.Records[i].Columns[name]; !sameValue(got, value) {
+					t.Errorf("Value %#v loaded as %#v", value, got)
+				}
+			}
+		}
+	}
+}
+
+func TestUntaggedValuesLoad(t *testing.T) {
+	tables, err := UnmarshalTables([]byte(`{"t": {"Name": "t",
+		"Columns": [{"Name": "n", "Type": "INT", "Default": 5}],
+		"Records": [{"Columns": {"n": 3, "f": 2.5, "s": "3", "b": true, "z": null}}]}}`))
+	if err != nil {
+		t.Fatalf("UnmarshalTables: %v", err)
+	}
+	expected := map[string]interface{}{"n": 3, "f": 2.5, "s": "3", "b": true, "z": nil}
+	if got := tables["t"].Records[0].Columns; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Loaded %#v, expected %#v", got, expected)
+	}
+	if got := tables["t"].Columns[0].Default; got != 5 {
+		t.Errorf("Loaded default %#v, expected 5", got)
+	}
+}
+
+func TestUntaggedNumbersTakeTheirColumnType(t *testing.T) {
+	tables, err := UnmarshalTables([]byte(`{"t": {"Name": "t",
+		"Columns": [
+			{"Name": "r", "Type": "REAL", "Default": 1},
+			{"Name": "d", "Type": "DOUBLE PRECISION"},
+			{"Name": "i", "Type": "INTEGER", "Default": 1.0},
+			{"Name": "s", "Type": "TEXT"}],
+		"Records": [
+			{"Columns": {"r": 2.0, "d": 3, "i": 4.0, "s": 5.0}},
+			{"Columns": {"r": 2.5, "d": -1, "i": 4.5, "s": 5}}]}}`))
+	if err != nil {
+		t.Fatalf("UnmarshalTables: %v", err)
+	}
+	expected := []map[string]interface{}{
+		{"r": 2.0, "d": 3.0, "i": 4, "s": 5.0},
+		{"r": 2.5, "d": -1.0, "i": 4.5, "s": 5},
+	}
+	for i, record := range tables["t"].Records {
+		if !reflect.DeepEqual(record.Columns, expected[i]) {
+			t.Errorf("Loaded row %d as %#v, expected %#v", i, record.Columns, expected[i])
+		}
+	}
+	if got := tables["t"].Columns[0].Default; got != 1.0 {
+		t.Errorf("Loaded REAL default %#v, expected 1.0", got)
+	}
+	if got := tables["t"].Columns[2].Default; got != 1 {
+		t.Errorf("Loaded INTEGER default %#v, expected 1", got)
+	}
+}
+
+func TestInvalidValuesFailToLoad(t *testing.T) {
+	for _, value := range []string{`{"int": 2.5}`, `{"int": "1"}`, `{"float": true}`, `{"date": "2024"}`, `{"int": 1, "float": 1}`, `[1]`} {
+		_, err := UnmarshalTables([]byte(`{"t": {"Name": "t", "Records": [{"Columns": {"v": ` + value + `}}]}}`))
+		if err == nil {
+			t.Errorf("Expected %s to fail to load", value)
+		}
+	}
+}
+
+func TestDamagedTableFailsChecksum(t *testing.T) {
+	users := &interfaces.Table{
+		Name:    "users",
+		Columns: []interfaces.Column{{Name: "name", Type: "TEXT"}},
+		Records: []*interfaces.Record{{Columns: map[string]interface{}{"name": "alice"}}},
+	}
+	notes := &interfaces.Table{
+		Name:    "notes",
+		Columns: []interfaces.Column{{Name: "body", Type: "TEXT"}},
+		Records: []*interfaces.Record{{Columns: map[string]interface{}{"body": "kept"}}},
+	}
+	path := filepath.Join(t.TempDir(), "test.json")
+	if err := SaveToFile(path, map[string]*interfaces.Table{"users": users, "notes": notes}, false); err != nil {
+		t.Fatalf("SaveToFile: %v", err)
+	}
+	b := NewJSONBackend(path)
+	if err := b.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if problems := b.Check(); len(problems) > 0 {
+		t.Errorf("Check of an undamaged file found %v", problems)
+	}
+
+	// Change a value without breaking the JSON
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, bytes.Replace(data, []byte("alice"), []byte("alicf"), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFromFile(path); err == nil || !strings.Contains(err.Error(), "users fails its checksum") {
+		t.Errorf("LoadFromFile returned %v, expected a checksum error", err)
+	}
+	if problems := b.Check(); len(problems) != 1 {
+		t.Errorf("Check found %v, expected the damaged table", problems)
+	}
+
+	// The damaged table is listed but cannot be read; the other one can
+	b = NewJSONBackend(path)
+	if err := b.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if tables, err := b.LoadCatalog(); err != nil || len(tables) != 2 {
+		t.Errorf("LoadCatalog returned %d tables, %v", len(tables), err)
+	}
+	if err := b.ReadTable("users", func(int64, *interfaces.Record) error { return nil }); err == nil {
+		t.Error("Expected reading the damaged table to fail")
+	}
+	if err := b.ReadTable("notes", func(int64, *interfaces.Record) error { return nil }); err != nil {
+		t.Errorf("ReadTable of the undamaged table: %v", err)
+	}
+}
+
+func TestCompressedTables(t *testing.T) {
+	notes := &interfaces.Table{
+		Name:    "notes",
+		Columns: []interfaces.Column{{Name: "body", Type: "TEXT"}},
+		Records: []*interfaces.Record{{Columns: map[string]interface{}{"body": "kept"}}},
+	}
+	users := &interfaces.Table{
+		Name: "users",
+		Columns: []interfaces.Column{
+			{Name: "id", Type: "INTEGER", PrimaryKey: true},
+			{Name: "email_address", Type: "TEXT", Nullable: true},
+		},
+	}
+	for i := 0; i < 500; i++ {
+		users.Records = append(users.Records, &interfaces.Record{Columns: map[string]interface{}{
+			"id":            i,
+			"email_address": fmt.Sprintf("user%d@example.com", i),
+		}})
+	}
+	tables := map[string]*interfaces.Table{"notes": notes, "users": users}
+
+	plain, err := MarshalTables(tables)
+	if err != nil {
+		t.Fatalf("MarshalTables: %v", err)
+	}
+	data, err := encodeTables(tables, true)
+	if err != nil {
+		t.Fatalf("encodeTables: %v", err)
+	}
+	if len(data)*5 > len(plain) {
+		t.Errorf("Compressed %d bytes of JSON to %d", len(plain), len(data))
+	}
+	decoded, damaged, err := decompressTables(data)
+	if err != nil || len(damaged) > 0 {
+		t.Fatalf("decompressTables returned %v, %v", damaged, err)
+	}
+	if !reflect.DeepEqual(decoded, tables) {
+		t.Errorf("Decoded %v, expected %v", decoded, tables)
+	}
+
+	// Damage the last block, which holds users: notes still reads
+	data[len(data)-20] ^= 0xff
+	decoded, damaged, err = decompressTables(data)
+	if err != nil {
+		t.Fatalf("decompressTables: %v", err)
+	}
+	if len(damaged) != 1 || damaged["users"] == nil {
+		t.Errorf("Found damaged tables %v, expected users", damaged)
+	}
+	if !reflect.DeepEqual(decoded["notes"], notes) {
+		t.Errorf("Decoded notes as %v, expected %v", decoded["notes"], notes)
+	}
+	if _, exists := decoded["users"]; !exists {
+		t.Error("Expected the damaged table to be listed")
+	}
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+
+	"sqlight/pkg/interfaces"
+)
+
+// MemoryBackend keeps tables in memory, for databases that need not
+// outlive the process and for tests
+type MemoryBackend struct {
+	tables  map[string]*memoryTable
+	written map[string]*memoryTable // the tables to keep on sync
+}
+
+// memoryTable is a table held by a MemoryBackend
+type memoryTable struct {
+	table *interfaces.Table
+	rows  map[int64]*interfaces.Record
+}
+
+// NewMemoryBackend returns an empty in-memory backend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{tables: make(map[string]*memoryTable)}
+}
+
+// Open does nothing; the backend keeps its tables while it is in use
+func (b *MemoryBackend) Open() error {
+	return nil
+}
+
+// LoadCatalog returns the definition of each table
+func (b *MemoryBackend) LoadCatalog() (map[string]*interfaces.Table, error) {
+	tables := make(map[string]*interfaces.Table, len(b.tables))
+	for name, t := range b.tables {
+		tables[name] = copySchema(t.table)
+	}
+	return tables, nil
+}
+
+// ReadTable returns a copy of each row of a table, in rowid order
+func (b *MemoryBackend) ReadTable(name string, fn func(rowid int64, record *interfaces.Record) error) error {
+	t, exists := b.tables[name]
+	if !exists {
+		return fmt.Errorf("table %s does not exist", name)
+	}
+	rowids := make([]int64, 0, len(t.rows))
+	for rowid := range t.rows {
+		rowids = append(rowids, rowid)
+	}
+	sort.Slice(rowids, func(i, j int) bool { return rowids[i] < rowids[j] })
+	for _, rowid := range rowids {
+		if err := fn(rowid, copyRecord(t.rows[rowid])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTables copies every table, to be kept on the next sync
+func (b *MemoryBackend) WriteTables(tables []*TableData) error {
+	b.written = nil
+	written := make(map[string]*memoryTable, len(tables))
+	for _, data := range tables {
+		t := &memoryTable{table: copySchema(data.Table), rows: make(map[int64]*interfaces.Record)}
+		err := data.Rows(func(rowid int64, record *interfaces.Record) error {
+			t.rows[rowid] = copyRecord(record)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("table %s: %v", data.Name, err)
+		}
+		written[data.Name] = t
+	}
+	b.written = written
+	return nil
+}
+
+// Sync keeps the tables last written
+func (b *MemoryBackend) Sync() error {
+	if b.written != nil {
+		b.tables, b.written = b.written, nil
+	}
+	return nil
+}
+
+// Close discards any unsynced write
+func (b *MemoryBackend) Close() error {
+	b.written = nil
+	return nil
+}
+
+// copySchema returns a copy of the definition of a table, without rows
+func copySchema(table *interfaces.Table) *interfaces.Table {
+	copied := &interfaces.Table{Name: table.Name}
+	copied.Columns = append(copied.Columns, table.Columns...)
+	for _, index := range table.Indexes {
+		index.Columns = append([]string(nil), index.Columns...)
+		copied.Indexes = append(copied.Indexes, index)
+	}
+	return copied
+}
+
+// copyRecord returns a copy of a record, so later changes to either do not
+// reach the other
+func copyRecord(record *interfaces.Record) *interfaces.Record {
+	copied := &interfaces.Record{Columns: make(map[string]interface{}, len(record.Columns))}
+	for name, value := range record.Columns {
+		copied.Columns[name] = value
+	}
+	return copied
+}
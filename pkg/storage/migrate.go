@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"fmt"
+
+	"sqlight/pkg/pager"
+)
+
+// Format migrations
+//
+// Opening a file of an older format version upgrades it one version at a
+// time, through each step of the chain for its kind of file, and then
+// rewrites it whole or rewrites its header. A file that fails to upgrade is
+// left as it was and opened read-only.
+
+// jsonMigration upgrades the tables of a JSON file, in the clear and
+// compressed if the file is, from one format version to the next
+type jsonMigration struct {
+	description string
+	migrate     func(tables []byte, compressed bool) ([]byte, error)
+}
+
+// jsonMigrations are the steps upgrading JSON files, by the version each
+// upgrades from
+var jsonMigrations = map[uint32]jsonMigration{
+	1: {"add the file header", func(tables []byte, compressed bool) ([]byte, error) {
+		// Only the header is new: the tables are encoded as before
+		return tables, nil
+	}},
+}
+
+// migrate upgrades a JSON file read by Open to JSONFormatVersion and writes
+// it back in place
+func (b *JSONBackend) migrate(file *jsonFile) error {
+	tables := file.tables
+	for v := file.header.Version; v < JSONFormatVersion; v++ {
+		step, ok := jsonMigrations[v]
+		if !ok {
+			return fmt.Errorf("no upgrade from JSON file version %d", v)
+		}
+		if err := failpoint("migrate"); err != nil {
+			return fmt.Errorf("%s: %v", step.description, err)
+		}
+		var err error
+		if tables, err = step.migrate(tables, file.header.Compressed); err != nil {
+			return fmt.Errorf("%s: %v", step.description, err)
+		}
+	}
+	data, err := encodeFile(tables, file.header.Compressed, b.key, b.params)
+	if err != nil {
+		return err
+	}
+	return WriteFile(b.path, data, b.backup)
+}
+
+// pageMigration upgrades an open page file from one format version to the
+// next
+type pageMigration struct {
+	description string
+	migrate     func(b *PageBackend) error
+}
+
+// pageMigrations are the steps upgrading page files, by the version each
+// upgrades from
+var pageMigrations = map[uint32]pageMigration{
+	1: {"add page checksums", func(b *PageBackend) error {
+		// The pages of version 1 have no room for a checksum, so the trees
+		// are copied into a new file
+		return b.replace(pager.Options{Version: 2, Passphrase: b.passphrase})
+	}},
+	2: {"add header flags", upgradeHeader},
+	3: {"record the creator version", upgradeHeader},
+}
+
+// upgradeHeader upgrades a page file whose pages are unchanged in the next
+// version by rewriting its header
+func upgradeHeader(b *PageBackend) error {
+	return b.pager.Upgrade()
+}
+
+// migrate upgrades the open page file to pager.FormatVersion
+func (b *PageBackend) migrate() error {
+	if _, err := b.LoadCatalog(); err != nil {
+		return err
+	}
+	for v := b.pager.Version(); v < pager.FormatVersion; v = b.pager.Version() {
+		step, ok := pageMigrations[v]
+		if !ok {
+			return fmt.Errorf("no upgrade from page file version %d", v)
+		}
+		if err := failpoint("migrate"); err != nil {
+			return fmt.Errorf("%s: %v", step.description, err)
+		}
+		if err := step.migrate(b); err != nil {
+			return fmt.Errorf("%s: %v", step.description, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sqlight/pkg/crypt"
+	"sqlight/pkg/interfaces"
+	"sqlight/pkg/pager"
+	"sqlight/pkg/version"
+)
+
+// legacyJSONFiles returns the contents of JSON files of format version 1
+// holding tables, by how they are encoded
+func legacyJSONFiles(t *testing.T, tables map[string]*interfaces.Table, passphrase string) map[string][]byte {
+	t.Helper()
+	plain, err := MarshalTables(tables)
+	if err != nil {
+		t.Fatalf("MarshalTables: %v", err)
+	}
+	blocks, err := compressTables(tables)
+	if err != nil {
+		t.Fatalf("compressTables: %v", err)
+	}
+	compressed := append([]byte(legacyCompressedMagic), blocks...)
+
+	params, err := crypt.NewParams()
+	if err != nil {
+		t.Fatalf("NewParams: %v", err)
+	}
+	key, err := crypt.DeriveKey(passphrase, params)
+	if err != nil {
+		t.Fatalf("DeriveKey: %v", err)
+	}
+	encrypt := func(document []byte) []byte {
+		header := append([]byte(legacyEncryptedMagic), params.Encode()...)
+		return append(header, key.Seal(document, header)...)
+	}
+	return map[string][]byte{
+		"plain":                plain,
+		"compressed":           compressed,
+		"encrypted":            encrypt(plain),
+		"compressed encrypted": encrypt(compressed),
+	}
+}
+
+func TestJSONFilesMigrate(t *testing.T) {
+	cost := crypt.DefaultCost
+	crypt.DefaultCost = 10
+	defer func() { crypt.DefaultCost = cost }()
+
+	newTables := func() (*testTable, map[string]*testTable) {
+		users := newTestTable("users")
+		users.put(1, "alice@example.com")
+		users.put(2, nil)
+		return users, map[string]*testTable{"users": users}
+	}
+	_, tables := newTables()
+	for kind, data := range legacyJSONFiles(t, expected(tables), "secret") {
+		t.Run(kind, func(t *testing.T) {
+			users, tables := newTables()
+			path := filepath.Join(t.TempDir(), "test.json")
+			if err := os.WriteFile(path, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			open := func() *JSONBackend {
+				t.Helper()
+				b := NewJSONBackend(path)
+				if bytes.Contains([]byte(kind), []byte("encrypted")) {
+					b.SetPassphrase("secret")
+				}
+				if err := b.Open(); err != nil {
+					t.Fatalf("Open: %v", err)
+				}
+				return b
+			}
+
+			b := open()
+			if err := b.ReadOnly(); err != nil {
+				t.Fatalf("The upgraded file is read-only: %v", err)
+			}
+			if got := contents(t, b); !reflect.DeepEqual(got, expected(tables)) {
+				t.Fatalf("Upgraded backend holds %v, expected %v", got, expected(tables))
+			}
+			migrated, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, err := parseFile(migrated)
+			if err != nil {
+				t.Fatalf("parseFile: %v", err)
+			}
+			if file.header.Version != JSONFormatVersion || file.header.Creator != version.String() {
+				t.Errorf("Upgraded to version %d by %q, expected %d by %q",
+					file.header.Version, file.header.Creator, JSONFormatVersion, version.String())
+			}
+			if kind == "plain" && !json.Valid(migrated) {
+				t.Error("The upgraded plain file is not JSON")
+			}
+
+			// The upgraded file is written in the format it was read in
+			users.put(3, "carol@example.com")
+			write(t, b, tables)
+			b.Close()
+			b = open()
+			defer b.Close()
+			if b.compress != file.header.Compressed {
+				t.Errorf("Reopened with compression %v, expected %v", b.compress, file.header.Compressed)
+			}
+			if got := contents(t, b); !reflect.DeepEqual(got, expected(tables)) {
+				t.Errorf("Reopened backend holds %v, expected %v", got, expected(tables))
+			}
+		})
+	}
+}
+
+func TestJSONFileFailingToMigrateIsReadOnly(t *testing.T) {
+	users := newTestTable("users")
+	users.put(1, "alice@example.com")
+	tables := map[string]*testTable{"users": users}
+	path := filepath.Join(t.TempDir(), "test.json")
+	data := legacyJSONFiles(t, expected(tables), "secret")["plain"]
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	failAt(t, "migrate")
+	b := NewJSONBackend(path)
+	if err := b.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer b.Close()
+	if err := b.ReadOnly(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("ReadOnly returned %v, expected %v", err, ErrReadOnly)
+	}
+	if got := contents(t, b); !reflect.DeepEqual(got, expected(tables)) {
+		t.Errorf("Read-only backend holds %v, expected %v", got, expected(tables))
+	}
+	users.put(2, "bob@example.com")
+	if err := b.WriteTables([]*TableData{users.data("users")}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("WriteTables returned %v, expected %v", err, ErrReadOnly)
+	}
+	if err := b.Rekey("secret"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Rekey returned %v, expected %v", err, ErrReadOnly)
+	}
+	if after, err := os.ReadFile(path); err != nil || !bytes.Equal(after, data) {
+		t.Errorf("The file changed: %v", err)
+	}
+}
+
+func TestJSONFileFromNewerVersionFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.json")
+	data := []byte(`{"Header": {"Magic": "SQLight", "Version": 99, "Creator": "SQLight v9.0.0"}, "Tables": {}}`)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	var newer *version.NewerFormatError
+	if err := NewJSONBackend(path).Open(); !errors.As(err, &newer) {
+		t.Fatalf("Open returned %v, expected a NewerFormatError", err)
+	}
+	if newer.Format != 99 || newer.Newest != JSONFormatVersion || newer.Creator != "SQLight v9.0.0" {
+		t.Errorf("Open returned %+v", newer)
+	}
+}
+
+// legacyPageFile writes tables to a page file of an older format version
+// at path
+func legacyPageFile(t *testing.T, path string, format uint32, tables map[string]*testTable) {
+	t.Helper()
+	b := NewPageBackend(path)
+	if err := b.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	write(t, b, tables)
+	if err := b.rebuild(path+".old", pager.Options{Version: format}); err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+	b.Close()
+	if err := os.Rename(path+".old", path); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path + "-wal")
+}
+
+func TestPageFilesMigrate(t *testing.T) {
+	for format := uint32(1); format < pager.FormatVersion; format++ {
+		users := newTestTable("users")
+		users.put(1, "alice@example.com")
+		users.put(2, nil)
+		tables := map[string]*testTable{"users": users}
+		path := filepath.Join(t.TempDir(), "test.db")
+		legacyPageFile(t, path, format, tables)
+
+		b := NewPageBackend(path)
+		if err := b.Open(); err != nil {
+			t.Fatalf("Opening version %d: %v", format, err)
+		}
+		if err := b.ReadOnly(); err != nil {
+			t.Fatalf("Version %d upgraded read-only: %v", format, err)
+		}
+		if v := b.Pager().Version(); v != pager.FormatVersion {
+			t.Errorf("Version %d upgraded to %d, expected %d", format, v, pager.FormatVersion)
+		}
+		if got := contents(t, b); !reflect.DeepEqual(got, expected(tables)) {
+			t.Errorf("Version %d upgraded holding %v, expected %v", format, got, expected(tables))
+		}
+		users.put(3, "carol@example.com")
+		write(t, b, tables)
+		b.Close()
+
+		b = NewPageBackend(path)
+		if err := b.Open(); err != nil {
+			t.Fatalf("Reopening version %d: %v", format, err)
+		}
+		if got := contents(t, b); !reflect.DeepEqual(got, expected(tables)) {
+			t.Errorf("Version %d reopened holding %v, expected %v", format, got, expected(tables))
+		}
+		if problems := b.Check(); len(problems) > 0 {
+			t.Errorf("Check of version %d found %v", format, problems)
+		}
+		b.Close()
+	}
+}
+
+func TestPageFileFailingToMigrateIsReadOnly(t *testing.T) {
+	users := newTestTable("users")
+	users.put(1, "alice@example.com")
+	tables := map[string]*testTable{"users": users}
+	path := filepath.Join(t.TempDir(), "test.db")
+	legacyPageFile(t, path, 1, tables)
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failAt(t, "migrate")
+	b := NewPageBackend(path)
+	if err := b.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer b.Close()
+	if err := b.ReadOnly(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("ReadOnly returned %v, expected %v", err, ErrReadOnly)
+	}
+	if v := b.Pager().Version(); v != 1 {
+		t.Errorf("The read-only file is version %d, expected 1", v)
+	}
+	if got := contents(t, b); !reflect.DeepEqual(got, expected(tables)) {
+		t.Errorf("Read-only backend holds %v, expected %v", got, expected(tables))
+	}
+	users.put(2, "bob@example.com")
+	if err := b.WriteTables([]*TableData{users.data("users")}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("WriteTables returned %v, expected %v", err, ErrReadOnly)
+	}
+	if after, err := os.ReadFile(path); err != nil || !bytes.Equal(after, before) {
+		t.Errorf("The file changed: %v", err)
+	}
+}
@@ -0,0 +1,441 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"sqlight/pkg/interfaces"
+	"sqlight/pkg/pager"
+)
+
+// Page files
+//
+// A database stored in a page file (see package pager) keeps the rows of
+// each table in a tree of their own, mapping rowids to encoded rows, and
+// lists the tables in the catalog tree, mapping each table name to the root
+// page of the table's tree followed by its encoded schema. Writing the
+// tables writes only the rows that changed since the last write and the
+// catalog entries of tables whose schema changed, so a single-row INSERT
+// touches a handful of pages.
+//
+// A page file created with a passphrase is encrypted page by page; Rekey
+// changes its passphrase by copying every tree into a new file encrypted
+// under the new one.
+//
+// A page file of an older format version is upgraded when it is opened
+// (see migrate.go). If that fails, or the file cannot be written, it is
+// opened read-only.
+
+// PageBackend stores tables in a page file
+type PageBackend struct {
+	path       string
+	passphrase string
+	cacheSize  int
+	pager      *pager.Pager
+	catalog    *pager.Tree
+	entries    map[string]catalogEntry
+	readOnly   error // why the file cannot be written, if it cannot
+
+	// committed holds the entries as of the last sync while a write is
+	// pending, nil otherwise
+	committed map[string]catalogEntry
+}
+
+// catalogEntry is the catalog entry of a saved table
+type catalogEntry struct {
+	root   pager.PageID
+	schema []byte
+}
+
+// encode returns the catalog value of an entry
+func (e catalogEntry) encode() []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(e.root)), e.schema...)
+}
+
+// NewPageBackend returns a backend for the page file at path
+func NewPageBackend(path string) *PageBackend {
+	return &PageBackend{path: path}
+}
+
+// SetPassphrase sets the passphrase of the page file, which must be set
+// before Open: a new file is created encrypted under it, and an existing
+// file must have been encrypted under it
+func (b *PageBackend) SetPassphrase(passphrase string) {
+	b.passphrase = passphrase
+}
+
+// Open opens the page file, creating it if it does not exist. A file of an
+// older format version is upgraded, and is opened read-only if that fails.
+func (b *PageBackend) Open() error {
+	b.readOnly = nil
+	options := pager.Options{Passphrase: b.passphrase}
+	if _, err := os.Stat(b.path); os.IsNotExist(err) {
+		p, err := pager.CreateWith(b.path, options)
+		if err != nil {
+			return err
+		}
+		b.setPager(p)
+		return nil
+	}
+
+	p, err := pager.OpenWith(b.path, options)
+	if errors.Is(err, os.ErrPermission) {
+		options.ReadOnly = true
+		p, err = pager.OpenWith(b.path, options)
+		b.readOnly = fmt.Errorf("%w: the file cannot be written", ErrReadOnly)
+	}
+	if err != nil {
+		return err
+	}
+	b.setPager(p)
+	if from := p.Version(); from < pager.FormatVersion && b.readOnly == nil {
+		if err := b.migrate(); err != nil {
+			if b.pager != nil {
+				b.pager.Close()
+			}
+			options.ReadOnly = true
+			if p, err = pager.OpenWith(b.path, options); err != nil {
+				return err
+			}
+			b.setPager(p)
+			b.readOnly = fmt.Errorf("%w: upgrading it from format version %d failed: %v", ErrReadOnly, from, err)
+		}
+	}
+	return nil
+}
+
+// setPager makes p the open page file
+func (b *PageBackend) setPager(p *pager.Pager) {
+	if b.cacheSize > 0 {
+		p.SetCacheSize(b.cacheSize)
+	}
+	b.pager = p
+	b.catalog = pager.OpenTree(p, p.Catalog())
+	b.entries = make(map[string]catalogEntry)
+}
+
+// ReadOnly returns why the page file cannot be written, or nil if it can
+func (b *PageBackend) ReadOnly() error {
+	return b.readOnly
+}
+
+// Pager returns the pager of the open page file
+func (b *PageBackend) Pager() *pager.Pager {
+	return b.pager
+}
+
+// SetCacheSize sets the memory budget of the page file's buffer pool, in
+// bytes, in place of pager.DefaultCacheSize
+func (b *PageBackend) SetCacheSize(bytes int) {
+	b.cacheSize = bytes
+	if b.pager != nil {
+		b.pager.SetCacheSize(bytes)
+	}
+}
+
+// CacheSize returns the memory budget of the page file's buffer pool, in
+// bytes
+func (b *PageBackend) CacheSize() int {
+	if b.cacheSize > 0 {
+		return b.cacheSize
+	}
+	return pager.DefaultCacheSize
+}
+
+// LoadCatalog reads the definition of every table from the catalog
+func (b *PageBackend) LoadCatalog() (map[string]*interfaces.Table, error) {
+	tables := make(map[string]*interfaces.Table)
+	err := b.catalog.Scan(func(key, value []byte) error {
+		name := string(key)
+		if len(value) < 4 {
+			return fmt.Errorf("invalid catalog entry for table %s", name)
+		}
+		entry := catalogEntry{
+			root:   pager.PageID(binary.BigEndian.Uint32(value)),
+			schema: value[4:],
+		}
+		table, err := decodeSchema(entry.schema)
+		if err != nil {
+			return fmt.Errorf("schema of table %s: %v", name, err)
+		}
+		b.entries[name] = entry
+		tables[name] = table
+		return nil
+	})
+	return tables, err
+}
+
+// ReadTable reads the rows of a table in rowid order
+func (b *PageBackend) ReadTable(name string, fn func(rowid int64, record *interfaces.Record) error) error {
+	entry, exists := b.entries[name]
+	if !exists {
+		return fmt.Errorf("table %s is not in the catalog", name)
+	}
+	table, err := decodeSchema(entry.schema)
+	if err != nil {
+		return fmt.Errorf("schema of table %s: %v", name, err)
+	}
+	err = pager.OpenTree(b.pager, entry.root).Scan(func(key, value []byte) error {
+		rowid, err := keyRowid(key)
+		if err != nil {
+			return err
+		}
+		record, err := decodeRecord(table, value)
+		if err != nil {
+			return fmt.Errorf("row %d: %v", rowid, err)
+		}
+		return fn(rowid, record)
+	})
+	if err != nil {
+		return fmt.Errorf("table %s: %v", name, err)
+	}
+	return nil
+}
+
+// Check verifies the checksum of every page and the trees of the catalog
+// and of every table
+func (b *PageBackend) Check() []error {
+	trees := map[string]pager.PageID{"the catalog": b.catalog.Root()}
+	for name, entry := range b.entries {
+		trees["table "+name] = entry.root
+	}
+	return b.pager.Check(trees)
+}
+
+// WriteTables writes the changes to the tables since the last write to
+// the pager. If writing fails the unsynced pages are discarded.
+func (b *PageBackend) WriteTables(tables []*TableData) error {
+	if b.readOnly != nil {
+		return b.readOnly
+	}
+	if b.committed == nil {
+		b.committed = make(map[string]catalogEntry, len(b.entries))
+		for name, entry := range b.entries {
+			b.committed[name] = entry
+		}
+	}
+	if err := b.write(tables); err != nil {
+		b.rollback()
+		return err
+	}
+	return nil
+}
+
+// Sync commits the written pages. If committing fails they are discarded.
+func (b *PageBackend) Sync() error {
+	if err := b.pager.Commit(); err != nil {
+		b.rollback()
+		return err
+	}
+	b.committed = nil
+	return nil
+}
+
+// rollback discards the pages written since the last sync
+func (b *PageBackend) rollback() {
+	b.pager.Rollback()
+	if b.committed != nil {
+		b.entries = b.committed
+		b.committed = nil
+	}
+}
+
+// write applies the changes to the tables to the pager
+func (b *PageBackend) write(tables []*TableData) error {
+	tables = append([]*TableData(nil), tables...)
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+
+	// Find the saved entry of each table, which may be under an old name
+	entries := make(map[string]catalogEntry, len(tables))
+	listed := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		if entry, exists := b.entries[table.Saved]; exists {
+			entries[table.Name] = entry
+			listed[table.Saved] = table.Saved == table.Name
+		}
+	}
+
+	// Unlist the entries of dropped and renamed tables, and free the pages
+	// of dropped ones
+	saved := make([]string, 0, len(b.entries))
+	for name := range b.entries {
+		saved = append(saved, name)
+	}
+	sort.Strings(saved)
+	for _, name := range saved {
+		kept, reused := listed[name]
+		if kept {
+			continue
+		}
+		if !reused {
+			if err := pager.OpenTree(b.pager, b.entries[name].root).Drop(); err != nil {
+				return err
+			}
+		}
+		if _, err := b.catalog.Delete([]byte(name)); err != nil {
+			return err
+		}
+	}
+
+	for _, table := range tables {
+		name := table.Name
+		entry, exists := entries[name]
+		rewrite := table.Rewrite
+		if !exists {
+			tree, err := pager.CreateTree(b.pager)
+			if err != nil {
+				return err
+			}
+			entry.root, rewrite = tree.Root(), true
+		}
+
+		schema, err := encodeSchema(table.Table)
+		if err != nil {
+			return fmt.Errorf("table %s: %v", name, err)
+		}
+		if !exists || table.Saved != name || !bytes.Equal(schema, entry.schema) {
+			entry.schema = schema
+			if err := b.catalog.Put([]byte(name), entry.encode()); err != nil {
+				return err
+			}
+		}
+		entries[name] = entry
+
+		if err := writeRows(pager.OpenTree(b.pager, entry.root), table, rewrite); err != nil {
+			return fmt.Errorf("table %s: %v", name, err)
+		}
+	}
+
+	b.entries = entries
+	return nil
+}
+
+// writeRows writes the changed rows of a table to its tree, or every row
+// if rewrite is set
+func writeRows(tree *pager.Tree, table *TableData, rewrite bool) error {
+	rowids := make([]int64, 0, len(table.Changed))
+	for rowid := range table.Changed {
+		rowids = append(rowids, rowid)
+	}
+	sort.Slice(rowids, func(i, j int) bool { return rowids[i] < rowids[j] })
+
+	put := func(rowid int64, record *interfaces.Record) error {
+		data, err := encodeRecord(table.Table, record)
+		if err != nil {
+			return err
+		}
+		return tree.Put(rowidKey(rowid), data)
+	}
+
+	for _, rowid := range rowids {
+		record := table.Changed[rowid]
+		if record == nil {
+			if _, err := tree.Delete(rowidKey(rowid)); err != nil {
+				return err
+			}
+		} else if !rewrite {
+			if err := put(rowid, record); err != nil {
+				return err
+			}
+		}
+	}
+	if rewrite {
+		return table.Rows(put)
+	}
+	return nil
+}
+
+// Rekey rewrites the page file encrypted under a new passphrase, or not
+// encrypted if it is empty
+func (b *PageBackend) Rekey(passphrase string) error {
+	if b.readOnly != nil {
+		return b.readOnly
+	}
+	return b.replace(pager.Options{Passphrase: passphrase})
+}
+
+// replace rewrites the page file with options. The catalog and the tables
+// are copied into a new file, which replaces the old one once complete, so
+// a crash leaves one or the other.
+func (b *PageBackend) replace(options pager.Options) error {
+	if b.committed != nil {
+		return errors.New("cannot rewrite the file with unsynced writes")
+	}
+	tmp := b.path + ".rekey"
+	if err := b.rebuild(tmp, options); err != nil {
+		os.Remove(tmp)
+		os.Remove(tmp + "-wal")
+		return err
+	}
+	if err := b.pager.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	err := os.Rename(tmp, b.path)
+	if err == nil {
+		b.passphrase = options.Passphrase
+		err = syncDir(filepath.Dir(b.path))
+	} else {
+		os.Remove(tmp)
+	}
+	p, openErr := pager.OpenWith(b.path, pager.Options{Passphrase: b.passphrase})
+	if openErr != nil {
+		b.pager = nil
+		return openErr
+	}
+	b.setPager(p)
+	if _, loadErr := b.LoadCatalog(); loadErr != nil {
+		return loadErr
+	}
+	return err
+}
+
+// rebuild copies the catalog and the tree of every table into a new page
+// file at path, committing after each table
+func (b *PageBackend) rebuild(path string, options pager.Options) error {
+	p, err := pager.CreateWith(path, options)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(b.entries))
+	for name := range b.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	catalog := pager.OpenTree(p, p.Catalog())
+	for _, name := range names {
+		entry := b.entries[name]
+		tree, err := pager.CreateTree(p)
+		if err == nil {
+			err = pager.OpenTree(b.pager, entry.root).Scan(tree.Put)
+		}
+		if err == nil {
+			entry.root = tree.Root()
+			err = catalog.Put([]byte(name), entry.encode())
+		}
+		if err == nil {
+			err = p.Commit()
+		}
+		if err != nil {
+			p.Close()
+			return fmt.Errorf("table %s: %v", name, err)
+		}
+	}
+	return p.Close()
+}
+
+// Close closes the page file, discarding any unsynced write
+func (b *PageBackend) Close() error {
+	b.committed = nil
+	if b.pager == nil {
+		return nil
+	}
+	return b.pager.Close()
+}
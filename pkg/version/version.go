@@ -0,0 +1,34 @@
+// Package version records the version of SQLight, which is written into
+// the header of every database file it creates, so that a file from a newer
+// version can be reported as such.
+package version
+
+import "fmt"
+
+// Version is the version of this build of SQLight, set when building a
+// release with -ldflags "-X sqlight/pkg/version.Version=v1.2.3"
+var Version = "devel"
+
+// String returns the name and version of this build, as recorded in file
+// headers
+func String() string {
+	return "SQLight " + Version
+}
+
+// NewerFormatError is returned when opening a file written in a newer
+// format version than this build reads
+type NewerFormatError struct {
+	Kind    string // the kind of file, "page file" or "JSON file"
+	Format  uint32 // the file's format version
+	Newest  uint32 // the newest format version this build reads
+	Creator string // the version of SQLight that wrote the file, if known
+}
+
+func (e *NewerFormatError) Error() string {
+	creator := "a newer version of SQLight"
+	if e.Creator != "" {
+		creator = e.Creator
+	}
+	return fmt.Sprintf("%s format version %d was written by %s; %s reads versions up to %d, upgrade to open it",
+		e.Kind, e.Format, creator, String(), e.Newest)
+}
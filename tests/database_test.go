@@ -3,188 +3,197 @@ package tests
 import (
 	"os"
 	"path/filepath"
+	"testing"
+
 	"sqlight/pkg/db"
 	"sqlight/pkg/interfaces"
-	"testing"
+	"sqlight/pkg/sql"
+	"sqlight/pkg/storage"
 )
 
+// execute parses and runs a single statement
+func execute(database *db.Database, query string) (*interfaces.Result, error) {
+	stmt, err := sql.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return database.Execute(stmt)
+}
+
+// mustExecute runs a statement, failing the test on error
+func mustExecute(t *testing.T, database *db.Database, query string) *interfaces.Result {
+	t.Helper()
+	result, err := execute(database, query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return result
+}
+
 func TestDatabase(t *testing.T) {
 	// Create a temporary database file for testing
 	tmpFile := "test_db.json"
 	defer os.Remove(tmpFile)
 
-	database := db.NewDatabase(tmpFile)
+	database, err := db.NewDatabase(storage.NewJSONBackend(tmpFile))
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
 
 	// Test CREATE TABLE with data types
-	err := database.CreateTable("users", []interfaces.ColumnDef{
-		{Name: "id", Type: "INTEGER"},
-		{Name: "name", Type: "TEXT"},
-		{Name: "email", Type: "TEXT"},
-	})
+	_, err = execute(database, "CREATE TABLE users (id INTEGER, name TEXT, email TEXT)")
 	if err != nil {
 		t.Fatalf("Error creating table: %v", err)
 	}
 
 	// Test duplicate table creation
-	err = database.CreateTable("users", []interfaces.ColumnDef{
-		{Name: "id", Type: "INTEGER"},
-	})
+	_, err = execute(database, "CREATE TABLE users (id INTEGER)")
 	if err == nil {
 		t.Error("Expected error when creating duplicate table")
 	}
 
 	// Test INSERT with data types
-	r1 := interfaces.NewRecord(map[string]interface{}{
-		"id":    1,
-		"name":  "Alice",
-		"email": "alice@email.com",
-	})
-
-	err = database.InsertIntoTable("users", r1)
+	_, err = execute(database, "INSERT INTO users (id, name, email) VALUES (1, 'Alice', 'alice@email.com')")
 	if err != nil {
 		t.Fatalf("Error inserting record: %v", err)
 	}
 
 	// Test invalid table insert
-	err = database.InsertIntoTable("nonexistent", r1)
+	_, err = execute(database, "INSERT INTO nonexistent (id, name, email) VALUES (1, 'Alice', 'alice@email.com')")
 	if err == nil {
 		t.Error("Expected error when inserting into non-existent table")
 	}
 
 	// Test SELECT
-	records, err := database.SelectFromTable("users")
+	result, err := execute(database, "SELECT * FROM users")
 	if err != nil {
 		t.Fatalf("Error selecting from table: %v", err)
 	}
+	records := result.Records
 
 	if len(records) != 1 {
 		t.Fatalf("Expected 1 record, got %d", len(records))
 	}
 
 	// Test invalid table select
-	_, err = database.SelectFromTable("nonexistent")
+	_, err = execute(database, "SELECT * FROM nonexistent")
 	if err == nil {
 		t.Error("Expected error when selecting from non-existent table")
 	}
 
 	// Test column values
-	r, ok := records[0].(*interfaces.Record)
-	if !ok {
-		t.Fatalf("Invalid record type")
-	}
-
+	r := records[0]
 	if r.Columns["name"] != "Alice" {
 		t.Errorf("Expected name 'Alice', got '%v'", r.Columns["name"])
 	}
 
 	// Test UPDATE
-	err = database.UpdateTable("users",
-		map[string]interface{}{"name": "Alice Smith"},
-		"id",
-		float64(1))
+	_, err = execute(database, "UPDATE users SET name = 'Alice Smith' WHERE id = 1")
 	if err != nil {
 		t.Fatalf("Error updating record: %v", err)
 	}
 
 	// Verify update
-	record, err := database.FindInTable("users", 1)
-	if err != nil {
-		t.Fatalf("Error finding updated record: %v", err)
-	}
-	r, _ = record.(*interfaces.Record)
-	if r.Columns["name"] != "Alice Smith" {
-		t.Errorf("Expected updated name 'Alice Smith', got '%v'", r.Columns["name"])
+	records = mustExecute(t, database, "SELECT * FROM users").Records
+	if len(records) != 1 || records[0].Columns["name"] != "Alice Smith" {
+		t.Errorf("Expected updated name 'Alice Smith', got %v", records)
 	}
 
-	// Test invalid update
-	err = database.UpdateTable("users",
-		map[string]interface{}{"name": "Bob"},
-		"id",
-		float64(999))
-	if err == nil {
-		t.Error("Expected error when updating non-existent record")
+	// Test update of a non-existent record
+	result, err = execute(database, "UPDATE users SET name = 'Bob' WHERE id = 999")
+	if err != nil {
+		t.Fatalf("Error updating non-existent record: %v", err)
+	}
+	if result.Message != "0 record(s) updated successfully" {
+		t.Errorf("Expected no records updated, got %q", result.Message)
 	}
 
 	// Test DELETE
-	err = database.DeleteFromTable("users", "id", float64(1))
+	_, err = execute(database, "DELETE FROM users WHERE id = 1")
 	if err != nil {
 		t.Fatalf("Error deleting record: %v", err)
 	}
 
 	// Verify delete
-	records, err = database.SelectFromTable("users")
-	if err != nil {
-		t.Fatalf("Error selecting after delete: %v", err)
-	}
+	records = mustExecute(t, database, "SELECT * FROM users").Records
 	if len(records) != 0 {
 		t.Errorf("Expected 0 records after delete, got %d", len(records))
 	}
 
-	// Test invalid delete
-	err = database.DeleteFromTable("users", "id", float64(999))
-	if err == nil {
-		t.Error("Expected error when deleting non-existent record")
+	// Test delete of a non-existent record
+	result, err = execute(database, "DELETE FROM users WHERE id = 999")
+	if err != nil {
+		t.Fatalf("Error deleting non-existent record: %v", err)
+	}
+	if result.Message != "0 record(s) deleted successfully" {
+		t.Errorf("Expected no records deleted, got %q", result.Message)
 	}
 
 	// Test file operations
 	// Save current state
-	err = database.Execute("INSERT INTO users VALUES (2, 'Bob', 'bob@email.com')")
+	_, err = execute(database, "INSERT INTO users (id, name, email) VALUES (2, 'Bob', 'bob@email.com')")
 	if err != nil {
 		t.Fatalf("Error executing INSERT: %v", err)
 	}
 
 	// Create new database instance to load saved state
-	database2 := db.NewDatabase(tmpFile)
-	records, err = database2.SelectFromTable("users")
+	database2, err := db.NewDatabase(storage.NewJSONBackend(tmpFile))
+	if err != nil {
+		t.Fatalf("Error loading database: %v", err)
+	}
+	result, err = execute(database2, "SELECT * FROM users")
 	if err != nil {
 		t.Fatalf("Error selecting from loaded database: %v", err)
 	}
-	if len(records) != 1 {
-		t.Errorf("Expected 1 record in loaded database, got %d", len(records))
+	if len(result.Records) != 1 {
+		t.Errorf("Expected 1 record in loaded database, got %d", len(result.Records))
 	}
 }
 
 func TestSQLParser(t *testing.T) {
-	database := db.NewDatabase("test_parser.json")
+	database, err := db.NewDatabase(storage.NewJSONBackend("test_parser.json"))
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
 	defer os.Remove("test_parser.json")
 
 	// Test CREATE TABLE
-	err := database.Execute("CREATE TABLE products (id INTEGER, name TEXT, price INTEGER)")
+	_, err = execute(database, "CREATE TABLE products (id INTEGER, name TEXT, price INTEGER)")
 	if err != nil {
 		t.Fatalf("Error executing CREATE TABLE: %v", err)
 	}
 
 	// Test INSERT
-	err = database.Execute("INSERT INTO products VALUES (1, 'Widget', 100)")
+	_, err = execute(database, "INSERT INTO products (id, name, price) VALUES (1, 'Widget', 100)")
 	if err != nil {
 		t.Fatalf("Error executing INSERT: %v", err)
 	}
 
 	// Test SELECT
-	err = database.Execute("SELECT * FROM products")
+	_, err = execute(database, "SELECT * FROM products")
 	if err != nil {
 		t.Fatalf("Error executing SELECT: %v", err)
 	}
 
 	// Test UPDATE
-	err = database.Execute("UPDATE products SET price = 200 WHERE id = 1")
+	_, err = execute(database, "UPDATE products SET price = 200 WHERE id = 1")
 	if err != nil {
 		t.Fatalf("Error executing UPDATE: %v", err)
 	}
 
 	// Test DELETE
-	err = database.Execute("DELETE FROM products WHERE id = 1")
+	_, err = execute(database, "DELETE FROM products WHERE id = 1")
 	if err != nil {
 		t.Fatalf("Error executing DELETE: %v", err)
 	}
 
 	// Test invalid SQL
-	err = database.Execute("INVALID SQL")
+	_, err = execute(database, "INVALID SQL")
 	if err == nil {
 		t.Error("Expected error for invalid SQL")
 	}
 
-	err = database.Execute("SELECT * FROM nonexistent")
+	_, err = execute(database, "SELECT * FROM nonexistent")
 	if err == nil {
 		t.Error("Expected error for non-existent table")
 	}
@@ -194,15 +203,15 @@ func TestBTree(t *testing.T) {
 	tree := db.NewBTree()
 
 	// Test Insert and Search
-	r1 := interfaces.NewRecord(map[string]interface{}{
+	r1 := &interfaces.Record{Columns: map[string]interface{}{
 		"id":    1,
 		"name":  "Alice",
 		"email": "alice@email.com",
-	})
+	}}
 
-	tree.Insert(1, r1)
+	tree.Insert(db.Key{1}, r1)
 
-	found := tree.Search(1)
+	found := tree.Search(db.Key{1})
 	if found == nil {
 		t.Fatal("Record not found after insertion")
 	}
@@ -212,18 +221,18 @@ func TestBTree(t *testing.T) {
 	}
 
 	// Test non-existent key
-	notFound := tree.Search(999)
+	notFound := tree.Search(db.Key{999})
 	if notFound != nil {
 		t.Error("Expected nil for non-existent key")
 	}
 
 	// Test multiple inserts
-	r2 := interfaces.NewRecord(map[string]interface{}{
+	r2 := &interfaces.Record{Columns: map[string]interface{}{
 		"id":    2,
 		"name":  "Bob",
 		"email": "bob@email.com",
-	})
-	tree.Insert(2, r2)
+	}}
+	tree.Insert(db.Key{2}, r2)
 
 	// Test Scan
 	records := tree.Scan()
@@ -232,8 +241,8 @@ func TestBTree(t *testing.T) {
 	}
 
 	// Test Delete
-	tree.Delete(1)
-	found = tree.Search(1)
+	tree.Delete(db.Key{1})
+	found = tree.Search(db.Key{1})
 	if found != nil {
 		t.Error("Record still exists after deletion")
 	}
@@ -244,111 +253,89 @@ func TestTransactions(t *testing.T) {
 	tmpFile := filepath.Join(os.TempDir(), "test_db_tx.json")
 	defer os.Remove(tmpFile)
 
-	db := db.NewDatabase(tmpFile)
+	db, err := db.NewDatabase(storage.NewJSONBackend(tmpFile))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
 
 	// Create a test table
-	columns := []interfaces.ColumnDef{
-		{Name: "id", Type: "INTEGER"},
-		{Name: "name", Type: "TEXT"},
-	}
-	err := db.CreateTable("users", columns)
+	_, err = execute(db, "CREATE TABLE users (id INTEGER, name TEXT)")
 	if err != nil {
 		t.Fatalf("Failed to create table: %v", err)
 	}
 
+	// exists reports whether the table holds the record with the given id
+	exists := func(t *testing.T, id int) bool {
+		t.Helper()
+		for _, record := range mustExecute(t, db, "SELECT * FROM users").Records {
+			if record.Columns["id"] == id {
+				return true
+			}
+		}
+		return false
+	}
+
 	// Test 1: Basic transaction commit
 	t.Run("Transaction Commit", func(t *testing.T) {
-		err := db.Begin()
+		_, err := execute(db, "BEGIN TRANSACTION")
 		if err != nil {
 			t.Fatalf("Failed to begin transaction: %v", err)
 		}
 
 		// Insert a record
-		record := interfaces.NewRecord(map[string]interface{}{
-			"id":   1,
-			"name": "Alice",
-		})
-		tables := db.Tables()
-		err = tables["users"].Insert(record)
+		_, err = execute(db, "INSERT INTO users (id, name) VALUES (1, 'Alice')")
 		if err != nil {
 			t.Fatalf("Failed to insert record: %v", err)
 		}
 
-		err = db.Commit()
+		_, err = execute(db, "COMMIT")
 		if err != nil {
 			t.Fatalf("Failed to commit transaction: %v", err)
 		}
 
 		// Verify record exists
-		tables = db.Tables()
-		cursor := tables["users"].NewCursor()
-		found := false
-		record, err = cursor.First()
-		for record != nil && err == nil {
-			if record.Columns["id"] == 1 {
-				found = true
-				break
-			}
-			record, err = cursor.Next()
-		}
-		if !found {
+		if !exists(t, 1) {
 			t.Error("Record not found after commit")
 		}
 	})
 
 	// Test 2: Transaction rollback
 	t.Run("Transaction Rollback", func(t *testing.T) {
-		err := db.Begin()
+		_, err := execute(db, "BEGIN TRANSACTION")
 		if err != nil {
 			t.Fatalf("Failed to begin transaction: %v", err)
 		}
 
 		// Insert a record
-		record := interfaces.NewRecord(map[string]interface{}{
-			"id":   2,
-			"name": "Bob",
-		})
-		tables := db.Tables()
-		err = tables["users"].Insert(record)
+		_, err = execute(db, "INSERT INTO users (id, name) VALUES (2, 'Bob')")
 		if err != nil {
 			t.Fatalf("Failed to insert record: %v", err)
 		}
 
-		err = db.Rollback()
+		_, err = execute(db, "ROLLBACK")
 		if err != nil {
 			t.Fatalf("Failed to rollback transaction: %v", err)
 		}
 
 		// Verify record does not exist
-		tables = db.Tables()
-		cursor := tables["users"].NewCursor()
-		found := false
-		record, err = cursor.First()
-		for record != nil && err == nil {
-			if record.Columns["id"] == 2 {
-				found = true
-				break
-			}
-			record, err = cursor.Next()
-		}
-		if found {
+		if exists(t, 2) {
 			t.Error("Record found after rollback")
 		}
 	})
 
 	// Test 3: Nested transactions not allowed
 	t.Run("Nested Transactions", func(t *testing.T) {
-		err := db.Begin()
+		_, err := execute(db, "BEGIN TRANSACTION")
 		if err != nil {
 			t.Fatalf("Failed to begin first transaction: %v", err)
 		}
 
-		err = db.Begin()
+		_, err = execute(db, "BEGIN TRANSACTION")
 		if err == nil {
 			t.Error("Expected error when beginning nested transaction")
 		}
 
-		err = db.Rollback()
+		_, err = execute(db, "ROLLBACK")
 		if err != nil {
 			t.Fatalf("Failed to rollback transaction: %v", err)
 		}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
+	"syscall"
 	"sqlight/pkg/db"
 	"sqlight/pkg/sql"
 
@@ -25,14 +28,39 @@ type QueryResponse struct {
 	Columns []string              `json:"columns,omitempty"`
 }
 
-const dbFile = "database.json"
+// legacyDBFile is the JSON database file of earlier versions
+const legacyDBFile = "database.json"
 
 func main() {
-	// Load database
-	database, err := db.NewDatabase(dbFile)
+	// Load database, encrypted under the passphrase in SQLIGHT_PASSPHRASE
+	// if it is set. The database is a page file, or a compressed JSON file
+	// if SQLIGHT_COMPRESS is set, since only JSON files can be compressed.
+	options := db.Options{
+		Passphrase: os.Getenv("SQLIGHT_PASSPHRASE"),
+		Compress:   os.Getenv("SQLIGHT_COMPRESS") != "",
+	}
+	dbFile := "database.db"
+	if options.Compress {
+		dbFile = legacyDBFile
+	}
+
+	// Copy the JSON database of earlier versions into a new page file
+	copied, err := db.Migrate(dbFile, legacyDBFile, options)
+	if err != nil {
+		log.Fatalf("Failed to copy %s into %s: %v", legacyDBFile, dbFile, err)
+	}
+	if copied {
+		log.Printf("Copied %s into %s; %s is left as it was", legacyDBFile, dbFile, legacyDBFile)
+	}
+
+	database, err := db.OpenWith(dbFile, options)
 	if err != nil {
 		log.Fatalf("Failed to load database: %v", err)
 	}
+	defer database.Close()
+	if err := database.ReadOnly(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
 
 	// Create router
 	r := mux.NewRouter()
@@ -147,6 +175,24 @@ func main() {
 
 	// Start server
 	port := ":8081"
+	server := &http.Server{Addr: port, Handler: r}
+
+	// Stop serving on SIGINT or SIGTERM, letting running requests finish,
+	// so that the database is closed before exiting
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		<-stop
+		log.Print("Shutting down")
+		server.Shutdown(context.Background())
+		close(stopped)
+	}()
+
 	log.Printf("Server starting on http://localhost%s", port)
-	log.Fatal(http.ListenAndServe(port, r))
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		database.Close()
+		log.Fatal(err)
+	}
+	<-stopped
 }